@@ -2,21 +2,36 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/VatsalP117/iris/pkg/api"
+	"github.com/VatsalP117/iris/pkg/core"
 	"github.com/VatsalP117/iris/pkg/db"
+	"github.com/VatsalP117/iris/pkg/metrics"
+	"github.com/VatsalP117/iris/pkg/oidc"
 )
 
 func main() {
+	logger, err := api.NewLogger(os.Stderr, os.Getenv("IRIS_LOG_LEVEL"), os.Getenv("IRIS_LOG_FORMAT"))
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	// The standard log package writes through the same handler, at the info
+	// level, so startup messages share the format.
+	slog.SetDefault(logger)
+
 	port := getEnv("PORT", "8080")
 	dbPath := getEnv("DB_PATH", "./data/iris.db")
 
@@ -44,6 +59,9 @@ func main() {
 			}
 			log.Printf("Iris retention removed %d expired raw events", deleted)
 			return
+		case "export-subject", "erase-subject":
+			runDataSubjectCommand(sqliteRepo, os.Args[1], os.Args[2:])
+			return
 		default:
 			log.Fatalf("Unknown command %q", os.Args[1])
 		}
@@ -74,29 +92,139 @@ func main() {
 		}
 	}
 
+	if rawQueueSize := os.Getenv("IRIS_INGEST_QUEUE_SIZE"); rawQueueSize != "" {
+		queueSize, parseErr := strconv.Atoi(rawQueueSize)
+		if parseErr != nil || queueSize <= 0 {
+			log.Fatalf("Invalid IRIS_INGEST_QUEUE_SIZE %q: must be a positive integer", rawQueueSize)
+		}
+		sqliteRepo.SetIngestQueueLimit(queueSize)
+	}
+
 	handler := api.NewHandlerWithAdminToken(sqliteRepo, os.Getenv("IRIS_ADMIN_TOKEN"))
+	if extensions := os.Getenv("IRIS_DOWNLOAD_EXTENSIONS"); extensions != "" {
+		handler.SetDownloadExtensions(strings.Split(extensions, ","))
+	}
+	if rawProxies := os.Getenv("IRIS_TRUSTED_PROXIES"); rawProxies != "" {
+		proxies, err := api.ParseTrustedProxies(rawProxies)
+		if err != nil {
+			log.Fatalf("Invalid IRIS_TRUSTED_PROXIES: %v", err)
+		}
+		handler.SetTrustedProxies(proxies)
+	}
+	if rawOrigins := os.Getenv("IRIS_DASHBOARD_ORIGINS"); rawOrigins != "" {
+		origins, err := api.ParseDashboardOrigins(rawOrigins)
+		if err != nil {
+			log.Fatalf("Invalid IRIS_DASHBOARD_ORIGINS: %v", err)
+		}
+		handler.SetDashboardOrigins(origins)
+	}
+	ingestRate, err := api.ParseRateLimits(os.Getenv("IRIS_INGEST_RATE_LIMITS"))
+	if err != nil {
+		log.Fatalf("Invalid IRIS_INGEST_RATE_LIMITS: %v", err)
+	}
+	readRate, err := api.ParseRateLimits(os.Getenv("IRIS_READ_RATE_LIMITS"))
+	if err != nil {
+		log.Fatalf("Invalid IRIS_READ_RATE_LIMITS: %v", err)
+	}
+	handler.SetRateLimits(ingestRate, readRate)
+	readAccess := api.ReadAccessAuto
+	if rawPrivateReads := os.Getenv("IRIS_PRIVATE_READS"); rawPrivateReads != "" {
+		privateReads, err := strconv.ParseBool(rawPrivateReads)
+		if err != nil {
+			log.Fatalf("Invalid IRIS_PRIVATE_READS %q: must be true or false", rawPrivateReads)
+		}
+		readAccess = api.ReadAccessPublic
+		if privateReads {
+			readAccess = api.ReadAccessPrivate
+		}
+	}
+	handler.SetReadAccess(readAccess)
+	if err := handler.SetSiteCreatorRole(os.Getenv("IRIS_SITE_CREATOR_ROLE")); err != nil {
+		log.Fatalf("Invalid IRIS_SITE_CREATOR_ROLE: %v", err)
+	}
+	handler.SetMetricsToken(os.Getenv("IRIS_METRICS_TOKEN"))
+	handler.SetNoiseSecret(os.Getenv("IRIS_NOISE_SECRET"))
+	if rawSampling := os.Getenv("IRIS_LOG_SUCCESS_SAMPLE"); rawSampling != "" {
+		every, err := strconv.Atoi(rawSampling)
+		if err != nil || every <= 0 {
+			log.Fatalf("Invalid IRIS_LOG_SUCCESS_SAMPLE %q: must be a positive integer", rawSampling)
+		}
+		handler.SetSuccessLogSampling(every)
+	}
+	if issuer := os.Getenv("IRIS_OIDC_ISSUER"); issuer != "" {
+		var scopes []string
+		if rawScopes := os.Getenv("IRIS_OIDC_SCOPES"); rawScopes != "" {
+			scopes = strings.Fields(rawScopes)
+		}
+		provider, err := oidc.NewProvider(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("IRIS_OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("IRIS_OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("IRIS_OIDC_REDIRECT_URL"),
+			Scopes:       scopes,
+		})
+		if err != nil {
+			log.Fatalf("Invalid OIDC configuration: %v", err)
+		}
+		rules, err := oidc.ParseRoleRules(os.Getenv("IRIS_OIDC_ROLE_MAP"))
+		if err != nil {
+			log.Fatalf("Invalid IRIS_OIDC_ROLE_MAP: %v", err)
+		}
+		handler.SetOIDC(provider, rules)
+	}
 	mux := http.NewServeMux()
+	read := func(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+		return handler.ReadCORS(handler.LimitReads(handler.ObserveQuery(next)), methods...)
+	}
+
+	mux.HandleFunc("/api/event", handler.IngestCORS(handler.ObserveIngest(handler.TrackEvent)))
+	mux.HandleFunc("/api/events", handler.IngestCORS(handler.ObserveIngest(handler.TrackBatchEvents)))
 
-	mux.HandleFunc("/api/event", api.NewCORSMiddleware(handler.TrackEvent))
-	mux.HandleFunc("/api/events", api.NewCORSMiddleware(handler.TrackBatchEvents))
-
-	mux.HandleFunc("/api/stats", api.NewCORSMiddleware(handler.GetStats))
-	mux.HandleFunc("/api/site-trends", api.NewCORSMiddleware(handler.GetSiteTrends))
-	mux.HandleFunc("/api/pages", api.NewCORSMiddleware(handler.GetPages))
-	mux.HandleFunc("/api/referrers", api.NewCORSMiddleware(handler.GetReferrers))
-	mux.HandleFunc("/api/vitals", api.NewCORSMiddleware(handler.GetVitals))
-	mux.HandleFunc("/api/vitals/distribution", api.NewCORSMiddleware(handler.GetVitalDistributions))
-	mux.HandleFunc("/api/vitals/pages", api.NewCORSMiddleware(handler.GetPagePerformance))
-	mux.HandleFunc("/api/vitals/score", api.NewCORSMiddleware(handler.GetPerformanceScore))
-	mux.HandleFunc("/api/custom-events", api.NewCORSMiddleware(handler.GetCustomEvents))
-	mux.HandleFunc("/api/custom-events/timeseries", api.NewCORSMiddleware(handler.GetCustomEventTimeSeries))
-	mux.HandleFunc("/api/devices", api.NewCORSMiddleware(handler.GetDevices))
-	mux.HandleFunc("/api/timeseries", api.NewCORSMiddleware(handler.GetTimeSeries))
-	mux.HandleFunc("/api/timeseries/visitors", api.NewCORSMiddleware(handler.GetUniqueVisitorsTimeSeries))
-	mux.HandleFunc("/api/timeseries/sessions", api.NewCORSMiddleware(handler.GetSessionsTimeSeries))
-	mux.HandleFunc("/api/sites", api.NewCORSMiddleware(handler.Sites))
-	mux.HandleFunc("/api/status", api.NewCORSMiddleware(handler.Status))
+	mux.HandleFunc("/api/stats", read(handler.GetStats))
+	mux.HandleFunc("/api/site-trends", read(handler.GetSiteTrends))
+	mux.HandleFunc("/api/pages", read(handler.GetPages))
+	mux.HandleFunc("/api/content-groups", read(handler.GetContentGroups))
+	mux.HandleFunc("/api/referrers", read(handler.GetReferrers))
+	mux.HandleFunc("/api/clicks", read(handler.GetClicks))
+	mux.HandleFunc("/api/clicks/timeseries", read(handler.GetClickTimeSeries))
+	mux.HandleFunc("/api/site-search", read(handler.GetSiteSearch))
+	mux.HandleFunc("/api/outbound-links", read(handler.GetOutboundLinks))
+	mux.HandleFunc("/api/downloads", read(handler.GetDownloads))
+	mux.HandleFunc("/api/not-found", read(handler.GetNotFoundPages))
+	mux.HandleFunc("/api/vitals", read(handler.GetVitals))
+	mux.HandleFunc("/api/vitals/distribution", read(handler.GetVitalDistributions))
+	mux.HandleFunc("/api/vitals/pages", read(handler.GetPagePerformance))
+	mux.HandleFunc("/api/vitals/score", read(handler.GetPerformanceScore))
+	mux.HandleFunc("/api/custom-events", read(handler.GetCustomEvents))
+	mux.HandleFunc("/api/custom-events/timeseries", read(handler.GetCustomEventTimeSeries))
+	mux.HandleFunc("/api/devices", read(handler.GetDevices))
+	mux.HandleFunc("/api/timeseries", read(handler.GetTimeSeries))
+	mux.HandleFunc("/api/timeseries/visitors", read(handler.GetUniqueVisitorsTimeSeries))
+	mux.HandleFunc("/api/timeseries/sessions", read(handler.GetSessionsTimeSeries))
+	mux.HandleFunc("/api/pixel.gif", handler.ObserveIngest(handler.TrackPixel))
+	mux.HandleFunc(api.TrackerScriptPath, handler.TrackerScript)
+	mux.HandleFunc(api.TrackerVersionedPath, handler.TrackerScript)
+	mux.HandleFunc("/api/sites", read(handler.Sites))
+	mux.HandleFunc("/api/sites/shares", api.SameOriginCORS(handler.SiteShares))
+	mux.HandleFunc("/api/share", read(handler.Shares, http.MethodPost))
+	mux.HandleFunc("/api/sites/members", api.SameOriginCORS(handler.SiteMembers))
+	mux.HandleFunc("/api/invites", api.SameOriginCORS(handler.Invites))
+	mux.HandleFunc("/api/invites/accept", api.SameOriginCORS(handler.AcceptInvite))
+	mux.HandleFunc("/api/auth/login", api.SameOriginCORS(handler.Login))
+	mux.HandleFunc("/api/auth/logout", api.SameOriginCORS(handler.Logout))
+	mux.HandleFunc("/api/auth/me", api.SameOriginCORS(handler.Me))
+	mux.HandleFunc("/api/auth/methods", api.SameOriginCORS(handler.AuthMethods))
+	mux.HandleFunc("/api/auth/oidc/login", handler.OIDCLogin)
+	mux.HandleFunc("/api/auth/oidc/callback", handler.OIDCCallback)
+	mux.HandleFunc("/api/users", api.SameOriginCORS(handler.Users))
+	mux.HandleFunc("/api/usage", api.SameOriginCORS(handler.Usage))
+	mux.HandleFunc("/api/ingest-keys", api.SameOriginCORS(handler.IngestKeys))
+	mux.HandleFunc("/api/data-subjects", api.SameOriginCORS(handler.DataSubjects))
+	mux.HandleFunc("/api/audit", api.SameOriginCORS(handler.Audit))
+	mux.HandleFunc("/api/tokens", api.SameOriginCORS(handler.APITokens))
+	mux.HandleFunc("/api/status", read(handler.Status))
 	mux.HandleFunc("/healthz", handler.Status)
+	mux.HandleFunc("/metrics", handler.Metrics)
 
 	if os.Getenv("IRIS_LAB_PPROF") == "1" {
 		mux.Handle("/debug/pprof/", http.DefaultServeMux)
@@ -109,11 +237,11 @@ func main() {
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
-	go runMaintenance(ctx, sqliteRepo)
+	go runMaintenance(ctx, sqliteRepo, handler.MetricsRegistry())
 
 	server := &http.Server{
 		Addr:              ":" + port,
-		Handler:           mux,
+		Handler:           api.RequestLogging(mux),
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       15 * time.Second,
 		WriteTimeout:      30 * time.Second,
@@ -139,7 +267,13 @@ func main() {
 	}
 }
 
-func runMaintenance(ctx context.Context, repo *db.SqliteRepository) {
+func runMaintenance(ctx context.Context, repo *db.SqliteRepository, registry *metrics.Registry) {
+	projectorBatches := registry.Histogram("iris_projector_batch_duration_seconds",
+		"Time to project one non-empty batch of stored events.", metrics.DefaultBuckets)
+	projectedEvents := registry.Counter("iris_projector_events_total", "Events projected into derived tables.")
+	retentionDeleted := registry.Counter("iris_retention_deleted_events_total",
+		"Raw events deleted because they passed their site's retention.")
+
 	projectorTicker := time.NewTicker(250 * time.Millisecond)
 	retentionTicker := time.NewTicker(24 * time.Hour)
 	defer projectorTicker.Stop()
@@ -147,13 +281,18 @@ func runMaintenance(ctx context.Context, repo *db.SqliteRepository) {
 
 	project := func() {
 		for {
+			started := time.Now()
 			count, err := repo.ProjectPending(ctx, 1000)
 			if err != nil {
 				if ctx.Err() == nil {
-					log.Printf("[Projector] error: %v", err)
+					slog.Error("projection failed", "component", "Projector", "error", err)
 				}
 				return
 			}
+			if count > 0 {
+				projectorBatches.Observe(time.Since(started).Seconds())
+				projectedEvents.Add(float64(count))
+			}
 			if count < 1000 {
 				return
 			}
@@ -163,12 +302,13 @@ func runMaintenance(ctx context.Context, repo *db.SqliteRepository) {
 		deleted, err := repo.ApplyRetention(ctx, time.Now().UTC())
 		if err != nil {
 			if ctx.Err() == nil {
-				log.Printf("[Retention] error: %v", err)
+				slog.Error("retention failed", "component", "Retention", "error", err)
 			}
 			return
 		}
+		retentionDeleted.Add(float64(deleted))
 		if deleted > 0 {
-			log.Printf("[Retention] removed %d expired raw events", deleted)
+			slog.Info("expired raw events removed", "component", "Retention", "deleted_events", deleted)
 		}
 	}
 
@@ -192,3 +332,38 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// runDataSubjectCommand exports a visitor's or session's events as JSON on
+// stdout, or erases them, recording the request with the "cli" actor. An
+// erasure and its audit entry are written in one transaction.
+func runDataSubjectCommand(repo *db.SqliteRepository, command string, args []string) {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	var subject core.DataSubject
+	flags.StringVar(&subject.VisitorID, "visitor-id", "", "visitor ID whose events are selected")
+	flags.StringVar(&subject.SessionID, "session-id", "", "session ID whose events are selected")
+	flags.StringVar(&subject.SiteID, "site-id", "", "limit the request to one site")
+	flags.Parse(args)
+
+	if command == "export-subject" {
+		export, err := repo.ExportDataSubject(context.Background(), subject, "cli")
+		if err != nil {
+			log.Fatalf("Failed to export data subject: %v", err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(export); err != nil {
+			log.Fatalf("Failed to write export: %v", err)
+		}
+		return
+	}
+	ctx := core.WithAudit(context.Background(), &core.AuditEntry{
+		Actor:  "cli",
+		Action: core.AuditDataSubjectErase,
+		SiteID: subject.SiteID,
+	})
+	deleted, err := repo.EraseDataSubject(ctx, subject, "cli")
+	if err != nil {
+		log.Fatalf("Failed to erase data subject: %v", err)
+	}
+	log.Printf("Iris erased %d events for the data subject", deleted)
+}
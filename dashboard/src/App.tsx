@@ -3,7 +3,11 @@ import { format, subDays, subHours } from "date-fns";
 
 import {
     api,
+    AuthError,
     DeviceStat,
+    setCsrfToken,
+    setShareToken,
+    ShareLinkInfo,
     PagePerformanceStat,
     PageStat,
     PerformanceScore,
@@ -11,6 +15,7 @@ import {
     SiteStat,
     SiteTrendResult,
     StatsResult,
+    UserSession,
     VitalDistribution,
     VitalStat,
 } from "./api";
@@ -18,6 +23,8 @@ import { DashboardShell, DashboardView } from "./components/DashboardShell";
 import { EmptyState } from "./components/EmptyState";
 import { EventsPage } from "./components/EventsPage";
 import { OverviewPage } from "./components/OverviewPage";
+import { SharePasswordForm } from "./components/SharePasswordForm";
+import { SignInForm } from "./components/SignInForm";
 import { SitesPage, SiteSummary } from "./components/SitesPage";
 import { VitalsPage } from "./components/VitalsPage";
 import { buildEmptyBuckets, DayBucket } from "./components/PageviewsChart";
@@ -69,6 +76,36 @@ function buildWindow(preset: PresetKey): DateWindow {
     };
 }
 
+// SHARE_SLUG is the share link slug from a `?share=` dashboard URL.
+const SHARE_SLUG = new URLSearchParams(window.location.search).get("share") ?? "";
+
+// INVITE_TOKEN is the one-time token from a `?invite=` dashboard URL.
+const INVITE_TOKEN = new URLSearchParams(window.location.search).get("invite") ?? "";
+
+type ShareState = "none" | "loading" | "locked" | "ready" | "invalid";
+
+function lockedWindow(share: ShareLinkInfo): DateWindow {
+    const fallback = buildWindow("30d");
+    return {
+        from: share.from ? new Date(share.from) : fallback.from,
+        to: share.to ? new Date(share.to) : fallback.to,
+        queryFrom: share.from ?? fallback.queryFrom,
+        queryTo: share.to ?? fallback.queryTo,
+    };
+}
+
+function savedShareToken(slug: string): string {
+    try {
+        const saved = JSON.parse(sessionStorage.getItem(`iris:share:${slug}`) ?? "null");
+        if (saved?.token && (!saved.expires_at || Date.parse(saved.expires_at) > Date.now())) {
+            return saved.token;
+        }
+    } catch {
+        // Ignore unreadable storage and ask for the password again.
+    }
+    return "";
+}
+
 export default function App() {
     const [view, setView] = useState<DashboardView>("dashboard");
     const [sites, setSites] = useState<SiteStat[]>([]);
@@ -90,18 +127,60 @@ export default function App() {
     const [sessions, setSessions] = useState<DayBucket[]>([]);
     const [siteSummaries, setSiteSummaries] = useState<Record<string, SiteSummary>>({});
     const [loading, setLoading] = useState(false);
+    const [share, setShare] = useState<ShareLinkInfo | null>(null);
+    const [shareState, setShareState] = useState<ShareState>(SHARE_SLUG ? "loading" : "none");
+    const [session, setSession] = useState<UserSession | null>(null);
+    const [sessionChecked, setSessionChecked] = useState(Boolean(SHARE_SLUG));
+    const [signInRequired, setSignInRequired] = useState(false);
     const abortRef = useRef<AbortController | null>(null);
+    const rangeLocked = Boolean(share?.from || share?.to);
+
+    useEffect(() => {
+        if (!SHARE_SLUG) return;
+        api.shareInfo(SHARE_SLUG)
+            .then((info) => {
+                setShare(info);
+                if (info.from || info.to) setDateWindow(lockedWindow(info));
+                const token = info.password_required ? savedShareToken(SHARE_SLUG) : SHARE_SLUG;
+                if (token) {
+                    setShareToken(token);
+                    setShareState("ready");
+                } else {
+                    setShareState("locked");
+                }
+            })
+            .catch(() => setShareState("invalid"));
+    }, []);
 
     useEffect(() => {
+        if (SHARE_SLUG) return;
+        api.me()
+            .then(applySession)
+            .catch(() => {
+                // Not signed in; reads may still be public.
+            })
+            .finally(() => setSessionChecked(true));
+    }, []);
+
+    useEffect(() => {
+        if (!sessionChecked || (shareState !== "none" && shareState !== "ready")) return;
+        if (INVITE_TOKEN && !session) return;
+        setSitesLoading(true);
         api.sites()
             .then((items) => {
                 const nextSites = items ?? [];
                 setSites(nextSites);
                 setSelectedSite(nextSites[0] ?? null);
             })
-            .catch((error) => console.error("Iris: failed to fetch sites", error))
+            .catch((error) => {
+                if (error instanceof AuthError) {
+                    setSignInRequired(true);
+                    return;
+                }
+                console.error("Iris: failed to fetch sites", error);
+            })
             .finally(() => setSitesLoading(false));
-    }, []);
+    }, [session, sessionChecked, shareState]);
 
     const fetchAnalytics = useCallback(async (siteId: string, range: DateWindow) => {
         abortRef.current?.abort();
@@ -193,7 +272,36 @@ export default function App() {
     }
 
     function handleRefresh() {
-        setDateWindow(buildWindow(preset));
+        setDateWindow(share && rangeLocked ? lockedWindow(share) : buildWindow(preset));
+    }
+
+    function handleUnlock(token: string, expiresAt?: string) {
+        sessionStorage.setItem(`iris:share:${SHARE_SLUG}`, JSON.stringify({ token, expires_at: expiresAt }));
+        setShareToken(token);
+        setShareState("ready");
+    }
+
+    function applySession(next: UserSession) {
+        setCsrfToken(next.csrf_token);
+        setSession(next);
+        setSignInRequired(false);
+    }
+
+    function handleSignIn(next: UserSession) {
+        if (INVITE_TOKEN) window.history.replaceState(null, "", window.location.pathname);
+        applySession(next);
+    }
+
+    function handleSignOut() {
+        api.logout()
+            .catch((error) => console.error("Iris: failed to sign out", error))
+            .finally(() => {
+                setCsrfToken("");
+                setSession(null);
+                setSites([]);
+                setSelectedSite(null);
+                setSignInRequired(true);
+            });
     }
 
     function handleViewChange(nextView: DashboardView) {
@@ -215,8 +323,23 @@ export default function App() {
             onSiteChange={handleSiteChange}
             onPresetChange={handlePreset}
             onRefresh={handleRefresh}
+            shareName={shareState === "none" ? undefined : share?.name ?? ""}
+            rangeLocked={rangeLocked}
+            user={session?.user}
+            onSignOut={handleSignOut}
         >
-            {sitesLoading ? (
+            {shareState === "invalid" ? (
+                <div className="page-state">
+                    <strong>This share link is not available</strong>
+                    <p>It may have expired or been revoked. Ask the site owner for a new link.</p>
+                </div>
+            ) : shareState === "locked" ? (
+                <SharePasswordForm slug={SHARE_SLUG} name={share?.name ?? ""} onUnlock={handleUnlock} />
+            ) : INVITE_TOKEN && !session && shareState === "none" ? (
+                <SignInForm inviteToken={INVITE_TOKEN} onSignIn={handleSignIn} />
+            ) : signInRequired && !session ? (
+                <SignInForm onSignIn={handleSignIn} />
+            ) : sitesLoading ? (
                 <div className="page-state">
                     <span className="spinner" />
                     <strong>Loading your sites</strong>
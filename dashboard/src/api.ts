@@ -3,10 +3,35 @@
 
 const BASE = "";
 
+// Share links let viewers read one site's analytics. The token is the link's
+// slug, or the signed token returned after entering the link's password.
+let shareToken = "";
+
+export function setShareToken(token: string) {
+    shareToken = token;
+}
+
+// Signed-in users authenticate with an HttpOnly session cookie. Requests that
+// change state echo the session's CSRF token, which lives only in memory.
+let csrfToken = "";
+
+export function setCsrfToken(token: string) {
+    csrfToken = token;
+}
+
 export interface StatsResult {
     pageviews: number;
     unique_visitors: number;
     sessions: number;
+    avg_engaged_ms: number;
+    sampling?: Sampling;
+}
+
+// Sampling is present when some events in the range were sampled at ingestion;
+// counts are then estimates scaled back up by each event's sample rate.
+export interface Sampling {
+    rate: number;
+    error_margin: number;
 }
 
 export interface StatsChange {
@@ -21,9 +46,20 @@ export interface SiteTrendResult {
     change: StatsChange;
 }
 
+export interface ScrollDepth {
+    samples: number;
+    reached_25: number;
+    reached_50: number;
+    reached_75: number;
+    reached_100: number;
+}
+
 export interface PageStat {
     url: string;
     pageviews: number;
+    visitors: number;
+    avg_engaged_ms: number;
+    scroll_depth: ScrollDepth;
 }
 
 export interface ReferrerStat {
@@ -31,6 +67,53 @@ export interface ReferrerStat {
     visitors: number;
 }
 
+export interface ClickStat {
+    signature: string;
+    pathname: string;
+    tag: string;
+    element_id: string;
+    text: string;
+    href: string;
+    clicks: number;
+    visitors: number;
+}
+
+export interface ClickTimeSeriesBucket {
+    date: string;
+    clicks: number;
+    visitors: number;
+}
+
+export interface SearchTermStat {
+    term: string;
+    searches: number;
+    visitors: number;
+    exits: number;
+    refinements: number;
+}
+
+export interface SiteSearchResult {
+    searches: number;
+    exits: number;
+    exit_rate: number;
+    refinement_rate: number;
+    terms: SearchTermStat[];
+    exit_terms: SearchTermStat[];
+}
+
+export interface LinkStat {
+    url: string;
+    clicks: number;
+    visitors: number;
+}
+
+export interface NotFoundStat {
+    url: string;
+    pageviews: number;
+    visitors: number;
+    referrers: ReferrerStat[];
+}
+
 export interface VitalStat {
     name: string;
     value: number;
@@ -88,10 +171,67 @@ export interface DeviceStat {
     count: number;
 }
 
+export interface PathRule {
+    pattern: string;
+    replacement?: string;
+}
+
+export interface ContentGroup {
+    name: string;
+    pattern: string;
+}
+
 export interface SiteStat {
     site_id: string;
     domain: string;
     domains: string[];
+    search_param?: string;
+    path_rules?: PathRule[];
+    content_groups?: ContentGroup[];
+    role?: SiteRole;
+}
+
+export type SiteRole = "owner" | "admin" | "viewer";
+
+export interface UserSession {
+    csrf_token: string;
+    expires_at: string;
+    user: {
+        id: string;
+        email: string;
+        name: string;
+        grants?: { site_id: string; role: SiteRole }[];
+    };
+}
+
+export interface ContentGroupStat {
+    group: string;
+    pageviews: number;
+    visitors: number;
+}
+
+export interface ShareLinkInfo {
+    id: string;
+    site_id: string;
+    name: string;
+    password_required: boolean;
+    from?: string;
+    to?: string;
+    expires_at?: string;
+}
+
+export class ShareError extends Error {
+    constructor(readonly status: number) {
+        super(`share link → ${status}`);
+    }
+}
+
+// AuthError reports a request that needs a signed-in user (401) or one the
+// user may not make (403).
+export class AuthError extends Error {
+    constructor(readonly status: number, path: string) {
+        super(`${path} → ${status}`);
+    }
 }
 
 function buildParams(siteId: string, from: string, to: string) {
@@ -102,11 +242,26 @@ function buildParams(siteId: string, from: string, to: string) {
 }
 
 async function get<T>(path: string, signal?: AbortSignal): Promise<T> {
-    const res = await fetch(BASE + path, { signal });
+    const headers: HeadersInit = shareToken ? { Authorization: `Bearer ${shareToken}` } : {};
+    const res = await fetch(BASE + path, { signal, headers });
+    if (res.status === 401 || res.status === 403) throw new AuthError(res.status, path);
     if (!res.ok) throw new Error(`${path} → ${res.status}`);
     return res.json();
 }
 
+async function send<T>(method: string, path: string, body?: unknown): Promise<T> {
+    const headers: Record<string, string> = { "Content-Type": "application/json" };
+    if (csrfToken) headers["X-CSRF-Token"] = csrfToken;
+    const res = await fetch(BASE + path, {
+        method,
+        headers,
+        body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    if (res.status === 401 || res.status === 403) throw new AuthError(res.status, path);
+    if (!res.ok) throw new Error(`${path} → ${res.status}`);
+    return res.status === 204 ? (undefined as T) : res.json();
+}
+
 export const api = {
     stats: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
         get<StatsResult>(`/api/stats?${buildParams(siteId, from, to)}`, signal),
@@ -114,12 +269,43 @@ export const api = {
     siteTrends: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
         get<SiteTrendResult>(`/api/site-trends?${buildParams(siteId, from, to)}`, signal),
 
-    pages: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
-        get<PageStat[]>(`/api/pages?${buildParams(siteId, from, to)}`, signal),
+    pages: (siteId: string, from: string, to: string, signal?: AbortSignal, contentGroup?: string) => {
+        const params = new URLSearchParams(buildParams(siteId, from, to));
+        if (contentGroup) params.set("content_group", contentGroup);
+        return get<PageStat[]>(`/api/pages?${params}`, signal);
+    },
+
+    contentGroups: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
+        get<ContentGroupStat[]>(`/api/content-groups?${buildParams(siteId, from, to)}`, signal),
 
     referrers: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
         get<ReferrerStat[]>(`/api/referrers?${buildParams(siteId, from, to)}`, signal),
 
+    clicks: (siteId: string, from: string, to: string, pathname?: string, signal?: AbortSignal) => {
+        const params = new URLSearchParams(buildParams(siteId, from, to));
+        if (pathname) params.set("pathname", pathname);
+        return get<ClickStat[]>(`/api/clicks?${params}`, signal);
+    },
+
+    clickTimeseries: (siteId: string, signature: string, from: string, to: string, pathname?: string, signal?: AbortSignal) => {
+        const params = new URLSearchParams(buildParams(siteId, from, to));
+        params.set("signature", signature);
+        if (pathname) params.set("pathname", pathname);
+        return get<ClickTimeSeriesBucket[]>(`/api/clicks/timeseries?${params}`, signal);
+    },
+
+    siteSearch: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
+        get<SiteSearchResult>(`/api/site-search?${buildParams(siteId, from, to)}`, signal),
+
+    outboundLinks: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
+        get<LinkStat[]>(`/api/outbound-links?${buildParams(siteId, from, to)}`, signal),
+
+    downloads: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
+        get<LinkStat[]>(`/api/downloads?${buildParams(siteId, from, to)}`, signal),
+
+    notFound: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
+        get<NotFoundStat[]>(`/api/not-found?${buildParams(siteId, from, to)}`, signal),
+
     vitals: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
         get<VitalStat[]>(`/api/vitals?${buildParams(siteId, from, to)}`, signal),
 
@@ -151,8 +337,41 @@ export const api = {
         get<{ date: string; uniqueVisitors: number }[]>(`/api/timeseries/visitors?${buildParams(siteId, from, to)}`, signal),
 
     sessionsTimeseries: (siteId: string, from: string, to: string, signal?: AbortSignal) =>
-        get<{ date: string; sessions: number }[]>(`/api/timeseries/sessions?${buildParams(siteId, from, to)}`, signal),
+        get<{ date: string; sessions: number; avgEngagedMs?: number }[]>(`/api/timeseries/sessions?${buildParams(siteId, from, to)}`, signal),
 
     sites: () =>
         get<SiteStat[]>(`/api/sites`),
+
+    me: () =>
+        get<UserSession>(`/api/auth/me`),
+
+    authMethods: () =>
+        get<{ password: boolean; sso: boolean }>(`/api/auth/methods`),
+
+    ssoLoginUrl: `${BASE}/api/auth/oidc/login`,
+
+    login: (email: string, password: string) =>
+        send<UserSession>("POST", `/api/auth/login`, { email, password }),
+
+    logout: () =>
+        send<void>("POST", `/api/auth/logout`),
+
+    acceptInvite: (token: string, name: string, password: string) =>
+        send<UserSession>("POST", `/api/invites/accept`, { token, name, password }),
+
+    shareInfo: async (slug: string) => {
+        const res = await fetch(`${BASE}/api/share?${new URLSearchParams({ slug })}`);
+        if (!res.ok) throw new ShareError(res.status);
+        return res.json() as Promise<ShareLinkInfo>;
+    },
+
+    unlockShare: async (slug: string, password: string) => {
+        const res = await fetch(`${BASE}/api/share`, {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ slug, password }),
+        });
+        if (!res.ok) throw new ShareError(res.status);
+        return res.json() as Promise<{ token: string; expires_at?: string }>;
+    },
 };
@@ -18,6 +18,14 @@ interface Props {
     onSiteChange: (siteId: string) => void;
     onPresetChange: (preset: PresetKey) => void;
     onRefresh: () => void;
+    // shareName is set when the dashboard is opened from a share link, which
+    // hides site management; rangeLocked hides the period picker.
+    shareName?: string;
+    rangeLocked?: boolean;
+    // user is the signed-in account; without one the dashboard runs on the
+    // admin token or public reads.
+    user?: { name: string; email: string };
+    onSignOut?: () => void;
 }
 
 const NAV_ITEMS: { view: DashboardView; label: string; icon: IconName }[] = [
@@ -39,6 +47,10 @@ export function DashboardShell({
     onSiteChange,
     onPresetChange,
     onRefresh,
+    shareName,
+    rangeLocked = false,
+    user,
+    onSignOut,
 }: Props) {
     const [mobileNavigationOpen, setMobileNavigationOpen] = useState(false);
 
@@ -61,7 +73,7 @@ export function DashboardShell({
                 </div>
 
                 <nav className="sidebar-nav" aria-label="Primary navigation">
-                    {NAV_ITEMS.map((item, index) => (
+                    {NAV_ITEMS.filter((item) => shareName === undefined || item.view !== "sites").map((item, index) => (
                         <button
                             className={view === item.view ? "active" : ""}
                             key={item.view}
@@ -79,13 +91,36 @@ export function DashboardShell({
                         <Icon name="settings" size={18} />
                         <span>Settings</span>
                     </button>
-                    <div className="profile">
-                        <div className="avatar">VP</div>
-                        <div>
-                            <strong>Admin User</strong>
-                            <small>Administrator</small>
+                    {shareName === undefined && user ? (
+                        <div className="profile">
+                            <div className="avatar">{initials(user.name || user.email)}</div>
+                            <div>
+                                <strong>{user.name || user.email}</strong>
+                                <small>{user.email}</small>
+                            </div>
+                            <button className="secondary-button" onClick={onSignOut} type="button">
+                                Sign out
+                            </button>
                         </div>
-                    </div>
+                    ) : shareName === undefined ? (
+                        <div className="profile">
+                            <div className="avatar">VP</div>
+                            <div>
+                                <strong>Admin User</strong>
+                                <small>Administrator</small>
+                            </div>
+                        </div>
+                    ) : (
+                        <div className="profile">
+                            <div className="avatar">
+                                <Icon name="globe" size={16} />
+                            </div>
+                            <div>
+                                <strong>{shareName || "Shared dashboard"}</strong>
+                                <small>Read-only view</small>
+                            </div>
+                        </div>
+                    )}
                 </div>
             </aside>
 
@@ -129,7 +164,7 @@ export function DashboardShell({
                             </label>
                         )}
 
-                        {view !== "sites" && sites.length > 0 && (
+                        {view !== "sites" && sites.length > 0 && !rangeLocked && (
                             <label className="select-shell period-select">
                                 <span className="sr-only">Date range</span>
                                 <Icon name="calendar" size={15} />
@@ -167,3 +202,12 @@ export function DashboardShell({
         </div>
     );
 }
+
+function initials(name: string) {
+    return name
+        .split(/[\s@.]+/)
+        .filter(Boolean)
+        .slice(0, 2)
+        .map((part) => part[0].toUpperCase())
+        .join("");
+}
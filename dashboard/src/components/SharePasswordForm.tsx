@@ -0,0 +1,51 @@
+import { FormEvent, useState } from "react";
+
+import { api, ShareError } from "../api";
+
+interface Props {
+    slug: string;
+    name: string;
+    onUnlock: (token: string, expiresAt?: string) => void;
+}
+
+export function SharePasswordForm({ slug, name, onUnlock }: Props) {
+    const [password, setPassword] = useState("");
+    const [error, setError] = useState("");
+    const [submitting, setSubmitting] = useState(false);
+
+    async function handleSubmit(event: FormEvent) {
+        event.preventDefault();
+        setSubmitting(true);
+        setError("");
+        try {
+            const access = await api.unlockShare(slug, password);
+            onUnlock(access.token, access.expires_at);
+        } catch (caught) {
+            setError(caught instanceof ShareError && caught.status === 401
+                ? "That password is not correct."
+                : "The shared dashboard could not be opened.");
+        } finally {
+            setSubmitting(false);
+        }
+    }
+
+    return (
+        <form className="page-state" onSubmit={handleSubmit}>
+            <strong>{name || "Shared dashboard"}</strong>
+            <p>This dashboard is password protected.</p>
+            <label className="search-field">
+                <span className="sr-only">Password</span>
+                <input
+                    autoFocus
+                    type="password"
+                    value={password}
+                    onChange={(event) => setPassword(event.target.value)}
+                />
+            </label>
+            {error && <p role="alert">{error}</p>}
+            <button className="secondary-button" disabled={submitting || !password} type="submit">
+                Open dashboard
+            </button>
+        </form>
+    );
+}
@@ -0,0 +1,104 @@
+import { FormEvent, useEffect, useState } from "react";
+
+import { api, AuthError, UserSession } from "../api";
+
+interface Props {
+    // inviteToken switches the form to accepting an invite, which asks new
+    // users for their name and a password.
+    inviteToken?: string;
+    onSignIn: (session: UserSession) => void;
+}
+
+export function SignInForm({ inviteToken, onSignIn }: Props) {
+    const [email, setEmail] = useState("");
+    const [name, setName] = useState("");
+    const [password, setPassword] = useState("");
+    const [error, setError] = useState("");
+    const [submitting, setSubmitting] = useState(false);
+    const [sso, setSso] = useState(false);
+
+    useEffect(() => {
+        if (inviteToken) return;
+        api.authMethods()
+            .then((methods) => setSso(methods.sso))
+            .catch(() => setSso(false));
+    }, [inviteToken]);
+
+    async function handleSubmit(event: FormEvent) {
+        event.preventDefault();
+        setSubmitting(true);
+        setError("");
+        try {
+            onSignIn(inviteToken
+                ? await api.acceptInvite(inviteToken, name, password)
+                : await api.login(email, password));
+        } catch (caught) {
+            setError(caught instanceof AuthError && caught.status === 401
+                ? inviteToken
+                    ? "That password does not match your existing account."
+                    : "That email or password is not correct."
+                : inviteToken
+                    ? "The invite could not be accepted. It may have expired, or the password is too short."
+                    : "Signing in failed. Try again.");
+        } finally {
+            setSubmitting(false);
+        }
+    }
+
+    return (
+        <form className="page-state" onSubmit={handleSubmit}>
+            <strong>{inviteToken ? "Accept your invite" : "Sign in to Iris"}</strong>
+            <p>
+                {inviteToken
+                    ? "Choose a name and a password of at least 10 characters. If you already have an account, enter its password."
+                    : "Use the email address your invite was sent to."}
+            </p>
+            {inviteToken ? (
+                <label className="search-field">
+                    <span className="sr-only">Name</span>
+                    <input
+                        autoFocus
+                        placeholder="Name"
+                        value={name}
+                        onChange={(event) => setName(event.target.value)}
+                    />
+                </label>
+            ) : (
+                <label className="search-field">
+                    <span className="sr-only">Email</span>
+                    <input
+                        autoFocus
+                        autoComplete="username"
+                        placeholder="Email"
+                        type="email"
+                        value={email}
+                        onChange={(event) => setEmail(event.target.value)}
+                    />
+                </label>
+            )}
+            <label className="search-field">
+                <span className="sr-only">Password</span>
+                <input
+                    autoComplete={inviteToken ? "new-password" : "current-password"}
+                    placeholder="Password"
+                    type="password"
+                    value={password}
+                    onChange={(event) => setPassword(event.target.value)}
+                />
+            </label>
+            {error && <p role="alert">{error}</p>}
+            <button
+                className="secondary-button"
+                disabled={submitting || !password || (!inviteToken && !email)}
+                type="submit"
+            >
+                {inviteToken ? "Join" : "Sign in"}
+            </button>
+            {sso && (
+                <a className="secondary-button" href={api.ssoLoginUrl}>
+                    Sign in with SSO
+                </a>
+            )}
+        </form>
+    );
+}
@@ -41,6 +41,12 @@ export function StatsCards({ stats, loading }: Props) {
                     </div>
                 </div>
             ))}
+            {!loading && stats?.sampling && (
+                <div className="stat-card-note">
+                    Sampled: {(stats.sampling.rate * 100).toFixed(1)}% of events stored, counts
+                    are estimates (±{(stats.sampling.error_margin * 100).toFixed(1)}%)
+                </div>
+            )}
         </div>
     );
 }
@@ -5,4 +5,5 @@ go 1.22
 require (
 	github.com/google/uuid v1.6.0
 	github.com/mattn/go-sqlite3 v1.14.33
+	golang.org/x/crypto v0.33.0
 )
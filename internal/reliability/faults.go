@@ -263,14 +263,21 @@ func runSQLiteDiskLimitFault(
 	defer diskServer.Stop()
 
 	loadConfig := faultLoadConfig(config, diskServer, "sqlite-disk-limit")
+	// Writes that hit the page limit are spooled rather than rejected. Restart
+	// without the limit before verification so the spool can be replayed.
+	var spooled int64
+	var recoveryErr error
+	loadConfig.BeforeVerify = func(ctx context.Context) error {
+		spooled, _ = spoolDepth(ctx, diskServer.URL())
+		stopErr := diskServer.Stop()
+		diskServer.Env = nil
+		recoveryErr = errors.Join(stopErr, diskServer.Start(ctx))
+		return recoveryErr
+	}
 	report, err := runRegisteredFaultLoad(ctx, diskServer, loadConfig)
-	stopErr := diskServer.Stop()
-	diskServer.Env = nil
-	restartErr := diskServer.Start(ctx)
-	recoveryErr := errors.Join(stopErr, restartErr)
 	recovered := recoveryErr == nil && serverHealthy(ctx, diskServer.URL())
 	result := finalizeFaultResult(config.OutputDir, "sqlite-disk-limit", report, err, recovered, recoveryErr)
-	if result.Passed && result.RejectedEvents == 0 && result.RequestErrors == 0 {
+	if result.Passed && spooled == 0 && result.RejectedEvents == 0 && result.RequestErrors == 0 {
 		result.Passed = false
 		result.Error = "disk limit did not produce a write failure"
 	}
@@ -14,6 +14,7 @@ var readEndpoints = []string{
 	"/api/stats",
 	"/api/pages",
 	"/api/referrers",
+	"/api/clicks",
 	"/api/vitals",
 	"/api/devices",
 	"/api/timeseries",
@@ -22,10 +23,11 @@ var readEndpoints = []string{
 }
 
 type readResult struct {
-	Endpoint string
-	Status   int
-	Latency  time.Duration
-	Err      error
+	Endpoint  string
+	Status    int
+	Latency   time.Duration
+	Err       error
+	RequestID string
 }
 
 func executeReadLoad(ctx context.Context, config Config) ReadSummary {
@@ -71,7 +73,7 @@ func executeReadLoad(ctx context.Context, config Config) ReadSummary {
 		if result.Err != nil {
 			summary.FailedRequests++
 			endpoint.Errors++
-			appendErrorSample(&summary.ErrorSamples, result.Err.Error())
+			appendErrorSample(&summary.ErrorSamples, fmt.Sprintf("%v [request %s]", result.Err, result.RequestID))
 		} else {
 			endpoint.StatusCodes[result.Status]++
 			if result.Status == http.StatusOK {
@@ -81,7 +83,7 @@ func executeReadLoad(ctx context.Context, config Config) ReadSummary {
 				endpoint.Errors++
 				appendErrorSample(
 					&summary.ErrorSamples,
-					fmt.Sprintf("%s returned HTTP %d", result.Endpoint, result.Status),
+					fmt.Sprintf("%s returned HTTP %d [request %s]", result.Endpoint, result.Status, result.RequestID),
 				)
 			}
 		}
@@ -131,16 +133,18 @@ func scheduleReads(ctx context.Context, rate int, jobs chan<- string) {
 func performRead(ctx context.Context, client *http.Client, config Config, endpoint string) readResult {
 	startedAt := time.Now()
 	requestURL := config.TargetURL + endpoint + "?site_id=" + url.QueryEscape(config.SiteID)
+	requestID := newRequestID("read")
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
 	if err != nil {
-		return readResult{Endpoint: endpoint, Err: err}
+		return readResult{Endpoint: endpoint, Err: err, RequestID: requestID}
 	}
+	request.Header.Set(requestIDHeader, requestID)
 	response, err := client.Do(request)
 	latency := time.Since(startedAt)
 	if err != nil {
-		return readResult{Endpoint: endpoint, Latency: latency, Err: err}
+		return readResult{Endpoint: endpoint, Latency: latency, Err: err, RequestID: requestID}
 	}
 	defer response.Body.Close()
 	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
-	return readResult{Endpoint: endpoint, Status: response.StatusCode, Latency: latency}
+	return readResult{Endpoint: endpoint, Status: response.StatusCode, Latency: latency, RequestID: requestID}
 }
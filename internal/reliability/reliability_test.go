@@ -73,9 +73,11 @@ func TestRunReconcilesSingleAndBatchIngestion(t *testing.T) {
 			mux := http.NewServeMux()
 			mux.HandleFunc("/api/event", handler.TrackEvent)
 			mux.HandleFunc("/api/events", handler.TrackBatchEvents)
+			mux.HandleFunc("/api/status", handler.Status)
 			mux.HandleFunc("/api/stats", handler.GetStats)
 			mux.HandleFunc("/api/pages", handler.GetPages)
 			mux.HandleFunc("/api/referrers", handler.GetReferrers)
+			mux.HandleFunc("/api/clicks", handler.GetClicks)
 			mux.HandleFunc("/api/vitals", handler.GetVitals)
 			mux.HandleFunc("/api/devices", handler.GetDevices)
 			mux.HandleFunc("/api/timeseries", handler.GetTimeSeries)
@@ -152,9 +154,11 @@ func TestRunMeasuresConcurrentAnalyticsReads(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/event", handler.TrackEvent)
 	mux.HandleFunc("/api/events", handler.TrackBatchEvents)
+	mux.HandleFunc("/api/status", handler.Status)
 	mux.HandleFunc("/api/stats", handler.GetStats)
 	mux.HandleFunc("/api/pages", handler.GetPages)
 	mux.HandleFunc("/api/referrers", handler.GetReferrers)
+	mux.HandleFunc("/api/clicks", handler.GetClicks)
 	mux.HandleFunc("/api/vitals", handler.GetVitals)
 	mux.HandleFunc("/api/devices", handler.GetDevices)
 	mux.HandleFunc("/api/timeseries", handler.GetTimeSeries)
@@ -208,9 +212,11 @@ func TestRunSupportsDeterministicRateStages(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/event", handler.TrackEvent)
 	mux.HandleFunc("/api/events", handler.TrackBatchEvents)
+	mux.HandleFunc("/api/status", handler.Status)
 	mux.HandleFunc("/api/stats", handler.GetStats)
 	mux.HandleFunc("/api/pages", handler.GetPages)
 	mux.HandleFunc("/api/referrers", handler.GetReferrers)
+	mux.HandleFunc("/api/clicks", handler.GetClicks)
 	mux.HandleFunc("/api/vitals", handler.GetVitals)
 	mux.HandleFunc("/api/devices", handler.GetDevices)
 	mux.HandleFunc("/api/timeseries", handler.GetTimeSeries)
@@ -386,6 +392,63 @@ func TestRunDetectsAcceptedEventsMissingFromStorage(t *testing.T) {
 	}
 }
 
+func TestRunAccountsForEventsShedByRateLimit(t *testing.T) {
+	originalLogWriter := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(originalLogWriter) })
+
+	dbPath := filepath.Join(t.TempDir(), "iris.db")
+	repo, err := db.NewSqliteDB(dbPath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	registerLabSite(t, repo, "rate-limited-site")
+
+	handler := api.NewHandler(repo)
+	handler.SetRateLimits(api.RateLimits{Site: api.RateLimit{Rate: 0.01, Burst: 30}}, api.RateLimits{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/events", handler.TrackBatchEvents)
+	mux.HandleFunc("/api/status", handler.Status)
+	mux.HandleFunc("/api/stats", handler.GetStats)
+	mux.HandleFunc("/api/pages", handler.GetPages)
+	mux.HandleFunc("/api/referrers", handler.GetReferrers)
+	mux.HandleFunc("/api/clicks", handler.GetClicks)
+	mux.HandleFunc("/api/vitals", handler.GetVitals)
+	mux.HandleFunc("/api/devices", handler.GetDevices)
+	mux.HandleFunc("/api/timeseries", handler.GetTimeSeries)
+	mux.HandleFunc("/api/timeseries/visitors", handler.GetUniqueVisitorsTimeSeries)
+	mux.HandleFunc("/api/timeseries/sessions", handler.GetSessionsTimeSeries)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	report, err := reliability.Run(context.Background(), reliability.Config{
+		TargetURL:      server.URL,
+		DBPath:         dbPath,
+		RunID:          "rate-limited",
+		SiteID:         "rate-limited-site",
+		Rate:           10_000,
+		EventCount:     100,
+		BatchSize:      10,
+		Workers:        1,
+		RequestTimeout: 2 * time.Second,
+		ExpectShedding: true,
+	})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if !report.Passed {
+		t.Fatalf("expected report to pass: %+v", report.Load)
+	}
+	if report.Load.AcceptedEvents != 30 || report.Load.ShedEvents != 70 {
+		t.Fatalf("accepted %d and shed %d events, want 30 and 70",
+			report.Load.AcceptedEvents, report.Load.ShedEvents)
+	}
+	if report.Storage.StoredRows != 30 || report.Storage.MissingEvents != 0 {
+		t.Fatalf("unexpected storage summary: %+v", report.Storage)
+	}
+}
+
 func TestRunRejectsNonLocalTargetByDefault(t *testing.T) {
 	_, err := reliability.Run(context.Background(), reliability.Config{
 		TargetURL:  "https://analytics.example.com",
@@ -397,3 +460,28 @@ func TestRunRejectsNonLocalTargetByDefault(t *testing.T) {
 		t.Fatal("expected non-local target error")
 	}
 }
+
+func TestResourceSamplerScrapesServerMetrics(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "iris.db")
+	repo, err := db.NewSqliteDB(dbPath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	registerLabSite(t, repo, "metrics-site")
+
+	handler := api.NewHandlerWithAdminToken(repo, "lab-token")
+	server := httptest.NewServer(http.HandlerFunc(handler.Metrics))
+	t.Cleanup(server.Close)
+
+	csvPath := filepath.Join(t.TempDir(), "resources.csv")
+	sampler := reliability.StartResourceSampler(server.URL, "lab-token", dbPath, csvPath, 20*time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	resources := sampler.Stop()
+	if resources.Samples == 0 || resources.PeakWALBytes == 0 || resources.DatabaseEndBytes == 0 {
+		t.Fatalf("resources = %+v, want samples scraped from /metrics", resources)
+	}
+	if _, err := os.Stat("/proc/self/statm"); err == nil && resources.PeakRSSBytes == 0 {
+		t.Fatalf("resources = %+v, want resident memory", resources)
+	}
+}
@@ -80,6 +80,8 @@ func markdownReport(report *Report) string {
 	fmt.Fprintf(&builder, "| Events rejected | %d |\n", report.Load.RejectedEvents)
 	fmt.Fprintf(&builder, "| Request errors | %d |\n", report.Load.RequestErrors)
 	fmt.Fprintf(&builder, "| Requests attempted | %d |\n", report.Load.AttemptedRequests)
+	fmt.Fprintf(&builder, "| Retries after HTTP 429 | %d |\n", report.Load.ThrottledRetries)
+	fmt.Fprintf(&builder, "| Events shed with HTTP 429 | %d |\n", report.Load.ShedEvents)
 	fmt.Fprintf(&builder, "| Achieved event rate | %.2f events/s |\n", report.Load.AchievedEventsPerSec)
 	fmt.Fprintf(&builder, "| Achieved request rate | %.2f requests/s |\n", report.Load.AchievedRequestsPerSec)
 	fmt.Fprintf(&builder, "| Maximum scheduling lag | %.2f ms |\n\n", report.Load.MaxScheduleLagMS)
@@ -1,10 +1,12 @@
 package reliability
 
 import (
+	"bufio"
 	"context"
 	"encoding/csv"
+	"io"
+	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -12,30 +14,47 @@ import (
 	"time"
 )
 
+// ResourceSampler scrapes the server's /metrics endpoint for process CPU,
+// resident memory, I/O and write-ahead log size.
 type ResourceSampler struct {
-	pid      int
-	dbPath   string
-	interval time.Duration
-	csvPath  string
-
-	cancel context.CancelFunc
-	done   chan struct{}
-	mu     sync.Mutex
-	result ResourceSummary
-	cpuSum float64
-	rssSum int64
+	metricsURL string
+	adminToken string
+	dbPath     string
+	interval   time.Duration
+	csvPath    string
+	client     *http.Client
+
+	cancel   context.CancelFunc
+	done     chan struct{}
+	mu       sync.Mutex
+	result   ResourceSummary
+	cpuSum   float64
+	rssSum   int64
+	previous *resourceScrape
+}
+
+// resourceScrape is one scrape of the metrics the sampler reads.
+type resourceScrape struct {
+	at         time.Time
+	cpuSeconds float64
+	rssBytes   int64
+	walBytes   int64
+	readBytes  int64
+	writeBytes int64
 }
 
-func StartResourceSampler(pid int, dbPath, csvPath string, interval time.Duration) *ResourceSampler {
+func StartResourceSampler(metricsURL, adminToken, dbPath, csvPath string, interval time.Duration) *ResourceSampler {
 	if interval <= 0 {
 		interval = time.Second
 	}
 	sampler := &ResourceSampler{
-		pid:      pid,
-		dbPath:   dbPath,
-		interval: interval,
-		csvPath:  csvPath,
-		done:     make(chan struct{}),
+		metricsURL: metricsURL,
+		adminToken: adminToken,
+		dbPath:     dbPath,
+		interval:   interval,
+		csvPath:    csvPath,
+		client:     &http.Client{Timeout: 5 * time.Second},
+		done:       make(chan struct{}),
 	}
 	sampler.result.DatabaseStartBytes = fileSize(dbPath)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -56,9 +75,10 @@ func (s *ResourceSampler) Stop() ResourceSummary {
 		s.result.AverageCPUPercent = s.cpuSum / float64(s.result.Samples)
 		s.result.AverageRSSBytes = s.rssSum / int64(s.result.Samples)
 	}
-	readBytes, writeBytes := processIO(s.pid)
-	s.result.ProcessReadBytes = readBytes
-	s.result.ProcessWriteBytes = writeBytes
+	if s.previous != nil {
+		s.result.ProcessReadBytes = s.previous.readBytes
+		s.result.ProcessWriteBytes = s.previous.writeBytes
+	}
 	return s.result
 }
 
@@ -87,7 +107,7 @@ func (s *ResourceSampler) run(ctx context.Context) {
 		defer writer.Flush()
 	}
 
-	s.sample(writer)
+	s.sample(ctx, writer)
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 	for {
@@ -95,94 +115,108 @@ func (s *ResourceSampler) run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			s.sample(writer)
+			s.sample(ctx, writer)
 		}
 	}
 }
 
-func (s *ResourceSampler) sample(writer *csv.Writer) {
-	cpu, rss, ok := processStats(s.pid)
+// sample scrapes the server once. CPU use is measured between two scrapes,
+// so the first scrape only sets the baseline.
+func (s *ResourceSampler) sample(ctx context.Context, writer *csv.Writer) {
+	scrape, ok := s.scrape(ctx)
 	if !ok {
 		return
 	}
-	databaseBytes := fileSize(s.dbPath)
-	walBytes := fileSize(s.dbPath + "-wal")
 
 	s.mu.Lock()
+	previous := s.previous
+	s.previous = scrape
+	if previous == nil {
+		s.mu.Unlock()
+		return
+	}
+	cpu := 0.0
+	if elapsed := scrape.at.Sub(previous.at).Seconds(); elapsed > 0 {
+		cpu = (scrape.cpuSeconds - previous.cpuSeconds) / elapsed * 100
+	}
 	s.result.Samples++
 	s.cpuSum += cpu
-	s.rssSum += rss
+	s.rssSum += scrape.rssBytes
 	if cpu > s.result.PeakCPUPercent {
 		s.result.PeakCPUPercent = cpu
 	}
-	if rss > s.result.PeakRSSBytes {
-		s.result.PeakRSSBytes = rss
+	if scrape.rssBytes > s.result.PeakRSSBytes {
+		s.result.PeakRSSBytes = scrape.rssBytes
 	}
-	if walBytes > s.result.PeakWALBytes {
-		s.result.PeakWALBytes = walBytes
+	if scrape.walBytes > s.result.PeakWALBytes {
+		s.result.PeakWALBytes = scrape.walBytes
 	}
 	s.mu.Unlock()
 
 	if writer != nil {
 		_ = writer.Write([]string{
-			time.Now().UTC().Format(time.RFC3339Nano),
+			scrape.at.UTC().Format(time.RFC3339Nano),
 			strconv.FormatFloat(cpu, 'f', 2, 64),
-			strconv.FormatInt(rss, 10),
-			strconv.FormatInt(databaseBytes, 10),
-			strconv.FormatInt(walBytes, 10),
+			strconv.FormatInt(scrape.rssBytes, 10),
+			strconv.FormatInt(fileSize(s.dbPath), 10),
+			strconv.FormatInt(scrape.walBytes, 10),
 		})
 		writer.Flush()
 	}
 }
 
-func processStats(pid int) (float64, int64, bool) {
-	output, err := exec.Command(
-		"ps",
-		"-p",
-		strconv.Itoa(pid),
-		"-o",
-		"%cpu=",
-		"-o",
-		"rss=",
-	).Output()
+func (s *ResourceSampler) scrape(ctx context.Context) (*resourceScrape, bool) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.metricsURL, nil)
 	if err != nil {
-		return 0, 0, false
-	}
-	fields := strings.Fields(string(output))
-	if len(fields) < 2 {
-		return 0, 0, false
+		return nil, false
 	}
-	cpu, err := strconv.ParseFloat(fields[0], 64)
+	request.Header.Set("Authorization", "Bearer "+s.adminToken)
+	response, err := s.client.Do(request)
 	if err != nil {
-		return 0, 0, false
+		return nil, false
 	}
-	rssKB, err := strconv.ParseInt(fields[1], 10, 64)
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, false
+	}
+	values, err := parseMetrics(response.Body)
 	if err != nil {
-		return 0, 0, false
+		return nil, false
 	}
-	return cpu, rssKB * 1024, true
+	cpuSeconds, ok := values["process_cpu_seconds_total"]
+	if !ok {
+		return nil, false
+	}
+	return &resourceScrape{
+		at:         time.Now(),
+		cpuSeconds: cpuSeconds,
+		rssBytes:   int64(values["process_resident_memory_bytes"]),
+		walBytes:   int64(values["iris_wal_bytes"]),
+		readBytes:  int64(values["process_io_read_bytes_total"]),
+		writeBytes: int64(values["process_io_write_bytes_total"]),
+	}, true
 }
 
-func processIO(pid int) (int64, int64) {
-	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "io"))
-	if err != nil {
-		return 0, 0
-	}
-	var readBytes, writeBytes int64
-	for _, line := range strings.Split(string(data), "\n") {
+// parseMetrics reads the unlabelled samples of a Prometheus text exposition.
+func parseMetrics(body io.Reader) (map[string]float64, error) {
+	values := map[string]float64{}
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "{") {
+			continue
+		}
 		fields := strings.Fields(line)
 		if len(fields) != 2 {
 			continue
 		}
-		value, _ := strconv.ParseInt(fields[1], 10, 64)
-		switch fields[0] {
-		case "read_bytes:":
-			readBytes = value
-		case "write_bytes:":
-			writeBytes = value
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
 		}
+		values[fields[0]] = value
 	}
-	return readBytes, writeBytes
+	return values, scanner.Err()
 }
 
 func fileSize(path string) int64 {
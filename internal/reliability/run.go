@@ -12,9 +12,13 @@ import (
 	"runtime"
 	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/google/uuid"
 )
 
 type requestJob struct {
@@ -30,9 +34,23 @@ type requestResult struct {
 	StageIndex  int
 	Latency     time.Duration
 	ScheduleLag time.Duration
-	Err         error
+	Throttled   int
+	// Shed counts events finally refused with HTTP 429.
+	Shed int
+	// Accepted lists the sequences the server stored: every sequence for a
+	// 202, or the per-event results of a partial batch.
+	Accepted []int
+	Err      error
+	// RequestID is the X-Request-ID of the last attempt, which the server
+	// logs with the request.
+	RequestID string
 }
 
+const (
+	maxThrottleRetries = 5
+	maxRetryAfter      = 2 * time.Second
+)
+
 type loadResult struct {
 	Summary  LoadSummary
 	Accepted map[int]struct{}
@@ -50,6 +68,16 @@ func Run(ctx context.Context, config Config) (*Report, error) {
 		return nil, err
 	}
 
+	if normalized.BeforeVerify != nil {
+		if err := normalized.BeforeVerify(ctx); err != nil {
+			return nil, fmt.Errorf("prepare verification: %w", err)
+		}
+	}
+	// Events accepted into the server's spool reach the database only after
+	// the drainer replays them.
+	if _, err := waitForSpoolDrain(ctx, normalized.TargetURL, 30*time.Second); err != nil {
+		return nil, fmt.Errorf("wait for spool replay: %w", err)
+	}
 	storage, err := VerifyStorage(ctx, normalized.DBPath, manifest, load.Accepted)
 	if err != nil {
 		return nil, fmt.Errorf("verify storage: %w", err)
@@ -71,6 +99,8 @@ func Run(ctx context.Context, config Config) (*Report, error) {
 			ReadRate:    normalized.ReadRate,
 			ReadWorkers: normalized.ReadWorkers,
 			Stages:      stageConfigs(normalized.Stages),
+
+			ExpectShedding: normalized.ExpectShedding,
 		},
 		Environment: currentEnvironment(),
 		Load:        load.Summary,
@@ -258,6 +288,8 @@ func executeLoad(ctx context.Context, config Config, manifest []PlannedEvent) (l
 		eventCount := len(result.Sequences)
 		summary.AttemptedEvents += eventCount
 		summary.AttemptedRequests++
+		summary.ThrottledRetries += result.Throttled
+		summary.ShedEvents += result.Shed
 		latencies = append(latencies, result.Latency)
 		if result.ScheduleLag.Seconds()*1000 > summary.MaxScheduleLagMS {
 			summary.MaxScheduleLagMS = result.ScheduleLag.Seconds() * 1000
@@ -280,7 +312,7 @@ func executeLoad(ctx context.Context, config Config, manifest []PlannedEvent) (l
 				stage.RequestErrors++
 				stage.RejectedEvents += eventCount
 			}
-			appendErrorSample(&summary.ErrorSamples, result.Err.Error())
+			appendErrorSample(&summary.ErrorSamples, fmt.Sprintf("%v [request %s]", result.Err, result.RequestID))
 			continue
 		}
 
@@ -288,10 +320,10 @@ func executeLoad(ctx context.Context, config Config, manifest []PlannedEvent) (l
 		if stage != nil {
 			stage.StatusCodes[result.Status]++
 		}
-		if result.Status == http.StatusAccepted {
+		if len(result.Accepted) == eventCount {
 			summary.AcceptedRequests++
 			summary.AcceptedEvents += eventCount
-			for _, sequence := range result.Sequences {
+			for _, sequence := range result.Accepted {
 				accepted[sequence] = struct{}{}
 			}
 			if stage != nil {
@@ -301,18 +333,24 @@ func executeLoad(ctx context.Context, config Config, manifest []PlannedEvent) (l
 		}
 
 		summary.RejectedRequests++
-		summary.RejectedEvents += eventCount
+		summary.AcceptedEvents += len(result.Accepted)
+		summary.RejectedEvents += eventCount - len(result.Accepted)
+		for _, sequence := range result.Accepted {
+			accepted[sequence] = struct{}{}
+		}
 		if stage != nil {
-			stage.RejectedEvents += eventCount
+			stage.AcceptedEvents += len(result.Accepted)
+			stage.RejectedEvents += eventCount - len(result.Accepted)
 		}
 		detail := strings.TrimSpace(result.Response)
 		if detail != "" {
 			appendErrorSample(
 				&summary.ErrorSamples,
-				fmt.Sprintf("HTTP %d for %d event(s): %s", result.Status, eventCount, detail),
+				fmt.Sprintf("HTTP %d for %d event(s) [request %s]: %s", result.Status, eventCount, result.RequestID, detail),
 			)
 		} else {
-			appendErrorSample(&summary.ErrorSamples, fmt.Sprintf("HTTP %d for %d event(s)", result.Status, eventCount))
+			appendErrorSample(&summary.ErrorSamples,
+				fmt.Sprintf("HTTP %d for %d event(s) [request %s]", result.Status, eventCount, result.RequestID))
 		}
 	}
 
@@ -343,6 +381,60 @@ func executeLoad(ctx context.Context, config Config, manifest []PlannedEvent) (l
 	return loadResult{Summary: summary, Accepted: accepted}, nil
 }
 
+// spoolDepth reads the number of accepted events waiting in the server's spool.
+func spoolDepth(ctx context.Context, baseURL string) (int64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/status", nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := (&http.Client{Timeout: 2 * time.Second}).Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		// Servers that predate the spool have nothing to replay.
+		return 0, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status returned HTTP %d", response.StatusCode)
+	}
+	var status struct {
+		SpoolDepth int64 `json:"spool_depth"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		return 0, err
+	}
+	return status.SpoolDepth, nil
+}
+
+// waitForSpoolDrain polls the server until its spool is empty and returns the
+// deepest spool it observed.
+func waitForSpoolDrain(ctx context.Context, baseURL string, timeout time.Duration) (int64, error) {
+	deadline := time.Now().Add(timeout)
+	var deepest int64
+	for {
+		depth, err := spoolDepth(ctx, baseURL)
+		if err == nil {
+			deepest = max(deepest, depth)
+			if depth == 0 {
+				return deepest, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return deepest, err
+			}
+			return deepest, fmt.Errorf("%d events still spooled after %s", depth, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return deepest, ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
 func sendRequest(ctx context.Context, client *http.Client, targetURL string, batchSize int, job requestJob) requestResult {
 	sequences := make([]int, len(job.Events))
 	events := make([]any, len(job.Events))
@@ -352,7 +444,7 @@ func sendRequest(ctx context.Context, client *http.Client, targetURL string, bat
 	}
 
 	var payload any = events
-	endpoint := "/api/events"
+	endpoint := "/api/events?partial=1"
 	if batchSize == 1 && len(job.Events) == 1 {
 		payload = job.Events[0].Event
 		endpoint = "/api/event"
@@ -363,39 +455,118 @@ func sendRequest(ctx context.Context, client *http.Client, targetURL string, bat
 		return requestResult{Sequences: sequences, StageIndex: job.StageIndex, Err: err}
 	}
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL+endpoint, bytes.NewReader(body))
+	sentAt := time.Now()
+	scheduleLag := sentAt.Sub(job.DueAt)
+	if scheduleLag < 0 {
+		scheduleLag = 0
+	}
+	result := requestResult{Sequences: sequences, StageIndex: job.StageIndex, ScheduleLag: scheduleLag}
+	for attempt := 0; ; attempt++ {
+		result.RequestID = newRequestID("ingest")
+		status, responseBody, retryAfter, err := postEvents(ctx, client, targetURL+endpoint, result.RequestID, body)
+		result.Latency = time.Since(sentAt)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		result.Status = status
+		result.Response = responseBody
+		result.Accepted = acceptedSequences(status, responseBody, sequences)
+		result.Shed = shedEvents(status, responseBody, len(sequences))
+		// A 429 is backpressure from the ingest queue, not a rejection, so the
+		// same payload is retried after the server's Retry-After hint.
+		if status != http.StatusTooManyRequests || attempt == maxThrottleRetries {
+			return result
+		}
+		result.Throttled++
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			result.Err = ctx.Err()
+			return result
+		case <-timer.C:
+		}
+	}
+}
+
+// acceptedSequences reconciles a response with the sequences it covered.
+func acceptedSequences(status int, responseBody string, sequences []int) []int {
+	if status == http.StatusAccepted {
+		return sequences
+	}
+	if status != http.StatusOK {
+		return nil
+	}
+	var batch core.BatchResult
+	if err := json.Unmarshal([]byte(responseBody), &batch); err != nil {
+		return nil
+	}
+	var accepted []int
+	for _, result := range batch.Results {
+		if result.Status == http.StatusAccepted && result.Index >= 0 && result.Index < len(sequences) {
+			accepted = append(accepted, sequences[result.Index])
+		}
+	}
+	return accepted
+}
+
+// shedEvents counts the events a response refused with HTTP 429.
+func shedEvents(status int, responseBody string, eventCount int) int {
+	if status == http.StatusTooManyRequests {
+		return eventCount
+	}
+	if status != http.StatusOK {
+		return 0
+	}
+	var batch core.BatchResult
+	if err := json.Unmarshal([]byte(responseBody), &batch); err != nil {
+		return 0
+	}
+	shed := 0
+	for _, result := range batch.Results {
+		if result.Status == http.StatusTooManyRequests {
+			shed++
+		}
+	}
+	return shed
+}
+
+func postEvents(
+	ctx context.Context,
+	client *http.Client,
+	targetURL, requestID string,
+	body []byte,
+) (int, string, time.Duration, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, bytes.NewReader(body))
 	if err != nil {
-		return requestResult{Sequences: sequences, StageIndex: job.StageIndex, Err: err}
+		return 0, "", 0, err
 	}
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("User-Agent", "iris-reliability-lab")
+	request.Header.Set(requestIDHeader, requestID)
 
-	sentAt := time.Now()
 	response, err := client.Do(request)
-	latency := time.Since(sentAt)
-	scheduleLag := sentAt.Sub(job.DueAt)
-	if scheduleLag < 0 {
-		scheduleLag = 0
-	}
 	if err != nil {
-		return requestResult{
-			Sequences:   sequences,
-			StageIndex:  job.StageIndex,
-			Latency:     latency,
-			ScheduleLag: scheduleLag,
-			Err:         err,
-		}
+		return 0, "", 0, err
 	}
 	defer response.Body.Close()
 	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
-	return requestResult{
-		Sequences:   sequences,
-		Status:      response.StatusCode,
-		Response:    string(responseBody),
-		StageIndex:  job.StageIndex,
-		Latency:     latency,
-		ScheduleLag: scheduleLag,
+	retryAfter := 100 * time.Millisecond
+	if seconds, parseErr := strconv.Atoi(response.Header.Get("Retry-After")); parseErr == nil && seconds >= 0 {
+		retryAfter = min(time.Duration(seconds)*time.Second, maxRetryAfter)
 	}
+	return response.StatusCode, string(responseBody), retryAfter, nil
+}
+
+// requestIDHeader carries the lab's request IDs; the server logs it with the
+// request and echoes it in the response.
+const requestIDHeader = "X-Request-ID"
+
+// newRequestID returns an X-Request-ID that marks a request as the lab's, so
+// that error samples can be matched with server log records.
+func newRequestID(kind string) string {
+	return "lab-" + kind + "-" + uuid.NewString()
 }
 
 func stageForOffset(config Config, eventOffset, eventCount int) (int, int) {
@@ -504,8 +675,15 @@ func currentEnvironment() Environment {
 }
 
 func reportPasses(report *Report) bool {
+	delivered := report.Load.AcceptedEvents == report.Load.AttemptedEvents
+	if report.Config.ExpectShedding {
+		// Shedding is the point of the run, but only 429s may account for
+		// events that were not accepted.
+		delivered = report.Load.ShedEvents > 0 && report.Load.AcceptedEvents > 0 &&
+			report.Load.AcceptedEvents+report.Load.ShedEvents == report.Load.AttemptedEvents
+	}
 	if report.Load.AttemptedEvents != report.Load.PlannedEvents ||
-		report.Load.AcceptedEvents != report.Load.AttemptedEvents ||
+		!delivered ||
 		report.Load.RequestErrors != 0 ||
 		report.Storage.MissingEvents != 0 ||
 		report.Storage.DuplicateRows != 0 ||
@@ -36,6 +36,13 @@ type LoadProfile struct {
 	ReadRate    int
 	ReadWorkers int
 	Stages      []RateStage
+	// MaxP95MS fails the profile when ingest p95 latency exceeds it. Zero
+	// leaves latency unchecked.
+	MaxP95MS float64
+	// Env is added to the isolated server's environment.
+	Env []string
+	// ExpectShedding marks a profile whose server rate limits ingestion.
+	ExpectShedding bool
 }
 
 type SuiteProfileResult struct {
@@ -96,6 +103,7 @@ func RunSuite(ctx context.Context, config SuiteConfig) (*SuiteReport, error) {
 			WorkDir: filepath.Join(profileDir, "server"),
 			DBPath:  filepath.Join(profileDir, "server", "iris.db"),
 			LogPath: filepath.Join(profileDir, "server.log"),
+			Env:     profile.Env,
 		}
 		if err := server.Start(ctx); err != nil {
 			suite.Profiles = append(suite.Profiles, SuiteProfileResult{
@@ -168,13 +176,21 @@ func normalizeSuiteConfig(config SuiteConfig) (SuiteConfig, []LoadProfile, error
 	return config, selected, nil
 }
 
+// ingestP95BudgetMS is the ingest p95 latency the sustained-target and spike
+// profiles must hold while every event is still committed.
+const ingestP95BudgetMS = 250
+
+// rateLimitProfileEnv holds the lab client, which sends from one IP, to a
+// fifth of the rate-limit profile's offered load.
+const rateLimitProfileEnv = "IRIS_INGEST_RATE_LIMITS=ip=100:200"
+
 func suiteProfiles(quick bool) map[string]LoadProfile {
 	if quick {
 		return map[string]LoadProfile{
 			"smoke":       {Name: "smoke", Rate: 10, Duration: 2 * time.Second, BatchSize: 1, Workers: 8},
 			"baseline":    {Name: "baseline", Rate: 100, Duration: 3 * time.Second, BatchSize: 1, Workers: 16},
 			"target-500":  {Name: "target-500", Rate: 500, Duration: 5 * time.Second, BatchSize: 1, Workers: 64},
-			"target-1000": {Name: "target-1000", Rate: 1000, Duration: 5 * time.Second, BatchSize: 10, Workers: 64},
+			"target-1000": {Name: "target-1000", Rate: 1000, Duration: 5 * time.Second, BatchSize: 10, Workers: 64, MaxP95MS: ingestP95BudgetMS},
 			"mixed":       {Name: "mixed", Rate: 500, Duration: 5 * time.Second, BatchSize: 10, Workers: 64, ReadRate: 25, ReadWorkers: 8},
 			"ramp": {
 				Name: "ramp", BatchSize: 10, Workers: 96,
@@ -186,7 +202,7 @@ func suiteProfiles(quick bool) map[string]LoadProfile {
 				},
 			},
 			"spike": {
-				Name: "spike", BatchSize: 10, Workers: 96,
+				Name: "spike", BatchSize: 10, Workers: 96, MaxP95MS: ingestP95BudgetMS,
 				Stages: []RateStage{
 					{Rate: 100, Duration: time.Second},
 					{Rate: 2000, Duration: 2 * time.Second},
@@ -194,6 +210,10 @@ func suiteProfiles(quick bool) map[string]LoadProfile {
 				},
 			},
 			"soak": {Name: "soak", Rate: 250, Duration: 10 * time.Second, BatchSize: 10, Workers: 32, ReadRate: 10, ReadWorkers: 4},
+			"rate-limit": {
+				Name: "rate-limit", Rate: 500, Duration: 5 * time.Second, BatchSize: 10, Workers: 32,
+				Env: []string{rateLimitProfileEnv}, ExpectShedding: true,
+			},
 		}
 	}
 
@@ -201,7 +221,7 @@ func suiteProfiles(quick bool) map[string]LoadProfile {
 		"smoke":       {Name: "smoke", Rate: 10, Duration: 30 * time.Second, BatchSize: 1, Workers: 16},
 		"baseline":    {Name: "baseline", Rate: 100, Duration: 2 * time.Minute, BatchSize: 1, Workers: 32},
 		"target-500":  {Name: "target-500", Rate: 500, Duration: 5 * time.Minute, BatchSize: 1, Workers: 128},
-		"target-1000": {Name: "target-1000", Rate: 1000, Duration: 5 * time.Minute, BatchSize: 10, Workers: 128},
+		"target-1000": {Name: "target-1000", Rate: 1000, Duration: 5 * time.Minute, BatchSize: 10, Workers: 128, MaxP95MS: ingestP95BudgetMS},
 		"mixed":       {Name: "mixed", Rate: 500, Duration: 5 * time.Minute, BatchSize: 10, Workers: 128, ReadRate: 25, ReadWorkers: 16},
 		"ramp": {
 			Name: "ramp", BatchSize: 10, Workers: 192,
@@ -213,7 +233,7 @@ func suiteProfiles(quick bool) map[string]LoadProfile {
 			},
 		},
 		"spike": {
-			Name: "spike", BatchSize: 10, Workers: 192,
+			Name: "spike", BatchSize: 10, Workers: 192, MaxP95MS: ingestP95BudgetMS,
 			Stages: []RateStage{
 				{Rate: 100, Duration: 10 * time.Second},
 				{Rate: 2000, Duration: 30 * time.Second},
@@ -221,6 +241,10 @@ func suiteProfiles(quick bool) map[string]LoadProfile {
 			},
 		},
 		"soak": {Name: "soak", Rate: 250, Duration: 30 * time.Minute, BatchSize: 10, Workers: 64, ReadRate: 10, ReadWorkers: 8},
+		"rate-limit": {
+			Name: "rate-limit", Rate: 500, Duration: 2 * time.Minute, BatchSize: 10, Workers: 64,
+			Env: []string{rateLimitProfileEnv}, ExpectShedding: true,
+		},
 	}
 }
 
@@ -247,6 +271,7 @@ func runSuiteProfile(
 		ReadWorkers:    profile.ReadWorkers,
 		RequestTimeout: 10 * time.Second,
 		Stages:         profile.Stages,
+		ExpectShedding: profile.ExpectShedding,
 	}
 	if err := server.RegisterSite(ctx, config.SiteID); err != nil {
 		return SuiteProfileResult{
@@ -256,7 +281,8 @@ func runSuiteProfile(
 	}
 
 	sampler := StartResourceSampler(
-		server.PID(),
+		server.URL()+"/metrics",
+		server.adminToken(),
 		server.DBPath,
 		filepath.Join(profileDir, "resources.csv"),
 		500*time.Millisecond,
@@ -300,6 +326,10 @@ func runSuiteProfile(
 	result.Events = report.Load.AcceptedEvents
 	result.EventRate = report.Load.AchievedEventsPerSec
 	result.P95MS = report.Load.Latency.P95MS
+	if profile.MaxP95MS > 0 && result.P95MS > profile.MaxP95MS {
+		result.Passed = false
+		result.Error = fmt.Sprintf("ingest p95 %.2f ms exceeds %.0f ms budget", result.P95MS, profile.MaxP95MS)
+	}
 	result.PeakCPU = report.Resources.PeakCPUPercent
 	result.PeakRSS = report.Resources.PeakRSSBytes
 	return result
@@ -336,10 +366,7 @@ func (s *LabServer) Start(ctx context.Context) error {
 		s.logFile = logFile
 	}
 
-	adminToken := s.AdminToken
-	if adminToken == "" {
-		adminToken = defaultAdminToken
-	}
+	adminToken := s.adminToken()
 	command := exec.Command(s.Binary)
 	command.Dir = s.WorkDir
 	command.Env = append(os.Environ(), s.Env...)
@@ -373,10 +400,7 @@ func (s *LabServer) Start(ctx context.Context) error {
 }
 
 func (s *LabServer) RegisterSite(ctx context.Context, siteID string) error {
-	adminToken := s.AdminToken
-	if adminToken == "" {
-		adminToken = defaultAdminToken
-	}
+	adminToken := s.adminToken()
 	payload, err := json.Marshal(struct {
 		SiteID        string   `json:"site_id"`
 		Name          string   `json:"name"`
@@ -454,6 +478,14 @@ func (s *LabServer) PID() int {
 	return s.command.Process.Pid
 }
 
+// adminToken is the admin bearer token the server was started with.
+func (s *LabServer) adminToken() string {
+	if s.AdminToken == "" {
+		return defaultAdminToken
+	}
+	return s.AdminToken
+}
+
 func (s *LabServer) URL() string {
 	return "http://127.0.0.1:" + strconv.Itoa(s.Port)
 }
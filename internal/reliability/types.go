@@ -1,6 +1,7 @@
 package reliability
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"time"
@@ -34,6 +35,13 @@ type Config struct {
 	ReadWorkers    int
 	Stages         []RateStage
 	AllowNonLocal  bool
+	// ExpectShedding declares that the target rate limits ingestion. Events
+	// still refused with HTTP 429 after retries count as shed rather than
+	// lost, and the run fails unless some were shed.
+	ExpectShedding bool
+	// BeforeVerify runs after the load completes and before storage is
+	// verified, for scenarios that must restore the server first.
+	BeforeVerify func(context.Context) error
 }
 
 type RateStage struct {
@@ -64,6 +72,8 @@ type RunConfig struct {
 	ReadRate    int               `json:"read_requests_per_second"`
 	ReadWorkers int               `json:"read_workers"`
 	Stages      []RateStageConfig `json:"stages,omitempty"`
+	// ExpectShedding is set when the target was expected to rate limit.
+	ExpectShedding bool `json:"expect_shedding,omitempty"`
 }
 
 type LatencySummary struct {
@@ -84,6 +94,8 @@ type LoadSummary struct {
 	AttemptedRequests      int            `json:"attempted_requests"`
 	AcceptedRequests       int            `json:"accepted_requests"`
 	RejectedRequests       int            `json:"rejected_requests"`
+	ThrottledRetries       int            `json:"throttled_retries"`
+	ShedEvents             int            `json:"shed_events"`
 	StatusCodes            map[int]int    `json:"status_codes"`
 	ErrorSamples           []string       `json:"error_samples,omitempty"`
 	ElapsedSeconds         float64        `json:"elapsed_seconds"`
@@ -206,10 +206,11 @@ func appendIntSample(samples *[]int, value int) {
 
 func VerifyAggregates(ctx context.Context, config Config, manifest []PlannedEvent, accepted map[int]struct{}) []AggregateCheck {
 	expectedStats := core.StatsResult{}
-	expectedPages := map[string]int{}
+	expectedPages := map[string]*pageTally{}
 	expectedDevices := map[string]int{}
 	expectedReferrers := map[string]map[string]struct{}{}
 	expectedVitals := map[string][]float64{}
+	expectedClicks := map[[2]string]*clickTally{}
 	visitors := map[string]struct{}{}
 	sessions := map[string]struct{}{}
 
@@ -223,13 +224,9 @@ func VerifyAggregates(ctx context.Context, config Config, manifest []PlannedEven
 				expectedVitals[name] = append(expectedVitals[name], value)
 			}
 		}
-		if planned.Event.EventName != "$pageview" {
+		if planned.Event.EventName != "$pageview" && planned.Event.EventName != "$click" {
 			continue
 		}
-
-		expectedStats.Pageviews++
-		visitors[planned.Event.VisitorID] = struct{}{}
-		sessions[planned.Event.SessionID] = struct{}{}
 		pathname := planned.Event.Pathname
 		if pathname == "" {
 			if parsed, err := url.Parse(planned.Event.URL); err == nil {
@@ -239,7 +236,27 @@ func VerifyAggregates(ctx context.Context, config Config, manifest []PlannedEven
 				pathname = "/"
 			}
 		}
-		expectedPages[pathname]++
+		if planned.Event.EventName == "$click" {
+			key := [2]string{pathname, core.ElementSignature(planned.Event.Properties)}
+			if expectedClicks[key] == nil {
+				expectedClicks[key] = &clickTally{
+					properties: planned.Event.Properties,
+					visitors:   map[string]struct{}{},
+				}
+			}
+			expectedClicks[key].clicks++
+			expectedClicks[key].visitors[planned.Event.VisitorID] = struct{}{}
+			continue
+		}
+
+		expectedStats.Pageviews++
+		visitors[planned.Event.VisitorID] = struct{}{}
+		sessions[planned.Event.SessionID] = struct{}{}
+		if expectedPages[pathname] == nil {
+			expectedPages[pathname] = &pageTally{visitors: map[string]struct{}{}}
+		}
+		expectedPages[pathname].pageviews++
+		expectedPages[pathname].visitors[planned.Event.VisitorID] = struct{}{}
 		expectedDevices[deviceForWidth(planned.Event.ScreenWidth)]++
 		host := normalizeLabReferrer(planned.Event.Referrer)
 		if host != "" {
@@ -258,6 +275,7 @@ func VerifyAggregates(ctx context.Context, config Config, manifest []PlannedEven
 		verifyJSONAggregate(ctx, config, "referrers", "/api/referrers", referrerStats(expectedReferrers)),
 		verifyJSONAggregate(ctx, config, "vitals", "/api/vitals", vitalStats(expectedVitals)),
 		verifyJSONAggregate(ctx, config, "devices", "/api/devices", deviceStats(expectedDevices)),
+		verifyJSONAggregate(ctx, config, "clicks", "/api/clicks", clickStats(expectedClicks)),
 	}
 	pageviews, uniqueVisitors, sessionSeries, err := expectedTimeSeries(ctx, config)
 	if err != nil {
@@ -304,6 +322,8 @@ func verifyJSONAggregate(ctx context.Context, config Config, name, path string,
 		decodeTarget = &[]core.ReferrerStat{}
 	case []core.VitalStat:
 		decodeTarget = &[]core.VitalStat{}
+	case []core.ClickStat:
+		decodeTarget = &[]core.ClickStat{}
 	case []core.TimeSeriesBucket:
 		decodeTarget = &[]core.TimeSeriesBucket{}
 	default:
@@ -355,6 +375,10 @@ func canonicalAggregate(value any) any {
 		result := append([]core.VitalStat(nil), (*typed)...)
 		sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
 		return result
+	case *[]core.ClickStat:
+		result := append([]core.ClickStat(nil), (*typed)...)
+		sortClickStats(result)
+		return result
 	case *[]core.TimeSeriesBucket:
 		result := append([]core.TimeSeriesBucket(nil), (*typed)...)
 		sort.Slice(result, func(i, j int) bool { return result[i].Date < result[j].Date })
@@ -364,10 +388,19 @@ func canonicalAggregate(value any) any {
 	}
 }
 
-func pageStats(counts map[string]int) []core.PageStat {
-	result := make([]core.PageStat, 0, len(counts))
-	for page, count := range counts {
-		result = append(result, core.PageStat{URL: page, Pageviews: count})
+type pageTally struct {
+	pageviews int
+	visitors  map[string]struct{}
+}
+
+func pageStats(tallies map[string]*pageTally) []core.PageStat {
+	result := make([]core.PageStat, 0, len(tallies))
+	for page, tally := range tallies {
+		result = append(result, core.PageStat{
+			URL:       page,
+			Pageviews: tally.pageviews,
+			Visitors:  len(tally.visitors),
+		})
 	}
 	sort.Slice(result, func(i, j int) bool {
 		if result[i].Pageviews == result[j].Pageviews {
@@ -378,6 +411,42 @@ func pageStats(counts map[string]int) []core.PageStat {
 	return result
 }
 
+type clickTally struct {
+	properties map[string]any
+	clicks     int
+	visitors   map[string]struct{}
+}
+
+func clickStats(tallies map[[2]string]*clickTally) []core.ClickStat {
+	result := make([]core.ClickStat, 0, len(tallies))
+	for key, tally := range tallies {
+		result = append(result, core.ClickStat{
+			Signature: key[1],
+			Pathname:  key[0],
+			Tag:       propertyString(tally.properties, "$tag"),
+			ElementID: propertyString(tally.properties, "$id"),
+			Text:      propertyString(tally.properties, "$text"),
+			Href:      propertyString(tally.properties, "$href"),
+			Clicks:    tally.clicks,
+			Visitors:  len(tally.visitors),
+		})
+	}
+	sortClickStats(result)
+	return result
+}
+
+func sortClickStats(result []core.ClickStat) {
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Clicks != result[j].Clicks {
+			return result[i].Clicks > result[j].Clicks
+		}
+		if result[i].Pathname != result[j].Pathname {
+			return result[i].Pathname < result[j].Pathname
+		}
+		return result[i].Signature < result[j].Signature
+	})
+}
+
 func deviceStats(counts map[string]int) []core.DeviceStat {
 	result := make([]core.DeviceStat, 0, len(counts))
 	for device, count := range counts {
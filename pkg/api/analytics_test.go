@@ -1,8 +1,12 @@
 package api
 
 import (
+	"fmt"
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
 )
 
 func TestPreviousPeriodMatchesCurrentDuration(t *testing.T) {
@@ -39,3 +43,123 @@ func TestPercentChange(t *testing.T) {
 		t.Fatalf("percentChange with empty periods returned %v, want 0", got)
 	}
 }
+
+func TestApplyBreakdown_FoldsSmallRowsIntoOther(t *testing.T) {
+	rows := []core.LinkStat{
+		{URL: "https://docs.example/", Clicks: 40, Visitors: 12},
+		{URL: "https://intranet.corp/", Clicks: 3, Visitors: 1},
+		{URL: "https://partner.example/", Clicks: 6, Visitors: 2},
+	}
+	got := applyBreakdown(breakdownPolicy{minCount: 5}, rows, linkBreakdown)
+	if len(got) != 2 || got[0].URL != "https://docs.example/" {
+		t.Fatalf("unexpected rows: %+v", got)
+	}
+	if got[1] != (core.LinkStat{URL: OtherBreakdownLabel, Clicks: 9, Visitors: 3}) {
+		t.Fatalf("other row = %+v, want the folded totals", got[1])
+	}
+
+	unchanged := applyBreakdown(breakdownPolicy{}, []core.LinkStat{{URL: "a", Visitors: 1}}, linkBreakdown)
+	if len(unchanged) != 1 || unchanged[0].URL != "a" {
+		t.Fatalf("rows without a policy = %+v, want them unchanged", unchanged)
+	}
+}
+
+func TestApplyBreakdown_NoiseModeDropsRowsWithoutOther(t *testing.T) {
+	rows := []core.ReferrerStat{
+		{Referrer: "small.example", Visitors: 2},
+		{Referrer: "search.example", Visitors: 30},
+	}
+	// A very large epsilon keeps the noise far below one visitor.
+	got := applyBreakdown(breakdownPolicy{minCount: 5, epsilon: 1e9}, rows, referrerBreakdown)
+	if len(got) != 1 || got[0] != (core.ReferrerStat{Referrer: "search.example", Visitors: 30}) {
+		t.Fatalf("unexpected rows: %+v", got)
+	}
+
+	var total float64
+	policy := breakdownPolicy{epsilon: 0.5, noiseKey: []byte("key")}
+	for index := range 20000 {
+		total += policy.noise(fmt.Sprint(index), 0)
+	}
+	if mean := total / 20000; mean < -0.2 || mean > 0.2 {
+		t.Fatalf("laplace noise mean = %v, want close to zero", mean)
+	}
+}
+
+func TestApplyBreakdown_NoiseIsStableForTheSameWindow(t *testing.T) {
+	rows := func() []core.ReferrerStat {
+		return []core.ReferrerStat{{Referrer: "a.example", Visitors: 40}, {Referrer: "b.example", Visitors: 60}}
+	}
+	policy := breakdownPolicy{epsilon: 0.1, noiseKey: noiseMAC([]byte("secret"), "GetReferrers", "site-a", "2026-07-01", "2026-07-07")}
+	first := applyBreakdown(policy, rows(), referrerBreakdown)
+	for range 5 {
+		if again := applyBreakdown(policy, rows(), referrerBreakdown); !slices.Equal(first, again) {
+			t.Fatalf("repeated query = %+v, want %+v", again, first)
+		}
+	}
+	other := policy
+	other.noiseKey = noiseMAC([]byte("secret"), "GetReferrers", "site-a", "2026-07-01", "2026-07-08")
+	if slices.Equal(first, applyBreakdown(other, rows(), referrerBreakdown)) {
+		t.Fatalf("another window returned the same noisy rows %+v", first)
+	}
+}
+
+func TestNoiseWindow_NormalizesEquivalentBounds(t *testing.T) {
+	site := &core.Site{ID: "site-a", Timezone: "UTC"}
+	now := time.Date(2026, 7, 7, 15, 0, 0, 0, time.UTC)
+	from, to := noiseWindow(site, "2026-07-01", "", now)
+	for _, window := range [][2]string{
+		{"2026-07-01T00:00:00Z", "2026-07-07"},
+		{"2026-07-01 00:00:00", "2026-07-09"},
+		{"2026-07-01", "2026-07-07T23:59:59.999999Z"},
+	} {
+		if gotFrom, gotTo := noiseWindow(site, window[0], window[1], now); gotFrom != from || gotTo != to {
+			t.Fatalf("noiseWindow(%v) = (%s, %s), want (%s, %s)", window, gotFrom, gotTo, from, to)
+		}
+	}
+	if gotFrom, _ := noiseWindow(site, "2026-07-02", "", now); gotFrom == from {
+		t.Fatalf("another start has the same noise window %s", from)
+	}
+}
+
+func TestApplyBreakdown_PagesNeedVisitorsAndHideAveragesOfLiftedRows(t *testing.T) {
+	reloaded := core.PageStat{URL: "/reloaded", Pageviews: 50, Visitors: 1, AvgEngagedMS: 9000}
+	got := applyBreakdown(breakdownPolicy{minCount: 5}, []core.PageStat{reloaded}, pageBreakdown)
+	if len(got) != 1 || got[0].URL != OtherBreakdownLabel || got[0].AvgEngagedMS != 0 {
+		t.Fatalf("rows = %+v, want one visitor's reloads folded into Other", got)
+	}
+
+	small := core.PageStat{URL: "/small", Pageviews: 4, Visitors: 4, AvgEngagedMS: 9000,
+		ScrollDepth: core.ScrollDepth{Samples: 4, Reached25: 4}}
+	large := core.PageStat{URL: "/large", Pageviews: 90, Visitors: 60, AvgEngagedMS: 7000}
+	lifted := false
+	for index := 0; index < 200 && !lifted; index++ {
+		policy := breakdownPolicy{minCount: 5, epsilon: 0.5, noiseKey: []byte(fmt.Sprint("key-", index))}
+		for _, row := range applyBreakdown(policy, []core.PageStat{small, large}, pageBreakdown) {
+			switch row.URL {
+			case "/small":
+				lifted = true
+				if row.AvgEngagedMS != 0 || row.ScrollDepth != (core.ScrollDepth{}) {
+					t.Fatalf("row lifted over the minimum by noise = %+v, want its averages hidden", row)
+				}
+			case "/large":
+				if row.AvgEngagedMS != 7000 {
+					t.Fatalf("large row = %+v, want its average kept", row)
+				}
+			}
+		}
+	}
+	if !lifted {
+		t.Fatal("noise never lifted the small row over the minimum")
+	}
+}
+
+func TestApplySeries_SuppressesSmallSeries(t *testing.T) {
+	points := []core.CustomEventTimeSeriesBucket{{Date: "2026-07-01", Count: 2}, {Date: "2026-07-02", Count: 1}}
+	if got := applySeries(breakdownPolicy{minCount: 5}, points, customEventSeries); len(got) != 0 {
+		t.Fatalf("series below the minimum = %+v, want it empty", got)
+	}
+	points = []core.CustomEventTimeSeriesBucket{{Date: "2026-07-01", Count: 4}, {Date: "2026-07-02", Count: 1}}
+	if got := applySeries(breakdownPolicy{minCount: 5}, points, customEventSeries); len(got) != 2 || got[0].Count != 4 {
+		t.Fatalf("series at the minimum = %+v, want it unchanged", got)
+	}
+}
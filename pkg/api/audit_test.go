@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
+
+func TestAudit_RecordsWhoChangedASite(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	handler := NewHandlerWithAdminToken(repo, "test-admin-token")
+	serve := func(route http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.RemoteAddr = "198.51.100.7:4321"
+		request.Header.Set("Authorization", "Bearer test-admin-token")
+		response := httptest.NewRecorder()
+		route(response, request)
+		return response
+	}
+
+	for _, body := range []string{
+		`{"site_id":"blog","domains":["blog.example.com"]}`,
+		`{"site_id":"blog","domains":["blog.example.com"],"retention_days":30}`,
+		`{"site_id":"docs","domains":["docs.example.com"]}`,
+	} {
+		if response := serve(handler.Sites, http.MethodPost, "/api/sites", body); response.Code != http.StatusCreated {
+			t.Fatalf("save site status = %d; body=%s", response.Code, response.Body.String())
+		}
+	}
+	created := serve(handler.IngestKeys, http.MethodPost, "/api/ingest-keys", `{"site_id":"blog","name":"backend"}`)
+	var key core.IngestKey
+	if err := json.NewDecoder(created.Body).Decode(&key); err != nil || key.Key == "" {
+		t.Fatalf("create ingest key = %+v, %v", key, err)
+	}
+
+	listed := serve(handler.Audit, http.MethodGet, "/api/audit?site_id=blog&action=site.update", "")
+	var page core.AuditPage
+	if err := json.NewDecoder(listed.Body).Decode(&page); err != nil || listed.Code != http.StatusOK {
+		t.Fatalf("audit status = %d, %v", listed.Code, err)
+	}
+	if len(page.Entries) != 1 {
+		t.Fatalf("site.update entries = %+v, want one", page.Entries)
+	}
+	entry := page.Entries[0]
+	change := entry.Changes["retention_days"]
+	if entry.Actor != adminActor || entry.IP != "198.51.100.7" || change.Before != float64(365) || change.After != float64(30) {
+		t.Fatalf("retention change entry = %+v", entry)
+	}
+	if len(entry.Changes) != 1 {
+		t.Fatalf("unchanged fields were recorded: %+v", entry.Changes)
+	}
+
+	all := serve(handler.Audit, http.MethodGet, "/api/audit?limit=2", "")
+	if err := json.NewDecoder(all.Body).Decode(&page); err != nil || len(page.Entries) != 2 || page.NextBeforeID == 0 {
+		t.Fatalf("first page = %+v, %v", page, err)
+	}
+	if page.Entries[0].Action != core.AuditIngestKeyCreate || strings.Contains(all.Body.String(), key.Key) {
+		t.Fatalf("ingest key entry = %+v; body=%s", page.Entries[0], all.Body.String())
+	}
+	if response := serve(handler.Audit, http.MethodGet, "/api/audit?from=yesterday", ""); response.Code != http.StatusBadRequest {
+		t.Fatalf("invalid from status = %d, want 400", response.Code)
+	}
+	if response := serve(handler.Audit, http.MethodPost, "/api/audit", ""); response.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST audit status = %d, want 405", response.Code)
+	}
+
+	invite := core.Invite{SiteID: "blog", Email: "editor@example.com", Role: core.RoleAdmin}
+	if err := repo.CreateInvite(context.Background(), &invite); err != nil {
+		t.Fatalf("CreateInvite returned error: %v", err)
+	}
+	session, err := repo.AcceptInvite(context.Background(), invite.Token, "Editor", "correct horse battery")
+	if err != nil {
+		t.Fatalf("AcceptInvite returned error: %v", err)
+	}
+	asEditor := func(target string) int {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session.Token})
+		response := httptest.NewRecorder()
+		handler.Audit(response, request)
+		return response.Code
+	}
+	if code := asEditor("/api/audit?site_id=blog"); code != http.StatusOK {
+		t.Fatalf("site admin audit status = %d, want 200", code)
+	}
+	for _, target := range []string{"/api/audit", "/api/audit?site_id=docs"} {
+		if code := asEditor(target); code != http.StatusForbidden {
+			t.Fatalf("site admin %s status = %d, want 403", target, code)
+		}
+	}
+}
+
+// actorlessAuditRepository blanks the actor of the pending audit entry, which
+// the repository refuses to record.
+type actorlessAuditRepository struct {
+	*db.SqliteRepository
+}
+
+func (r actorlessAuditRepository) CreateSite(ctx context.Context, site *core.Site) error {
+	if entry := core.PendingAudit(ctx); entry != nil {
+		entry.Actor = ""
+	}
+	return r.SqliteRepository.CreateSite(ctx, site)
+}
+
+func TestAudit_FailedRecordRollsBackTheChange(t *testing.T) {
+	_, repo := newQuotaTestHandler(t)
+	handler := NewHandlerWithAdminToken(actorlessAuditRepository{repo}, "test-admin-token")
+
+	request := httptest.NewRequest(http.MethodPost, "/api/sites",
+		strings.NewReader(`{"site_id":"blog","domains":["blog.example.com"]}`))
+	request.Header.Set("Authorization", "Bearer test-admin-token")
+	response := httptest.NewRecorder()
+	handler.Sites(response, request)
+	if response.Code == http.StatusCreated {
+		t.Fatalf("unaudited change status = %d, want a failure", response.Code)
+	}
+	if _, err := repo.GetSite(context.Background(), "blog"); !errors.Is(err, core.ErrSiteNotFound) {
+		t.Fatalf("GetSite after a failed audit returned %v, want ErrSiteNotFound", err)
+	}
+	audit, err := repo.GetAuditLog(context.Background(), core.AuditFilter{})
+	if err != nil {
+		t.Fatalf("GetAuditLog returned error: %v", err)
+	}
+	if len(audit.Entries) != 0 {
+		t.Fatalf("audit entries = %+v, want none", audit.Entries)
+	}
+}
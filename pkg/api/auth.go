@@ -0,0 +1,667 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// SessionCookieName is the cookie that carries a dashboard user's session.
+const SessionCookieName = "iris_session"
+
+// CSRFHeader must echo the session's CSRF token on every cookie-authenticated
+// request that changes state.
+const CSRFHeader = "X-CSRF-Token"
+
+// Read access modes for analytics endpoints.
+const (
+	ReadAccessAuto    = "auto"
+	ReadAccessPublic  = "public"
+	ReadAccessPrivate = "private"
+)
+
+// principal is the caller behind a request: the admin token, a signed-in
+// user, a share link, a scoped API token, or nobody.
+type principal struct {
+	admin   bool
+	session *core.UserSession
+	share   *core.ShareLink
+	token   *core.APIToken
+}
+
+// role returns the caller's role on a site, or "" when it has none.
+func (p principal) role(siteID string) string {
+	if p.admin {
+		return core.RoleOwner
+	}
+	if p.session == nil || siteID == "" {
+		return ""
+	}
+	for _, grant := range p.session.User.Grants {
+		if grant.SiteID == siteID {
+			return grant.Role
+		}
+	}
+	return ""
+}
+
+func (p principal) can(siteID, minimum string) bool {
+	return core.RoleAtLeast(p.role(siteID), minimum)
+}
+
+// holdsAnywhere reports whether a signed-in caller has at least minimum on
+// some site.
+func (p principal) holdsAnywhere(minimum string) bool {
+	if p.session == nil {
+		return false
+	}
+	for _, grant := range p.session.User.Grants {
+		if core.RoleAtLeast(grant.Role, minimum) {
+			return true
+		}
+	}
+	return false
+}
+
+// scoped reports whether the caller is an API token granting scope on siteID.
+// API tokens hold no role, so role checks alone never admit them.
+func (p principal) scoped(scope, siteID string) bool {
+	return p.token != nil && p.token.Allows(scope, siteID)
+}
+
+// manages reports whether the caller may change a site's settings, ingest
+// keys and share links.
+func (p principal) manages(siteID string) bool {
+	return p.can(siteID, core.RoleAdmin) || p.scoped(core.ScopeSitesWrite, siteID)
+}
+
+// actor identifies the caller in audit records.
+func (p principal) actor() string {
+	if p.session != nil && !p.admin {
+		return "user:" + p.session.User.Email
+	}
+	if p.token != nil {
+		return "token:" + p.token.ID
+	}
+	return adminActor
+}
+
+// rateKey identifies the caller to the per-key rate limits, or returns ""
+// for an anonymous caller, who is limited by IP alone.
+func (p principal) rateKey() string {
+	switch {
+	case p.admin:
+		return adminActor
+	case p.token != nil:
+		return "token:" + p.token.ID
+	case p.share != nil:
+		return "share:" + p.share.ID
+	case p.session != nil:
+		return "user:" + p.session.User.ID
+	}
+	return ""
+}
+
+// SetReadAccess chooses who may read analytics: "public" keeps the original
+// open API, "private" requires a credential, and "auto" becomes private as
+// soon as the first user account exists.
+func (h *Handler) SetReadAccess(mode string) {
+	h.readAccess = mode
+}
+
+// SetSiteCreatorRole lets signed-in users who hold role on any site register
+// new sites. With no role, only the admin token may.
+func (h *Handler) SetSiteCreatorRole(role string) error {
+	role = strings.TrimSpace(role)
+	if role != "" && !core.RoleAtLeast(role, core.RoleViewer) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	h.siteCreatorRole = role
+	return nil
+}
+
+// createsSites reports whether the caller may register a new site.
+func (h *Handler) createsSites(p principal) bool {
+	return p.admin || (h.siteCreatorRole != "" && p.token == nil && p.holdsAnywhere(h.siteCreatorRole))
+}
+
+func (h *Handler) readsPrivate(r *http.Request) (bool, error) {
+	switch h.readAccess {
+	case ReadAccessPublic:
+		return false, nil
+	case ReadAccessPrivate:
+		return true, nil
+	}
+	if h.Repo == nil {
+		return false, nil
+	}
+	return h.Repo.HasUsers(r.Context())
+}
+
+// errUnknownCredential marks a bearer credential that is neither the admin
+// token, a share link nor an API token.
+var errUnknownCredential = errors.New("unknown bearer credential")
+
+// principalKey carries the resolvedPrincipal of a request that LimitReads has
+// already authenticated.
+type principalKey struct{}
+
+type resolvedPrincipal struct {
+	principal principal
+	err       error
+}
+
+// resolvePrincipal resolves the request's credentials without answering the
+// request. A bearer token wins over the session cookie; a stale cookie is
+// treated as anonymous.
+func (h *Handler) resolvePrincipal(r *http.Request) (principal, error) {
+	if resolved, ok := r.Context().Value(principalKey{}).(resolvedPrincipal); ok {
+		return resolved.principal, resolved.err
+	}
+	if bearer := bearerToken(r); bearer != "" {
+		if h.isAdmin(r) {
+			return principal{admin: true}, nil
+		}
+		if strings.HasPrefix(bearer, core.ShareSlugPrefix) {
+			link, err := h.shareLink(r.Context(), bearer, time.Now().UTC())
+			if err != nil {
+				return principal{}, fmt.Errorf("look up share link: %w", err)
+			}
+			return principal{share: link}, nil
+		}
+		if strings.HasPrefix(bearer, core.APITokenPrefix) && h.Repo != nil {
+			token, err := h.Repo.LookupAPIToken(r.Context(), bearer)
+			if err != nil {
+				return principal{}, fmt.Errorf("look up API token: %w", err)
+			}
+			return principal{token: token}, nil
+		}
+		return principal{}, errUnknownCredential
+	}
+
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" || h.Repo == nil {
+		return principal{}, nil
+	}
+	session, err := h.Repo.LookupUserSession(r.Context(), cookie.Value)
+	if errors.Is(err, core.ErrSessionInvalid) {
+		return principal{}, nil
+	}
+	if err != nil {
+		return principal{}, fmt.Errorf("look up session: %w", err)
+	}
+	return principal{session: session}, nil
+}
+
+// authenticate resolves the request's credentials, writing the error response
+// when they are invalid. Cookie-based requests that change state must carry
+// the matching CSRF header.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	p, err := h.resolvePrincipal(r)
+	switch {
+	case errors.Is(err, core.ErrShareLinkInvalid):
+		http.Error(w, "Invalid or expired share link", http.StatusUnauthorized)
+		return principal{}, false
+	case errors.Is(err, core.ErrAPITokenInvalid):
+		http.Error(w, "Invalid or expired API token", http.StatusUnauthorized)
+		return principal{}, false
+	case errors.Is(err, errUnknownCredential):
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return principal{}, false
+	case err != nil:
+		logError(r.Context(), "Auth", "credential lookup error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return principal{}, false
+	}
+	if p.session == nil {
+		return p, true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		provided := r.Header.Get(CSRFHeader)
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(p.session.CSRFToken)) != 1 {
+			http.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+			return principal{}, false
+		}
+	}
+	return p, true
+}
+
+// authorizeRead admits a request to analytics reads. Site-level checks happen
+// once the site is known, in parseStatsQuery.
+func (h *Handler) authorizeRead(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	p, ok := h.authenticate(w, r)
+	if !ok || p.admin || p.session != nil || p.share != nil || p.token != nil {
+		return p, ok
+	}
+	private, err := h.readsPrivate(r)
+	if err != nil {
+		logError(r.Context(), "Auth", "read policy error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return p, false
+	}
+	if private {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return p, false
+	}
+	return p, true
+}
+
+// canReadSite applies per-site grants to signed-in users. When reads are
+// public, grants only widen what the dashboard shows, so every site remains
+// readable. API tokens always need the stats:read scope on the site.
+func (h *Handler) canReadSite(r *http.Request, p principal, siteID string) (bool, error) {
+	if p.token != nil {
+		return p.scoped(core.ScopeStatsRead, siteID), nil
+	}
+	if p.admin || p.session == nil || p.can(siteID, core.RoleViewer) {
+		return true, nil
+	}
+	private, err := h.readsPrivate(r)
+	return !private, err
+}
+
+// requireManager admits the admin token, a signed-in user or an API token,
+// writing the error response otherwise.
+func (h *Handler) requireManager(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	p, ok := h.authenticate(w, r)
+	if !ok {
+		return p, false
+	}
+	if p.admin || p.session != nil || p.token != nil {
+		return p, true
+	}
+	if h.adminToken == "" {
+		hasUsers := false
+		if h.Repo != nil {
+			var err error
+			if hasUsers, err = h.Repo.HasUsers(r.Context()); err != nil {
+				logError(r.Context(), "Auth", "user lookup error", err)
+				http.Error(w, "Query failed", http.StatusInternalServerError)
+				return p, false
+			}
+		}
+		if !hasUsers {
+			http.Error(w, "Site management is disabled until IRIS_ADMIN_TOKEN is configured", http.StatusServiceUnavailable)
+			return p, false
+		}
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return p, false
+}
+
+// requireSiteRole admits callers holding at least the minimum role on a site.
+func (h *Handler) requireSiteRole(w http.ResponseWriter, r *http.Request, siteID, minimum string) (principal, bool) {
+	p, ok := h.requireManager(w, r)
+	if !ok {
+		return p, false
+	}
+	if !p.can(siteID, minimum) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return p, false
+	}
+	return p, true
+}
+
+func (h *Handler) setSessionCookie(w http.ResponseWriter, r *http.Request, session *core.UserSession) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   h.isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *Handler) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Login exchanges an email and password for a session cookie. The response
+// carries the CSRF token the dashboard must send with later mutations.
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	var request struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	session, err := h.Repo.Login(r.Context(), request.Email, request.Password)
+	if errors.Is(err, core.ErrLoginFailed) {
+		http.Error(w, "Incorrect email or password", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logError(r.Context(), "Login", "error", err)
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+	h.setSessionCookie(w, r, session)
+	writeJSON(w, http.StatusOK, session)
+}
+
+// Logout ends the caller's session and clears its cookie.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if p.session != nil {
+		if err := h.Repo.DeleteUserSession(r.Context(), p.session.Token); err != nil {
+			logError(r.Context(), "Logout", "error", err)
+			http.Error(w, "Logout failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	h.clearSessionCookie(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Me returns the signed-in user's session, including the CSRF token, so a
+// reloaded dashboard can resume without signing in again.
+func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if p.session == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.session)
+}
+
+// Users lists accounts (GET) and off-boards one (DELETE ?id=). Only the admin
+// token may use it, so a compromised dashboard login cannot lock others out.
+func (h *Handler) Users(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		users, err := h.Repo.GetUsers(r.Context())
+		if err != nil {
+			logError(r.Context(), "Users", "query error", err)
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, users)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+	if err := h.Repo.DisableUser(h.audited(r, principal{admin: true}, core.AuditUserDisable, ""), id); err != nil {
+		if errors.Is(err, core.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		logError(r.Context(), "Users", "disable error", err)
+		http.Error(w, "Failed to disable user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SiteMembers lists (GET ?site_id=), grants (POST) and removes
+// (DELETE ?site_id=&user_id=) roles on a site. Site admins manage viewers and
+// admins; only owners may grant or remove the owner role.
+func (h *Handler) SiteMembers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site_id")
+		if siteID == "" {
+			http.Error(w, "Missing site_id", http.StatusBadRequest)
+			return
+		}
+		if _, ok := h.requireSiteRole(w, r, siteID, core.RoleAdmin); !ok {
+			return
+		}
+		members, err := h.Repo.GetSiteMembers(r.Context(), siteID)
+		if err != nil {
+			logError(r.Context(), "SiteMembers", "query error", err)
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, members)
+	case http.MethodPost, http.MethodDelete:
+		p, ok := h.requireManager(w, r)
+		if !ok {
+			return
+		}
+		var grant core.SiteMember
+		var siteID string
+		if r.Method == http.MethodPost {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+			var request struct {
+				SiteID string `json:"site_id"`
+				core.SiteMember
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			if request.Role == "" {
+				http.Error(w, "Missing role", http.StatusBadRequest)
+				return
+			}
+			siteID, grant = request.SiteID, request.SiteMember
+		} else {
+			siteID = r.URL.Query().Get("site_id")
+			grant.UserID = r.URL.Query().Get("user_id")
+		}
+		if siteID == "" || grant.UserID == "" {
+			http.Error(w, "Missing site_id or user_id", http.StatusBadRequest)
+			return
+		}
+		current, err := h.memberRole(r, siteID, grant.UserID)
+		if err != nil {
+			logError(r.Context(), "SiteMembers", "query error", err)
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			return
+		}
+		minimum := core.RoleAdmin
+		if grant.Role == core.RoleOwner || current == core.RoleOwner {
+			minimum = core.RoleOwner
+		}
+		if !p.can(siteID, minimum) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		action := core.AuditMemberSet
+		if r.Method == http.MethodDelete {
+			action = core.AuditMemberRemove
+		}
+		if err := h.Repo.SetSiteRole(h.audited(r, p, action, siteID), siteID, grant.UserID, grant.Role); err != nil {
+			switch {
+			case errors.Is(err, core.ErrUserNotFound):
+				http.Error(w, "User not found", http.StatusNotFound)
+			case errors.Is(err, core.ErrLastOwner):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				logError(r.Context(), "SiteMembers", "update error", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{
+			"site_id": siteID, "user_id": grant.UserID, "role": grant.Role,
+		})
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost+", "+http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) memberRole(r *http.Request, siteID, userID string) (string, error) {
+	members, err := h.Repo.GetSiteMembers(r.Context(), siteID)
+	if err != nil {
+		return "", err
+	}
+	for _, member := range members {
+		if member.UserID == userID {
+			return member.Role, nil
+		}
+	}
+	return "", nil
+}
+
+// Invites lists (GET ?site_id=), creates (POST) and revokes
+// (DELETE ?site_id=&id=) one-time invite links. The token is returned only by
+// the request that creates it.
+func (h *Handler) Invites(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site_id")
+		if siteID == "" {
+			http.Error(w, "Missing site_id", http.StatusBadRequest)
+			return
+		}
+		if _, ok := h.requireSiteRole(w, r, siteID, core.RoleAdmin); !ok {
+			return
+		}
+		invites, err := h.Repo.GetInvites(r.Context(), siteID)
+		if err != nil {
+			logError(r.Context(), "Invites", "query error", err)
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, invites)
+	case http.MethodPost:
+		p, ok := h.requireManager(w, r)
+		if !ok {
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		var invite core.Invite
+		if err := json.NewDecoder(r.Body).Decode(&invite); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		minimum := core.RoleAdmin
+		if invite.Role == core.RoleOwner {
+			minimum = core.RoleOwner
+		}
+		if !p.can(invite.SiteID, minimum) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		invite.InvitedBy = p.actor()
+		if err := h.Repo.CreateInvite(h.audited(r, p, core.AuditInviteCreate, invite.SiteID), &invite); err != nil {
+			logError(r.Context(), "Invites", "create error", err)
+			if errors.Is(err, core.ErrSiteNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"invite": invite,
+			"url":    "/?invite=" + invite.Token,
+		})
+	case http.MethodDelete:
+		q := r.URL.Query()
+		siteID, id := q.Get("site_id"), q.Get("id")
+		if siteID == "" || id == "" {
+			http.Error(w, "Missing site_id or id", http.StatusBadRequest)
+			return
+		}
+		p, ok := h.requireSiteRole(w, r, siteID, core.RoleAdmin)
+		if !ok {
+			return
+		}
+		if err := h.Repo.RevokeInvite(h.audited(r, p, core.AuditInviteRevoke, siteID), siteID, id); err != nil {
+			if errors.Is(err, core.ErrInviteInvalid) {
+				http.Error(w, "Invite not found", http.StatusNotFound)
+				return
+			}
+			logError(r.Context(), "Invites", "revoke error", err)
+			http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost+", "+http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AcceptInvite consumes an invite token. New users choose a name and
+// password; existing users confirm theirs. Either way the caller is signed in.
+func (h *Handler) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	var request struct {
+		Token    string `json:"token"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	session, err := h.Repo.AcceptInvite(r.Context(), request.Token, request.Name, request.Password)
+	switch {
+	case errors.Is(err, core.ErrInviteInvalid):
+		http.Error(w, "Invite not found or expired", http.StatusNotFound)
+		return
+	case errors.Is(err, core.ErrLoginFailed):
+		http.Error(w, "Incorrect password for the existing account", http.StatusUnauthorized)
+		return
+	case err != nil:
+		logError(r.Context(), "AcceptInvite", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.setSessionCookie(w, r, session)
+	writeJSON(w, http.StatusOK, session)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
+
+func TestUserSessions_CheckSiteGrantsAndCSRF(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range []core.Site{
+		{ID: "site-a", Domains: []string{"example.com"}},
+		{ID: "site-b", Domains: []string{"other.com"}},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
+	}
+	handler := NewHandlerWithAdminToken(repo, "test-admin-token")
+
+	var cookie *http.Cookie
+	var csrf string
+	serve := func(route http.HandlerFunc, method, target, body, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		} else if cookie != nil {
+			request.AddCookie(cookie)
+			if csrf != "" {
+				request.Header.Set(CSRFHeader, csrf)
+			}
+		}
+		response := httptest.NewRecorder()
+		route(response, request)
+		return response
+	}
+
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", ""); response.Code != http.StatusOK {
+		t.Fatalf("public read before any user status = %d, want 200", response.Code)
+	}
+	created := serve(handler.Invites, http.MethodPost, "/api/invites",
+		`{"site_id":"site-a","email":"analyst@example.com","role":"admin"}`, "test-admin-token")
+	var invite struct {
+		Invite core.Invite `json:"invite"`
+		URL    string      `json:"url"`
+	}
+	if err := json.NewDecoder(created.Body).Decode(&invite); err != nil || created.Code != http.StatusCreated {
+		t.Fatalf("create invite status = %d, %v", created.Code, err)
+	}
+	if invite.URL != "/?invite="+invite.Invite.Token || invite.Invite.InvitedBy != adminActor {
+		t.Fatalf("unexpected invite: %+v", invite)
+	}
+
+	accepted := serve(handler.AcceptInvite, http.MethodPost, "/api/invites/accept",
+		`{"token":"`+invite.Invite.Token+`","name":"Analyst","password":"correct horse battery"}`, "")
+	if accepted.Code != http.StatusOK {
+		t.Fatalf("accept invite status = %d; body=%s", accepted.Code, accepted.Body.String())
+	}
+	for _, candidate := range accepted.Result().Cookies() {
+		if candidate.Name == SessionCookieName {
+			cookie = candidate
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("session cookie = %+v", cookie)
+	}
+	var session core.UserSession
+	if err := json.NewDecoder(accepted.Body).Decode(&session); err != nil || session.CSRFToken == "" {
+		t.Fatalf("session response = %+v, %v", session, err)
+	}
+	if strings.Contains(accepted.Body.String(), cookie.Value) {
+		t.Fatal("session response leaks the cookie token")
+	}
+
+	saved := cookie
+	cookie = nil
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous read once users exist status = %d, want 401", response.Code)
+	}
+	cookie = saved
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", ""); response.Code != http.StatusOK {
+		t.Fatalf("granted read status = %d, want 200", response.Code)
+	}
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats?site_id=site-b", "", ""); response.Code != http.StatusForbidden {
+		t.Fatalf("ungranted read status = %d, want 403", response.Code)
+	}
+	listed := serve(handler.Sites, http.MethodGet, "/api/sites", "", "")
+	var sites []core.SiteStat
+	if err := json.NewDecoder(listed.Body).Decode(&sites); err != nil || len(sites) != 1 ||
+		sites[0].SiteID != "site-a" || sites[0].Role != core.RoleAdmin {
+		t.Fatalf("listed sites = %+v, %v", sites, err)
+	}
+
+	viewerInvite := `{"site_id":"site-a","email":"viewer@example.com","role":"viewer"}`
+	if response := serve(handler.Invites, http.MethodPost, "/api/invites", viewerInvite, ""); response.Code != http.StatusForbidden {
+		t.Fatalf("mutation without CSRF status = %d, want 403", response.Code)
+	}
+	csrf = session.CSRFToken
+	if response := serve(handler.Invites, http.MethodPost, "/api/invites", viewerInvite, ""); response.Code != http.StatusCreated {
+		t.Fatalf("admin invites viewer status = %d; body=%s", response.Code, response.Body.String())
+	}
+	ownerInvite := `{"site_id":"site-a","email":"boss@example.com","role":"owner"}`
+	if response := serve(handler.Invites, http.MethodPost, "/api/invites", ownerInvite, ""); response.Code != http.StatusForbidden {
+		t.Fatalf("admin invites owner status = %d, want 403", response.Code)
+	}
+	if response := serve(handler.IngestKeys, http.MethodGet, "/api/ingest-keys?site_id=site-b", "", ""); response.Code != http.StatusForbidden {
+		t.Fatalf("ungranted ingest keys status = %d, want 403", response.Code)
+	}
+	newSite := `{"site_id":"site-c","domains":["new.example.com"]}`
+	if response := serve(handler.Sites, http.MethodPost, "/api/sites", newSite, ""); response.Code != http.StatusForbidden {
+		t.Fatalf("create site without the creator role status = %d, want 403", response.Code)
+	}
+	if err := handler.SetSiteCreatorRole(core.RoleAdmin); err != nil {
+		t.Fatalf("SetSiteCreatorRole returned error: %v", err)
+	}
+	if response := serve(handler.Sites, http.MethodPost, "/api/sites",
+		`{"site_id":"site-b","domains":["other.com"]}`, ""); response.Code != http.StatusForbidden {
+		t.Fatalf("update ungranted site status = %d, want 403", response.Code)
+	}
+	if response := serve(handler.Sites, http.MethodPost, "/api/sites", newSite, ""); response.Code != http.StatusCreated {
+		t.Fatalf("create site status = %d; body=%s", response.Code, response.Body.String())
+	}
+	me := serve(handler.Me, http.MethodGet, "/api/auth/me", "", "")
+	if !strings.Contains(me.Body.String(), `{"site_id":"site-c","role":"owner"}`) {
+		t.Fatalf("creator is not owner of the new site: %s", me.Body.String())
+	}
+
+	if response := serve(handler.Users, http.MethodDelete, "/api/users?id="+session.User.ID, "", ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("user off-boarding without admin token status = %d, want 401", response.Code)
+	}
+	if response := serve(handler.Users, http.MethodDelete, "/api/users?id="+session.User.ID, "", "test-admin-token"); response.Code != http.StatusNoContent {
+		t.Fatalf("off-board status = %d", response.Code)
+	}
+	if response := serve(handler.Me, http.MethodGet, "/api/auth/me", "", ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("off-boarded user's session status = %d, want 401", response.Code)
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+const (
+	maxBodyBytes = 1 << 20 // 1 MiB
+	maxBatchSize = 50
+
+	// IngestKeyHeader carries a trusted sender's ingest key.
+	IngestKeyHeader = "X-Iris-Ingest-Key"
+)
+
+var (
+	errBodyTooLarge        = errors.New("request body is too large")
+	errBatchTooLarge       = errors.New("batch is too large")
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errUnsupportedType     = errors.New("unsupported content type")
+)
+
+// ingestLimits are the body and batch limits for one ingest request. A
+// trusted ingest key may raise them and restricts events to its site; an API
+// token with the ingest scope restricts events to its site, if it has one.
+type ingestLimits struct {
+	bodyBytes int64
+	batchSize int
+	siteID    string
+	// keyID names the credential's rate limit bucket.
+	keyID string
+}
+
+func (h *Handler) ingestLimits(r *http.Request) (ingestLimits, error) {
+	limits := ingestLimits{bodyBytes: maxBodyBytes, batchSize: maxBatchSize}
+	rawKey := strings.TrimSpace(r.Header.Get(IngestKeyHeader))
+	if rawKey == "" && strings.HasPrefix(bearerToken(r), core.APITokenPrefix) {
+		rawKey = bearerToken(r)
+	}
+	if rawKey == "" {
+		return limits, nil
+	}
+	if strings.HasPrefix(rawKey, core.APITokenPrefix) {
+		token, err := h.Repo.LookupAPIToken(r.Context(), rawKey)
+		if errors.Is(err, core.ErrAPITokenInvalid) || err == nil && !slices.Contains(token.Scopes, core.ScopeIngest) {
+			return limits, core.ErrIngestKeyInvalid
+		}
+		if err != nil {
+			return limits, err
+		}
+		limits.siteID = token.SiteID
+		limits.keyID = "api_token:" + token.ID
+		return limits, nil
+	}
+	key, err := h.Repo.LookupIngestKey(r.Context(), rawKey)
+	if err != nil {
+		return limits, err
+	}
+	limits.siteID = key.SiteID
+	limits.keyID = "ingest_key:" + key.ID
+	if key.MaxBodyBytes > 0 {
+		limits.bodyBytes = key.MaxBodyBytes
+	}
+	if key.MaxBatchSize > 0 {
+		limits.batchSize = key.MaxBatchSize
+	}
+	return limits, nil
+}
+
+// checkSite rejects an event for a site other than the credential's.
+func (l ingestLimits) checkSite(event *core.Event) error {
+	if l.siteID != "" && event.SiteID != l.siteID {
+		return fmt.Errorf("%w: key is not valid for site %q", core.ErrIngestKeyInvalid, event.SiteID)
+	}
+	return nil
+}
+
+// ingestBody returns the request body limited to limit bytes, decompressing
+// gzip bodies and applying the same limit to the decompressed size.
+func ingestBody(w http.ResponseWriter, r *http.Request, limit int64) (io.Reader, error) {
+	body := http.MaxBytesReader(w, r.Body, limit)
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		decompressed, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return &cappedReader{reader: decompressed, remaining: limit}, nil
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
+
+// cappedReader fails with errBodyTooLarge once more than remaining bytes
+// have been read, bounding the output of a decompressor.
+type cappedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		// Probe for one more byte so a body of exactly the limit still ends
+		// with io.EOF.
+		var probe [1]byte
+		if n, err := c.reader.Read(probe[:]); n > 0 {
+			return 0, errBodyTooLarge
+		} else {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// decodeEvents reads a JSON array, or one event per line for
+// application/x-ndjson. text/plain bodies are JSON arrays sent by
+// navigator.sendBeacon, which avoids a CORS preflight.
+func decodeEvents(body io.Reader, contentType string, maxEvents int) ([]core.Event, error) {
+	mediaType := "application/json"
+	if strings.TrimSpace(contentType) != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, errUnsupportedType
+		}
+		mediaType = parsed
+	}
+
+	switch mediaType {
+	case "application/json", "text/plain":
+		var events []core.Event
+		if err := json.NewDecoder(body).Decode(&events); err != nil {
+			return nil, err
+		}
+		if len(events) > maxEvents {
+			return nil, errBatchTooLarge
+		}
+		return events, nil
+	case "application/x-ndjson":
+		decoder := json.NewDecoder(body)
+		events := []core.Event{}
+		for {
+			var event core.Event
+			err := decoder.Decode(&event)
+			if err == io.EOF {
+				return events, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(events) == maxEvents {
+				return nil, errBatchTooLarge
+			}
+			events = append(events, event)
+		}
+	default:
+		return nil, errUnsupportedType
+	}
+}
+
+// writeBodyError reports a request body that could not be read or decoded.
+func writeBodyError(w http.ResponseWriter, r *http.Request, name string, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr), errors.Is(err, errBodyTooLarge):
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errBatchTooLarge):
+		http.Error(w, "Batch too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errUnsupportedEncoding), errors.Is(err, errUnsupportedType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		logError(r.Context(), name, "body decode error", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	}
+}
+
+// writeIngestKeyError reports a request whose ingest key could not be used.
+func writeIngestKeyError(w http.ResponseWriter, r *http.Request, name string, err error) {
+	if errors.Is(err, core.ErrIngestKeyInvalid) {
+		http.Error(w, "Invalid ingest key", http.StatusUnauthorized)
+		return
+	}
+	logError(r.Context(), name, "ingest key lookup error", err)
+	http.Error(w, "Failed to verify ingest key", http.StatusInternalServerError)
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// OtherBreakdownLabel names the row that collects breakdown rows below a
+// site's minimum count.
+const OtherBreakdownLabel = "Other"
+
+// breakdownPolicy is a site's small-count protection for breakdown reports.
+// noiseKey seeds the noise of one report over one window, so repeating a
+// query returns the same counts instead of fresh noise to average away.
+type breakdownPolicy struct {
+	minCount int
+	epsilon  float64
+	noiseKey []byte
+}
+
+// within returns the policy for rows nested under the row labelled label,
+// such as the referrers of one missing page.
+func (p breakdownPolicy) within(label string) breakdownPolicy {
+	p.noiseKey = noiseMAC(p.noiseKey, label)
+	return p
+}
+
+// noiseMAC returns the HMAC-SHA256 of parts under key.
+func noiseMAC(key []byte, parts ...string) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range parts {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
+
+// noise returns the Laplace noise for the count at index of the row labelled
+// label. The same policy, label and index always give the same noise.
+func (p breakdownPolicy) noise(label string, index int) float64 {
+	sum := noiseMAC(p.noiseKey, label, strconv.Itoa(index))
+	u := (float64(binary.BigEndian.Uint64(sum)>>11)+0.5)/(1<<53) - 0.5
+	return laplaceNoise(1/p.epsilon, u)
+}
+
+// parseBreakdownQuery parses a stats query and loads the site's breakdown
+// policy. Unknown sites get an empty policy because their reports are empty.
+func (h *Handler) parseBreakdownQuery(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+) (statsQuery, breakdownPolicy, bool) {
+	q, ok := h.parseStatsQuery(w, r)
+	if !ok {
+		return q, breakdownPolicy{}, false
+	}
+	policy, err := h.breakdownPolicy(r.Context(), q, name, time.Now())
+	if err != nil {
+		logError(r.Context(), name, "site lookup error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return q, breakdownPolicy{}, false
+	}
+	return q, policy, true
+}
+
+func (h *Handler) breakdownPolicy(ctx context.Context, q statsQuery, name string, now time.Time) (breakdownPolicy, error) {
+	site, err := h.Repo.GetSite(ctx, q.SiteID)
+	if errors.Is(err, core.ErrSiteNotFound) {
+		return breakdownPolicy{}, nil
+	}
+	if err != nil {
+		return breakdownPolicy{}, err
+	}
+	from, to := noiseWindow(site, q.From, q.To, now)
+	return breakdownPolicy{
+		minCount: site.MinBreakdownCount,
+		epsilon:  site.PrivacyEpsilon,
+		noiseKey: noiseMAC(h.noiseSecret, name, site.ID, from, to),
+	}, nil
+}
+
+// noiseWindow returns the bounds a report reads as UTC microseconds, so that
+// one window written in different ways gets the same noise. A missing upper
+// bound, or one past the end of the site-local day, is the end of that day.
+// Bounds that do not parse are returned as given; the query rejects them.
+func noiseWindow(site *core.Site, from, to string, now time.Time) (string, string) {
+	location, err := time.LoadLocation(site.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	if start, err := core.ParseAnalyticsTime(from, false, location); err == nil {
+		from = strconv.FormatInt(start.UnixMicro(), 10)
+	}
+	today := now.In(location).Format("2006-01-02")
+	endOfToday, _ := core.ParseAnalyticsTime(today, true, location)
+	end, err := core.ParseAnalyticsTime(to, true, location)
+	if to == "" || (err == nil && end.After(endOfToday)) {
+		end, err = endOfToday, nil
+	}
+	if err == nil {
+		to = strconv.FormatInt(end.UnixMicro(), 10)
+	}
+	return from, to
+}
+
+// breakdownFields describes the counts of one breakdown row type. key is the
+// count compared with the site minimum, normally distinct visitors; counts
+// lists every additive count, key included; label identifies the row.
+// suppress, when set, clears the values that are not noised, such as
+// averages, from a row that only noise lifted over the minimum.
+type breakdownFields[T any] struct {
+	label    func(*T) string
+	key      func(*T) *int
+	counts   func(*T) []*int
+	other    func() T
+	suppress func(*T)
+}
+
+// applyBreakdown folds rows whose key count is below the policy minimum into
+// a trailing "Other" row. With a privacy epsilon it instead adds Laplace noise
+// of scale 1/epsilon to every count and drops rows whose noisy key count is
+// below the minimum, so no exact small count or "Other" total is revealed.
+// Rows kept only because of noise lose their suppressed values.
+func applyBreakdown[T any](policy breakdownPolicy, rows []T, fields breakdownFields[T]) []T {
+	if policy.epsilon > 0 {
+		minimum := max(policy.minCount, 1)
+		kept := rows[:0]
+		for _, row := range rows {
+			exact := *fields.key(&row)
+			addNoise(policy, &row, fields)
+			if *fields.key(&row) < minimum {
+				continue
+			}
+			if exact < minimum && fields.suppress != nil {
+				fields.suppress(&row)
+			}
+			kept = append(kept, row)
+		}
+		sort.SliceStable(kept, func(i, j int) bool {
+			return *fields.key(&kept[i]) > *fields.key(&kept[j])
+		})
+		return kept
+	}
+	if policy.minCount <= 1 {
+		return rows
+	}
+	kept := rows[:0]
+	other := fields.other()
+	folded := false
+	for _, row := range rows {
+		if *fields.key(&row) >= policy.minCount {
+			kept = append(kept, row)
+			continue
+		}
+		folded = true
+		totals := fields.counts(&other)
+		for index, count := range fields.counts(&row) {
+			*totals[index] += *count
+		}
+	}
+	if folded {
+		kept = append(kept, other)
+	}
+	return kept
+}
+
+// applySeries protects a daily series of one element or event. A series whose
+// key counts add up to less than the site minimum is returned empty; with a
+// privacy epsilon every point is noised first and the noisy total is compared.
+func applySeries[T any](policy breakdownPolicy, points []T, fields breakdownFields[T]) []T {
+	total := 0
+	for index := range points {
+		if policy.epsilon > 0 {
+			addNoise(policy, &points[index], fields)
+		}
+		total += *fields.key(&points[index])
+	}
+	if total < policy.minCount || (policy.epsilon > 0 && total < 1) {
+		return points[:0]
+	}
+	return points
+}
+
+func addNoise[T any](policy breakdownPolicy, row *T, fields breakdownFields[T]) {
+	label := fields.label(row)
+	for index, count := range fields.counts(row) {
+		*count = max(0, int(math.Round(float64(*count)+policy.noise(label, index))))
+	}
+}
+
+// laplaceNoise maps u, uniform on (-0.5, 0.5), to the Laplace distribution
+// centred on zero.
+func laplaceNoise(scale, u float64) float64 {
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+var pageBreakdown = breakdownFields[core.PageStat]{
+	label: func(row *core.PageStat) string { return row.URL },
+	key:   func(row *core.PageStat) *int { return &row.Visitors },
+	counts: func(row *core.PageStat) []*int {
+		return []*int{
+			&row.Pageviews, &row.Visitors, &row.ScrollDepth.Samples, &row.ScrollDepth.Reached25,
+			&row.ScrollDepth.Reached50, &row.ScrollDepth.Reached75, &row.ScrollDepth.Reached100,
+		}
+	},
+	other: func() core.PageStat { return core.PageStat{URL: OtherBreakdownLabel} },
+	suppress: func(row *core.PageStat) {
+		row.AvgEngagedMS, row.ScrollDepth = 0, core.ScrollDepth{}
+	},
+}
+
+var contentGroupBreakdown = breakdownFields[core.ContentGroupStat]{
+	label: func(row *core.ContentGroupStat) string { return row.Group },
+	key:   func(row *core.ContentGroupStat) *int { return &row.Visitors },
+	counts: func(row *core.ContentGroupStat) []*int {
+		return []*int{&row.Pageviews, &row.Visitors}
+	},
+	other: func() core.ContentGroupStat { return core.ContentGroupStat{Group: OtherBreakdownLabel} },
+}
+
+var referrerBreakdown = breakdownFields[core.ReferrerStat]{
+	label:  func(row *core.ReferrerStat) string { return row.Referrer },
+	key:    func(row *core.ReferrerStat) *int { return &row.Visitors },
+	counts: func(row *core.ReferrerStat) []*int { return []*int{&row.Visitors} },
+	other:  func() core.ReferrerStat { return core.ReferrerStat{Referrer: OtherBreakdownLabel} },
+}
+
+var linkBreakdown = breakdownFields[core.LinkStat]{
+	label:  func(row *core.LinkStat) string { return row.URL },
+	key:    func(row *core.LinkStat) *int { return &row.Visitors },
+	counts: func(row *core.LinkStat) []*int { return []*int{&row.Clicks, &row.Visitors} },
+	other:  func() core.LinkStat { return core.LinkStat{URL: OtherBreakdownLabel} },
+}
+
+var clickBreakdown = breakdownFields[core.ClickStat]{
+	label:  func(row *core.ClickStat) string { return row.Signature + "\x00" + row.Pathname },
+	key:    func(row *core.ClickStat) *int { return &row.Visitors },
+	counts: func(row *core.ClickStat) []*int { return []*int{&row.Clicks, &row.Visitors} },
+	other:  func() core.ClickStat { return core.ClickStat{Text: OtherBreakdownLabel} },
+}
+
+var searchTermBreakdown = breakdownFields[core.SearchTermStat]{
+	label: func(row *core.SearchTermStat) string { return row.Term },
+	key:   func(row *core.SearchTermStat) *int { return &row.Visitors },
+	counts: func(row *core.SearchTermStat) []*int {
+		return []*int{&row.Searches, &row.Visitors, &row.Exits, &row.Refinements}
+	},
+	other: func() core.SearchTermStat { return core.SearchTermStat{Term: OtherBreakdownLabel} },
+}
+
+var notFoundBreakdown = breakdownFields[core.NotFoundStat]{
+	label:  func(row *core.NotFoundStat) string { return row.URL },
+	key:    func(row *core.NotFoundStat) *int { return &row.Visitors },
+	counts: func(row *core.NotFoundStat) []*int { return []*int{&row.Pageviews, &row.Visitors} },
+	other: func() core.NotFoundStat {
+		return core.NotFoundStat{URL: OtherBreakdownLabel, Referrers: []core.ReferrerStat{}}
+	},
+}
+
+var pagePerformanceBreakdown = breakdownFields[core.PagePerformanceStat]{
+	label:  func(row *core.PagePerformanceStat) string { return row.URL },
+	key:    func(row *core.PagePerformanceStat) *int { return &row.Traffic },
+	counts: func(row *core.PagePerformanceStat) []*int { return []*int{&row.Traffic} },
+	other:  func() core.PagePerformanceStat { return core.PagePerformanceStat{URL: OtherBreakdownLabel} },
+}
+
+var customEventBreakdown = breakdownFields[core.CustomEventStat]{
+	label: func(row *core.CustomEventStat) string { return row.EventName },
+	key:   func(row *core.CustomEventStat) *int { return &row.UniqueUsers },
+	counts: func(row *core.CustomEventStat) []*int {
+		return []*int{&row.TotalCount, &row.UniqueUsers}
+	},
+	other: func() core.CustomEventStat { return core.CustomEventStat{EventName: OtherBreakdownLabel} },
+}
+
+var deviceBreakdown = breakdownFields[core.DeviceStat]{
+	label:  func(row *core.DeviceStat) string { return row.Device },
+	key:    func(row *core.DeviceStat) *int { return &row.Count },
+	counts: func(row *core.DeviceStat) []*int { return []*int{&row.Count} },
+	other:  func() core.DeviceStat { return core.DeviceStat{Device: OtherBreakdownLabel} },
+}
+
+var clickSeries = breakdownFields[core.ClickTimeSeriesBucket]{
+	label: func(point *core.ClickTimeSeriesBucket) string { return point.Date },
+	key:   func(point *core.ClickTimeSeriesBucket) *int { return &point.Visitors },
+	counts: func(point *core.ClickTimeSeriesBucket) []*int {
+		return []*int{&point.Clicks, &point.Visitors}
+	},
+}
+
+var customEventSeries = breakdownFields[core.CustomEventTimeSeriesBucket]{
+	label:  func(point *core.CustomEventTimeSeriesBucket) string { return point.Date },
+	key:    func(point *core.CustomEventTimeSeriesBucket) *int { return &point.Count },
+	counts: func(point *core.CustomEventTimeSeriesBucket) []*int { return []*int{&point.Count} },
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ingestClient describes the sender of an ingest request.
+type ingestClient struct {
+	origin    string
+	ip        string
+	userAgent string
+	// privacySignal is set when the request carries Sec-GPC: 1 or DNT: 1.
+	privacySignal bool
+	// serverIdentity derives missing session and visitor IDs even when the
+	// site has not enabled server identity. The pixel sets it because its
+	// clients cannot store identifiers.
+	serverIdentity bool
+}
+
+func (h *Handler) ingestClient(r *http.Request) ingestClient {
+	return ingestClient{
+		origin:    r.Header.Get("Origin"),
+		ip:        h.clientIP(r),
+		userAgent: r.UserAgent(),
+		privacySignal: strings.TrimSpace(r.Header.Get("Sec-GPC")) == "1" ||
+			strings.TrimSpace(r.Header.Get("DNT")) == "1",
+	}
+}
+
+// SetTrustedProxies sets the proxy addresses whose X-Forwarded-For header is
+// believed when resolving a client's IP address.
+func (h *Handler) SetTrustedProxies(prefixes []netip.Prefix) {
+	h.trustedProxies = prefixes
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDR prefixes or
+// single IP addresses.
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// clientIP returns the request's peer address or, when the peer is a trusted
+// proxy, the rightmost X-Forwarded-For address that is not itself trusted.
+func (h *Handler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !h.isTrustedProxy(addr) {
+		return addr.String()
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for index := len(forwarded) - 1; index >= 0; index-- {
+		candidate, err := netip.ParseAddr(strings.TrimSpace(forwarded[index]))
+		if err != nil {
+			break
+		}
+		addr = candidate.Unmap()
+		if !h.isTrustedProxy(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// isHTTPS reports whether the client reached Iris over HTTPS. X-Forwarded-Proto
+// is believed only from a trusted proxy, as X-Forwarded-For is in clientIP.
+func (h *Handler) isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !h.isTrustedProxy(addr.Unmap()) {
+		return false
+	}
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+func (h *Handler) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range h.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,28 +1,139 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func NewCORSMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// corsMaxAge is how long browsers may cache a preflight response. Chromium
+// caps the value at two hours.
+const corsMaxAge = 2 * time.Hour
+
+// IngestCORS lets pages on a registered site domain send events. With a
+// site_id query parameter the origin must belong to that site; otherwise any
+// enabled site's domain is allowed here and each event's origin is checked
+// against its own site during ingestion. Ingestion never uses credentials.
+func (h *Handler) IngestCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Add("Vary", "Origin")
+		origin, host, ok := requestOrigin(r)
+		if ok {
+			var err error
+			if siteID := r.URL.Query().Get("site_id"); siteID != "" {
+				ok = h.Repo.ValidateSite(r.Context(), siteID, host) == nil
+			} else if ok, err = h.Repo.HasSiteDomain(r.Context(), host); err != nil {
+				logError(r.Context(), "CORS", "domain lookup error", err)
+			}
+		}
+		if ok {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions {
+			if ok {
+				writePreflight(w, "POST", "Content-Type, Content-Encoding")
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next(w, r)
+	}
+}
 
-		if origin := strings.TrimSpace(r.Header.Get("Origin")); origin != "" {
+// ReadCORS lets the configured dashboard origins read with credentials. Only
+// GET is granted unless the route names further methods, so a route that
+// also writes, such as /api/sites, stays same-origin for its writes.
+func (h *Handler) ReadCORS(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	methods = append([]string{http.MethodGet}, methods...)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin, _, ok := requestOrigin(r)
+		ok = ok && slices.Contains(h.dashboardOrigins, origin)
+		if r.Method == http.MethodOptions {
+			if ok && slices.Contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+				writePreflight(w, strings.Join(methods, ", "), "Authorization, Content-Type, X-CSRF-Token")
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if ok && slices.Contains(methods, r.Method) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Add("Vary", "Origin")
-		} else {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Expose-Headers", sampleRateHeader+", "+sampleErrorMarginHeader)
 		}
+		next(w, r)
+	}
+}
 
+// SameOriginCORS serves administrative routes to the dashboard's own origin
+// only. Cross-origin preflights are answered without any grant, so browsers
+// refuse to send the request.
+func SameOriginCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
-
 		next(w, r)
 	}
 }
+
+func writePreflight(w http.ResponseWriter, methods, headers string) {
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", headers)
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+}
+
+// requestOrigin returns the request's Origin header in canonical form and
+// its hostname. ok is false when the header is missing or not a web origin.
+func requestOrigin(r *http.Request) (origin, host string, ok bool) {
+	origin, err := canonicalOrigin(r.Header.Get("Origin"))
+	if err != nil {
+		return "", "", false
+	}
+	parsed, _ := url.Parse(origin)
+	return origin, parsed.Hostname(), true
+}
+
+func canonicalOrigin(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+		parsed.User != nil || strings.TrimSuffix(parsed.Path, "/") != "" ||
+		parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("not an origin")
+	}
+	return strings.ToLower(parsed.Scheme + "://" + parsed.Host), nil
+}
+
+// SetDashboardOrigins sets the origins, besides Iris's own, that may read
+// reporting data from a browser.
+func (h *Handler) SetDashboardOrigins(origins []string) {
+	h.dashboardOrigins = origins
+}
+
+// ParseDashboardOrigins parses a comma-separated list of origins such as
+// https://dash.example.com.
+func ParseDashboardOrigins(value string) ([]string, error) {
+	var origins []string
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		origin, err := canonicalOrigin(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dashboard origin %q", strings.TrimSpace(item))
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
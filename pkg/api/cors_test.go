@@ -1,83 +1,159 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
-)
-
-func TestCORSMiddlewareAllowsAllOrigins(t *testing.T) {
-	req := httptest.NewRequest(http.MethodPost, "/api/event", nil)
-	req.Header.Set("Origin", "https://algomind.pro")
 
-	rec := httptest.NewRecorder()
-	called := false
-	NewCORSMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		called = true
-		w.WriteHeader(http.StatusAccepted)
-	})(rec, req)
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
 
-	if !called {
-		t.Fatal("expected wrapped handler to be called")
+func newCORSTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
 	}
-	if rec.Code != http.StatusAccepted {
-		t.Fatalf("unexpected status code: got %d want %d", rec.Code, http.StatusAccepted)
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range []core.Site{
+		{ID: "site-a", Name: "Site A", Domains: []string{"example.com"}},
+		{ID: "site-b", Name: "Site B", Domains: []string{"other.com"}},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
 	}
-	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://algomind.pro" {
-		t.Fatalf("unexpected allow-origin header: got %q", got)
+	handler := NewHandler(repo)
+	origins, err := ParseDashboardOrigins(" https://Dash.Example.net/ ,")
+	if err != nil {
+		t.Fatalf("ParseDashboardOrigins returned error: %v", err)
 	}
-	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, OPTIONS" {
-		t.Fatalf("unexpected allow-methods header: got %q", got)
+	handler.SetDashboardOrigins(origins)
+	return handler
+}
+
+func TestIngestCORS_AllowsOnlyRegisteredSiteDomains(t *testing.T) {
+	handler := newCORSTestHandler(t)
+	tests := []struct {
+		target string
+		origin string
+		want   string
+	}{
+		{"/api/event", "https://example.com", "https://example.com"},
+		{"/api/event", "https://other.com", "https://other.com"},
+		{"/api/event?site_id=site-a", "https://other.com", ""},
+		{"/api/event?site_id=site-a", "https://example.com", "https://example.com"},
+		{"/api/event", "https://evil.example", ""},
+		{"/api/event", "null", ""},
 	}
-	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Authorization, Content-Type" {
-		t.Fatalf("unexpected allow-headers header: got %q", got)
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodOptions, test.target, nil)
+		request.Header.Set("Origin", test.origin)
+		request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		response := httptest.NewRecorder()
+		called := false
+		handler.IngestCORS(func(http.ResponseWriter, *http.Request) { called = true })(response, request)
+
+		if called || response.Code != http.StatusOK {
+			t.Fatalf("%s from %s: called=%v status=%d", test.target, test.origin, called, response.Code)
+		}
+		if got := response.Header().Get("Access-Control-Allow-Origin"); got != test.want {
+			t.Fatalf("%s from %s: Allow-Origin = %q, want %q", test.target, test.origin, got, test.want)
+		}
+		if test.want == "" {
+			continue
+		}
+		if got := response.Header().Get("Access-Control-Max-Age"); got != "7200" {
+			t.Fatalf("Max-Age = %q, want 7200", got)
+		}
+		if got := response.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+			t.Fatalf("ingest preflight allowed credentials")
+		}
 	}
-	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
-		t.Fatalf("unexpected allow-credentials header: got %q", got)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/event", nil)
+	request.Header.Set("Origin", "https://example.com")
+	response := httptest.NewRecorder()
+	handler.IngestCORS(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) })(response, request)
+	if response.Code != http.StatusAccepted || response.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Fatalf("unexpected ingest response: %d %v", response.Code, response.Header())
 	}
-	if got := rec.Header().Get("Vary"); got != "Origin" {
-		t.Fatalf("unexpected vary header: got %q", got)
+	if response.Header().Get("Vary") != "Origin" {
+		t.Fatalf("Vary = %q, want Origin", response.Header().Get("Vary"))
 	}
 }
 
-func TestCORSMiddlewareHandlesPreflight(t *testing.T) {
-	req := httptest.NewRequest(http.MethodOptions, "/api/events", nil)
-	req.Header.Set("Origin", "https://evil.example")
-	rec := httptest.NewRecorder()
-	called := false
-	NewCORSMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		called = true
-	})(rec, req)
+func TestReadCORS_AllowsOnlyDashboardOrigins(t *testing.T) {
+	handler := newCORSTestHandler(t)
+	next := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
 
-	if called {
-		t.Fatal("expected wrapped handler not to be called for preflight")
+	request := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+	request.Header.Set("Origin", "https://dash.example.net")
+	response := httptest.NewRecorder()
+	handler.ReadCORS(next)(response, request)
+	if response.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.net" ||
+		response.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("dashboard origin was not allowed: %v", response.Header())
 	}
-	if rec.Code != http.StatusOK {
-		t.Fatalf("unexpected status code: got %d want %d", rec.Code, http.StatusOK)
+
+	for _, origin := range []string{"https://example.com", "https://evil.example"} {
+		request = httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+		request.Header.Set("Origin", origin)
+		response = httptest.NewRecorder()
+		handler.ReadCORS(next)(response, request)
+		if got := response.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Fatalf("%s was allowed to read: %q", origin, got)
+		}
 	}
-	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://evil.example" {
-		t.Fatalf("unexpected allow-origin header: got %q", got)
+
+	// /api/sites reads cross-origin but writes same-origin only.
+	request = httptest.NewRequest(http.MethodOptions, "/api/sites", nil)
+	request.Header.Set("Origin", "https://dash.example.net")
+	request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	response = httptest.NewRecorder()
+	handler.ReadCORS(next)(response, request)
+	if got := response.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("write preflight was allowed: %q", got)
 	}
-	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Authorization, Content-Type" {
-		t.Fatalf("unexpected allow-headers header: got %q", got)
+
+	request = httptest.NewRequest(http.MethodOptions, "/api/share", nil)
+	request.Header.Set("Origin", "https://dash.example.net")
+	request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	response = httptest.NewRecorder()
+	handler.ReadCORS(next, http.MethodPost)(response, request)
+	if response.Header().Get("Access-Control-Allow-Methods") != "GET, POST" ||
+		response.Header().Get("Access-Control-Max-Age") != "7200" {
+		t.Fatalf("unexpected share preflight: %v", response.Header())
 	}
 }
 
-func TestCORSMiddlewareUsesWildcardWithoutOrigin(t *testing.T) {
-	req := httptest.NewRequest(http.MethodGet, "/api/sites", nil)
-
-	rec := httptest.NewRecorder()
-	NewCORSMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})(rec, req)
+func TestSameOriginCORS_GrantsNoCrossOriginAccess(t *testing.T) {
+	for _, method := range []string{http.MethodOptions, http.MethodPost} {
+		request := httptest.NewRequest(method, "/api/tokens", nil)
+		request.Header.Set("Origin", "https://dash.example.net")
+		request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		response := httptest.NewRecorder()
+		called := false
+		SameOriginCORS(func(http.ResponseWriter, *http.Request) { called = true })(response, request)
 
-	if rec.Code != http.StatusOK {
-		t.Fatalf("unexpected status code: got %d want %d", rec.Code, http.StatusOK)
+		if called != (method != http.MethodOptions) {
+			t.Fatalf("%s: called = %v", method, called)
+		}
+		for _, header := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Allow-Methods"} {
+			if got := response.Header().Get(header); got != "" {
+				t.Fatalf("%s: %s = %q", method, header, got)
+			}
+		}
 	}
-	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
-		t.Fatalf("unexpected allow-origin header: got %q", got)
-	}
-	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
-		t.Fatalf("expected no allow-credentials header, got %q", got)
+}
+
+func TestParseDashboardOrigins_RejectsURLsWithPaths(t *testing.T) {
+	for _, value := range []string{"https://dash.example.net/app", "dash.example.net", "ftp://dash.example.net"} {
+		if _, err := ParseDashboardOrigins(value); err == nil {
+			t.Fatalf("ParseDashboardOrigins(%q) returned nil error", value)
+		}
 	}
 }
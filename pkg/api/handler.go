@@ -1,30 +1,78 @@
 package api
 
 import (
+	"crypto/rand"
 	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
+	"net/netip"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/oidc"
 )
 
 type Handler struct {
-	Repo       core.EventRepository
-	adminToken string
+	Repo               core.EventRepository
+	adminToken         string
+	downloadExtensions map[string]struct{}
+	sites              sync.Map // site ID -> cachedSite
+	pathRules          sync.Map // site ID -> compiledPathRules
+	quotas             sync.Map // site ID -> *quotaUsage
+	trustedProxies     []netip.Prefix
+	dashboardOrigins   []string
+	ingestRate         *classLimiter
+	readRate           *classLimiter
+	readAccess         string
+	siteCreatorRole    string
+	oidc               *oidc.Provider
+	oidcRules          []oidc.RoleRule
+	// metricsToken may read /metrics; metrics is what it reports.
+	metricsToken string
+	metrics      *handlerMetrics
+	// successLogEvery samples successful ingestion logs; successLogs counts
+	// them.
+	successLogEvery uint64
+	successLogs     atomic.Uint64
+	// noiseSecret keys the privacy noise added to breakdown reports.
+	noiseSecret []byte
 }
 
 func NewHandler(repo core.EventRepository) *Handler {
-	return &Handler{Repo: repo}
+	return NewHandlerWithAdminToken(repo, "")
 }
 
 func NewHandlerWithAdminToken(repo core.EventRepository, adminToken string) *Handler {
-	return &Handler{Repo: repo, adminToken: strings.TrimSpace(adminToken)}
+	h := &Handler{Repo: repo, adminToken: strings.TrimSpace(adminToken), metrics: newHandlerMetrics()}
+	h.SetDownloadExtensions(core.DefaultDownloadExtensions)
+	h.noiseSecret = make([]byte, 32)
+	if _, err := rand.Read(h.noiseSecret); err != nil {
+		panic(fmt.Sprintf("generate noise secret: %v", err))
+	}
+	return h
+}
+
+// SetNoiseSecret sets the secret that keys the privacy noise of breakdown
+// reports. Without one, a random secret is used and the noise of a report
+// changes when the server restarts.
+func (h *Handler) SetNoiseSecret(secret string) {
+	if secret = strings.TrimSpace(secret); secret != "" {
+		h.noiseSecret = []byte(secret)
+	}
+}
+
+// SetDownloadExtensions replaces the extensions used to classify clicked links
+// as file downloads. Leading dots and letter case are ignored.
+func (h *Handler) SetDownloadExtensions(extensions []string) {
+	h.downloadExtensions = core.DownloadExtensionSet(extensions)
 }
 
 func writeJSON(w http.ResponseWriter, status int, data any) {
@@ -37,143 +85,303 @@ type statsQuery struct {
 	SiteID string
 	From   string
 	To     string
+	// Sampling is set when the window holds sampled events.
+	Sampling *core.Sampling
+	// locked is set when a share link fixes the range, so that nothing
+	// outside it may be read, not even for comparison.
+	locked bool
 }
 
-func parseStatsQuery(w http.ResponseWriter, r *http.Request) (statsQuery, bool) {
+// previousPeriod returns the range just before the query's, for trends.
+// Queries locked to a shared range have none.
+func (q statsQuery) previousPeriod() (string, string, bool) {
+	if q.locked {
+		return "", "", false
+	}
+	return previousPeriod(q.From, q.To)
+}
+
+// parseStatsQuery parses an analytics query and authorizes it. A share link
+// supplies a missing site_id, rejects other sites, and replaces from and to
+// with its locked range. Signed-in users need a grant on the site. When the
+// window holds sampled events, the sampling headers are set.
+func (h *Handler) parseStatsQuery(w http.ResponseWriter, r *http.Request) (statsQuery, bool) {
+	p, ok := h.authorizeRead(w, r)
+	if !ok {
+		return statsQuery{}, false
+	}
+	link := p.share
 	q := r.URL.Query()
 	siteID := q.Get("site_id")
 	if siteID == "" {
 		siteID = q.Get("domain")
 	}
+	if siteID == "" && link != nil {
+		siteID = link.SiteID
+	}
 	if siteID == "" {
 		http.Error(w, "site_id is required", http.StatusBadRequest)
 		return statsQuery{}, false
 	}
-	return statsQuery{SiteID: siteID, From: q.Get("from"), To: q.Get("to")}, true
+	allowed, err := h.canReadSite(r, p, siteID)
+	if err != nil {
+		logError(r.Context(), "Auth", "read policy error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return statsQuery{}, false
+	}
+	if !allowed {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return statsQuery{}, false
+	}
+	query := statsQuery{SiteID: siteID, From: q.Get("from"), To: q.Get("to")}
+	if link != nil {
+		if siteID != link.SiteID {
+			http.Error(w, "Share link does not cover this site", http.StatusForbidden)
+			return statsQuery{}, false
+		}
+		if link.From != "" {
+			query.From = link.From
+			query.locked = true
+		}
+		if link.To != "" {
+			query.To = link.To
+			query.locked = true
+		}
+	}
+	if writeRateLimited(w, h.readRate.allowSite(siteID, 1)) {
+		return statsQuery{}, false
+	}
+	query.Sampling = h.writeSampling(w, r, query)
+	return query, true
 }
 
-const maxBodyBytes = 1 << 20 // 1 MiB
-
 func (h *Handler) TrackEvent(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	limits, err := h.ingestLimits(r)
+	if err != nil {
+		writeIngestKeyError(w, r, "TrackEvent", err)
+		return
+	}
+	if writeRateLimited(w, h.ingestRate.allowClient(h.clientIP(r), limits.keyID, 1)) {
+		return
+	}
+	body, err := ingestBody(w, r, limits.bodyBytes)
+	if err != nil {
+		writeBodyError(w, r, "TrackEvent", err)
+		return
+	}
 	var event core.Event
-	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-		log.Printf("[TrackEvent] JSON decode error: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if err := json.NewDecoder(body).Decode(&event); err != nil {
+		writeBodyError(w, r, "TrackEvent", err)
 		return
 	}
 
-	if err := h.prepareIncomingEvent(
-		r.Context(), &event, time.Now().UTC(), r.Header.Get("Origin"),
-	); err != nil {
+	err = h.prepareIncomingEvent(r.Context(), &event, time.Now().UTC(), h.ingestClient(r))
+	if err == nil {
+		err = limits.checkSite(&event)
+	}
+	if err == nil {
+		err = h.ingestRate.allowSite(event.SiteID, 1)
+	}
+	if err == nil {
+		err = h.reserveQuota(r.Context(), &event)
+	}
+	if err != nil {
+		h.countRejected(err, &event)
+		if errors.Is(err, errEventDropped) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
 		writeIngestError(w, err)
 		return
 	}
 
-	if err := h.Repo.Insert(r.Context(), &event); err != nil {
-		log.Printf("[TrackEvent] DB Insert error: %v", err)
-		http.Error(w, "Failed to save event", http.StatusInternalServerError)
+	started := time.Now()
+	err = h.Repo.Insert(r.Context(), &event)
+	h.observeWrite("event", started)
+	if err != nil {
+		logError(r.Context(), "TrackEvent", "DB Insert error", err)
+		h.countRejected(fmt.Errorf("%w: %w", errWriteFailed, err), &event)
+		h.releaseQuota(&event)
+		writeInsertError(w, err, "Failed to save event")
 		return
 	}
+	h.countAccepted(&event)
 
-	log.Printf("[TrackEvent] OK: %s (domain=%s, site=%s, url=%s)", event.EventName, event.Domain, event.SiteID, event.URL)
+	h.logSuccess(r.Context(), "TrackEvent", "event stored", "event", event.EventName, "site", event.SiteID)
 	w.WriteHeader(http.StatusAccepted)
 }
 
-const maxBatchSize = 50
-
 func (h *Handler) TrackBatchEvents(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
-	var events []core.Event
-	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
-		log.Printf("[TrackBatchEvents] JSON decode error: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	limits, err := h.ingestLimits(r)
+	if err != nil {
+		writeIngestKeyError(w, r, "TrackBatchEvents", err)
+		return
+	}
+	body, err := ingestBody(w, r, limits.bodyBytes)
+	if err != nil {
+		writeBodyError(w, r, "TrackBatchEvents", err)
+		return
+	}
+	events, err := decodeEvents(body, r.Header.Get("Content-Type"), limits.batchSize)
+	if err != nil {
+		writeBodyError(w, r, "TrackBatchEvents", err)
 		return
 	}
 
+	// With partial=1 valid events are stored even when others are rejected,
+	// and the response reports the outcome of each event by index.
+	partial := isTruthy(r.URL.Query().Get("partial"))
 	if len(events) == 0 {
+		if partial {
+			writeJSON(w, http.StatusOK, core.BatchResult{Results: []core.BatchEventResult{}})
+			return
+		}
 		w.WriteHeader(http.StatusAccepted)
 		return
 	}
-
-	if len(events) > maxBatchSize {
-		http.Error(w, "Batch too large", http.StatusRequestEntityTooLarge)
+	if writeRateLimited(w, h.ingestRate.allowClient(h.clientIP(r), limits.keyID, float64(len(events)))) {
 		return
 	}
 
 	now := time.Now().UTC()
-	ptrs := make([]*core.Event, len(events))
+	client := h.ingestClient(r)
+	ptrs := make([]*core.Event, 0, len(events))
+	result := core.BatchResult{Results: make([]core.BatchEventResult, len(events))}
 	for i := range events {
-		if err := h.prepareIncomingEvent(r.Context(), &events[i], now, r.Header.Get("Origin")); err != nil {
-			writeIngestError(w, fmt.Errorf("event %d: %w", i, err))
-			return
+		result.Results[i] = core.BatchEventResult{Index: i, Status: http.StatusAccepted}
+		err := h.prepareIncomingEvent(r.Context(), &events[i], now, client)
+		if err == nil {
+			err = limits.checkSite(&events[i])
+		}
+		if err == nil {
+			err = h.ingestRate.allowSite(events[i].SiteID, 1)
+		}
+		if err == nil {
+			err = h.reserveQuota(r.Context(), &events[i])
+		}
+		if err != nil {
+			h.countRejected(err, &events[i])
 		}
-		ptrs[i] = &events[i]
+		if errors.Is(err, errEventDropped) {
+			continue
+		}
+		if err != nil {
+			if !partial {
+				// The whole batch is refused, so the events accepted so far
+				// are not stored either.
+				h.countRejected(err, ptrs...)
+				h.releaseQuota(ptrs...)
+				writeIngestError(w, fmt.Errorf("event %d: %w", i, err))
+				return
+			}
+			result.Results[i].Status = ingestErrorStatus(err)
+			result.Results[i].Error = err.Error()
+			result.Rejected++
+			continue
+		}
+		ptrs = append(ptrs, &events[i])
 	}
+	result.Accepted = len(events) - result.Rejected
 
-	if err := h.Repo.InsertBatch(r.Context(), ptrs); err != nil {
-		log.Printf("[TrackBatchEvents] DB InsertBatch error: %v", err)
-		http.Error(w, "Failed to save events", http.StatusInternalServerError)
+	started := time.Now()
+	err = h.Repo.InsertBatch(r.Context(), ptrs)
+	h.observeWrite("batch", started)
+	if err != nil {
+		logError(r.Context(), "TrackBatchEvents", "DB InsertBatch error", err)
+		h.countRejected(fmt.Errorf("%w: %w", errWriteFailed, err), ptrs...)
+		h.releaseQuota(ptrs...)
+		writeInsertError(w, err, "Failed to save events")
 		return
 	}
+	h.countAccepted(ptrs...)
 
-	log.Printf("[TrackBatchEvents] OK: %d events ingested", len(events))
+	h.logSuccess(r.Context(), "TrackBatchEvents", "batch stored", "accepted", result.Accepted, "rejected", result.Rejected)
+	if partial {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
 	w.WriteHeader(http.StatusAccepted)
 }
 
 func writeIngestError(w http.ResponseWriter, err error) {
-	status := http.StatusBadRequest
-	if errors.Is(err, core.ErrSiteNotFound) {
-		status = http.StatusNotFound
-	} else if errors.Is(err, core.ErrDomainNotAllowed) {
-		status = http.StatusForbidden
+	if writeRateLimited(w, err) {
+		return
 	}
-	http.Error(w, err.Error(), status)
+	http.Error(w, err.Error(), ingestErrorStatus(err))
+}
+
+func ingestErrorStatus(err error) int {
+	var limited *rateLimitError
+	if errors.As(err, &limited) {
+		return http.StatusTooManyRequests
+	} else if errors.Is(err, core.ErrSiteNotFound) {
+		return http.StatusNotFound
+	} else if errors.Is(err, core.ErrDomainNotAllowed) || errors.Is(err, core.ErrIngestKeyInvalid) ||
+		errors.Is(err, core.ErrQuotaExceeded) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// ingestRetryAfter is the Retry-After hint, in seconds, sent while the ingest
+// queue is full.
+const ingestRetryAfter = "1"
+
+// writeInsertError reports a failed write. A full ingest queue is temporary
+// backpressure, so clients are told to retry instead of dropping the events.
+func writeInsertError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, core.ErrIngestQueueFull) {
+		w.Header().Set("Retry-After", ingestRetryAfter)
+		http.Error(w, "Ingest queue is full", http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
 }
 
 func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, ok := h.parseStatsQuery(w, r)
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetStats(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetStats] query error: %v", err)
+		logError(r.Context(), "GetStats", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
+	result.Sampling = q.Sampling
 	writeJSON(w, http.StatusOK, result)
 }
 
 func (h *Handler) GetSiteTrends(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, ok := h.parseStatsQuery(w, r)
 	if !ok {
 		return
 	}
 
 	current, err := h.Repo.GetStats(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetSiteTrends] current-period query error: %v", err)
+		logError(r.Context(), "GetSiteTrends", "current-period query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
 
 	result := core.SiteTrendResult{Current: *current}
-	previousFrom, previousTo, hasPrevious := previousPeriod(q.From, q.To)
+	previousFrom, previousTo, hasPrevious := q.previousPeriod()
 	if hasPrevious {
 		previous, queryErr := h.Repo.GetStats(r.Context(), q.SiteID, previousFrom, previousTo)
 		if queryErr != nil {
-			log.Printf("[GetSiteTrends] previous-period query error: %v", queryErr)
+			logError(r.Context(), "GetSiteTrends", "previous-period query error", queryErr)
 			http.Error(w, "Query failed", http.StatusInternalServerError)
 			return
 		}
@@ -189,41 +397,159 @@ func (h *Handler) GetSiteTrends(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetPages(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetPages")
 	if !ok {
 		return
 	}
-	result, err := h.Repo.GetTopPages(r.Context(), q.SiteID, q.From, q.To, 10)
+	var result []core.PageStat
+	var err error
+	if group := strings.TrimSpace(r.URL.Query().Get("content_group")); group != "" {
+		result, err = h.Repo.GetContentGroupPages(r.Context(), q.SiteID, group, q.From, q.To, 10)
+	} else {
+		result, err = h.Repo.GetTopPages(r.Context(), q.SiteID, q.From, q.To, 10)
+	}
 	if err != nil {
-		log.Printf("[GetPages] query error: %v", err)
+		logError(r.Context(), "GetPages", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, result)
+	writeJSON(w, http.StatusOK, applyBreakdown(policy, result, pageBreakdown))
+}
+
+func (h *Handler) GetContentGroups(w http.ResponseWriter, r *http.Request) {
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetContentGroups")
+	if !ok {
+		return
+	}
+	result, err := h.Repo.GetContentGroups(r.Context(), q.SiteID, q.From, q.To)
+	if err != nil {
+		logError(r.Context(), "GetContentGroups", "query error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, applyBreakdown(policy, result, contentGroupBreakdown))
 }
 
 func (h *Handler) GetReferrers(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetReferrers")
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetTopReferrers(r.Context(), q.SiteID, q.From, q.To, 10)
 	if err != nil {
-		log.Printf("[GetReferrers] query error: %v", err)
+		logError(r.Context(), "GetReferrers", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, http.StatusOK, applyBreakdown(policy, result, referrerBreakdown))
+}
+
+func (h *Handler) GetOutboundLinks(w http.ResponseWriter, r *http.Request) {
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetOutboundLinks")
+	if !ok {
+		return
+	}
+	result, err := h.Repo.GetOutboundLinks(r.Context(), q.SiteID, q.From, q.To, 10)
+	if err != nil {
+		logError(r.Context(), "GetOutboundLinks", "query error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, applyBreakdown(policy, result, linkBreakdown))
+}
+
+func (h *Handler) GetDownloads(w http.ResponseWriter, r *http.Request) {
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetDownloads")
+	if !ok {
+		return
+	}
+	result, err := h.Repo.GetDownloads(r.Context(), q.SiteID, q.From, q.To, 10)
+	if err != nil {
+		logError(r.Context(), "GetDownloads", "query error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, applyBreakdown(policy, result, linkBreakdown))
+}
+
+func (h *Handler) GetNotFoundPages(w http.ResponseWriter, r *http.Request) {
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetNotFoundPages")
+	if !ok {
+		return
+	}
+	result, err := h.Repo.GetNotFoundPages(r.Context(), q.SiteID, q.From, q.To, 10)
+	if err != nil {
+		logError(r.Context(), "GetNotFoundPages", "query error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	result = applyBreakdown(policy, result, notFoundBreakdown)
+	for index := range result {
+		result[index].Referrers = applyBreakdown(policy.within(result[index].URL), result[index].Referrers, referrerBreakdown)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) GetClicks(w http.ResponseWriter, r *http.Request) {
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetClicks")
+	if !ok {
+		return
+	}
+	pathname := strings.TrimSpace(r.URL.Query().Get("pathname"))
+	result, err := h.Repo.GetClicks(r.Context(), q.SiteID, pathname, q.From, q.To, 50)
+	if err != nil {
+		logError(r.Context(), "GetClicks", "query error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, applyBreakdown(policy, result, clickBreakdown))
+}
+
+func (h *Handler) GetClickTimeSeries(w http.ResponseWriter, r *http.Request) {
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetClickTimeSeries")
+	if !ok {
+		return
+	}
+	signature := strings.TrimSpace(r.URL.Query().Get("signature"))
+	if signature == "" {
+		http.Error(w, "signature is required", http.StatusBadRequest)
+		return
+	}
+	pathname := strings.TrimSpace(r.URL.Query().Get("pathname"))
+
+	result, err := h.Repo.GetClickTimeSeries(r.Context(), q.SiteID, signature, pathname, q.From, q.To)
+	if err != nil {
+		logError(r.Context(), "GetClickTimeSeries", "query error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, applySeries(policy.within(signature+"\x00"+pathname), result, clickSeries))
+}
+
+func (h *Handler) GetSiteSearch(w http.ResponseWriter, r *http.Request) {
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetSiteSearch")
+	if !ok {
+		return
+	}
+	result, err := h.Repo.GetSiteSearch(r.Context(), q.SiteID, q.From, q.To, 20)
+	if err != nil {
+		logError(r.Context(), "GetSiteSearch", "query error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	result.Terms = applyBreakdown(policy, result.Terms, searchTermBreakdown)
+	result.ExitTerms = applyBreakdown(policy, result.ExitTerms, searchTermBreakdown)
 	writeJSON(w, http.StatusOK, result)
 }
 
 func (h *Handler) GetVitals(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, ok := h.parseStatsQuery(w, r)
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetVitals(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetVitals] query error: %v", err)
+		logError(r.Context(), "GetVitals", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
@@ -231,13 +557,13 @@ func (h *Handler) GetVitals(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetVitalDistributions(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, ok := h.parseStatsQuery(w, r)
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetVitalDistributions(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetVitalDistributions] query error: %v", err)
+		logError(r.Context(), "GetVitalDistributions", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
@@ -245,27 +571,27 @@ func (h *Handler) GetVitalDistributions(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *Handler) GetPagePerformance(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetPagePerformance")
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetPagePerformance(r.Context(), q.SiteID, q.From, q.To, 20)
 	if err != nil {
-		log.Printf("[GetPagePerformance] query error: %v", err)
+		logError(r.Context(), "GetPagePerformance", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, result)
+	writeJSON(w, http.StatusOK, applyBreakdown(policy, result, pagePerformanceBreakdown))
 }
 
 func (h *Handler) GetPerformanceScore(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, ok := h.parseStatsQuery(w, r)
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetPerformanceScore(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetPerformanceScore] query error: %v", err)
+		logError(r.Context(), "GetPerformanceScore", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
@@ -273,28 +599,34 @@ func (h *Handler) GetPerformanceScore(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetCustomEvents(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetCustomEvents")
 	if !ok {
 		return
 	}
 
 	result, err := h.Repo.GetCustomEvents(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetCustomEvents] current-period query error: %v", err)
+		logError(r.Context(), "GetCustomEvents", "current-period query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
 
-	previousFrom, previousTo, hasPrevious := previousPeriod(q.From, q.To)
+	result.Events = applyBreakdown(policy, result.Events, customEventBreakdown)
+	previousFrom, previousTo, hasPrevious := q.previousPeriod()
 	if hasPrevious {
+		previousPolicy := policy
+		previousPolicy.noiseKey = noiseMAC(h.noiseSecret, "GetCustomEvents", q.SiteID, previousFrom, previousTo)
 		previous, queryErr := h.Repo.GetCustomEvents(r.Context(), q.SiteID, previousFrom, previousTo)
 		if queryErr != nil {
-			log.Printf("[GetCustomEvents] previous-period query error: %v", queryErr)
+			logError(r.Context(), "GetCustomEvents", "previous-period query error", queryErr)
 			http.Error(w, "Query failed", http.StatusInternalServerError)
 			return
 		}
 
 		result.Summary.ChangePercent = percentChange(result.Summary.TotalEvents, previous.Summary.TotalEvents)
+		// Changes compare the protected rows of both periods, so that they
+		// reveal no count the rows themselves hide.
+		previous.Events = applyBreakdown(previousPolicy, previous.Events, customEventBreakdown)
 		previousCounts := make(map[string]int, len(previous.Events))
 		for _, event := range previous.Events {
 			previousCounts[event.EventName] = event.TotalCount
@@ -306,12 +638,11 @@ func (h *Handler) GetCustomEvents(w http.ResponseWriter, r *http.Request) {
 			)
 		}
 	}
-
 	writeJSON(w, http.StatusOK, result)
 }
 
 func (h *Handler) GetCustomEventTimeSeries(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetCustomEventTimeSeries")
 	if !ok {
 		return
 	}
@@ -323,35 +654,35 @@ func (h *Handler) GetCustomEventTimeSeries(w http.ResponseWriter, r *http.Reques
 
 	result, err := h.Repo.GetCustomEventTimeSeries(r.Context(), q.SiteID, eventName, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetCustomEventTimeSeries] query error: %v", err)
+		logError(r.Context(), "GetCustomEventTimeSeries", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, result)
+	writeJSON(w, http.StatusOK, applySeries(policy.within(eventName), result, customEventSeries))
 }
 
 func (h *Handler) GetDevices(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, policy, ok := h.parseBreakdownQuery(w, r, "GetDevices")
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetDevices(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetDevices] query error: %v", err)
+		logError(r.Context(), "GetDevices", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, result)
+	writeJSON(w, http.StatusOK, applyBreakdown(policy, result, deviceBreakdown))
 }
 
 func (h *Handler) GetTimeSeries(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, ok := h.parseStatsQuery(w, r)
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetPageviewsTimeSeries(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetTimeSeries] query error: %v", err)
+		logError(r.Context(), "GetTimeSeries", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
@@ -359,13 +690,13 @@ func (h *Handler) GetTimeSeries(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetUniqueVisitorsTimeSeries(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, ok := h.parseStatsQuery(w, r)
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetUniqueVisitorsTimeSeries(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetUniqueVisitorsTimeSeries] query error: %v", err)
+		logError(r.Context(), "GetUniqueVisitorsTimeSeries", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
@@ -373,26 +704,58 @@ func (h *Handler) GetUniqueVisitorsTimeSeries(w http.ResponseWriter, r *http.Req
 }
 
 func (h *Handler) GetSessionsTimeSeries(w http.ResponseWriter, r *http.Request) {
-	q, ok := parseStatsQuery(w, r)
+	q, ok := h.parseStatsQuery(w, r)
 	if !ok {
 		return
 	}
 	result, err := h.Repo.GetSessionsTimeSeries(r.Context(), q.SiteID, q.From, q.To)
 	if err != nil {
-		log.Printf("[GetSessionsTimeSeries] query error: %v", err)
+		logError(r.Context(), "GetSessionsTimeSeries", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
 	writeJSON(w, http.StatusOK, result)
 }
 
+// ListSites lists every site, or only the share link's site, or the sites a
+// signed-in user may read along with their role on each, or the sites an API
+// token may read or manage.
 func (h *Handler) ListSites(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.authorizeRead(w, r)
+	if !ok {
+		return
+	}
 	result, err := h.Repo.GetSites(r.Context())
 	if err != nil {
-		log.Printf("[ListSites] query error: %v", err)
+		logError(r.Context(), "ListSites", "query error", err)
 		http.Error(w, "Query failed", http.StatusInternalServerError)
 		return
 	}
+	if p.share != nil || p.session != nil || p.token != nil {
+		visible := []core.SiteStat{}
+		for _, site := range result {
+			if p.share != nil && site.SiteID != p.share.SiteID {
+				continue
+			}
+			if p.token != nil && !p.scoped(core.ScopeStatsRead, site.SiteID) && !p.manages(site.SiteID) {
+				continue
+			}
+			if p.session != nil {
+				allowed, err := h.canReadSite(r, p, site.SiteID)
+				if err != nil {
+					logError(r.Context(), "ListSites", "read policy error", err)
+					http.Error(w, "Query failed", http.StatusInternalServerError)
+					return
+				}
+				if !allowed {
+					continue
+				}
+				site.Role = p.role(site.SiteID)
+			}
+			visible = append(visible, site)
+		}
+		result = visible
+	}
 	writeJSON(w, http.StatusOK, result)
 }
 
@@ -401,13 +764,8 @@ func (h *Handler) Sites(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		h.ListSites(w, r)
 	case http.MethodPost:
-		if h.adminToken == "" {
-			http.Error(w, "Site management is disabled until IRIS_ADMIN_TOKEN is configured", http.StatusServiceUnavailable)
-			return
-		}
-		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		if len(provided) != len(h.adminToken) || subtle.ConstantTimeCompare([]byte(provided), []byte(h.adminToken)) != 1 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		p, ok := h.requireManager(w, r)
+		if !ok {
 			return
 		}
 		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
@@ -420,11 +778,48 @@ func (h *Handler) Sites(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid site configuration", http.StatusBadRequest)
 			return
 		}
-		if err := h.Repo.CreateSite(r.Context(), &site); err != nil {
-			log.Printf("[Sites] create error: %v", err)
+		// Changing a site needs the admin role on it, or sites:write for an
+		// API token. Other users may only add a new site, when they hold the
+		// configured creator role, and become its owner; the insert refuses
+		// an ID that is already taken.
+		_, err := h.Repo.GetSite(r.Context(), site.ID)
+		isNew := errors.Is(err, core.ErrSiteNotFound)
+		if err != nil && !isNew {
+			logError(r.Context(), "Sites", "lookup error", err)
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			return
+		}
+		switch {
+		case p.manages(site.ID):
+			action := core.AuditSiteUpdate
+			if isNew {
+				action = core.AuditSiteCreate
+			}
+			err = h.Repo.CreateSite(h.audited(r, p, action, site.ID), &site)
+		case h.createsSites(p):
+			ctx := h.audited(r, p, core.AuditSiteCreate, site.ID)
+			err, isNew = h.Repo.InsertSite(ctx, &site), true
+		default:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, core.ErrSiteExists) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logError(r.Context(), "Sites", "create error", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		h.sites.Delete(site.ID)
+		if isNew && p.session != nil {
+			if err := h.Repo.SetSiteRole(r.Context(), site.ID, p.session.User.ID, core.RoleOwner); err != nil {
+				logError(r.Context(), "Sites", "owner grant error", err)
+				http.Error(w, "Failed to grant site owner", http.StatusInternalServerError)
+				return
+			}
+		}
 		writeJSON(w, http.StatusCreated, site)
 	default:
 		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
@@ -432,6 +827,177 @@ func (h *Handler) Sites(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// adminActor identifies the admin bearer token in audit records.
+const adminActor = "admin-token"
+
+// requireAdmin checks the admin bearer token and writes the error response
+// when the request may not use instance-wide administration.
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if h.adminToken == "" {
+		http.Error(w, "Site management is disabled until IRIS_ADMIN_TOKEN is configured", http.StatusServiceUnavailable)
+		return false
+	}
+	if !h.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) isAdmin(r *http.Request) bool {
+	provided := bearerToken(r)
+	return h.adminToken != "" && len(provided) == len(h.adminToken) &&
+		subtle.ConstantTimeCompare([]byte(provided), []byte(h.adminToken)) == 1
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// IngestKeys manages the trusted ingest keys of a site and needs the admin
+// role or the sites:write scope on it. Keys are returned in full only by the request that creates them.
+func (h *Handler) IngestKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost+", "+http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := h.requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site_id")
+		if siteID == "" {
+			http.Error(w, "Missing site_id", http.StatusBadRequest)
+			return
+		}
+		if !p.manages(siteID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		keys, err := h.Repo.GetIngestKeys(r.Context(), siteID)
+		if err != nil {
+			logError(r.Context(), "IngestKeys", "query error", err)
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, keys)
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		var key core.IngestKey
+		if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if !p.manages(key.SiteID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := h.Repo.CreateIngestKey(h.audited(r, p, core.AuditIngestKeyCreate, key.SiteID), &key); err != nil {
+			logError(r.Context(), "IngestKeys", "create error", err)
+			if errors.Is(err, core.ErrSiteNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, key)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing id", http.StatusBadRequest)
+			return
+		}
+		// Site members name the site so the key can be checked against it.
+		if !p.admin {
+			siteID := r.URL.Query().Get("site_id")
+			if !p.manages(siteID) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			keys, err := h.Repo.GetIngestKeys(r.Context(), siteID)
+			if err != nil {
+				logError(r.Context(), "IngestKeys", "query error", err)
+				http.Error(w, "Query failed", http.StatusInternalServerError)
+				return
+			}
+			if !slices.ContainsFunc(keys, func(key core.IngestKey) bool { return key.ID == id }) {
+				http.Error(w, "Ingest key not found", http.StatusNotFound)
+				return
+			}
+		}
+		ctx := h.audited(r, p, core.AuditIngestKeyRevoke, r.URL.Query().Get("site_id"))
+		if err := h.Repo.RevokeIngestKey(ctx, id); err != nil {
+			if errors.Is(err, core.ErrIngestKeyInvalid) {
+				http.Error(w, "Ingest key not found", http.StatusNotFound)
+				return
+			}
+			logError(r.Context(), "IngestKeys", "revoke error", err)
+			http.Error(w, "Failed to revoke ingest key", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DataSubjects exports (GET) or erases (DELETE) every event for one
+// visitor_id or session_id, optionally limited to a site_id. Site admins may
+// act on their own sites, and API tokens with the export scope may export;
+// requests spanning every site need the admin token or an unrestricted token.
+func (h *Handler) DataSubjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := h.requireManager(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	subject := core.DataSubject{
+		SiteID:    q.Get("site_id"),
+		VisitorID: q.Get("visitor_id"),
+		SessionID: q.Get("session_id"),
+	}
+	if (subject.VisitorID == "") == (subject.SessionID == "") {
+		http.Error(w, "Exactly one of visitor_id or session_id is required", http.StatusBadRequest)
+		return
+	}
+	exporter := r.Method == http.MethodGet && p.scoped(core.ScopeExport, subject.SiteID)
+	if !p.admin && !p.can(subject.SiteID, core.RoleAdmin) && !exporter {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		export, err := h.Repo.ExportDataSubject(r.Context(), subject, p.actor())
+		if err != nil {
+			logError(r.Context(), "DataSubjects", "export error", err)
+			http.Error(w, "Export failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="iris-data-subject.json"`)
+		writeJSON(w, http.StatusOK, export)
+		return
+	}
+	ctx := h.audited(r, p, core.AuditDataSubjectErase, subject.SiteID)
+	deleted, err := h.Repo.EraseDataSubject(ctx, subject, p.actor())
+	if err != nil {
+		logError(r.Context(), "DataSubjects", "erase error", err)
+		http.Error(w, "Erasure failed", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "data subject erased", "component", "DataSubjects", "deleted_events", deleted)
+	writeJSON(w, http.StatusOK, map[string]int64{"deleted_events": deleted})
+}
+
 func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.Header().Set("Allow", http.MethodGet)
@@ -440,10 +1006,11 @@ func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
 	}
 	status, err := h.Repo.GetSystemStatus(r.Context())
 	if err != nil {
-		log.Printf("[Status] database error: %v", err)
+		logError(r.Context(), "Status", "database error", err)
 		http.Error(w, "Unavailable", http.StatusServiceUnavailable)
 		return
 	}
+	status.RateLimited = h.RateLimited()
 	writeJSON(w, http.StatusOK, status)
 }
 
@@ -2,7 +2,9 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/url"
 	"strings"
 	"time"
@@ -13,15 +15,23 @@ import (
 
 const (
 	maxIdentifierLength = 128
-	maxURLLength        = 2048
 	maxFutureClockSkew  = 5 * time.Minute
+	maxEngagedMS        = 24 * 60 * 60 * 1000
+	maxSearchTermLength = 100
 )
 
+var reservedEventNames = map[string]struct{}{
+	"$pageview":   {},
+	"$click":      {},
+	"$web_vital":  {},
+	"$engagement": {},
+}
+
 func (h *Handler) prepareIncomingEvent(
 	ctx context.Context,
 	event *core.Event,
 	receivedAt time.Time,
-	origin string,
+	client ingestClient,
 ) error {
 	event.ID = strings.TrimSpace(event.ID)
 	event.EventName = strings.TrimSpace(event.EventName)
@@ -34,14 +44,17 @@ func (h *Handler) prepareIncomingEvent(
 		"session id": event.SessionID, "visitor id": event.VisitorID,
 	} {
 		if value == "" {
+			if field == "session id" || field == "visitor id" {
+				// Checked once the site's server identity setting is known.
+				continue
+			}
 			return fmt.Errorf("%s is required", field)
 		}
 		if len(value) > maxIdentifierLength {
 			return fmt.Errorf("%s exceeds %d characters", field, maxIdentifierLength)
 		}
 	}
-	if strings.HasPrefix(event.EventName, "$") &&
-		event.EventName != "$pageview" && event.EventName != "$click" && event.EventName != "$web_vital" {
+	if _, reserved := reservedEventNames[event.EventName]; strings.HasPrefix(event.EventName, "$") && !reserved {
 		return fmt.Errorf("unsupported reserved event name %q", event.EventName)
 	}
 	if strings.IndexFunc(event.EventName, unicode.IsControl) >= 0 {
@@ -51,7 +64,8 @@ func (h *Handler) prepareIncomingEvent(
 		return fmt.Errorf("screen width is out of range")
 	}
 
-	parsedURL, err := normalizeTrackedURL(event.URL)
+	rawURL := event.URL
+	parsedURL, err := core.NormalizeTrackedURL(event.URL)
 	if err != nil {
 		return fmt.Errorf("invalid url: %w", err)
 	}
@@ -65,7 +79,7 @@ func (h *Handler) prepareIncomingEvent(
 	if event.Pathname == "" {
 		event.Pathname = "/"
 	}
-	if origin = strings.TrimSpace(origin); origin != "" {
+	if origin := strings.TrimSpace(client.origin); origin != "" {
 		parsedOrigin, parseErr := url.Parse(origin)
 		if parseErr != nil || (parsedOrigin.Scheme != "http" && parsedOrigin.Scheme != "https") ||
 			strings.ToLower(parsedOrigin.Hostname()) != event.Domain {
@@ -74,16 +88,37 @@ func (h *Handler) prepareIncomingEvent(
 	}
 
 	if event.Referrer != "" {
-		parsedReferrer, parseErr := normalizeTrackedURL(event.Referrer)
+		parsedReferrer, parseErr := core.NormalizeTrackedURL(event.Referrer)
 		if parseErr != nil {
 			return fmt.Errorf("invalid referrer: %w", parseErr)
 		}
 		event.Referrer = parsedReferrer.String()
 		event.ReferrerHost = strings.TrimPrefix(parsedReferrer.Hostname(), "www.")
 	}
-	if err := h.Repo.ValidateSite(ctx, event.SiteID, event.Domain); err != nil {
+	site, err := h.ingestSite(ctx, event.SiteID)
+	if err != nil {
+		return err
+	}
+	if !site.HasDomain(event.Domain) {
+		return fmt.Errorf("%w: %s", core.ErrDomainNotAllowed, event.Domain)
+	}
+	h.metrics.knownSites.Store(site.ID, struct{}{})
+	anonymous, err := applyPrivacyPolicy(event, site, client)
+	if err != nil {
+		return err
+	}
+	rules, err := h.sitePathRules(site)
+	if err != nil {
 		return err
 	}
+	event.Pathname, event.ContentGroup = rules.Apply(event.Pathname)
+	switch event.EventName {
+	case "$click":
+		h.classifyClick(event, site, parsedURL)
+	case "$pageview":
+		event.NotFound = isTruthy(event.Properties["$404"])
+		event.SearchTerm = extractSearchTerm(site, rawURL)
+	}
 
 	event.ReceivedAt = receivedAt.UTC()
 	if event.Timestamp.IsZero() {
@@ -94,6 +129,11 @@ func (h *Handler) prepareIncomingEvent(
 			return fmt.Errorf("event timestamp is too far in the future")
 		}
 	}
+	if anonymous {
+		event.SessionID, event.VisitorID = "", ""
+	} else if err := h.resolveIdentity(ctx, event, site, client); err != nil {
+		return err
+	}
 	if event.SchemaVersion == 0 {
 		event.SchemaVersion = 1
 	}
@@ -108,27 +148,195 @@ func (h *Handler) prepareIncomingEvent(
 	} else {
 		event.Properties = truncateStrings(event.Properties, 200).(map[string]any)
 	}
+	switch event.EventName {
+	case "$engagement":
+		if err := validateEngagement(event.Properties); err != nil {
+			return err
+		}
+	case "$click":
+		event.ElementSignature = core.ElementSignature(event.Properties)
+	}
+	event.SampleRate = site.EventSampleRate(event.EventName)
+	if !sampled(site.ID, sampleKey(event), event.SampleRate) {
+		return errEventDropped
+	}
 	return nil
 }
 
-func normalizeTrackedURL(raw string) (*url.URL, error) {
-	if len(raw) == 0 || len(raw) > maxURLLength {
-		return nil, fmt.Errorf("url must contain between 1 and %d characters", maxURLLength)
+// errEventDropped marks an event discarded by the site's privacy policy or
+// by sampling. It is acknowledged like an accepted event so that clients
+// do not retry it.
+var errEventDropped = errors.New("event dropped by site policy")
+
+// applyPrivacyPolicy reports whether an event must be stored anonymously. A
+// privacy signal overrides the event's $consent property, which in turn
+// overrides the site's default consent.
+func applyPrivacyPolicy(event *core.Event, site *core.Site, client ingestClient) (bool, error) {
+	consent := site.DefaultConsent
+	if raw, ok := event.Properties["$consent"]; ok {
+		value, _ := raw.(string)
+		if value != core.ConsentFull && value != core.ConsentAnonymous {
+			return false, fmt.Errorf("$consent must be %q or %q", core.ConsentFull, core.ConsentAnonymous)
+		}
+		consent = value
+	}
+	if client.privacySignal {
+		switch site.PrivacySignals {
+		case core.PrivacySignalsDrop:
+			return false, errEventDropped
+		case core.PrivacySignalsAnonymize:
+			return true, nil
+		}
+	}
+	return consent == core.ConsentAnonymous, nil
+}
+
+// resolveIdentity derives a missing visitor or session ID when the site or
+// the client allows server identity, and rejects the event otherwise. The
+// visitor salt follows receive time, so only the current day's salt is kept;
+// session inactivity follows occurrence time.
+func (h *Handler) resolveIdentity(
+	ctx context.Context,
+	event *core.Event,
+	site *core.Site,
+	client ingestClient,
+) error {
+	if event.SessionID != "" && event.VisitorID != "" {
+		return nil
+	}
+	if !site.ServerIdentity && !client.serverIdentity {
+		if event.SessionID == "" {
+			return fmt.Errorf("session id is required")
+		}
+		return fmt.Errorf("visitor id is required")
+	}
+	if event.VisitorID == "" {
+		visitorID, err := h.Repo.ServerVisitorID(ctx, site, client.ip+"\x00"+client.userAgent, event.ReceivedAt)
+		if err != nil {
+			return err
+		}
+		event.VisitorID = visitorID
+	}
+	if event.SessionID == "" {
+		sessionID, err := h.Repo.ServerSessionID(ctx, site.ID, event.VisitorID, event.Timestamp)
+		if err != nil {
+			return err
+		}
+		event.SessionID = sessionID
 	}
-	parsed, err := url.Parse(raw)
+	return nil
+}
+
+// validateEngagement checks the measurements carried by a $engagement event:
+// active milliseconds on the page and the deepest scroll position reached.
+func validateEngagement(properties map[string]any) error {
+	engagedMS, ok := properties["$engaged_ms"].(float64)
+	if !ok || math.IsNaN(engagedMS) || engagedMS < 0 || engagedMS > maxEngagedMS {
+		return fmt.Errorf("engagement $engaged_ms must be a number between 0 and %d", maxEngagedMS)
+	}
+	scrollPercent, ok := properties["$scroll_pct"].(float64)
+	if !ok || math.IsNaN(scrollPercent) || scrollPercent < 0 || scrollPercent > 100 {
+		return fmt.Errorf("engagement $scroll_pct must be a number between 0 and 100")
+	}
+	properties["$engaged_ms"] = math.Round(engagedMS)
+	properties["$scroll_pct"] = math.Round(scrollPercent)
+	return nil
+}
+
+// classifyClick marks a clicked link as a file download or as outbound using
+// the site already loaded for the event. Other clicks are left unclassified.
+func (h *Handler) classifyClick(event *core.Event, site *core.Site, page *url.URL) {
+	href, _ := event.Properties["$href"].(string)
+	event.LinkKind, event.LinkURL = core.ClassifyLink(site, page, href, h.downloadExtensions)
+}
+
+// extractSearchTerm returns the on-site search term carried in the page URL's
+// query string, which core.NormalizeTrackedURL otherwise discards.
+func extractSearchTerm(site *core.Site, rawURL string) string {
+	if site.SearchParam == "" {
+		return ""
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.RawQuery == "" {
+		return ""
+	}
+	values, _ := url.ParseQuery(parsed.RawQuery)
+	return normalizeSearchTerm(values.Get(site.SearchParam))
+}
+
+// siteCacheTTL bounds how long ingestion keeps using a site's settings after
+// they change outside this process, such as from another server instance.
+const siteCacheTTL = 5 * time.Second
+
+type cachedSite struct {
+	site   *core.Site
+	loaded time.Time
+}
+
+// ingestSite returns the enabled site for siteID, loading it at most once per
+// siteCacheTTL. The returned site is shared between requests and must not be
+// modified.
+func (h *Handler) ingestSite(ctx context.Context, siteID string) (*core.Site, error) {
+	if cached, ok := h.sites.Load(siteID); ok && time.Since(cached.(cachedSite).loaded) < siteCacheTTL {
+		return cached.(cachedSite).site, nil
+	}
+	site, err := h.Repo.GetSite(ctx, siteID)
 	if err != nil {
+		h.sites.Delete(siteID)
 		return nil, err
 	}
-	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" || parsed.User != nil {
-		return nil, fmt.Errorf("url must be an absolute http or https url")
+	h.sites.Store(siteID, cachedSite{site: site, loaded: time.Now()})
+	return site, nil
+}
+
+type compiledPathRules struct {
+	source string
+	rules  *core.PathRuleSet
+}
+
+// sitePathRules returns the compiled path rules for site, recompiling only
+// when the site's configuration has changed since the last event.
+func (h *Handler) sitePathRules(site *core.Site) (*core.PathRuleSet, error) {
+	if len(site.PathRules) == 0 && len(site.ContentGroups) == 0 {
+		return nil, nil
+	}
+	source := fmt.Sprintf("%q %q", site.PathRules, site.ContentGroups)
+	if cached, ok := h.pathRules.Load(site.ID); ok && cached.(compiledPathRules).source == source {
+		return cached.(compiledPathRules).rules, nil
 	}
-	parsed.Scheme = strings.ToLower(parsed.Scheme)
-	parsed.Host = strings.ToLower(parsed.Host)
-	parsed.RawQuery = ""
-	parsed.ForceQuery = false
-	parsed.Fragment = ""
-	if parsed.Path == "" {
-		parsed.Path = "/"
+	rules, err := core.CompilePathRules(site.PathRules, site.ContentGroups)
+	if err != nil {
+		return nil, fmt.Errorf("compile path rules for site %q: %w", site.ID, err)
+	}
+	h.pathRules.Store(site.ID, compiledPathRules{source: source, rules: rules})
+	return rules, nil
+}
+
+// normalizeSearchTerm lowercases a search term, collapses its whitespace,
+// drops control characters, and truncates it to maxSearchTermLength runes.
+func normalizeSearchTerm(term string) string {
+	term = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, term)
+	term = strings.Join(strings.Fields(strings.ToLower(term)), " ")
+	if runes := []rune(term); len(runes) > maxSearchTermLength {
+		term = strings.TrimSpace(string(runes[:maxSearchTermLength]))
+	}
+	return term
+}
+
+func isTruthy(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v == 1
+	case string:
+		return v == "true" || v == "1"
+	default:
+		return false
 	}
-	return parsed, nil
 }
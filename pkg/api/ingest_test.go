@@ -2,8 +2,11 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
@@ -213,3 +216,728 @@ func TestSites_RequiresAdminToken(t *testing.T) {
 		})
 	}
 }
+
+func TestTrackEvent_ValidatesEngagementMeasurements(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandler(repo)
+
+	tests := []struct {
+		name       string
+		properties string
+		status     int
+	}{
+		{name: "valid", properties: `{"$engaged_ms":12500.4,"$scroll_pct":64}`, status: http.StatusAccepted},
+		{name: "missing scroll", properties: `{"$engaged_ms":12500}`, status: http.StatusBadRequest},
+		{name: "negative time", properties: `{"$engaged_ms":-1,"$scroll_pct":10}`, status: http.StatusBadRequest},
+		{name: "scroll over 100", properties: `{"$engaged_ms":10,"$scroll_pct":101}`, status: http.StatusBadRequest},
+		{name: "string time", properties: `{"$engaged_ms":"10","$scroll_pct":10}`, status: http.StatusBadRequest},
+	}
+	for index, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := fmt.Sprintf(
+				`{"id":"engagement-%d","n":"$engagement","u":"https://example.com/guide","s":"site-a","sid":"s","vid":"v","p":%s}`,
+				index, test.properties,
+			)
+			request := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body))
+			response := httptest.NewRecorder()
+			handler.TrackEvent(response, request)
+			if response.Code != test.status {
+				t.Fatalf("status = %d, want %d; body=%s", response.Code, test.status, response.Body.String())
+			}
+		})
+	}
+}
+
+func TestTrackEvent_ClassifiesLinksAndNotFoundPages(t *testing.T) {
+	databasePath := filepath.Join(t.TempDir(), "iris.db")
+	repo, err := db.NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com", "docs.example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandler(repo)
+
+	tests := []struct {
+		id       string
+		body     string
+		linkKind string
+		linkURL  string
+		notFound int
+	}{
+		{id: "outbound", body: `"n":"$click","p":{"$href":"https://github.com/iris?tab=readme"}`, linkKind: "outbound", linkURL: "https://github.com/iris"},
+		{id: "sibling", body: `"n":"$click","p":{"$href":"https://docs.example.com/start"}`},
+		{id: "relative-download", body: `"n":"$click","p":{"$href":"../files/Report.PDF"}`, linkKind: "download", linkURL: "https://example.com/files/Report.PDF"},
+		{id: "mailto", body: `"n":"$click","p":{"$href":"mailto:team@example.com"}`},
+		{id: "broken", body: `"n":"$pageview","p":{"$404":true}`, notFound: 1},
+	}
+	for _, test := range tests {
+		body := `{"id":"` + test.id + `","u":"https://example.com/guides/intro","s":"site-a","sid":"s","vid":"v",` + test.body + `}`
+		request := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body))
+		response := httptest.NewRecorder()
+		handler.TrackEvent(response, request)
+		if response.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d; body=%s", test.id, response.Code, response.Body.String())
+		}
+	}
+
+	database, err := sql.Open("sqlite3", databasePath)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer database.Close()
+	for _, test := range tests {
+		var linkKind, linkURL string
+		var notFound int
+		if err := database.QueryRow(`
+			SELECT link_kind, link_url, not_found FROM events WHERE id = ?
+		`, test.id).Scan(&linkKind, &linkURL, &notFound); err != nil {
+			t.Fatalf("%s: read event: %v", test.id, err)
+		}
+		if linkKind != test.linkKind || linkURL != test.linkURL || notFound != test.notFound {
+			t.Fatalf("%s: classification = (%q, %q, %d), want (%q, %q, %d)",
+				test.id, linkKind, linkURL, notFound, test.linkKind, test.linkURL, test.notFound)
+		}
+	}
+}
+
+func TestTrackEvent_ExtractsSiteSearchTerm(t *testing.T) {
+	databasePath := filepath.Join(t.TempDir(), "iris.db")
+	repo, err := db.NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range []core.Site{
+		{ID: "docs", Domains: []string{"docs.example.com"}, SearchParam: "search"},
+		{ID: "marketing", Domains: []string{"example.com"}},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
+	}
+	handler := NewHandler(repo)
+
+	tests := []struct {
+		id, site, url, want string
+	}{
+		{id: "search", site: "docs", url: "https://docs.example.com/search?search=%20Rate%0A%20%20LIMITS&page=2", want: "rate limits"},
+		{id: "long", site: "docs", url: "https://docs.example.com/search?search=" + strings.Repeat("a", 150), want: strings.Repeat("a", maxSearchTermLength)},
+		{id: "other-param", site: "docs", url: "https://docs.example.com/search?q=ignored"},
+		{id: "disabled", site: "marketing", url: "https://example.com/?search=ignored"},
+	}
+	for _, test := range tests {
+		body := `{"id":"` + test.id + `","n":"$pageview","u":"` + test.url + `","s":"` + test.site + `","sid":"s","vid":"v"}`
+		request := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body))
+		response := httptest.NewRecorder()
+		handler.TrackEvent(response, request)
+		if response.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d; body=%s", test.id, response.Code, response.Body.String())
+		}
+	}
+
+	database, err := sql.Open("sqlite3", databasePath)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer database.Close()
+	for _, test := range tests {
+		var storedURL, term string
+		if err := database.QueryRow(
+			"SELECT url, search_term FROM events WHERE id = ?", test.id,
+		).Scan(&storedURL, &term); err != nil {
+			t.Fatalf("%s: read event: %v", test.id, err)
+		}
+		if term != test.want || strings.Contains(storedURL, "?") {
+			t.Fatalf("%s: url=%q term=%q, want term %q and no query", test.id, storedURL, term, test.want)
+		}
+	}
+}
+
+func TestTrackEvent_AppliesSitePathRules(t *testing.T) {
+	databasePath := filepath.Join(t.TempDir(), "iris.db")
+	repo, err := db.NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com"},
+		PathRules: []core.PathRule{
+			{Pattern: `^/orders/[0-9]+/(invoice|receipt)$`, Replacement: "/orders/:id/$1"},
+			{Pattern: "/users/:id/settings"},
+		},
+		ContentGroups: []core.ContentGroup{
+			{Name: "Account", Pattern: "/users/**"},
+			{Name: "Blog", Pattern: "/blog/**"},
+		},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandler(repo)
+
+	tests := []struct {
+		id, path, wantPath, wantGroup string
+	}{
+		{id: "regex", path: "/orders/981/receipt", wantPath: "/orders/:id/receipt"},
+		{id: "param", path: "/users/123/settings", wantPath: "/users/:id/settings", wantGroup: "Account"},
+		{id: "group", path: "/blog/2026/launch", wantPath: "/blog/2026/launch", wantGroup: "Blog"},
+		{id: "plain", path: "/pricing", wantPath: "/pricing"},
+	}
+	for _, test := range tests {
+		body := `{"id":"` + test.id + `","n":"$pageview","u":"https://example.com` + test.path + `","s":"site-a","sid":"s","vid":"v"}`
+		request := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body))
+		response := httptest.NewRecorder()
+		handler.TrackEvent(response, request)
+		if response.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d; body=%s", test.id, response.Code, response.Body.String())
+		}
+	}
+
+	database, err := sql.Open("sqlite3", databasePath)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer database.Close()
+	for _, test := range tests {
+		var storedURL, pathname, group string
+		if err := database.QueryRow(
+			"SELECT url, pathname, content_group FROM events WHERE id = ?", test.id,
+		).Scan(&storedURL, &pathname, &group); err != nil {
+			t.Fatalf("%s: read event: %v", test.id, err)
+		}
+		if storedURL != "https://example.com"+test.path || pathname != test.wantPath || group != test.wantGroup {
+			t.Fatalf("%s: url=%q pathname=%q group=%q, want pathname %q group %q",
+				test.id, storedURL, pathname, group, test.wantPath, test.wantGroup)
+		}
+	}
+}
+
+type fullQueueRepository struct {
+	*db.SqliteRepository
+}
+
+func (fullQueueRepository) Insert(context.Context, *core.Event) error {
+	return core.ErrIngestQueueFull
+}
+
+func TestTrackEvent_FullIngestQueueAsksClientToRetry(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandler(fullQueueRepository{repo})
+
+	body := `{"id":"event-1","n":"$pageview","u":"https://example.com/","s":"site-a","sid":"s","vid":"v"}`
+	request := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body))
+	response := httptest.NewRecorder()
+	handler.TrackEvent(response, request)
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429; body=%s", response.Code, response.Body.String())
+	}
+	if response.Header().Get("Retry-After") == "" {
+		t.Fatal("429 response is missing Retry-After")
+	}
+}
+
+type countingSiteRepository struct {
+	*db.SqliteRepository
+	lookups int
+}
+
+func (r *countingSiteRepository) GetSite(ctx context.Context, siteID string) (*core.Site, error) {
+	r.lookups++
+	return r.SqliteRepository.GetSite(ctx, siteID)
+}
+
+func (r *countingSiteRepository) ValidateSite(ctx context.Context, siteID, domain string) error {
+	r.lookups++
+	return r.SqliteRepository.ValidateSite(ctx, siteID, domain)
+}
+
+func TestTrackEvent_LoadsTheSiteOncePerCacheWindow(t *testing.T) {
+	_, sqlite := newQuotaTestHandler(t, core.Site{ID: "site-a", Domains: []string{"example.com"}})
+	repo := &countingSiteRepository{SqliteRepository: sqlite}
+	handler := NewHandler(repo)
+
+	for i, domain := range []string{"example.com", "example.com", "other.com"} {
+		body := fmt.Sprintf(`{"id":"event-%d","n":"$pageview","u":"https://%s/","s":"site-a","sid":"s","vid":"v"}`, i, domain)
+		response := httptest.NewRecorder()
+		handler.TrackEvent(response, httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body)))
+		want := http.StatusAccepted
+		if domain == "other.com" {
+			want = http.StatusForbidden
+		}
+		if response.Code != want {
+			t.Fatalf("event %d status = %d, want %d; body=%s", i, response.Code, want, response.Body.String())
+		}
+	}
+	if repo.lookups != 1 {
+		t.Fatalf("site lookups = %d, want 1", repo.lookups)
+	}
+}
+
+func TestTrackBatchEvents_PartialModeStoresValidEvents(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandler(repo)
+	body := `[
+		{"id":"good-1","n":"$pageview","u":"https://example.com/","s":"site-a","sid":"s","vid":"v"},
+		{"id":"bad-url","n":"$pageview","u":"not a url","s":"site-a","sid":"s","vid":"v"},
+		{"id":"unknown-site","n":"$pageview","u":"https://example.com/","s":"missing","sid":"s","vid":"v"},
+		{"id":"good-2","n":"signup","u":"https://example.com/join","s":"site-a","sid":"s","vid":"v"}
+	]`
+
+	request := httptest.NewRequest(http.MethodPost, "/api/events", strings.NewReader(body))
+	response := httptest.NewRecorder()
+	handler.TrackBatchEvents(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("default mode status = %d, want 400", response.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/api/events?partial=1", strings.NewReader(body))
+	response = httptest.NewRecorder()
+	handler.TrackBatchEvents(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("partial mode status = %d; body=%s", response.Code, response.Body.String())
+	}
+	var result core.BatchResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatalf("decode batch result: %v", err)
+	}
+	wantStatuses := []int{http.StatusAccepted, http.StatusBadRequest, http.StatusNotFound, http.StatusAccepted}
+	if result.Accepted != 2 || result.Rejected != 2 || len(result.Results) != len(wantStatuses) {
+		t.Fatalf("unexpected batch result: %+v", result)
+	}
+	for index, want := range wantStatuses {
+		got := result.Results[index]
+		if got.Index != index || got.Status != want || (want != http.StatusAccepted) != (got.Error != "") {
+			t.Fatalf("result %d = %+v, want status %d", index, got, want)
+		}
+	}
+
+	stats, err := repo.GetStats(context.Background(), "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 1 {
+		t.Fatalf("pageviews = %d, want only the valid pageview", stats.Pageviews)
+	}
+}
+
+func TestTrackBatchEvents_AcceptsEncodedBodies(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandler(repo)
+	event := func(id string) string {
+		return fmt.Sprintf(`{"id":%q,"n":"$pageview","u":"https://example.com/","s":"site-a","sid":"s","vid":"v"}`, id)
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("[" + event("gzip-1") + "," + event("gzip-2") + "]"))
+	writer.Close()
+
+	for _, test := range []struct {
+		name        string
+		body        []byte
+		contentType string
+		encoding    string
+	}{
+		{name: "gzip", body: compressed.Bytes(), contentType: "application/json", encoding: "gzip"},
+		{name: "ndjson", body: []byte(event("nd-1") + "\n" + event("nd-2") + "\n"), contentType: "application/x-ndjson"},
+		{name: "beacon", body: []byte("[" + event("beacon-1") + "]"), contentType: "text/plain;charset=UTF-8"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/events", bytes.NewReader(test.body))
+			request.Header.Set("Content-Type", test.contentType)
+			request.Header.Set("Content-Encoding", test.encoding)
+			response := httptest.NewRecorder()
+			handler.TrackBatchEvents(response, request)
+			if response.Code != http.StatusAccepted {
+				t.Fatalf("status = %d; body=%s", response.Code, response.Body.String())
+			}
+		})
+	}
+
+	stats, err := repo.GetStats(context.Background(), "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 5 {
+		t.Fatalf("pageviews = %d, want 5", stats.Pageviews)
+	}
+
+	for _, test := range []struct {
+		name        string
+		contentType string
+		encoding    string
+		status      int
+	}{
+		{name: "unsupported type", contentType: "application/xml", status: http.StatusUnsupportedMediaType},
+		{name: "unsupported encoding", contentType: "application/json", encoding: "br", status: http.StatusUnsupportedMediaType},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/events", strings.NewReader("[]"))
+			request.Header.Set("Content-Type", test.contentType)
+			request.Header.Set("Content-Encoding", test.encoding)
+			response := httptest.NewRecorder()
+			handler.TrackBatchEvents(response, request)
+			if response.Code != test.status {
+				t.Fatalf("status = %d, want %d", response.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestTrackBatchEvents_CapsDecompressedSize(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("[" + strings.Repeat(" ", 8<<20) + "]"))
+	writer.Close()
+	if compressed.Len() >= maxBodyBytes {
+		t.Fatalf("compressed body is %d bytes; the test needs it under the limit", compressed.Len())
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/events", &compressed)
+	request.Header.Set("Content-Encoding", "gzip")
+	response := httptest.NewRecorder()
+	NewHandler(repo).TrackBatchEvents(response, request)
+	if response.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", response.Code)
+	}
+}
+
+func TestTrackBatchEvents_IngestKeyRaisesLimitsForItsSite(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range []core.Site{
+		{ID: "site-a", Domains: []string{"example.com"}},
+		{ID: "site-b", Domains: []string{"other.com"}},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
+	}
+	handler := NewHandlerWithAdminToken(repo, "test-admin-token")
+
+	request := httptest.NewRequest(http.MethodPost, "/api/ingest-keys", strings.NewReader(
+		`{"site_id":"site-a","name":"backend","max_batch_size":200}`,
+	))
+	request.Header.Set("Authorization", "Bearer test-admin-token")
+	response := httptest.NewRecorder()
+	handler.IngestKeys(response, request)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("create key status = %d; body=%s", response.Code, response.Body.String())
+	}
+	var key core.IngestKey
+	if err := json.NewDecoder(response.Body).Decode(&key); err != nil || key.Key == "" {
+		t.Fatalf("decode created key %+v: %v", key, err)
+	}
+
+	var batch bytes.Buffer
+	for index := 0; index < 120; index++ {
+		fmt.Fprintf(&batch, `{"id":"keyed-%d","n":"$pageview","u":"https://example.com/","s":"site-a","sid":"s","vid":"v"}`+"\n", index)
+	}
+	send := func(rawKey string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/api/events?partial=1", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/x-ndjson")
+		if rawKey != "" {
+			request.Header.Set(IngestKeyHeader, rawKey)
+		}
+		response := httptest.NewRecorder()
+		handler.TrackBatchEvents(response, request)
+		return response
+	}
+
+	if response := send("", batch.String()); response.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unkeyed oversized batch status = %d, want 413", response.Code)
+	}
+	if response := send("iris_ik_wrong", batch.String()); response.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid key status = %d, want 401", response.Code)
+	}
+	if response := send(key.Key, batch.String()); response.Code != http.StatusOK {
+		t.Fatalf("keyed batch status = %d; body=%s", response.Code, response.Body.String())
+	}
+
+	response = send(key.Key, `{"id":"other-site","n":"$pageview","u":"https://other.com/","s":"site-b","sid":"s","vid":"v"}`)
+	var result core.BatchResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatalf("decode batch result: %v", err)
+	}
+	if result.Rejected != 1 || result.Results[0].Status != http.StatusForbidden {
+		t.Fatalf("other-site result = %+v, want 403", result)
+	}
+
+	stats, err := repo.GetStats(context.Background(), "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 120 {
+		t.Fatalf("pageviews = %d, want 120", stats.Pageviews)
+	}
+}
+
+func TestTrackEvent_DerivesServerIdentityWhenSiteAllowsIt(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range []core.Site{
+		{ID: "cookieless", Domains: []string{"example.com"}, ServerIdentity: true},
+		{ID: "sdk-only", Domains: []string{"other.com"}},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
+	}
+	handler := NewHandler(repo)
+	send := func(id, site, host, remoteAddr string) int {
+		body := fmt.Sprintf(`{"id":%q,"n":"$pageview","u":"https://%s/","s":%q}`, id, host, site)
+		request := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body))
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("User-Agent", "Mozilla/5.0")
+		response := httptest.NewRecorder()
+		handler.TrackEvent(response, request)
+		return response.Code
+	}
+
+	for index, remoteAddr := range []string{"203.0.113.7:5000", "203.0.113.7:5001", "198.51.100.2:5000"} {
+		if status := send(fmt.Sprintf("derived-%d", index), "cookieless", "example.com", remoteAddr); status != http.StatusAccepted {
+			t.Fatalf("event %d status = %d, want 202", index, status)
+		}
+	}
+	if status := send("missing-ids", "sdk-only", "other.com", "203.0.113.7:5000"); status != http.StatusBadRequest {
+		t.Fatalf("site without server identity status = %d, want 400", status)
+	}
+
+	stats, err := repo.GetStats(context.Background(), "cookieless", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 3 || stats.UniqueVisitors != 2 || stats.Sessions != 2 {
+		t.Fatalf("stats = %+v, want 3 pageviews from 2 visitors in 2 sessions", stats)
+	}
+}
+
+func TestClientIP_TrustsForwardedForOnlyFromTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies returned error: %v", err)
+	}
+	handler := NewHandler(nil)
+	handler.SetTrustedProxies(proxies)
+
+	for _, test := range []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{remoteAddr: "203.0.113.7:443", forwarded: "198.51.100.9", want: "203.0.113.7"},
+		{remoteAddr: "10.1.2.3:443", forwarded: "198.51.100.9", want: "198.51.100.9"},
+		{remoteAddr: "10.1.2.3:443", forwarded: "spoofed, 198.51.100.9, 192.0.2.1", want: "198.51.100.9"},
+		{remoteAddr: "10.1.2.3:443", want: "10.1.2.3"},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if got := handler.clientIP(request); got != test.want {
+			t.Fatalf("clientIP(%s, %q) = %s, want %s", test.remoteAddr, test.forwarded, got, test.want)
+		}
+	}
+}
+
+func TestIsHTTPS_TrustsForwardedProtoOnlyFromTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies returned error: %v", err)
+	}
+	handler := NewHandler(nil)
+	handler.SetTrustedProxies(proxies)
+
+	for _, test := range []struct {
+		remoteAddr string
+		proto      string
+		want       bool
+	}{
+		{remoteAddr: "203.0.113.7:443", proto: "https", want: false},
+		{remoteAddr: "10.1.2.3:443", proto: "https", want: true},
+		{remoteAddr: "10.1.2.3:443", proto: "HTTPS, http", want: true},
+		{remoteAddr: "10.1.2.3:443", proto: "http", want: false},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = test.remoteAddr
+		request.Header.Set("X-Forwarded-Proto", test.proto)
+		if got := handler.isHTTPS(request); got != test.want {
+			t.Fatalf("isHTTPS(%s, %q) = %v, want %v", test.remoteAddr, test.proto, got, test.want)
+		}
+	}
+}
+
+func TestTrackBatchEvents_AppliesPrivacySignalAndConsentPolicy(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range []core.Site{
+		{ID: "dropping", Domains: []string{"drop.example.com"}, PrivacySignals: core.PrivacySignalsDrop},
+		{ID: "anonymizing", Domains: []string{"anon.example.com"}, PrivacySignals: core.PrivacySignalsAnonymize},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
+	}
+	handler := NewHandler(repo)
+	send := func(body string, headers map[string]string) core.BatchResult {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "/api/events?partial=1", strings.NewReader(body))
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		response := httptest.NewRecorder()
+		handler.TrackBatchEvents(response, request)
+		if response.Code != http.StatusOK {
+			t.Fatalf("status = %d; body=%s", response.Code, response.Body.String())
+		}
+		var result core.BatchResult
+		if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+			t.Fatalf("decode batch result: %v", err)
+		}
+		return result
+	}
+	event := func(id, site, host, visitor, properties string) string {
+		return fmt.Sprintf(
+			`{"id":%q,"n":"$pageview","u":"https://%s/","s":%q,"sid":"s-%s","vid":%q,"p":%s}`,
+			id, host, site, visitor, visitor, properties,
+		)
+	}
+
+	result := send("["+event("gpc", "dropping", "drop.example.com", "v1", "{}")+"]", map[string]string{"Sec-GPC": "1"})
+	if result.Accepted != 1 || result.Results[0].Status != http.StatusAccepted {
+		t.Fatalf("dropped event result = %+v, want an accepted acknowledgement", result)
+	}
+	result = send("["+strings.Join([]string{
+		event("dnt-1", "anonymizing", "anon.example.com", "v1", "{}"),
+		event("dnt-2", "anonymizing", "anon.example.com", "v2", `{"$consent":"full"}`),
+	}, ",")+"]", map[string]string{"DNT": "1"})
+	if result.Accepted != 2 {
+		t.Fatalf("anonymized events result = %+v", result)
+	}
+	result = send("["+strings.Join([]string{
+		event("consent-anonymous", "anonymizing", "anon.example.com", "v3", `{"$consent":"anonymous"}`),
+		event("consent-full", "anonymizing", "anon.example.com", "v4", `{"$consent":"full"}`),
+		event("consent-invalid", "anonymizing", "anon.example.com", "v5", `{"$consent":"maybe"}`),
+	}, ",")+"]", nil)
+	if result.Accepted != 2 || result.Results[2].Status != http.StatusBadRequest {
+		t.Fatalf("consent result = %+v", result)
+	}
+
+	stats, err := repo.GetStats(context.Background(), "dropping", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 0 {
+		t.Fatalf("dropping site stored %d pageviews, want 0", stats.Pageviews)
+	}
+	stats, err = repo.GetStats(context.Background(), "anonymizing", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 4 || stats.UniqueVisitors != 1 || stats.Sessions != 1 {
+		t.Fatalf("stats = %+v, want 4 pageviews with only the full-consent visitor identified", stats)
+	}
+}
+
+func TestDataSubjects_ExportsAndErasesWithAdminToken(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandlerWithAdminToken(repo, "test-admin-token")
+	request := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(
+		`{"id":"subject-1","n":"$pageview","u":"https://example.com/","s":"site-a","sid":"s","vid":"subject"}`,
+	))
+	response := httptest.NewRecorder()
+	handler.TrackEvent(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("track status = %d", response.Code)
+	}
+
+	call := func(method, query, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "/api/data-subjects?"+query, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		handler.DataSubjects(response, request)
+		return response
+	}
+	if response := call(http.MethodGet, "visitor_id=subject", ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status = %d, want 401", response.Code)
+	}
+	if response := call(http.MethodGet, "visitor_id=subject&session_id=s", "test-admin-token"); response.Code != http.StatusBadRequest {
+		t.Fatalf("ambiguous subject status = %d, want 400", response.Code)
+	}
+
+	response = call(http.MethodGet, "visitor_id=subject", "test-admin-token")
+	var export core.DataSubjectExport
+	if err := json.NewDecoder(response.Body).Decode(&export); err != nil || len(export.Events) != 1 {
+		t.Fatalf("export status %d = %+v, %v; want one event", response.Code, export, err)
+	}
+	response = call(http.MethodDelete, "visitor_id=subject", "test-admin-token")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"deleted_events":1`) {
+		t.Fatalf("erase status = %d; body=%s", response.Code, response.Body.String())
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the ID that ties a request to its log records. A
+// client may send its own; otherwise the server generates one. Either way it
+// is echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds a client-supplied request ID.
+const maxRequestIDLength = 128
+
+// DefaultSuccessLogSampling logs one in this many successful ingestion
+// requests at the info level.
+const DefaultSuccessLogSampling = 100
+
+// NewLogger returns a logger writing to w at level ("debug", "info", "warn"
+// or "error", default info) in format ("text" or "json", default text).
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var minimum slog.Level
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "", "info":
+		minimum = slog.LevelInfo
+	case "debug":
+		minimum = slog.LevelDebug
+	case "warn", "warning":
+		minimum = slog.LevelWarn
+	case "error":
+		minimum = slog.LevelError
+	default:
+		return nil, fmt.Errorf("unknown log level %q: use debug, info, warn or error", level)
+	}
+	options := &slog.HandlerOptions{Level: minimum}
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		return slog.New(requestIDHandler{slog.NewTextHandler(w, options)}), nil
+	case "json":
+		return slog.New(requestIDHandler{slog.NewJSONHandler(w, options)}), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q: use text or json", format)
+}
+
+// requestIDHandler adds the request ID of the record's context, so that any
+// package logging with slog's Context methods, the repository included, ties
+// its records to the request without knowing about request IDs.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request that ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logError logs a failed operation with the request ID of ctx. component
+// names the handler or subsystem, as the bracketed prefixes of older log
+// lines did.
+func logError(ctx context.Context, component, message string, err error) {
+	slog.ErrorContext(ctx, message, "component", component, "error", err)
+}
+
+// validRequestID accepts IDs of letters, digits and ".-_:" so that a client
+// cannot forge log structure.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '.', c == '-', c == '_', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ingestPaths are the routes whose successful requests are logged only at
+// the debug level, since they arrive once per tracked event.
+var ingestPaths = map[string]bool{"/api/event": true, "/api/events": true, "/api/pixel.gif": true}
+
+// RequestLogging assigns each request an ID and writes an access log record
+// with its status, latency and response size once it completes. Server
+// errors are logged at the error level, successful ingestion at debug and
+// everything else at info.
+func RequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case recorder.status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case recorder.status < http.StatusBadRequest && ingestPaths[r.URL.Path]:
+			level = slog.LevelDebug
+		}
+		slog.Default().LogAttrs(ctx, level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Float64("latency_ms", float64(time.Since(started).Microseconds())/1000),
+			slog.Int64("bytes", recorder.bytes),
+		)
+	})
+}
+
+// SetSuccessLogSampling sets how many successful ingestion requests share one
+// info-level log record; 1 logs them all. The debug level logs them all too.
+func (h *Handler) SetSuccessLogSampling(every int) {
+	if every < 1 {
+		every = 1
+	}
+	h.successLogEvery = uint64(every)
+}
+
+// logSuccess logs a successful ingestion request, sampled at the info level
+// so that busy sites do not flood the log.
+func (h *Handler) logSuccess(ctx context.Context, component, message string, attrs ...any) {
+	logger := slog.Default().With("component", component)
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		logger.DebugContext(ctx, message, attrs...)
+		return
+	}
+	every := h.successLogEvery
+	if every == 0 {
+		every = DefaultSuccessLogSampling
+	}
+	if every > 1 && h.successLogs.Add(1)%every != 1 {
+		return
+	}
+	logger.InfoContext(ctx, message, append(attrs, "sampled_one_in", every)...)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
+
+// captureLogs sends the default logger to a JSON buffer at level for the rest
+// of the test and returns a function that decodes the records so far.
+func captureLogs(t *testing.T, level string) func() []map[string]any {
+	t.Helper()
+	var buffer bytes.Buffer
+	logger, err := NewLogger(&buffer, level, "json")
+	if err != nil {
+		t.Fatalf("NewLogger returned error: %v", err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return func() []map[string]any {
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var record map[string]any
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("decode log line %q: %v", line, err)
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+type failingStatsRepository struct {
+	*db.SqliteRepository
+}
+
+func (failingStatsRepository) GetStats(context.Context, string, string, string) (*core.StatsResult, error) {
+	return nil, errors.New("disk I/O error")
+}
+
+func TestRequestLogging_PropagatesRequestIDIntoAccessAndErrorLogs(t *testing.T) {
+	logs := captureLogs(t, "info")
+	_, repo := newQuotaTestHandler(t)
+	handler := NewHandler(failingStatsRepository{repo})
+	server := RequestLogging(http.HandlerFunc(handler.GetStats))
+
+	request := httptest.NewRequest(http.MethodGet, "/api/stats?site_id=site-a", nil)
+	request.Header.Set(RequestIDHeader, "lab-read-42")
+	response := httptest.NewRecorder()
+	server.ServeHTTP(response, request)
+	if response.Code != http.StatusInternalServerError || response.Header().Get(RequestIDHeader) != "lab-read-42" {
+		t.Fatalf("status = %d, request ID = %q", response.Code, response.Header().Get(RequestIDHeader))
+	}
+
+	records := logs()
+	if len(records) != 2 {
+		t.Fatalf("records = %v, want the query error and the access log", records)
+	}
+	failure, access := records[0], records[1]
+	if failure["level"] != "ERROR" || failure["request_id"] != "lab-read-42" ||
+		failure["component"] != "GetStats" || failure["error"] != "disk I/O error" {
+		t.Fatalf("error record = %v", failure)
+	}
+	if access["msg"] != "request" || access["level"] != "ERROR" || access["request_id"] != "lab-read-42" ||
+		access["status"] != float64(http.StatusInternalServerError) || access["path"] != "/api/stats" ||
+		access["bytes"] != float64(response.Body.Len()) || access["latency_ms"] == nil {
+		t.Fatalf("access record = %v", access)
+	}
+
+	for _, id := range []string{"", "has space", strings.Repeat("x", maxRequestIDLength+1)} {
+		request := httptest.NewRequest(http.MethodGet, "/api/stats?site_id=site-a", nil)
+		request.Header.Set(RequestIDHeader, id)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		if generated := response.Header().Get(RequestIDHeader); generated == id || !validRequestID(generated) {
+			t.Fatalf("request ID %q was replaced by %q", id, generated)
+		}
+	}
+}
+
+// warningStatsRepository logs the way the repository does, through slog with
+// the caller's context and no knowledge of request IDs.
+type warningStatsRepository struct {
+	*db.SqliteRepository
+}
+
+func (r warningStatsRepository) GetStats(ctx context.Context, siteID, from, to string) (*core.StatsResult, error) {
+	slog.WarnContext(ctx, "slow stats query", "component", "Repository")
+	return r.SqliteRepository.GetStats(ctx, siteID, from, to)
+}
+
+func TestNewLogger_AddsRequestIDToRecordsLoggedWithItsContext(t *testing.T) {
+	logs := captureLogs(t, "warn")
+	_, repo := newQuotaTestHandler(t)
+	server := RequestLogging(http.HandlerFunc(NewHandler(warningStatsRepository{repo}).GetStats))
+	request := httptest.NewRequest(http.MethodGet, "/api/stats?site_id=site-a", nil)
+	request.Header.Set(RequestIDHeader, "lab-read-7")
+	server.ServeHTTP(httptest.NewRecorder(), request)
+	slog.WarnContext(context.Background(), "background warning")
+
+	records := logs()
+	if len(records) != 2 {
+		t.Fatalf("records = %v, want the repository warning and the background warning", records)
+	}
+	if records[0]["msg"] != "slow stats query" || records[0]["request_id"] != "lab-read-7" {
+		t.Fatalf("repository record = %v", records[0])
+	}
+	if _, ok := records[1]["request_id"]; ok {
+		t.Fatalf("background record = %v, want no request ID", records[1])
+	}
+}
+
+func TestTrackEvent_SamplesSuccessLogs(t *testing.T) {
+	logs := captureLogs(t, "info")
+	handler, _ := newQuotaTestHandler(t, core.Site{ID: "site-a", Name: "Site A", Domains: []string{"example.com"}})
+	handler.SetSuccessLogSampling(3)
+	server := RequestLogging(http.HandlerFunc(handler.TrackEvent))
+	for i := 0; i < 6; i++ {
+		body := fmt.Sprintf(`{"id":"event-%d","n":"$pageview","u":"https://example.com/?q=secret","s":"site-a","sid":"s","vid":"v"}`, i)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body)))
+		if response.Code != http.StatusAccepted {
+			t.Fatalf("status = %d", response.Code)
+		}
+	}
+
+	records := logs()
+	if len(records) != 2 {
+		t.Fatalf("records = %v, want one success record per three events and no access records", records)
+	}
+	for _, record := range records {
+		if record["msg"] != "event stored" || record["site"] != "site-a" || record["sampled_one_in"] != float64(3) ||
+			record["request_id"] == nil || strings.Contains(fmt.Sprint(record), "secret") {
+			t.Fatalf("success record = %v", record)
+		}
+	}
+}
+
+func TestNewLogger_RejectsUnknownSettings(t *testing.T) {
+	for _, settings := range [][2]string{{"verbose", "text"}, {"info", "xml"}} {
+		if _, err := NewLogger(&bytes.Buffer{}, settings[0], settings[1]); err == nil {
+			t.Fatalf("NewLogger(%q, %q) returned nil error", settings[0], settings[1])
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/metrics"
+)
+
+// handlerMetrics holds what the handler records for GET /metrics.
+type handlerMetrics struct {
+	registry       *metrics.Registry
+	ingestRequests *metrics.Counter
+	accepted       *metrics.Counter
+	rejected       *metrics.Counter
+	writeDuration  *metrics.Histogram
+	queryDuration  *metrics.Histogram
+	// knownSites holds the IDs of registered sites that events have named.
+	// Other site IDs come from clients and are not used as label values.
+	knownSites sync.Map
+}
+
+func newHandlerMetrics() *handlerMetrics {
+	registry := metrics.NewRegistry()
+	return &handlerMetrics{
+		registry: registry,
+		ingestRequests: registry.Counter("iris_ingest_requests_total",
+			"Ingestion requests by endpoint and response status.", "endpoint", "code"),
+		accepted: registry.Counter("iris_events_accepted_total",
+			"Events stored, by site.", "site"),
+		rejected: registry.Counter("iris_events_rejected_total",
+			"Events not stored, by site and reason.", "site", "reason"),
+		writeDuration: registry.Histogram("iris_ingest_write_duration_seconds",
+			"Time to store an event or a batch.", metrics.DefaultBuckets, "mode"),
+		queryDuration: registry.Histogram("iris_query_duration_seconds",
+			"Time to answer an analytics read, by endpoint.", metrics.DefaultBuckets, "endpoint"),
+	}
+}
+
+// MetricsRegistry returns the registry written by GET /metrics, so that the
+// server can add metrics of its own, such as the projector's.
+func (h *Handler) MetricsRegistry() *metrics.Registry {
+	return h.metrics.registry
+}
+
+// SetMetricsToken sets a bearer token that may read /metrics in addition to
+// the admin token, so that a scraper does not need admin rights.
+func (h *Handler) SetMetricsToken(token string) {
+	h.metricsToken = strings.TrimSpace(token)
+}
+
+// siteLabel returns siteID for registered sites that events have named and ""
+// for any other, so that clients cannot create series at will.
+func (h *Handler) siteLabel(siteID string) string {
+	if _, ok := h.metrics.knownSites.Load(siteID); ok {
+		return siteID
+	}
+	return ""
+}
+
+func (h *Handler) countAccepted(events ...*core.Event) {
+	for _, event := range events {
+		h.metrics.accepted.Inc(h.siteLabel(event.SiteID))
+	}
+}
+
+func (h *Handler) countRejected(err error, events ...*core.Event) {
+	reason := rejectReason(err)
+	for _, event := range events {
+		h.metrics.rejected.Inc(h.siteLabel(event.SiteID), reason)
+	}
+}
+
+// rejectReason names why an event was not stored. Events dropped by a site's
+// privacy, sampling or quota policy are acknowledged but count as "dropped".
+func rejectReason(err error) string {
+	var limited *rateLimitError
+	switch {
+	case errors.Is(err, errEventDropped):
+		return "dropped"
+	case errors.As(err, &limited):
+		return "rate_limited"
+	case errors.Is(err, core.ErrSiteNotFound):
+		return "site_not_found"
+	case errors.Is(err, core.ErrDomainNotAllowed):
+		return "domain_not_allowed"
+	case errors.Is(err, core.ErrIngestKeyInvalid):
+		return "ingest_key_invalid"
+	case errors.Is(err, core.ErrQuotaExceeded):
+		return "quota_exceeded"
+	case errors.Is(err, core.ErrIngestQueueFull):
+		return "queue_full"
+	case errors.Is(err, errWriteFailed):
+		return "write_failed"
+	}
+	return "invalid"
+}
+
+// errWriteFailed marks events rejected because storing them failed.
+var errWriteFailed = errors.New("write failed")
+
+func (h *Handler) observeWrite(mode string, started time.Time) {
+	h.metrics.writeDuration.Observe(time.Since(started).Seconds(), mode)
+}
+
+// statusRecorder keeps the status code and response size a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// ObserveIngest counts ingestion requests by path and response status. Routes
+// are registered on exact paths, so the path label is bounded.
+func (h *Handler) ObserveIngest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		h.metrics.ingestRequests.Inc(r.URL.Path, strconv.Itoa(recorder.status))
+	}
+}
+
+// ObserveQuery records how long an analytics read took, by path.
+func (h *Handler) ObserveQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		next(w, r)
+		h.metrics.queryDuration.Observe(time.Since(started).Seconds(), r.URL.Path)
+	}
+}
+
+// Metrics writes the server's metrics in the Prometheus text format. It needs
+// the admin token or the metrics token. Database sizes and projection lag are
+// read from GetSystemStatus, and rate limit refusals from RateLimited, on
+// every scrape.
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.isAdmin(r) && !h.isMetricsScraper(r) {
+		if h.adminToken == "" && h.metricsToken == "" {
+			http.Error(w, "Metrics are disabled until IRIS_ADMIN_TOKEN or IRIS_METRICS_TOKEN is configured",
+				http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.metrics.registry.Write(w)
+	h.writeRateLimitMetrics(w)
+	status, err := h.Repo.GetSystemStatus(r.Context())
+	if err != nil {
+		logError(r.Context(), "Metrics", "status error", err)
+		metrics.WriteGauge(w, "iris_database_up", "Whether the database answered the status query.", 0)
+	} else {
+		metrics.WriteGauge(w, "iris_database_up", "Whether the database answered the status query.", 1)
+		metrics.WriteGauge(w, "iris_projection_lag_events", "Stored events the projector has not processed yet.",
+			float64(status.ProjectionLag))
+		metrics.WriteGauge(w, "iris_spool_depth_events", "Events waiting in the local spool.", float64(status.SpoolDepth))
+		metrics.WriteGauge(w, "iris_database_pages", "Pages in the main database file.", float64(status.PageCount))
+		metrics.WriteGauge(w, "iris_database_freelist_pages", "Unused pages in the main database file.",
+			float64(status.FreelistPages))
+		metrics.WriteGauge(w, "iris_database_bytes", "Size of the main database file.", float64(status.DatabaseBytes))
+		metrics.WriteGauge(w, "iris_wal_bytes", "Size of the write-ahead log.", float64(status.WALBytes))
+	}
+	metrics.WriteRuntime(w)
+}
+
+// writeRateLimitMetrics exports the refusals that RateLimited reports to
+// /api/status, by class and scope.
+func (h *Handler) writeRateLimitMetrics(w io.Writer) {
+	scrape := metrics.NewRegistry()
+	limited := scrape.Counter("iris_rate_limited_total",
+		"Requests or events refused by a rate limit, by class and scope.", "class", "scope")
+	for name, refused := range h.RateLimited() {
+		class, scope, _ := strings.Cut(name, "_")
+		limited.Add(float64(refused), class, scope)
+	}
+	scrape.Write(w)
+}
+
+func (h *Handler) isMetricsScraper(r *http.Request) bool {
+	provided := bearerToken(r)
+	return h.metricsToken != "" && len(provided) == len(h.metricsToken) &&
+		subtle.ConstantTimeCompare([]byte(provided), []byte(h.metricsToken)) == 1
+}
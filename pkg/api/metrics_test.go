@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestMetrics_ReportsIngestionQueriesAndStorage(t *testing.T) {
+	handler, _ := newQuotaTestHandler(t, core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+	})
+	handler.SetMetricsToken("scrape-token")
+	handler.SetRateLimits(RateLimits{IP: RateLimit{Rate: 0.01, Burst: 3}}, RateLimits{IP: RateLimit{Rate: 1000, Burst: 1000}})
+	track := handler.ObserveIngest(handler.TrackEvent)
+	for _, body := range []string{
+		`{"id":"ok","n":"$pageview","u":"https://example.com/","s":"site-a","sid":"s","vid":"v"}`,
+		`{"id":"wrong-domain","n":"$pageview","u":"https://other.example/","s":"site-a","sid":"s","vid":"v"}`,
+		`{"id":"unknown","n":"$pageview","u":"https://example.com/","s":"site-unknown","sid":"s","vid":"v"}`,
+		`{"id":"limited","n":"$pageview","u":"https://example.com/","s":"site-a","sid":"s","vid":"v"}`,
+	} {
+		track(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body)))
+	}
+	handler.ObserveQuery(handler.GetStats)(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/api/stats?site_id=site-a", nil))
+
+	response := httptest.NewRecorder()
+	handler.Metrics(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if response.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated scrape status = %d, want 401", response.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Authorization", "Bearer scrape-token")
+	response = httptest.NewRecorder()
+	handler.Metrics(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("scrape status = %d; body=%s", response.Code, response.Body.String())
+	}
+	body := response.Body.String()
+	for _, want := range []string{
+		`iris_ingest_requests_total{endpoint="/api/event",code="202"} 1`,
+		`iris_ingest_requests_total{endpoint="/api/event",code="404"} 1`,
+		`iris_events_accepted_total{site="site-a"} 1`,
+		`iris_events_rejected_total{site="site-a",reason="domain_not_allowed"} 1`,
+		`iris_events_rejected_total{site="",reason="site_not_found"} 1`,
+		`iris_ingest_write_duration_seconds_count{mode="event"} 1`,
+		`iris_query_duration_seconds_count{endpoint="/api/stats"} 1`,
+		`iris_rate_limited_total{class="ingest",scope="ip"} 1`,
+		`iris_rate_limited_total{class="read",scope="ip"} 0`,
+		"iris_database_up 1",
+		"iris_projection_lag_events 1",
+		"go_goroutines ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
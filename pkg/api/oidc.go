@@ -0,0 +1,135 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/oidc"
+)
+
+// oidcStateCookie carries the state, nonce and PKCE verifier of a sign-in in
+// progress, so the flow needs no server-side storage.
+const oidcStateCookie = "iris_oidc"
+
+// oidcStateTTL bounds how long a user may spend at the identity provider.
+const oidcStateTTL = 10 * 60
+
+// SetOIDC enables single sign-on through an OpenID Connect provider. Users
+// are granted the site roles their ID token claims match in rules.
+func (h *Handler) SetOIDC(provider *oidc.Provider, rules []oidc.RoleRule) {
+	h.oidc = provider
+	h.oidcRules = rules
+}
+
+// AuthMethods tells the dashboard which sign-in options to offer.
+func (h *Handler) AuthMethods(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]bool{"password": true, "sso": h.oidc != nil})
+}
+
+// OIDCLogin starts single sign-on by redirecting to the identity provider.
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			logError(r.Context(), "OIDC", "random error", err)
+			http.Error(w, "Sign-in failed", http.StatusInternalServerError)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+	target, err := h.oidc.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		logError(r.Context(), "OIDC", "discovery error", err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    strings.Join(values[:], "."),
+		Path:     "/api/auth/oidc",
+		MaxAge:   oidcStateTTL,
+		HttpOnly: true,
+		Secure:   h.isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallback completes single sign-on: it checks the state, redeems the
+// code with the PKCE verifier, verifies the ID token, maps its claims to site
+// roles and signs the user in like a password login.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1, HttpOnly: true})
+	q := r.URL.Query()
+	if reason := q.Get("error"); reason != "" {
+		http.Error(w, "The identity provider refused the sign-in: "+reason, http.StatusUnauthorized)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "Sign-in expired; start again", http.StatusBadRequest)
+		return
+	}
+	stored := strings.Split(cookie.Value, ".")
+	if len(stored) != 3 || subtle.ConstantTimeCompare([]byte(stored[0]), []byte(q.Get("state"))) != 1 {
+		http.Error(w, "Sign-in state does not match; start again", http.StatusBadRequest)
+		return
+	}
+	nonce, verifier := stored[1], stored[2]
+
+	rawToken, err := h.oidc.Exchange(r.Context(), q.Get("code"), verifier)
+	if err != nil {
+		logError(r.Context(), "OIDC", "exchange error", err)
+		http.Error(w, "The identity provider did not accept the sign-in", http.StatusBadGateway)
+		return
+	}
+	claims, err := h.oidc.Verify(r.Context(), rawToken, nonce)
+	if err != nil {
+		logError(r.Context(), "OIDC", "verify error", err)
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	if claims.Email == "" {
+		http.Error(w, "The identity provider did not share an email address", http.StatusForbidden)
+		return
+	}
+
+	session, err := h.Repo.SignInExternal(r.Context(), core.ExternalIdentity{
+		Issuer:        h.oidc.Issuer(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, claims.Grants(h.oidcRules))
+	switch {
+	case errors.Is(err, core.ErrNoSiteAccess):
+		http.Error(w, "No Iris role is mapped to this account", http.StatusForbidden)
+		return
+	case errors.Is(err, core.ErrLoginFailed):
+		http.Error(w, "This account is disabled or its email belongs to another account", http.StatusForbidden)
+		return
+	case err != nil:
+		logError(r.Context(), "OIDC", "sign-in error", err)
+		http.Error(w, "Sign-in failed", http.StatusInternalServerError)
+		return
+	}
+	h.setSessionCookie(w, r, session)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
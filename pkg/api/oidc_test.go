@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+	"github.com/VatsalP117/iris/pkg/oidc"
+	"github.com/VatsalP117/iris/pkg/oidc/oidctest"
+)
+
+func TestOIDC_SignsInMappedUsersWithSessionCookie(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{ID: "site-a", Domains: []string{"example.com"}}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	idp := oidctest.NewServer(t, "iris", "client-secret")
+	provider, err := oidc.NewProvider(oidc.Config{
+		Issuer: idp.URL, ClientID: "iris", ClientSecret: "client-secret",
+		RedirectURL: "https://iris.example.com/api/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider returned error: %v", err)
+	}
+	rules, err := oidc.ParseRoleRules("groups=analysts:site-a:viewer")
+	if err != nil {
+		t.Fatalf("ParseRoleRules returned error: %v", err)
+	}
+	handler := NewHandlerWithAdminToken(repo, "test-admin-token")
+
+	methods := httptest.NewRecorder()
+	handler.AuthMethods(methods, httptest.NewRequest(http.MethodGet, "/api/auth/methods", nil))
+	if methods.Body.String() != "{\"password\":true,\"sso\":false}\n" {
+		t.Fatalf("methods without SSO = %s", methods.Body.String())
+	}
+	handler.SetOIDC(provider, rules)
+
+	// signIn runs the browser side of the flow and returns the callback response.
+	signIn := func(tamperState bool) *httptest.ResponseRecorder {
+		t.Helper()
+		start := httptest.NewRecorder()
+		handler.OIDCLogin(start, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+		if start.Code != http.StatusFound {
+			t.Fatalf("login status = %d; body=%s", start.Code, start.Body.String())
+		}
+		callback := oidctest.FollowLogin(t, start.Header().Get("Location"))
+		if tamperState {
+			q := callback.Query()
+			q.Set("state", "forged")
+			callback.RawQuery = q.Encode()
+		}
+		request := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+callback.RawQuery, nil)
+		for _, cookie := range start.Result().Cookies() {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		handler.OIDCCallback(response, request)
+		return response
+	}
+
+	idp.SetClaims(map[string]any{"sub": "user-1", "email": "ada@example.com", "email_verified": true, "groups": []string{"sales"}})
+	if response := signIn(false); response.Code != http.StatusForbidden {
+		t.Fatalf("unmapped user status = %d, want 403", response.Code)
+	}
+	idp.SetClaims(map[string]any{"sub": "user-1", "email": "ada@example.com", "email_verified": true, "groups": []string{"analysts"}})
+	if response := signIn(true); response.Code != http.StatusBadRequest {
+		t.Fatalf("forged state status = %d, want 400", response.Code)
+	}
+	response := signIn(false)
+	if response.Code != http.StatusFound || response.Header().Get("Location") != "/" {
+		t.Fatalf("callback status = %d, location %q; body=%s", response.Code, response.Header().Get("Location"), response.Body.String())
+	}
+	var session *http.Cookie
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == SessionCookieName {
+			session = cookie
+		}
+	}
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("session cookie = %+v", session)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+	request.AddCookie(session)
+	me := httptest.NewRecorder()
+	handler.Me(me, request)
+	var user struct {
+		User core.User `json:"user"`
+	}
+	if err := json.NewDecoder(me.Body).Decode(&user); err != nil || me.Code != http.StatusOK {
+		t.Fatalf("me status = %d, %v", me.Code, err)
+	}
+	if user.User.Email != "ada@example.com" || len(user.User.Grants) != 1 || user.User.Grants[0].Role != core.RoleViewer {
+		t.Fatalf("signed-in user = %+v", user.User)
+	}
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// quotaRefreshInterval is how often a site's stored usage is reloaded. In
+// between, events stored by this process are counted locally, so a quota is
+// enforced to within what other processes have stored since the last reload.
+const quotaRefreshInterval = 30 * time.Second
+
+type quotaUsage struct {
+	mu       sync.Mutex
+	location *time.Location
+	month    string
+	stored   int64
+	// local counts events admitted since stored was read, less those whose
+	// insert failed.
+	local      int64
+	refreshed  time.Time
+	refreshing bool
+	warned     bool
+}
+
+// reserveQuota applies the site's monthly event quota to an event that is
+// otherwise ready to store, and counts it toward the quota when it is
+// admitted. A soft quota logs once a month and accepts the event, a hard
+// quota rejects it with core.ErrQuotaExceeded, and a sampling quota keeps
+// only the sessions that fall within the site's sample rate. The check and
+// the count happen under one lock, so concurrent requests and the events of
+// one batch cannot all take the last slot. releaseQuota gives back the slot
+// of an event that was not stored.
+func (h *Handler) reserveQuota(ctx context.Context, event *core.Event) error {
+	site, err := h.ingestSite(ctx, event.SiteID)
+	if err != nil {
+		return err
+	}
+	if site.MonthlyEventQuota <= 0 {
+		h.quotas.Delete(site.ID)
+		return nil
+	}
+	location, err := time.LoadLocation(site.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	month := event.Timestamp.In(location).Format("2006-01")
+
+	cached, _ := h.quotas.LoadOrStore(site.ID, &quotaUsage{})
+	usage := cached.(*quotaUsage)
+	warn := false
+	err = h.admitQuota(ctx, usage, site.ID, month, location, func(used int64) error {
+		if used < site.MonthlyEventQuota {
+			return nil
+		}
+		switch site.QuotaMode {
+		case core.QuotaHard:
+			return core.ErrQuotaExceeded
+		case core.QuotaSample:
+			// Sessions kept here are a subset of those kept by the site's own
+			// sample rate, since both compare the same hash.
+			event.SampleRate = math.Min(event.SampleRate, site.QuotaSampleRate)
+			if !sampled(site.ID, sampleKey(event), event.SampleRate) {
+				return errEventDropped
+			}
+		default:
+			warn = !usage.warned
+			usage.warned = true
+		}
+		return nil
+	})
+	if warn {
+		slog.WarnContext(ctx, "site is over its monthly event quota", "component", "Quota",
+			"site", site.ID, "quota", site.MonthlyEventQuota, "month", month)
+	}
+	return err
+}
+
+// admitQuota calls decide with the site's events in month and counts one more
+// when decide returns nil; decide runs with usage.mu held. The stored count is
+// reloaded when the month changes or quotaRefreshInterval has passed; the
+// read runs without holding usage.mu, and only one periodic reload runs at a
+// time while other events use the previous count.
+func (h *Handler) admitQuota(
+	ctx context.Context,
+	usage *quotaUsage,
+	siteID, month string,
+	location *time.Location,
+	decide func(used int64) error,
+) error {
+	usage.mu.Lock()
+	usage.location = location
+	current := usage.month == month
+	if current && (usage.refreshing || time.Since(usage.refreshed) < quotaRefreshInterval) {
+		defer usage.mu.Unlock()
+		return usage.admit(decide)
+	}
+	usage.refreshing = true
+	var counted int64
+	if current {
+		counted = usage.local
+	}
+	usage.mu.Unlock()
+
+	stored, err := h.Repo.CountMonthEvents(ctx, siteID, month)
+
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	usage.refreshing = false
+	if err != nil {
+		return fmt.Errorf("read usage: %w", err)
+	}
+	if usage.month != month {
+		usage.month, usage.local, usage.warned = month, 0, false
+	} else {
+		// Events admitted during the read stay counted locally. Any the read
+		// already saw are counted twice, and any admitted before it but not
+		// yet stored are missed, until the next reload.
+		usage.local -= counted
+	}
+	usage.stored, usage.refreshed = stored, time.Now()
+	return usage.admit(decide)
+}
+
+// admit counts an event that decide lets in. The caller holds mu.
+func (u *quotaUsage) admit(decide func(used int64) error) error {
+	if err := decide(u.stored + u.local); err != nil {
+		return err
+	}
+	u.local++
+	return nil
+}
+
+// releaseQuota gives back the quota reserved for events that were admitted
+// but not stored.
+func (h *Handler) releaseQuota(events ...*core.Event) {
+	for _, event := range events {
+		cached, ok := h.quotas.Load(event.SiteID)
+		if !ok {
+			continue
+		}
+		usage := cached.(*quotaUsage)
+		usage.mu.Lock()
+		if usage.location != nil && usage.local > 0 &&
+			event.Timestamp.In(usage.location).Format("2006-01") == usage.month {
+			usage.local--
+		}
+		usage.mu.Unlock()
+	}
+}
+
+// Usage returns events per site per calendar month, broken down by event
+// name, for the months from through to (YYYY-MM, default the current month).
+// The admin token sees every site; a site manager passes site_id.
+func (h *Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := h.requireManager(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	siteID := q.Get("site_id")
+	if !p.admin && (siteID == "" || !p.manages(siteID)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	current := time.Now().UTC().Format("2006-01")
+	from, to := q.Get("from"), q.Get("to")
+	if from == "" {
+		from = current
+	}
+	if to == "" {
+		to = current
+	}
+	for _, month := range []string{from, to} {
+		if _, err := time.Parse("2006-01", month); err != nil {
+			http.Error(w, "Invalid month: use YYYY-MM", http.StatusBadRequest)
+			return
+		}
+	}
+	if from > to {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	usage, err := h.Repo.GetUsage(r.Context(), siteID, from, to)
+	if err != nil {
+		logError(r.Context(), "Usage", "query error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
+
+func newQuotaTestHandler(t *testing.T, sites ...core.Site) (*Handler, *db.SqliteRepository) {
+	t.Helper()
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range sites {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
+	}
+	return NewHandlerWithAdminToken(repo, "test-admin-token"), repo
+}
+
+func trackQuotaEvent(handler *Handler, id, session string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"id":%q,"n":"$pageview","u":"https://example.com/","s":"site-a","sid":%q,"vid":"v"}`, id, session)
+	response := httptest.NewRecorder()
+	handler.TrackEvent(response, httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body)))
+	return response
+}
+
+func TestQuota_HardModeRejectsEventsOverQuota(t *testing.T) {
+	handler, repo := newQuotaTestHandler(t, core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+		MonthlyEventQuota: 2, QuotaMode: core.QuotaHard,
+	})
+
+	for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusForbidden} {
+		response := trackQuotaEvent(handler, fmt.Sprint("event-", i), "session")
+		if response.Code != want {
+			t.Fatalf("event %d status = %d, want %d; body=%s", i, response.Code, want, response.Body.String())
+		}
+		if want == http.StatusForbidden && !strings.Contains(response.Body.String(), core.ErrQuotaExceeded.Error()) {
+			t.Fatalf("quota rejection body = %q", response.Body.String())
+		}
+	}
+	if _, err := repo.ProjectPending(context.Background(), 10); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/usage?site_id=site-a", nil)
+	request.Header.Set("Authorization", "Bearer test-admin-token")
+	response := httptest.NewRecorder()
+	handler.Usage(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("usage status = %d; body=%s", response.Code, response.Body.String())
+	}
+	var usage []core.SiteUsage
+	if err := json.Unmarshal(response.Body.Bytes(), &usage); err != nil {
+		t.Fatalf("decode usage: %v", err)
+	}
+	month := time.Now().UTC().Format("2006-01")
+	if len(usage) != 1 || usage[0].Month != month || usage[0].Events != 2 ||
+		usage[0].ByEvent["$pageview"] != 2 || !usage[0].OverQuota {
+		t.Fatalf("usage = %+v", usage)
+	}
+
+	for _, target := range []string{"/api/usage?from=2026-13", "/api/usage?from=2026-09&to=2026-08"} {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("Authorization", "Bearer test-admin-token")
+		response := httptest.NewRecorder()
+		handler.Usage(response, request)
+		if response.Code != http.StatusBadRequest {
+			t.Fatalf("%s status = %d, want 400", target, response.Code)
+		}
+	}
+}
+
+func TestQuota_SampleModeKeepsWholeSessions(t *testing.T) {
+	handler, repo := newQuotaTestHandler(t, core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+		MonthlyEventQuota: 1, QuotaMode: core.QuotaSample, QuotaSampleRate: 0.5,
+	})
+	if response := trackQuotaEvent(handler, "first", "first"); response.Code != http.StatusAccepted {
+		t.Fatalf("first event status = %d", response.Code)
+	}
+
+	kept := 0
+	for session := 0; session < 40; session++ {
+		for i := 0; i < 2; i++ {
+			response := trackQuotaEvent(handler, fmt.Sprintf("event-%d-%d", session, i), fmt.Sprint("session-", session))
+			if response.Code != http.StatusAccepted {
+				t.Fatalf("sampled event status = %d, want 202", response.Code)
+			}
+		}
+		if sampled("site-a", fmt.Sprint("session-", session), 0.5) {
+			kept++
+		}
+	}
+	if kept == 0 || kept == 40 {
+		t.Fatalf("kept %d of 40 sessions at a 0.5 sample rate", kept)
+	}
+	stats, err := repo.GetStats(context.Background(), "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	// Kept sessions record the quota's sample rate and are scaled back up.
+	if stats.Pageviews != 1+2*kept*2 {
+		t.Fatalf("pageviews = %d, want the first event and both events of %d kept sessions, doubled", stats.Pageviews, kept)
+	}
+}
+
+func TestQuota_HardModeCapsABatchThatStraddlesTheQuota(t *testing.T) {
+	handler, repo := newQuotaTestHandler(t, core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+		MonthlyEventQuota: 3, QuotaMode: core.QuotaHard,
+	})
+	if response := trackQuotaEvent(handler, "first", "session"); response.Code != http.StatusAccepted {
+		t.Fatalf("first event status = %d, want 202", response.Code)
+	}
+
+	var events []string
+	for i := range 4 {
+		events = append(events, fmt.Sprintf(
+			`{"id":"batch-%d","n":"$pageview","u":"https://example.com/","s":"site-a","sid":"session","vid":"v"}`, i))
+	}
+	request := httptest.NewRequest(http.MethodPost, "/api/events?partial=1",
+		strings.NewReader("["+strings.Join(events, ",")+"]"))
+	response := httptest.NewRecorder()
+	handler.TrackBatchEvents(response, request)
+	var result core.BatchResult
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode batch result: %v; body=%s", err, response.Body.String())
+	}
+	if result.Accepted != 2 || result.Results[2].Status != http.StatusForbidden ||
+		result.Results[3].Status != http.StatusForbidden {
+		t.Fatalf("batch result = %+v, want the first two events accepted", result)
+	}
+	stats, err := repo.GetStats(context.Background(), "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 3 {
+		t.Fatalf("pageviews = %d, want the quota of 3", stats.Pageviews)
+	}
+}
+
+type failingInsertRepository struct {
+	*db.SqliteRepository
+	fail bool
+}
+
+func (r *failingInsertRepository) Insert(ctx context.Context, event *core.Event) error {
+	if r.fail {
+		return errors.New("disk full")
+	}
+	return r.SqliteRepository.Insert(ctx, event)
+}
+
+func TestQuota_CountsOnlyStoredEvents(t *testing.T) {
+	_, sqlite := newQuotaTestHandler(t, core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+		MonthlyEventQuota: 2, QuotaMode: core.QuotaHard,
+	})
+	repo := &failingInsertRepository{SqliteRepository: sqlite, fail: true}
+	handler := NewHandler(repo)
+
+	for i := range 3 {
+		if response := trackQuotaEvent(handler, fmt.Sprint("failed-", i), "session"); response.Code != http.StatusInternalServerError {
+			t.Fatalf("failed insert %d status = %d, want 500", i, response.Code)
+		}
+	}
+	repo.fail = false
+	for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusForbidden} {
+		if response := trackQuotaEvent(handler, fmt.Sprint("event-", i), "session"); response.Code != want {
+			t.Fatalf("event %d status = %d, want %d", i, response.Code, want)
+		}
+	}
+}
+
+func TestQuota_ReloadCountsUnprojectedEvents(t *testing.T) {
+	first, repo := newQuotaTestHandler(t, core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+		MonthlyEventQuota: 2, QuotaMode: core.QuotaHard,
+	})
+	for i := range 2 {
+		if response := trackQuotaEvent(first, fmt.Sprint("event-", i), "session"); response.Code != http.StatusAccepted {
+			t.Fatalf("event %d status = %d, want 202", i, response.Code)
+		}
+	}
+
+	// A handler that loads usage before the projector runs still sees both.
+	second := NewHandler(repo)
+	if response := trackQuotaEvent(second, "event-2", "session"); response.Code != http.StatusForbidden {
+		t.Fatalf("event over quota status = %d, want 403", response.Code)
+	}
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit is a token bucket that refills at Rate tokens per second up to
+// Burst tokens. A zero Rate leaves the bucket unlimited.
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// RateLimits are the budgets of one route class, each kept per site, per
+// client IP and per API key. Ingestion spends one token per event; reads
+// spend one per request. A batch larger than a burst is let through from a
+// full bucket and paid back before the next request.
+type RateLimits struct {
+	Site RateLimit
+	IP   RateLimit
+	Key  RateLimit
+}
+
+// ParseRateLimits parses a comma-separated list of scope=rate[:burst] items
+// such as "ip=20:40,site=500". The burst defaults to one second of rate.
+func ParseRateLimits(value string) (RateLimits, error) {
+	var limits RateLimits
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		scope, raw, ok := strings.Cut(item, "=")
+		if !ok {
+			return RateLimits{}, fmt.Errorf("invalid rate limit %q: want scope=rate[:burst]", item)
+		}
+		rawRate, rawBurst, hasBurst := strings.Cut(raw, ":")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rawRate), 64)
+		if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+			return RateLimits{}, fmt.Errorf("invalid rate in %q", item)
+		}
+		limit := RateLimit{Rate: rate, Burst: math.Max(rate, 1)}
+		if hasBurst {
+			burst, err := strconv.ParseFloat(strings.TrimSpace(rawBurst), 64)
+			if err != nil || burst < 1 || math.IsInf(burst, 0) {
+				return RateLimits{}, fmt.Errorf("invalid burst in %q", item)
+			}
+			limit.Burst = burst
+		}
+		switch strings.TrimSpace(scope) {
+		case "site":
+			limits.Site = limit
+		case "ip":
+			limits.IP = limit
+		case "key":
+			limits.Key = limit
+		default:
+			return RateLimits{}, fmt.Errorf("unknown rate limit scope %q: want site, ip or key", scope)
+		}
+	}
+	return limits, nil
+}
+
+// SetRateLimits replaces the ingestion and read budgets. Buckets restart
+// full.
+func (h *Handler) SetRateLimits(ingest, read RateLimits) {
+	h.ingestRate = newClassLimiter("ingest", ingest)
+	h.readRate = newClassLimiter("read", read)
+}
+
+// RateLimited returns how many requests or events each limit has refused
+// since the server started, keyed by class and scope, e.g. "ingest_ip".
+func (h *Handler) RateLimited() map[string]int64 {
+	counts := map[string]int64{}
+	for _, class := range []*classLimiter{h.ingestRate, h.readRate} {
+		if class == nil {
+			continue
+		}
+		for _, limiter := range []*rateLimiter{class.site, class.ip, class.key} {
+			if limiter.limit.Rate > 0 {
+				counts[limiter.name] = limiter.refused.Load()
+			}
+		}
+	}
+	return counts
+}
+
+// rateLimitError reports an exhausted budget and when a retry may succeed.
+type rateLimitError struct {
+	scope      string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded", e.scope)
+}
+
+// retryAfterSeconds is the Retry-After value for err, in whole seconds.
+func retryAfterSeconds(err *rateLimitError) string {
+	return strconv.Itoa(max(1, int(math.Ceil(err.retryAfter.Seconds()))))
+}
+
+// writeRateLimited answers 429 when err is a rate limit error and reports
+// whether it did.
+func writeRateLimited(w http.ResponseWriter, err error) bool {
+	var limited *rateLimitError
+	if !errors.As(err, &limited) {
+		return false
+	}
+	w.Header().Set("Retry-After", retryAfterSeconds(limited))
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	return true
+}
+
+type classLimiter struct {
+	site, ip, key *rateLimiter
+}
+
+func newClassLimiter(class string, limits RateLimits) *classLimiter {
+	return &classLimiter{
+		site: newRateLimiter(class+"_site", limits.Site),
+		ip:   newRateLimiter(class+"_ip", limits.IP),
+		key:  newRateLimiter(class+"_key", limits.Key),
+	}
+}
+
+// allowClient spends cost tokens from the client's IP bucket and, when the
+// request carries one, its key's bucket. Nothing is spent unless both allow
+// it.
+func (c *classLimiter) allowClient(ip, key string, cost float64) error {
+	if c == nil {
+		return nil
+	}
+	now := time.Now()
+	if key == "" {
+		return c.ip.take(ip, cost, now)
+	}
+	c.ip.mu.Lock()
+	defer c.ip.mu.Unlock()
+	if err := c.ip.check(ip, cost, now); err != nil {
+		return err
+	}
+	if err := c.key.take(key, cost, now); err != nil {
+		return err
+	}
+	c.ip.spend(ip, cost, now)
+	return nil
+}
+
+func (c *classLimiter) allowSite(siteID string, cost float64) error {
+	if c == nil || siteID == "" {
+		return nil
+	}
+	return c.site.take(siteID, cost, time.Now())
+}
+
+// rateLimiterSweepInterval is how often buckets that have refilled
+// completely are dropped, which bounds memory to recently active keys.
+const rateLimiterSweepInterval = time.Minute
+
+type rateLimiter struct {
+	name    string
+	limit   RateLimit
+	refused atomic.Int64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(name string, limit RateLimit) *rateLimiter {
+	return &rateLimiter{name: name, limit: limit, buckets: map[string]*tokenBucket{}}
+}
+
+func (l *rateLimiter) take(key string, cost float64, now time.Time) error {
+	if l.limit.Rate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.check(key, cost, now); err != nil {
+		return err
+	}
+	l.spend(key, cost, now)
+	return nil
+}
+
+// check reports whether key's bucket holds cost tokens. The caller holds mu.
+func (l *rateLimiter) check(key string, cost float64, now time.Time) error {
+	if l.limit.Rate <= 0 {
+		return nil
+	}
+	if now.Sub(l.swept) >= rateLimiterSweepInterval {
+		for bucketKey, bucket := range l.buckets {
+			if l.refill(bucket, now) >= l.limit.Burst {
+				delete(l.buckets, bucketKey)
+			}
+		}
+		l.swept = now
+	}
+	tokens := l.limit.Burst
+	if bucket, ok := l.buckets[key]; ok {
+		tokens = l.refill(bucket, now)
+	}
+	// A cost above the burst is allowed from a full bucket and leaves it in
+	// debt, so large batches are slowed rather than refused forever.
+	need := math.Min(cost, l.limit.Burst)
+	if tokens >= need {
+		return nil
+	}
+	l.refused.Add(int64(math.Ceil(cost)))
+	wait := time.Duration((need - tokens) / l.limit.Rate * float64(time.Second))
+	return &rateLimitError{scope: l.name, retryAfter: wait}
+}
+
+// spend removes cost tokens from key's bucket. The caller holds mu and has
+// checked the balance.
+func (l *rateLimiter) spend(key string, cost float64, now time.Time) {
+	if l.limit.Rate <= 0 {
+		return
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.limit.Burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now) - cost
+	bucket.updated = now
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	return math.Min(l.limit.Burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.limit.Rate)
+}
+
+// LimitReads spends one read token per request from the client's IP and from
+// the caller its credentials resolve to. A credential that does not resolve
+// is charged to the IP alone, so made-up tokens cannot open fresh buckets. The
+// resolved caller is kept on the request for authenticate. The site's budget
+// is spent by parseStatsQuery once the request is authorized for the site, so
+// that other clients cannot use it up.
+func (h *Handler) LimitReads(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := h.resolvePrincipal(r)
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, resolvedPrincipal{principal: p, err: err}))
+		if writeRateLimited(w, h.readRate.allowClient(h.clientIP(r), p.rateKey(), 1)) {
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits(" ip=20:40, site=500 ,key=0.5")
+	if err != nil {
+		t.Fatalf("ParseRateLimits returned error: %v", err)
+	}
+	want := RateLimits{
+		IP:   RateLimit{Rate: 20, Burst: 40},
+		Site: RateLimit{Rate: 500, Burst: 500},
+		Key:  RateLimit{Rate: 0.5, Burst: 1},
+	}
+	if limits != want {
+		t.Fatalf("limits = %+v, want %+v", limits, want)
+	}
+	for _, value := range []string{"ip", "ip=0", "ip=10:0", "visitor=10", "site=fast"} {
+		if _, err := ParseRateLimits(value); err == nil {
+			t.Fatalf("ParseRateLimits(%q) returned nil error", value)
+		}
+	}
+}
+
+func TestRateLimits_ShedIngestionPerIPAndSite(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandler(repo)
+	handler.SetRateLimits(RateLimits{
+		IP:   RateLimit{Rate: 0.01, Burst: 2},
+		Site: RateLimit{Rate: 0.01, Burst: 4},
+	}, RateLimits{})
+	event := func(id string) string {
+		return fmt.Sprintf(`{"id":%q,"n":"$pageview","u":"https://example.com/","s":"site-a","sid":"s","vid":"v"}`, id)
+	}
+
+	for i, want := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusTooManyRequests} {
+		request := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(event(fmt.Sprint("single-", i))))
+		response := httptest.NewRecorder()
+		handler.TrackEvent(response, request)
+		if response.Code != want {
+			t.Fatalf("request %d status = %d, want %d; body=%s", i, response.Code, want, response.Body.String())
+		}
+		if want == http.StatusTooManyRequests && response.Header().Get("Retry-After") == "" {
+			t.Fatalf("429 response has no Retry-After")
+		}
+	}
+
+	// Another client still has its own IP budget, but the site has two
+	// events left.
+	body := "[" + event("batch-0") + "," + event("batch-1") + "," + event("batch-2") + "]"
+	request := httptest.NewRequest(http.MethodPost, "/api/events?partial=1", strings.NewReader(body))
+	request.RemoteAddr = "198.51.100.7:1234"
+	response := httptest.NewRecorder()
+	handler.TrackBatchEvents(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("batch status = %d; body=%s", response.Code, response.Body.String())
+	}
+	var result core.BatchResult
+	if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode batch result: %v", err)
+	}
+	if result.Accepted != 2 || result.Results[2].Status != http.StatusTooManyRequests {
+		t.Fatalf("unexpected batch result: %+v", result)
+	}
+
+	stats, err := repo.GetStats(context.Background(), "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 4 {
+		t.Fatalf("pageviews = %d, want the 4 accepted events", stats.Pageviews)
+	}
+
+	response = httptest.NewRecorder()
+	handler.Status(response, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	var status core.SystemStatus
+	if err := json.Unmarshal(response.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if status.RateLimited["ingest_ip"] != 1 || status.RateLimited["ingest_site"] != 1 {
+		t.Fatalf("rate_limited = %v", status.RateLimited)
+	}
+	if _, ok := status.RateLimited["read_ip"]; ok {
+		t.Fatalf("unconfigured read limit is reported: %v", status.RateLimited)
+	}
+}
+
+func TestLimitReads_KeepsSeparateBudgetPerAuthenticatedKey(t *testing.T) {
+	handler, repo := newQuotaTestHandler(t, core.Site{ID: "site-a", Domains: []string{"example.com"}})
+	handler.SetReadAccess(ReadAccessPrivate)
+	handler.SetRateLimits(RateLimits{}, RateLimits{
+		IP:  RateLimit{Rate: 0.01, Burst: 3},
+		Key: RateLimit{Rate: 0.01, Burst: 1},
+	})
+	read := handler.LimitReads(handler.GetStats)
+	var tokens []string
+	for _, name := range []string{"a", "b"} {
+		token := core.APIToken{Name: name, Scopes: []string{core.ScopeStatsRead}, SiteID: "site-a"}
+		if err := repo.CreateAPIToken(context.Background(), &token); err != nil {
+			t.Fatalf("CreateAPIToken returned error: %v", err)
+		}
+		tokens = append(tokens, token.Token)
+	}
+
+	for i, test := range []struct {
+		token string
+		want  int
+	}{
+		{tokens[0], http.StatusOK},
+		{tokens[0], http.StatusTooManyRequests},
+		{tokens[1], http.StatusOK},
+		// Made-up tokens spend the IP budget rather than buckets of their own.
+		{core.APITokenPrefix + "forged-1", http.StatusUnauthorized},
+		{core.APITokenPrefix + "forged-2", http.StatusTooManyRequests},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/api/stats?site_id=site-a", nil)
+		request.Header.Set("Authorization", "Bearer "+test.token)
+		response := httptest.NewRecorder()
+		read(response, request)
+		if response.Code != test.want {
+			t.Fatalf("request %d status = %d, want %d", i, response.Code, test.want)
+		}
+	}
+
+	handler.readRate.key.mu.Lock()
+	defer handler.readRate.key.mu.Unlock()
+	if len(handler.readRate.key.buckets) != 2 {
+		t.Fatalf("key buckets = %d, want one per valid token", len(handler.readRate.key.buckets))
+	}
+	for key := range handler.readRate.key.buckets {
+		if !strings.HasPrefix(key, "token:") || strings.Contains(key, tokens[0]) || strings.Contains(key, tokens[1]) {
+			t.Fatalf("key bucket %q is not keyed on a token ID", key)
+		}
+	}
+}
+
+func TestReadSiteLimit_SpendsOnlyAuthorizedRequests(t *testing.T) {
+	handler, _ := newQuotaTestHandler(t, core.Site{ID: "site-a", Domains: []string{"example.com"}})
+	handler.SetReadAccess(ReadAccessPrivate)
+	handler.SetRateLimits(RateLimits{}, RateLimits{Site: RateLimit{Rate: 0.01, Burst: 1}})
+	read := handler.LimitReads(handler.GetStats)
+
+	for i, test := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong-token", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+		{"test-admin-token", http.StatusOK},
+		{"test-admin-token", http.StatusTooManyRequests},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/api/stats?site_id=site-a", nil)
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		response := httptest.NewRecorder()
+		read(response, request)
+		if response.Code != test.want {
+			t.Fatalf("request %d status = %d, want %d", i, response.Code, test.want)
+		}
+	}
+}
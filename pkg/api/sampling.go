@@ -0,0 +1,56 @@
+package api
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// Sampling headers are sent with every analytics read whose window holds
+// sampled events, so that array responses can be labelled as estimates too.
+const (
+	sampleRateHeader        = "X-Iris-Sample-Rate"
+	sampleErrorMarginHeader = "X-Iris-Sample-Error-Margin"
+)
+
+// sampleKey groups events for sampling so that whole sessions are kept or
+// dropped together. Anonymous events have no session and are sampled one by
+// one.
+func sampleKey(event *core.Event) string {
+	if event.SessionID != "" {
+		return event.SessionID
+	}
+	return event.ID
+}
+
+// sampled reports whether key falls within rate, a share between 0 and 1.
+// The decision depends only on the site and key, so it is the same for every
+// event of a session.
+func sampled(siteID, key string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(siteID))
+	hash.Write([]byte{0})
+	hash.Write([]byte(key))
+	return float64(hash.Sum64())/float64(^uint64(0)) < rate
+}
+
+// writeSampling looks up how the query's window was sampled and sets the
+// sampling headers. A failed lookup is logged and leaves the read unlabelled.
+func (h *Handler) writeSampling(w http.ResponseWriter, r *http.Request, q statsQuery) *core.Sampling {
+	sampling, err := h.Repo.GetSampling(r.Context(), q.SiteID, q.From, q.To)
+	if err != nil {
+		logError(r.Context(), "Sampling", "query error", err)
+		return nil
+	}
+	if sampling == nil {
+		return nil
+	}
+	w.Header().Set(sampleRateHeader, strconv.FormatFloat(sampling.Rate, 'f', -1, 64))
+	w.Header().Set(sampleErrorMarginHeader, strconv.FormatFloat(sampling.ErrorMargin, 'f', -1, 64))
+	return sampling
+}
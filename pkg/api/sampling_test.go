@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestSampling_KeepsWholeSessionsAndLabelsReads(t *testing.T) {
+	handler, _ := newQuotaTestHandler(t, core.Site{
+		ID: "site-a", Name: "Site A", Domains: []string{"example.com"},
+		SampleRate: 0.5, EventSampleRates: map[string]float64{"signup": 1},
+	})
+	kept := 0
+	for session := 0; session < 40; session++ {
+		sessionID := fmt.Sprint("session-", session)
+		for i, name := range []string{"$pageview", "$pageview", "signup"} {
+			body := fmt.Sprintf(`{"id":"event-%d-%d","n":%q,"u":"https://example.com/","s":"site-a","sid":%q,"vid":%q}`,
+				session, i, name, sessionID, sessionID)
+			response := httptest.NewRecorder()
+			handler.TrackEvent(response, httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body)))
+			if response.Code != http.StatusAccepted {
+				t.Fatalf("event status = %d, want 202; body=%s", response.Code, response.Body.String())
+			}
+		}
+		if sampled("site-a", sessionID, 0.5) {
+			kept++
+		}
+	}
+	if kept == 0 || kept == 40 {
+		t.Fatalf("kept %d of 40 sessions at a 0.5 sample rate", kept)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/stats?site_id=site-a", nil)
+	response := httptest.NewRecorder()
+	handler.GetStats(response, request)
+	var stats core.StatsResult
+	if err := json.Unmarshal(response.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode stats: %v; body=%s", err, response.Body.String())
+	}
+	if stats.Pageviews != 2*kept*2 || stats.Sessions != 2*kept {
+		t.Fatalf("stats = %+v, want %d kept sessions scaled by 2", stats, kept)
+	}
+	// 2*kept pageviews at 0.5 and 40 signups at 1 stand for 4*kept + 40.
+	stored, estimated := float64(2*kept+40), float64(4*kept+40)
+	if stats.Sampling == nil || stats.Sampling.Rate != math.Round(stored/estimated*10000)/10000 ||
+		stats.Sampling.ErrorMargin <= 0 {
+		t.Fatalf("sampling = %+v", stats.Sampling)
+	}
+	if response.Header().Get(sampleRateHeader) == "" || response.Header().Get(sampleErrorMarginHeader) == "" {
+		t.Fatalf("sampling headers missing: %v", response.Header())
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/api/events?site_id=site-a", nil)
+	response = httptest.NewRecorder()
+	handler.GetCustomEvents(response, request)
+	var custom core.CustomEventsResult
+	if err := json.Unmarshal(response.Body.Bytes(), &custom); err != nil {
+		t.Fatalf("decode custom events: %v", err)
+	}
+	if custom.Summary.TotalEvents != 40 {
+		t.Fatalf("signups = %d, want all 40 stored unsampled", custom.Summary.TotalEvents)
+	}
+}
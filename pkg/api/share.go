@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// shareAccessTTL bounds how long an unlocked password-protected share link
+// stays usable before the viewer must enter the password again.
+const shareAccessTTL = 12 * time.Hour
+
+// shareLink resolves a share credential. Links without a password accept the
+// bare slug; protected links need the "slug.expiry.signature" token returned
+// when the password is entered.
+func (h *Handler) shareLink(ctx context.Context, credential string, now time.Time) (*core.ShareLink, error) {
+	slug, access, _ := strings.Cut(credential, ".")
+	link, err := h.Repo.LookupShareLink(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if !link.PasswordRequired {
+		return link, nil
+	}
+	rawExpiry, signature, _ := strings.Cut(access, ".")
+	expiry, err := strconv.ParseInt(rawExpiry, 10, 64)
+	if err != nil || now.Unix() >= expiry {
+		return nil, core.ErrShareLinkInvalid
+	}
+	expected := signShareAccess(link, slug, rawExpiry)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, core.ErrShareLinkInvalid
+	}
+	return link, nil
+}
+
+// signShareAccess keys the signature with the stored password hash, so
+// changing the password or recreating the link invalidates earlier tokens.
+func signShareAccess(link *core.ShareLink, slug, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(link.PasswordHash))
+	mac.Write([]byte(link.ID + "\x00" + slug + "\x00" + expiry))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Shares lets a dashboard viewer inspect a share link (GET ?slug=) and
+// exchange its password for an access token (POST {"slug","password"}).
+func (h *Handler) Shares(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		link, err := h.Repo.LookupShareLink(r.Context(), r.URL.Query().Get("slug"))
+		if !h.shareLookupOK(w, r, err) {
+			return
+		}
+		link.PasswordHash = ""
+		writeJSON(w, http.StatusOK, link)
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		var request struct {
+			Slug     string `json:"slug"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		link, err := h.Repo.LookupShareLink(r.Context(), request.Slug)
+		if !h.shareLookupOK(w, r, err) {
+			return
+		}
+		slug := strings.TrimSpace(request.Slug)
+		if !link.PasswordRequired {
+			writeJSON(w, http.StatusOK, map[string]any{"token": slug, "expires_at": link.ExpiresAt})
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(request.Password)) != nil {
+			http.Error(w, "Incorrect password", http.StatusUnauthorized)
+			return
+		}
+		expiresAt := time.Now().UTC().Add(shareAccessTTL).Truncate(time.Second)
+		if link.ExpiresAt != nil && link.ExpiresAt.Before(expiresAt) {
+			expiresAt = link.ExpiresAt.Truncate(time.Second)
+		}
+		expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"token":      slug + "." + expiry + "." + signShareAccess(link, slug, expiry),
+			"expires_at": expiresAt,
+		})
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) shareLookupOK(w http.ResponseWriter, r *http.Request, err error) bool {
+	if errors.Is(err, core.ErrShareLinkInvalid) {
+		http.Error(w, "Share link not found or expired", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		logError(r.Context(), "Shares", "lookup error", err)
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// SiteShares lists (GET ?site_id=), creates (POST), and revokes
+// (DELETE ?id=) share links. It needs the admin role or the sites:write scope
+// on the site; callers other than the admin token also pass site_id when
+// revoking.
+func (h *Handler) SiteShares(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost+", "+http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := h.requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site_id")
+		if siteID == "" {
+			http.Error(w, "Missing site_id", http.StatusBadRequest)
+			return
+		}
+		if !p.manages(siteID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		links, err := h.Repo.GetShareLinks(r.Context(), siteID)
+		if err != nil {
+			logError(r.Context(), "SiteShares", "query error", err)
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, links)
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		var link core.ShareLink
+		if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if !p.manages(link.SiteID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := h.Repo.CreateShareLink(h.audited(r, p, core.AuditShareLinkCreate, link.SiteID), &link); err != nil {
+			logError(r.Context(), "SiteShares", "create error", err)
+			if errors.Is(err, core.ErrSiteNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, link)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing id", http.StatusBadRequest)
+			return
+		}
+		if !p.admin {
+			siteID := r.URL.Query().Get("site_id")
+			if !p.manages(siteID) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			links, err := h.Repo.GetShareLinks(r.Context(), siteID)
+			if err != nil {
+				logError(r.Context(), "SiteShares", "query error", err)
+				http.Error(w, "Query failed", http.StatusInternalServerError)
+				return
+			}
+			if !slices.ContainsFunc(links, func(link core.ShareLink) bool { return link.ID == id }) {
+				http.Error(w, "Share link not found", http.StatusNotFound)
+				return
+			}
+		}
+		ctx := h.audited(r, p, core.AuditShareLinkRevoke, r.URL.Query().Get("site_id"))
+		if err := h.Repo.RevokeShareLink(ctx, id); err != nil {
+			if errors.Is(err, core.ErrShareLinkInvalid) {
+				http.Error(w, "Share link not found", http.StatusNotFound)
+				return
+			}
+			logError(r.Context(), "SiteShares", "revoke error", err)
+			http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
+
+func TestShareLinks_GrantPasswordProtectedReadOnlyAccess(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range []core.Site{
+		{ID: "site-a", Domains: []string{"example.com"}},
+		{ID: "site-b", Domains: []string{"other.com"}},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
+	}
+	handler := NewHandlerWithAdminToken(repo, "test-admin-token")
+	handler.SetReadAccess(ReadAccessPrivate)
+
+	serve := func(route http.HandlerFunc, method, target, body, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		route(response, request)
+		return response
+	}
+
+	created := serve(handler.SiteShares, http.MethodPost, "/api/sites/shares",
+		`{"site_id":"site-a","name":"client","password":"hunter22","from":"2026-07-01","to":"2026-07-31"}`,
+		"test-admin-token")
+	var link core.ShareLink
+	if err := json.NewDecoder(created.Body).Decode(&link); err != nil || created.Code != http.StatusCreated {
+		t.Fatalf("create share status = %d, %v", created.Code, err)
+	}
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", ""); response.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous private read status = %d, want 401", response.Code)
+	}
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats", "", link.Slug); response.Code != http.StatusUnauthorized {
+		t.Fatalf("protected slug without password status = %d, want 401", response.Code)
+	}
+
+	info := serve(handler.Shares, http.MethodGet, "/api/share?slug="+link.Slug, "", "")
+	if !strings.Contains(info.Body.String(), `"password_required":true`) || strings.Contains(info.Body.String(), "$2") {
+		t.Fatalf("share info = %s", info.Body.String())
+	}
+	wrong := serve(handler.Shares, http.MethodPost, "/api/share", `{"slug":"`+link.Slug+`","password":"nope"}`, "")
+	if wrong.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password status = %d, want 401", wrong.Code)
+	}
+	unlocked := serve(handler.Shares, http.MethodPost, "/api/share", `{"slug":"`+link.Slug+`","password":"hunter22"}`, "")
+	var access struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(unlocked.Body).Decode(&access); err != nil || !strings.HasPrefix(access.Token, link.Slug+".") {
+		t.Fatalf("unlock status = %d, token %q, %v", unlocked.Code, access.Token, err)
+	}
+
+	query, ok := handler.parseStatsQuery(httptest.NewRecorder(), func() *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/api/stats?from=2020-01-01", nil)
+		request.Header.Set("Authorization", "Bearer "+access.Token)
+		return request
+	}())
+	if !ok || query != (statsQuery{SiteID: "site-a", From: "2026-07-01", To: "2026-07-31", locked: true}) {
+		t.Fatalf("shared query = %+v, %v; want the locked site and range", query, ok)
+	}
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats?site_id=site-b", "", access.Token); response.Code != http.StatusForbidden {
+		t.Fatalf("other site status = %d, want 403", response.Code)
+	}
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats", "", access.Token+"0"); response.Code != http.StatusUnauthorized {
+		t.Fatalf("tampered token status = %d, want 401", response.Code)
+	}
+	sites := serve(handler.Sites, http.MethodGet, "/api/sites", "", access.Token)
+	if sites.Code != http.StatusOK || strings.Contains(sites.Body.String(), "site-b") {
+		t.Fatalf("shared site list = %d %s", sites.Code, sites.Body.String())
+	}
+	if response := serve(handler.Sites, http.MethodPost, "/api/sites", `{"site_id":"x","domains":["x.com"]}`, access.Token); response.Code != http.StatusUnauthorized {
+		t.Fatalf("site mutation with share token status = %d, want 401", response.Code)
+	}
+
+	if response := serve(handler.SiteShares, http.MethodDelete, "/api/sites/shares?id="+link.ID, "", "test-admin-token"); response.Code != http.StatusNoContent {
+		t.Fatalf("revoke status = %d", response.Code)
+	}
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats", "", access.Token); response.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked link status = %d, want 401", response.Code)
+	}
+}
+
+func TestShareLinks_LockedRangeHidesThePreviousPeriod(t *testing.T) {
+	handler, repo := newQuotaTestHandler(t, core.Site{ID: "site-a", Domains: []string{"example.com"}})
+	for i, at := range []string{"2026-06-10T12:00:00Z", "2026-06-11T12:00:00Z", "2026-07-10T12:00:00Z"} {
+		timestamp, _ := time.Parse(time.RFC3339, at)
+		for _, name := range []string{"$pageview", "signup"} {
+			event := core.Event{
+				ID: fmt.Sprintf("%s-%d", name, i), EventName: name, SiteID: "site-a",
+				URL: "https://example.com/", Domain: "example.com", Pathname: "/",
+				SessionID: fmt.Sprint("session-", i), VisitorID: fmt.Sprint("visitor-", i), Timestamp: timestamp,
+			}
+			if err := repo.Insert(context.Background(), &event); err != nil {
+				t.Fatalf("Insert returned error: %v", err)
+			}
+		}
+	}
+	if _, err := repo.ProjectPending(context.Background(), 100); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+	link := core.ShareLink{SiteID: "site-a", Name: "july", From: "2026-07-01", To: "2026-07-31"}
+	if err := repo.CreateShareLink(context.Background(), &link); err != nil {
+		t.Fatalf("CreateShareLink returned error: %v", err)
+	}
+
+	read := func(route http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("Authorization", "Bearer "+link.Slug)
+		response := httptest.NewRecorder()
+		route(response, request)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s status = %d; body=%s", target, response.Code, response.Body.String())
+		}
+		return response
+	}
+	var trends core.SiteTrendResult
+	if err := json.NewDecoder(read(handler.GetSiteTrends, "/api/trends?from=2026-06-01&to=2026-06-30").Body).Decode(&trends); err != nil {
+		t.Fatalf("decode trends: %v", err)
+	}
+	if trends.Current.Pageviews != 1 || trends.Previous.Pageviews != 0 || trends.Change != (core.StatsChange{}) {
+		t.Fatalf("shared trends = %+v, want July only and no previous period", trends)
+	}
+	var events core.CustomEventsResult
+	if err := json.NewDecoder(read(handler.GetCustomEvents, "/api/events/custom").Body).Decode(&events); err != nil {
+		t.Fatalf("decode custom events: %v", err)
+	}
+	if events.Summary.TotalEvents != 1 || events.Summary.ChangePercent != 0 ||
+		len(events.Events) != 1 || events.Events[0].ChangePercent != 0 {
+		t.Fatalf("shared custom events = %+v, want July only and no changes", events)
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// APITokens lists (GET), mints (POST) and revokes (DELETE ?id=) named API
+// tokens. The admin token manages every token; a site owner manages tokens
+// restricted to that site and passes site_id when listing or revoking. API
+// tokens cannot manage tokens. A mint with "replaces" rotates an existing
+// token, which keeps working for a short grace period.
+func (h *Handler) APITokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost+", "+http.MethodDelete)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := h.requireManager(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		siteID := r.URL.Query().Get("site_id")
+		if !p.admin && (siteID == "" || !p.can(siteID, core.RoleOwner)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		tokens, err := h.Repo.GetAPITokens(r.Context(), siteID)
+		if err != nil {
+			logError(r.Context(), "APITokens", "query error", err)
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, tokens)
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		var token core.APIToken
+		if err := json.NewDecoder(r.Body).Decode(&token); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if !p.admin && (token.SiteID == "" || !p.can(token.SiteID, core.RoleOwner)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if token.Replaces != "" && !p.admin {
+			found, err := h.siteHasAPIToken(r, token.SiteID, token.Replaces)
+			if err != nil {
+				logError(r.Context(), "APITokens", "query error", err)
+				http.Error(w, "Query failed", http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "API token not found", http.StatusNotFound)
+				return
+			}
+		}
+		token.CreatedBy = p.actor()
+		if err := h.Repo.CreateAPIToken(h.audited(r, p, core.AuditAPITokenCreate, token.SiteID), &token); err != nil {
+			switch {
+			case errors.Is(err, core.ErrSiteNotFound):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case errors.Is(err, core.ErrAPITokenInvalid):
+				http.Error(w, "API token to replace not found", http.StatusNotFound)
+			default:
+				logError(r.Context(), "APITokens", "create error", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		writeJSON(w, http.StatusCreated, token)
+	case http.MethodDelete:
+		q := r.URL.Query()
+		id, siteID := q.Get("id"), q.Get("site_id")
+		if id == "" {
+			http.Error(w, "Missing id", http.StatusBadRequest)
+			return
+		}
+		if !p.admin {
+			if siteID == "" || !p.can(siteID, core.RoleOwner) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			found, err := h.siteHasAPIToken(r, siteID, id)
+			if err != nil {
+				logError(r.Context(), "APITokens", "query error", err)
+				http.Error(w, "Query failed", http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "API token not found", http.StatusNotFound)
+				return
+			}
+		}
+		if err := h.Repo.RevokeAPIToken(h.audited(r, p, core.AuditAPITokenRevoke, siteID), id); err != nil {
+			if errors.Is(err, core.ErrAPITokenInvalid) {
+				http.Error(w, "API token not found", http.StatusNotFound)
+				return
+			}
+			logError(r.Context(), "APITokens", "revoke error", err)
+			http.Error(w, "Failed to revoke API token", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) siteHasAPIToken(r *http.Request, siteID, id string) (bool, error) {
+	tokens, err := h.Repo.GetAPITokens(r.Context(), siteID)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(tokens, func(token core.APIToken) bool { return token.ID == id }), nil
+}
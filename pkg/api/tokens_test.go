@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
+
+func TestAPITokens_EnforceScopesAndRotateWithoutDowntime(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	for _, site := range []core.Site{
+		{ID: "site-a", Domains: []string{"example.com"}},
+		{ID: "site-b", Domains: []string{"other.com"}},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err != nil {
+			t.Fatalf("CreateSite returned error: %v", err)
+		}
+	}
+	handler := NewHandlerWithAdminToken(repo, "test-admin-token")
+	handler.SetReadAccess(ReadAccessPrivate)
+	serve := func(route http.HandlerFunc, method, target, body, bearer string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		if bearer != "" {
+			request.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		response := httptest.NewRecorder()
+		route(response, request)
+		return response
+	}
+	mint := func(body, bearer string) core.APIToken {
+		t.Helper()
+		response := serve(handler.APITokens, http.MethodPost, "/api/tokens", body, bearer)
+		var token core.APIToken
+		if err := json.NewDecoder(response.Body).Decode(&token); err != nil || response.Code != http.StatusCreated {
+			t.Fatalf("mint %s status = %d, %v", body, response.Code, err)
+		}
+		return token
+	}
+
+	reader := mint(`{"name":"grafana","scopes":["stats:read"],"site_id":"site-a"}`, "test-admin-token")
+	writer := mint(`{"name":"terraform","scopes":["sites:write"]}`, "test-admin-token")
+	ingest := mint(`{"name":"backend","scopes":["ingest"],"site_id":"site-a"}`, "test-admin-token")
+
+	for _, check := range []struct {
+		name   string
+		route  http.HandlerFunc
+		method string
+		target string
+		body   string
+		bearer string
+		want   int
+	}{
+		{"read granted site", handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", reader.Token, http.StatusOK},
+		{"read other site", handler.GetStats, http.MethodGet, "/api/stats?site_id=site-b", "", reader.Token, http.StatusForbidden},
+		{"write without scope", handler.Sites, http.MethodPost, "/api/sites", `{"site_id":"site-a","domains":["example.com"]}`, reader.Token, http.StatusForbidden},
+		{"read without scope", handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", writer.Token, http.StatusForbidden},
+		{"write any site", handler.Sites, http.MethodPost, "/api/sites", `{"site_id":"site-b","domains":["other.com"],"retention_days":30}`, writer.Token, http.StatusCreated},
+		{"export without scope", handler.DataSubjects, http.MethodGet, "/api/data-subjects?visitor_id=v", "", writer.Token, http.StatusForbidden},
+		{"tokens cannot mint tokens", handler.APITokens, http.MethodPost, "/api/tokens", `{"name":"x","scopes":["export"]}`, writer.Token, http.StatusForbidden},
+		{"tokens cannot read the audit log", handler.Audit, http.MethodGet, "/api/audit", "", writer.Token, http.StatusForbidden},
+		{"unknown token", handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", core.APITokenPrefix + "nope", http.StatusUnauthorized},
+	} {
+		if response := serve(check.route, check.method, check.target, check.body, check.bearer); response.Code != check.want {
+			t.Errorf("%s: status = %d, want %d; body=%s", check.name, response.Code, check.want, response.Body.String())
+		}
+	}
+
+	listed := serve(handler.Sites, http.MethodGet, "/api/sites", "", reader.Token)
+	var sites []core.SiteStat
+	if err := json.NewDecoder(listed.Body).Decode(&sites); err != nil || len(sites) != 1 || sites[0].SiteID != "site-a" {
+		t.Fatalf("sites listed for a restricted token = %+v, %v", sites, err)
+	}
+	audit, err := repo.GetAuditLog(context.Background(), core.AuditFilter{Action: core.AuditSiteUpdate})
+	if err != nil || len(audit.Entries) != 1 || audit.Entries[0].Actor != "token:"+writer.ID {
+		t.Fatalf("site update audit = %+v, %v; want the token as actor", audit, err)
+	}
+
+	event := `{"id":"token-event","n":"$pageview","u":"https://other.com/","s":"site-b","sid":"s","vid":"v"}`
+	if response := serve(handler.TrackEvent, http.MethodPost, "/api/event", event, ingest.Token); response.Code != http.StatusForbidden {
+		t.Fatalf("ingest token for another site status = %d, want 403", response.Code)
+	}
+	if response := serve(handler.TrackEvent, http.MethodPost, "/api/event", event, reader.Token); response.Code != http.StatusUnauthorized {
+		t.Fatalf("ingest without scope status = %d, want 401", response.Code)
+	}
+
+	// Rotating keeps the old token usable during the grace period; revoking
+	// stops it at once.
+	rotated := mint(`{"name":"grafana","scopes":["stats:read"],"site_id":"site-a","replaces":"`+reader.ID+`"}`, "test-admin-token")
+	for _, bearer := range []string{reader.Token, rotated.Token} {
+		if response := serve(handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", bearer); response.Code != http.StatusOK {
+			t.Fatalf("read during rotation status = %d, want 200", response.Code)
+		}
+	}
+	if response := serve(handler.APITokens, http.MethodDelete, "/api/tokens?id="+reader.ID, "", "test-admin-token"); response.Code != http.StatusNoContent {
+		t.Fatalf("revoke status = %d", response.Code)
+	}
+	if response := serve(handler.GetStats, http.MethodGet, "/api/stats?site_id=site-a", "", reader.Token); response.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked token status = %d, want 401", response.Code)
+	}
+
+	invite := core.Invite{SiteID: "site-a", Email: "owner@example.com", Role: core.RoleOwner}
+	if err := repo.CreateInvite(context.Background(), &invite); err != nil {
+		t.Fatalf("CreateInvite returned error: %v", err)
+	}
+	session, err := repo.AcceptInvite(context.Background(), invite.Token, "Owner", "correct horse battery")
+	if err != nil {
+		t.Fatalf("AcceptInvite returned error: %v", err)
+	}
+	asOwner := func(method, target, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session.Token})
+		request.Header.Set(CSRFHeader, session.CSRFToken)
+		response := httptest.NewRecorder()
+		handler.APITokens(response, request)
+		return response
+	}
+	if response := asOwner(http.MethodPost, "/api/tokens", `{"name":"all sites","scopes":["export"]}`); response.Code != http.StatusForbidden {
+		t.Fatalf("owner minting an unrestricted token status = %d, want 403", response.Code)
+	}
+	if response := asOwner(http.MethodPost, "/api/tokens", `{"name":"exports","scopes":["export"],"site_id":"site-a"}`); response.Code != http.StatusCreated {
+		t.Fatalf("owner minting a site token status = %d; body=%s", response.Code, response.Body.String())
+	}
+	if response := asOwner(http.MethodDelete, "/api/tokens?site_id=site-a&id="+writer.ID, ""); response.Code != http.StatusNotFound {
+		t.Fatalf("owner revoking another site's token status = %d, want 404", response.Code)
+	}
+}
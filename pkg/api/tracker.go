@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/google/uuid"
+)
+
+// TrackerVersion is the version of the first-party tracker script. Bump it
+// whenever tracker/iris.js changes so the immutable versioned path changes too.
+const TrackerVersion = "1.0.0"
+
+const (
+	// TrackerScriptPath always serves the current tracker and is revalidated
+	// daily; TrackerVersionedPath never changes content and is cached for a year.
+	TrackerScriptPath    = "/js/iris.js"
+	TrackerVersionedPath = "/js/iris-" + TrackerVersion + ".js"
+)
+
+//go:embed tracker/iris.js
+var trackerSource string
+
+var (
+	trackerScript = minifyScript(strings.ReplaceAll(trackerSource, "__IRIS_VERSION__", TrackerVersion))
+	trackerETag   = scriptETag(trackerScript)
+)
+
+// minifyScript removes indentation, blank lines, and whole-line comments. The
+// tracker source keeps one statement per line so line breaks stay significant
+// and automatic semicolon insertion is unaffected.
+func minifyScript(source string) []byte {
+	var out bytes.Buffer
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+func scriptETag(script []byte) string {
+	sum := sha256.Sum256(script)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// TrackerScript serves the embedded tracker so sites can load it first-party
+// without a package manager.
+func (h *Handler) TrackerScript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch r.URL.Path {
+	case TrackerScriptPath:
+		w.Header().Set("Cache-Control", "public, max-age=86400, stale-while-revalidate=604800")
+	case TrackerVersionedPath:
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", trackerETag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "iris.js", time.Time{}, bytes.NewReader(trackerScript))
+}
+
+// transparentGIF is a 1x1 transparent GIF.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// TrackPixel records a $pageview for clients without JavaScript, such as
+// <noscript> images and email opens. The page URL comes from u or, when it is
+// omitted, the Referer header, which for an embedded image is the page itself.
+// Without sid and vid parameters the server derives both identifiers.
+func (h *Handler) TrackPixel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	event := core.Event{
+		ID:        q.Get("id"),
+		EventName: "$pageview",
+		SiteID:    q.Get("s"),
+		SessionID: q.Get("sid"),
+		VisitorID: q.Get("vid"),
+		URL:       q.Get("u"),
+		Referrer:  q.Get("r"),
+	}
+	if event.URL == "" {
+		event.URL = r.Header.Get("Referer")
+	}
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+
+	client := h.ingestClient(r)
+	client.serverIdentity = true
+	err := h.ingestRate.allowClient(client.ip, "", 1)
+	if err == nil {
+		err = h.prepareIncomingEvent(r.Context(), &event, time.Now().UTC(), client)
+	}
+	if err == nil {
+		err = h.ingestRate.allowSite(event.SiteID, 1)
+	}
+	if err == nil {
+		err = h.reserveQuota(r.Context(), &event)
+	}
+	if err != nil {
+		h.countRejected(err, &event)
+	}
+	if err != nil && !errors.Is(err, errEventDropped) {
+		writeIngestError(w, err)
+		return
+	}
+	if err == nil {
+		started := time.Now()
+		err := h.Repo.Insert(r.Context(), &event)
+		h.observeWrite("event", started)
+		if err != nil {
+			logError(r.Context(), "TrackPixel", "DB Insert error", err)
+			h.countRejected(fmt.Errorf("%w: %w", errWriteFailed, err), &event)
+			h.releaseQuota(&event)
+			writeInsertError(w, err, "Failed to save event")
+			return
+		}
+		h.countAccepted(&event)
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(transparentGIF)
+}
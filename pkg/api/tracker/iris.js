@@ -0,0 +1,98 @@
+// Iris first-party tracker. Load it with
+//   <script defer src="https://iris.example.com/js/iris.js" data-site="docs"
+//     data-timezone="Europe/Berlin"></script>
+// It records pageviews, including history navigation, and exposes
+// window.iris(name, props) for custom events. Identity storage matches the
+// npm SDK so both share visitor and session IDs on one origin.
+(function () {
+  "use strict";
+  var script = document.currentScript;
+  if (!script || window.iris) return;
+  var site = script.getAttribute("data-site");
+  if (!site) return;
+  var host = script.getAttribute("data-host") || new URL(script.src).origin;
+  var timezone = script.getAttribute("data-timezone") || "UTC";
+  var endpoint = host + "/api/events?partial=1";
+  var sessionTimeout = 30 * 60 * 1000;
+  var lastPath = null;
+
+  function id() {
+    if (window.crypto && crypto.randomUUID) return crypto.randomUUID();
+    return "xxxxxxxx-xxxx-4xxx-yxxx-xxxxxxxxxxxx".replace(/[xy]/g, function (c) {
+      var r = (Math.random() * 16) | 0;
+      return (c === "x" ? r : (r & 3) | 8).toString(16);
+    });
+  }
+
+  function today() {
+    var parts = new Intl.DateTimeFormat("en-US", {
+      timeZone: timezone, year: "numeric", month: "2-digit", day: "2-digit"
+    }).formatToParts(new Date());
+    var values = {};
+    parts.forEach(function (part) { values[part.type] = part.value; });
+    return values.year + "-" + values.month + "-" + values.day;
+  }
+
+  var memory = {};
+  function read(key) {
+    try { return localStorage.getItem(key + ":" + site); } catch (e) { return memory[key]; }
+  }
+  function write(key, value) {
+    try { localStorage.setItem(key + ":" + site, value); } catch (e) { memory[key] = value; }
+  }
+
+  function visitorId() {
+    var day = today();
+    var vid = read("iris_vid");
+    if (!vid || read("iris_vid_day") !== day) {
+      vid = id();
+      write("iris_vid", vid);
+      write("iris_vid_day", day);
+    }
+    return vid;
+  }
+
+  function sessionId() {
+    var now = Date.now();
+    var sid = read("iris_sid");
+    if (!sid || now - Number(read("iris_sid_last_activity") || "0") > sessionTimeout) {
+      sid = id();
+      write("iris_sid", sid);
+    }
+    write("iris_sid_last_activity", String(now));
+    return sid;
+  }
+
+  function send(name, props) {
+    var event = {
+      id: id(), n: name, u: location.href, d: location.hostname,
+      r: document.referrer || null, w: window.innerWidth, s: site,
+      sid: sessionId(), vid: visitorId(), ts: new Date().toISOString(),
+      v: 1, sv: "__IRIS_VERSION__"
+    };
+    if (props) event.p = props;
+    var body = JSON.stringify([event]);
+    // text/plain is CORS-safelisted, so neither transport needs a preflight.
+    if (navigator.sendBeacon && navigator.sendBeacon(endpoint, new Blob([body], { type: "text/plain" }))) return;
+    fetch(endpoint, { method: "POST", body: body, keepalive: true, headers: { "Content-Type": "text/plain" } })
+      .catch(function () {});
+  }
+
+  function pageview() {
+    if (location.pathname === lastPath) return;
+    lastPath = location.pathname;
+    send("$pageview");
+  }
+
+  var pushState = history.pushState;
+  history.pushState = function () {
+    var result = pushState.apply(this, arguments);
+    pageview();
+    return result;
+  };
+  window.addEventListener("popstate", pageview);
+  window.iris = function (name, props) {
+    if (typeof name === "string" && name.charAt(0) !== "$") send(name, props);
+  };
+  pageview();
+})();
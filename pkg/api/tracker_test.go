@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/db"
+)
+
+func TestTrackPixel_RecordsPageviewFromReferer(t *testing.T) {
+	repo, err := db.NewSqliteDB(filepath.Join(t.TempDir(), "iris.db"))
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	handler := NewHandler(repo)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/pixel.gif?s=site-a&r=https://mail.example.net/inbox", nil)
+	request.Header.Set("Referer", "https://example.com/newsletter?utm=1")
+	response := httptest.NewRecorder()
+	handler.TrackPixel(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d; body=%s", response.Code, response.Body.String())
+	}
+	if got := response.Header().Get("Cache-Control"); !strings.Contains(got, "no-store") {
+		t.Fatalf("Cache-Control = %q, want no-store", got)
+	}
+	if _, err := gif.Decode(bytes.NewReader(response.Body.Bytes())); err != nil {
+		t.Fatalf("pixel is not a GIF: %v", err)
+	}
+
+	pages, err := repo.GetTopPages(context.Background(), "site-a", "", "", 10)
+	if err != nil {
+		t.Fatalf("GetTopPages returned error: %v", err)
+	}
+	if len(pages) != 1 || pages[0].URL != "/newsletter" {
+		t.Fatalf("unexpected pages: %+v", pages)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/api/pixel.gif?s=missing&u=https://example.com/", nil)
+	response = httptest.NewRecorder()
+	handler.TrackPixel(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Fatalf("unknown site status = %d, want 404", response.Code)
+	}
+}
+
+func TestTrackerScript_ServesVersionedScriptWithETag(t *testing.T) {
+	handler := NewHandler(nil)
+	request := httptest.NewRequest(http.MethodGet, TrackerVersionedPath, nil)
+	response := httptest.NewRecorder()
+	handler.TrackerScript(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d", response.Code)
+	}
+	body := response.Body.String()
+	if !strings.Contains(body, `sv: "`+TrackerVersion+`"`) || strings.Contains(body, "\n  ") || strings.Contains(body, "// ") {
+		t.Fatalf("script is not minified with its version:\n%s", body)
+	}
+	if got := response.Header().Get("Cache-Control"); !strings.Contains(got, "immutable") {
+		t.Fatalf("Cache-Control = %q, want immutable", got)
+	}
+	etag := response.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("script response is missing ETag")
+	}
+
+	request = httptest.NewRequest(http.MethodGet, TrackerScriptPath, nil)
+	request.Header.Set("If-None-Match", etag)
+	response = httptest.NewRecorder()
+	handler.TrackerScript(response, request)
+	if response.Code != http.StatusNotModified {
+		t.Fatalf("revalidation status = %d, want 304", response.Code)
+	}
+}
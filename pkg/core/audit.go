@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// Audit actions. Each names the kind of record changed and what happened.
+const (
+	AuditSiteCreate       = "site.create"
+	AuditSiteUpdate       = "site.update"
+	AuditIngestKeyCreate  = "ingest_key.create"
+	AuditIngestKeyRevoke  = "ingest_key.revoke"
+	AuditShareLinkCreate  = "share_link.create"
+	AuditShareLinkRevoke  = "share_link.revoke"
+	AuditMemberSet        = "member.set"
+	AuditMemberRemove     = "member.remove"
+	AuditInviteCreate     = "invite.create"
+	AuditInviteRevoke     = "invite.revoke"
+	AuditUserDisable      = "user.disable"
+	AuditAPITokenCreate   = "api_token.create"
+	AuditAPITokenRevoke   = "api_token.revoke"
+	AuditDataSubjectErase = "data_subject.erase"
+	AuditRetentionApply   = "retention.apply"
+)
+
+// AuditActorRetention is the actor of scheduled and command-line retention
+// runs.
+const AuditActorRetention = "system:retention"
+
+// AuditEntry is one append-only record of an administrative change. Changes
+// holds each top-level field that differs, with its value before and after;
+// a creation has only after values and a removal only before values.
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	Actor     string                 `json:"actor"`
+	Action    string                 `json:"action"`
+	SiteID    string                 `json:"site_id,omitempty"`
+	Target    string                 `json:"target,omitempty"`
+	Changes   map[string]AuditChange `json:"changes,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type AuditChange struct {
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+// AuditFilter selects audit entries. Empty fields match everything; BeforeID
+// pages backwards from an earlier page's NextBeforeID.
+type AuditFilter struct {
+	SiteID   string
+	Actor    string
+	Action   string
+	From     time.Time
+	To       time.Time
+	BeforeID int64
+	Limit    int
+}
+
+// AuditPage is a page of audit entries, newest first. NextBeforeID is set
+// when older entries remain.
+type AuditPage struct {
+	Entries      []AuditEntry `json:"entries"`
+	NextBeforeID int64        `json:"next_before_id,omitempty"`
+}
+
+type auditKey struct{}
+
+// WithAudit returns a context under which the repository records entry in the
+// same transaction as the change it makes, so that the change and its entry
+// commit or fail together. The caller sets the actor, action, site and IP;
+// the repository sets the target and the changes, without secrets, from the
+// record it writes. Pass the context only to the one audited call.
+func WithAudit(ctx context.Context, entry *AuditEntry) context.Context {
+	return context.WithValue(ctx, auditKey{}, entry)
+}
+
+// PendingAudit returns the entry attached to ctx by WithAudit, or nil.
+func PendingAudit(ctx context.Context) *AuditEntry {
+	entry, _ := ctx.Value(auditKey{}).(*AuditEntry)
+	return entry
+}
+
+// AuditChanges compares the JSON form of two records field by field. Either
+// may be nil for a creation or removal.
+func AuditChanges(before, after any) (map[string]AuditChange, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]AuditChange{}
+	for name, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[name]) {
+			changes[name] = AuditChange{Before: value, After: afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, seen := beforeFields[name]; !seen {
+			changes[name] = AuditChange{After: value}
+		}
+	}
+	return changes, nil
+}
+
+func auditFields(record any) (map[string]any, error) {
+	if value := reflect.ValueOf(record); !value.IsValid() || value.Kind() == reflect.Pointer && value.IsNil() {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
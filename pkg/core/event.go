@@ -2,34 +2,78 @@ package core
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"slices"
+	"strings"
 	"time"
 )
 
 var (
 	ErrSiteNotFound      = errors.New("site not found")
+	ErrSiteExists        = errors.New("site already exists")
 	ErrDomainNotAllowed  = errors.New("domain not allowed")
 	ErrTimezoneImmutable = errors.New("site timezone cannot change after events are stored")
+	ErrIngestQueueFull   = errors.New("ingest queue is full")
+	ErrIngestKeyInvalid  = errors.New("invalid ingest key")
+	ErrShareLinkInvalid  = errors.New("invalid share link")
+	ErrLoginFailed       = errors.New("invalid email or password")
+	ErrSessionInvalid    = errors.New("invalid or expired session")
+	ErrInviteInvalid     = errors.New("invalid or expired invite")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrLastOwner         = errors.New("a site must keep at least one owner")
+	ErrNoSiteAccess      = errors.New("no site role is granted to this account")
+	ErrAPITokenInvalid   = errors.New("invalid or expired API token")
+	ErrQuotaExceeded     = errors.New("monthly event quota exceeded")
 )
 
 type Event struct {
-	ID            string         `json:"id"           db:"id"`
-	EventName     string         `json:"n"            db:"event_name"`
-	URL           string         `json:"u"            db:"url"`
-	Domain        string         `json:"d"            db:"domain"`
-	Referrer      string         `json:"r,omitempty"  db:"referrer"`
-	ScreenWidth   int            `json:"w"            db:"screen_width"`
-	SiteID        string         `json:"s"            db:"site_id"`
-	SessionID     string         `json:"sid"          db:"session_id"`
-	VisitorID     string         `json:"vid"          db:"visitor_id"`
-	Properties    map[string]any `json:"p,omitempty"  db:"properties"`
-	Timestamp     time.Time      `json:"ts,omitempty" db:"timestamp"`
-	ReceivedAt    time.Time      `json:"-"             db:"received_at"`
-	Pathname      string         `json:"-"             db:"pathname"`
-	ReferrerHost  string         `json:"-"             db:"referrer_host"`
-	LocalDay      string         `json:"-"             db:"local_day"`
-	SchemaVersion int            `json:"v,omitempty"   db:"schema_version"`
-	SDKVersion    string         `json:"sv,omitempty"  db:"sdk_version"`
+	ID               string         `json:"id"           db:"id"`
+	EventName        string         `json:"n"            db:"event_name"`
+	URL              string         `json:"u"            db:"url"`
+	Domain           string         `json:"d"            db:"domain"`
+	Referrer         string         `json:"r,omitempty"  db:"referrer"`
+	ScreenWidth      int            `json:"w"            db:"screen_width"`
+	SiteID           string         `json:"s"            db:"site_id"`
+	SessionID        string         `json:"sid"          db:"session_id"`
+	VisitorID        string         `json:"vid"          db:"visitor_id"`
+	Properties       map[string]any `json:"p,omitempty"  db:"properties"`
+	Timestamp        time.Time      `json:"ts,omitempty" db:"timestamp"`
+	ReceivedAt       time.Time      `json:"-"             db:"received_at"`
+	Pathname         string         `json:"-"             db:"pathname"`
+	ReferrerHost     string         `json:"-"             db:"referrer_host"`
+	LocalDay         string         `json:"-"             db:"local_day"`
+	SchemaVersion    int            `json:"v,omitempty"   db:"schema_version"`
+	SDKVersion       string         `json:"sv,omitempty"  db:"sdk_version"`
+	LinkKind         string         `json:"-"             db:"link_kind"`
+	LinkURL          string         `json:"-"             db:"link_url"`
+	NotFound         bool           `json:"-"             db:"not_found"`
+	ElementSignature string         `json:"-"             db:"element_signature"`
+	SearchTerm       string         `json:"-"             db:"search_term"`
+	ContentGroup     string         `json:"-"             db:"content_group"`
+	SampleRate       float64        `json:"-"             db:"sample_rate"`
+}
+
+// ElementSignature identifies the element behind an autocaptured $click by its
+// tag, id, visible text, and href. Whitespace in the text is collapsed so the
+// same element produces the same signature across browsers and layouts.
+func ElementSignature(properties map[string]any) string {
+	field := func(name string) string {
+		value, _ := properties[name].(string)
+		return strings.TrimSpace(value)
+	}
+	hash := sha256.New()
+	for _, part := range []string{
+		strings.ToLower(field("$tag")),
+		field("$id"),
+		strings.Join(strings.Fields(field("$text")), " "),
+		field("$href"),
+	} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
 }
 
 type Site struct {
@@ -38,6 +82,281 @@ type Site struct {
 	Timezone      string   `json:"timezone"`
 	RetentionDays int      `json:"retention_days"`
 	Domains       []string `json:"domains"`
+	// SearchParam names the page URL query parameter that carries on-site
+	// search terms, such as "q". Empty disables site search tracking.
+	SearchParam string `json:"search_param,omitempty"`
+	// PathRules and ContentGroups are applied to each event's pathname during
+	// ingestion. Changing them reprocesses the site's stored events.
+	PathRules     []PathRule     `json:"path_rules,omitempty"`
+	ContentGroups []ContentGroup `json:"content_groups,omitempty"`
+	// ServerIdentity lets events omit sid and vid. The server then derives a
+	// visitor ID from a daily salted hash of site, IP and User-Agent, and a
+	// session that ends after 30 minutes of inactivity.
+	ServerIdentity bool `json:"server_identity,omitempty"`
+	// PrivacySignals decides what happens to events sent with Sec-GPC: 1 or
+	// DNT: 1, and DefaultConsent applies to events without a $consent
+	// property. See the Privacy* and Consent* constants.
+	PrivacySignals string `json:"privacy_signals,omitempty"`
+	DefaultConsent string `json:"default_consent,omitempty"`
+	// MinBreakdownCount is the smallest visitor count a breakdown row may
+	// report; smaller rows are folded into an "Other" row. A positive
+	// PrivacyEpsilon adds Laplace noise to breakdown counts instead and drops
+	// rows whose noisy count is below MinBreakdownCount.
+	MinBreakdownCount int     `json:"min_breakdown_count,omitempty"`
+	PrivacyEpsilon    float64 `json:"privacy_epsilon,omitempty"`
+	// MonthlyEventQuota caps the events stored per calendar month in the
+	// site's timezone; zero is unlimited. QuotaMode decides what happens to
+	// events beyond it, and QuotaSampleRate is the share of sessions still
+	// kept in QuotaSample mode. See the Quota* constants.
+	MonthlyEventQuota int64   `json:"monthly_event_quota,omitempty"`
+	QuotaMode         string  `json:"quota_mode,omitempty"`
+	QuotaSampleRate   float64 `json:"quota_sample_rate,omitempty"`
+	// SampleRate is the share of sessions stored, between 0 and 1; zero
+	// stores every event. EventSampleRates overrides it per event name.
+	// Sessions are kept or dropped whole by a hash of their ID, and reports
+	// scale the stored events back up by the rate they were kept at.
+	SampleRate       float64            `json:"sample_rate,omitempty"`
+	EventSampleRates map[string]float64 `json:"event_sample_rates,omitempty"`
+}
+
+// HasDomain reports whether hostname is one of the site's registered domains.
+func (s *Site) HasDomain(hostname string) bool {
+	hostname = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	return slices.Contains(s.Domains, hostname)
+}
+
+// EventSampleRate is the share of eventName events the site stores.
+func (s *Site) EventSampleRate(eventName string) float64 {
+	rate, ok := s.EventSampleRates[eventName]
+	if !ok {
+		rate = s.SampleRate
+	}
+	if rate <= 0 || rate > 1 {
+		return 1
+	}
+	return rate
+}
+
+// Sampling describes a result scaled up from sampled events. Rate is the
+// share of events stored in the window and ErrorMargin the relative 95%
+// margin of error of the scaled event counts.
+type Sampling struct {
+	Rate        float64 `json:"rate"`
+	ErrorMargin float64 `json:"error_margin"`
+}
+
+// Quota modes. A soft quota logs a warning and keeps storing events, a hard
+// quota rejects them with ErrQuotaExceeded, and a sampling quota keeps whole
+// sessions at the site's QuotaSampleRate.
+const (
+	QuotaSoft   = "soft"
+	QuotaHard   = "hard"
+	QuotaSample = "sample"
+)
+
+// SiteUsage is what a site stored in one calendar month of its timezone.
+// Usage is kept apart from analytics, so it survives retention and erasure.
+type SiteUsage struct {
+	SiteID    string           `json:"site_id"`
+	Month     string           `json:"month"`
+	Events    int64            `json:"events"`
+	ByEvent   map[string]int64 `json:"by_event"`
+	Quota     int64            `json:"quota,omitempty"`
+	QuotaMode string           `json:"quota_mode,omitempty"`
+	OverQuota bool             `json:"over_quota,omitempty"`
+}
+
+// Privacy signal policies. Anonymized events are stored without visitor and
+// session IDs, so they count only toward pageview and event totals.
+const (
+	PrivacySignalsIgnore    = "ignore"
+	PrivacySignalsDrop      = "drop"
+	PrivacySignalsAnonymize = "anonymize"
+)
+
+// Consent modes carried by the $consent event property. Anonymous events are
+// stored like events anonymized for a privacy signal.
+const (
+	ConsentFull      = "full"
+	ConsentAnonymous = "anonymous"
+)
+
+// DataSubject identifies one person's data for export or erasure by exactly
+// one of VisitorID or SessionID, optionally limited to one site.
+type DataSubject struct {
+	SiteID    string `json:"site_id,omitempty"`
+	VisitorID string `json:"visitor_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// SubjectEvent is a stored event as returned to a data subject.
+type SubjectEvent struct {
+	ID            string         `json:"id"`
+	SiteID        string         `json:"site_id"`
+	EventName     string         `json:"event_name"`
+	URL           string         `json:"url"`
+	Pathname      string         `json:"pathname"`
+	Referrer      string         `json:"referrer,omitempty"`
+	SearchTerm    string         `json:"search_term,omitempty"`
+	ScreenWidth   int            `json:"screen_width"`
+	SessionID     string         `json:"session_id"`
+	VisitorID     string         `json:"visitor_id"`
+	Properties    map[string]any `json:"properties"`
+	OccurredAt    time.Time      `json:"occurred_at"`
+	ReceivedAt    time.Time      `json:"received_at"`
+	SchemaVersion int            `json:"schema_version"`
+	SDKVersion    string         `json:"sdk_version,omitempty"`
+}
+
+type DataSubjectExport struct {
+	Subject    DataSubject    `json:"subject"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Events     []SubjectEvent `json:"events"`
+}
+
+// IngestKey lets a trusted server-side sender ingest events for one site with
+// larger request limits. Only a hash of the key is stored; Key holds the raw
+// value once, in the response that creates it. Zero limits use the defaults.
+type IngestKey struct {
+	ID           string    `json:"id"`
+	SiteID       string    `json:"site_id"`
+	Name         string    `json:"name"`
+	Key          string    `json:"key,omitempty"`
+	MaxBodyBytes int64     `json:"max_body_bytes,omitempty"`
+	MaxBatchSize int       `json:"max_batch_size,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ShareLink grants read-only access to one site's analytics. Only a hash of
+// the slug is stored; Slug holds the raw value once, in the response that
+// creates the link. Password is accepted on creation and never returned. A
+// non-empty From or To locks the reporting range.
+type ShareLink struct {
+	ID               string     `json:"id"`
+	SiteID           string     `json:"site_id"`
+	Name             string     `json:"name"`
+	Slug             string     `json:"slug,omitempty"`
+	Password         string     `json:"password,omitempty"`
+	PasswordRequired bool       `json:"password_required"`
+	PasswordHash     string     `json:"-"`
+	From             string     `json:"from,omitempty"`
+	To               string     `json:"to,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// ShareSlugPrefix starts every share link slug, so a bearer credential can be
+// recognized as a share link without a lookup.
+const ShareSlugPrefix = "iris_sh_"
+
+// APIToken is a named credential for scripts and integrations. It may use
+// only its Scopes, and only on SiteID unless SiteID is empty. Only a hash is
+// stored; Token holds the raw value once, in the response that mints it.
+// Replaces names a token that the new one rotates out; the old token keeps
+// working for a short grace period.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	SiteID     string     `json:"site_id,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Replaces   string     `json:"replaces,omitempty"`
+}
+
+// APITokenPrefix starts every API token, so a bearer credential can be
+// recognized as one without a lookup.
+const APITokenPrefix = "iris_tok_"
+
+// API token scopes. sites:write manages site settings, ingest keys and share
+// links; stats:read reads analytics; ingest sends events like an ingest key;
+// export exports data subject requests.
+const (
+	ScopeSitesWrite = "sites:write"
+	ScopeStatsRead  = "stats:read"
+	ScopeIngest     = "ingest"
+	ScopeExport     = "export"
+)
+
+// Allows reports whether the token grants scope on siteID.
+func (t *APIToken) Allows(scope, siteID string) bool {
+	if t.SiteID != "" && t.SiteID != siteID {
+		return false
+	}
+	return slices.Contains(t.Scopes, scope)
+}
+
+// Site roles, from most to least privileged. Viewers read analytics; admins
+// also manage site settings, keys, share links, members and data subject
+// requests; owners can also grant and revoke the owner role.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+)
+
+// RoleAtLeast reports whether role grants at least the minimum role.
+func RoleAtLeast(role, minimum string) bool {
+	rank := map[string]int{RoleViewer: 1, RoleAdmin: 2, RoleOwner: 3}
+	return rank[role] > 0 && rank[role] >= rank[minimum]
+}
+
+type User struct {
+	ID        string      `json:"id"`
+	Email     string      `json:"email"`
+	Name      string      `json:"name"`
+	CreatedAt time.Time   `json:"created_at"`
+	Disabled  bool        `json:"disabled,omitempty"`
+	Grants    []SiteGrant `json:"grants,omitempty"`
+}
+
+type SiteGrant struct {
+	SiteID string `json:"site_id"`
+	Role   string `json:"role"`
+}
+
+type SiteMember struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+}
+
+// UserSession is a signed-in dashboard session. Token holds the raw cookie
+// value once, when the session is created; CSRFToken must accompany every
+// state-changing request made with the cookie.
+type UserSession struct {
+	Token     string    `json:"-"`
+	CSRFToken string    `json:"csrf_token"`
+	User      User      `json:"user"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExternalIdentity is a user asserted by a single sign-on provider. Issuer and
+// Subject identify the account; an existing local account with the same email
+// is linked only when the provider verified the address.
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Invite is a one-time link that grants a role on a site to the person who
+// accepts it. Token holds the raw value once, in the response that creates it.
+type Invite struct {
+	ID        string    `json:"id"`
+	SiteID    string    `json:"site_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Token     string    `json:"token,omitempty"`
+	InvitedBy string    `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type SystemStatus struct {
@@ -46,12 +365,41 @@ type SystemStatus struct {
 	ProjectionVersion int    `json:"projection_version"`
 	EventLastSeq      int64  `json:"event_last_seq"`
 	ProjectionLag     int64  `json:"projection_lag"`
+	SpoolDepth        int64  `json:"spool_depth"`
+	// RateLimited counts the requests or events each configured rate limit
+	// has refused since the server started, e.g. "ingest_ip".
+	RateLimited map[string]int64 `json:"rate_limited,omitempty"`
+	// DatabaseBytes is page_count × page_size of the main database file,
+	// FreelistPages the pages it holds unused, and WALBytes the size of its
+	// write-ahead log.
+	PageCount     int64 `json:"page_count"`
+	FreelistPages int64 `json:"freelist_pages"`
+	DatabaseBytes int64 `json:"database_bytes"`
+	WALBytes      int64 `json:"wal_bytes"`
+}
+
+// BatchEventResult is the outcome of one event in a partially accepted batch.
+// Status is 202 for a stored event or the HTTP status its rejection would
+// have produced on its own.
+type BatchEventResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+	Accepted int                `json:"accepted"`
+	Rejected int                `json:"rejected"`
+	Results  []BatchEventResult `json:"results"`
 }
 
 type StatsResult struct {
-	Pageviews      int `json:"pageviews"`
-	UniqueVisitors int `json:"unique_visitors"`
-	Sessions       int `json:"sessions"`
+	Pageviews      int     `json:"pageviews"`
+	UniqueVisitors int     `json:"unique_visitors"`
+	Sessions       int     `json:"sessions"`
+	AvgEngagedMS   float64 `json:"avg_engaged_ms"`
+	// Sampling is set when some of the counted events were sampled.
+	Sampling *Sampling `json:"sampling,omitempty"`
 }
 
 type StatsChange struct {
@@ -67,8 +415,21 @@ type SiteTrendResult struct {
 }
 
 type PageStat struct {
-	URL       string `json:"url"`
-	Pageviews int    `json:"pageviews"`
+	URL          string      `json:"url"`
+	Pageviews    int         `json:"pageviews"`
+	Visitors     int         `json:"visitors"`
+	AvgEngagedMS float64     `json:"avg_engaged_ms"`
+	ScrollDepth  ScrollDepth `json:"scroll_depth"`
+}
+
+// ScrollDepth counts engagement reports whose maximum scroll position reached
+// each quarter of the page.
+type ScrollDepth struct {
+	Samples    int `json:"samples"`
+	Reached25  int `json:"reached_25"`
+	Reached50  int `json:"reached_50"`
+	Reached75  int `json:"reached_75"`
+	Reached100 int `json:"reached_100"`
 }
 
 type ReferrerStat struct {
@@ -76,6 +437,62 @@ type ReferrerStat struct {
 	Visitors int    `json:"visitors"`
 }
 
+type LinkStat struct {
+	URL      string `json:"url"`
+	Clicks   int    `json:"clicks"`
+	Visitors int    `json:"visitors"`
+}
+
+type ClickStat struct {
+	Signature string `json:"signature"`
+	Pathname  string `json:"pathname"`
+	Tag       string `json:"tag"`
+	ElementID string `json:"element_id"`
+	Text      string `json:"text"`
+	Href      string `json:"href"`
+	Clicks    int    `json:"clicks"`
+	Visitors  int    `json:"visitors"`
+}
+
+type ClickTimeSeriesBucket struct {
+	Date     string `json:"date"`
+	Clicks   int    `json:"clicks"`
+	Visitors int    `json:"visitors"`
+}
+
+type ContentGroupStat struct {
+	Group     string `json:"group"`
+	Pageviews int    `json:"pageviews"`
+	Visitors  int    `json:"visitors"`
+}
+
+type SearchTermStat struct {
+	Term        string `json:"term"`
+	Searches    int    `json:"searches"`
+	Visitors    int    `json:"visitors"`
+	Exits       int    `json:"exits"`
+	Refinements int    `json:"refinements"`
+}
+
+// SiteSearchResult summarizes on-site searches. An exit is a search with no
+// later pageview in the same session; a refinement is a search whose next
+// pageview is a search for a different term. Rates are percentages.
+type SiteSearchResult struct {
+	Searches       int              `json:"searches"`
+	Exits          int              `json:"exits"`
+	ExitRate       float64          `json:"exit_rate"`
+	RefinementRate float64          `json:"refinement_rate"`
+	Terms          []SearchTermStat `json:"terms"`
+	ExitTerms      []SearchTermStat `json:"exit_terms"`
+}
+
+type NotFoundStat struct {
+	URL       string         `json:"url"`
+	Pageviews int            `json:"pageviews"`
+	Visitors  int            `json:"visitors"`
+	Referrers []ReferrerStat `json:"referrers"`
+}
+
 type VitalStat struct {
 	Name  string  `json:"name"`
 	Value float64 `json:"value"`
@@ -134,12 +551,30 @@ type DeviceStat struct {
 }
 
 type SiteStat struct {
-	SiteID        string   `json:"site_id"`
-	Name          string   `json:"name"`
-	Domain        string   `json:"domain"`
-	Domains       []string `json:"domains,omitempty"`
-	Timezone      string   `json:"timezone"`
-	RetentionDays int      `json:"retention_days"`
+	SiteID         string         `json:"site_id"`
+	Name           string         `json:"name"`
+	Domain         string         `json:"domain"`
+	Domains        []string       `json:"domains,omitempty"`
+	Timezone       string         `json:"timezone"`
+	RetentionDays  int            `json:"retention_days"`
+	SearchParam    string         `json:"search_param,omitempty"`
+	PathRules      []PathRule     `json:"path_rules,omitempty"`
+	ContentGroups  []ContentGroup `json:"content_groups,omitempty"`
+	ServerIdentity bool           `json:"server_identity,omitempty"`
+	PrivacySignals string         `json:"privacy_signals,omitempty"`
+	DefaultConsent string         `json:"default_consent,omitempty"`
+	// MinBreakdownCount and PrivacyEpsilon mirror the Site fields, as do the
+	// quota settings.
+	MinBreakdownCount int     `json:"min_breakdown_count,omitempty"`
+	PrivacyEpsilon    float64 `json:"privacy_epsilon,omitempty"`
+	MonthlyEventQuota int64   `json:"monthly_event_quota,omitempty"`
+	QuotaMode         string  `json:"quota_mode,omitempty"`
+	QuotaSampleRate   float64 `json:"quota_sample_rate,omitempty"`
+	// SampleRate and EventSampleRates mirror the Site sampling settings.
+	SampleRate       float64            `json:"sample_rate,omitempty"`
+	EventSampleRates map[string]float64 `json:"event_sample_rates,omitempty"`
+	// Role is the signed-in user's role on the site, when listed for a user.
+	Role string `json:"role,omitempty"`
 }
 
 type TimeSeriesBucket struct {
@@ -147,17 +582,102 @@ type TimeSeriesBucket struct {
 	Pageviews      int    `json:"pageviews"`
 	UniqueVisitors int    `json:"uniqueVisitors,omitempty"`
 	Sessions       int    `json:"sessions,omitempty"`
+	// AvgEngagedMS is the mean engaged time of the day's sessions, each
+	// counted with its whole engaged time.
+	AvgEngagedMS float64 `json:"avgEngagedMs,omitempty"`
 }
 
 type EventRepository interface {
 	CreateSite(ctx context.Context, site *Site) error
+	// InsertSite is CreateSite for a new site only; it returns ErrSiteExists
+	// when the ID is taken.
+	InsertSite(ctx context.Context, site *Site) error
 	ValidateSite(ctx context.Context, siteID, domain string) error
+	// HasSiteDomain reports whether any enabled site registers domain.
+	HasSiteDomain(ctx context.Context, domain string) (bool, error)
+	// GetUsage returns each site's usage for the months from through to,
+	// formatted 2006-01. An empty siteID covers every site.
+	GetUsage(ctx context.Context, siteID, from, to string) ([]SiteUsage, error)
+	// CountMonthEvents returns how many events the site has stored in month,
+	// including those GetUsage does not count yet.
+	CountMonthEvents(ctx context.Context, siteID, month string) (int64, error)
+	GetSite(ctx context.Context, siteID string) (*Site, error)
+	// ServerVisitorID hashes client, the caller's IP and User-Agent, with the
+	// site's salt for the site-local day containing at.
+	ServerVisitorID(ctx context.Context, site *Site, client string, at time.Time) (string, error)
+	// ServerSessionID continues the visitor's session when it was active in
+	// the last 30 minutes and otherwise starts a new one.
+	ServerSessionID(ctx context.Context, siteID, visitorID string, at time.Time) (string, error)
 	GetSystemStatus(ctx context.Context) (*SystemStatus, error)
+	// ExportDataSubject and EraseDataSubject record each request, with the
+	// acting credential, in the data subject audit table.
+	ExportDataSubject(ctx context.Context, subject DataSubject, actor string) (*DataSubjectExport, error)
+	EraseDataSubject(ctx context.Context, subject DataSubject, actor string) (int64, error)
+	CreateIngestKey(ctx context.Context, key *IngestKey) error
+	GetIngestKeys(ctx context.Context, siteID string) ([]IngestKey, error)
+	LookupIngestKey(ctx context.Context, rawKey string) (*IngestKey, error)
+	RevokeIngestKey(ctx context.Context, id string) error
+	CreateShareLink(ctx context.Context, link *ShareLink) error
+	GetShareLinks(ctx context.Context, siteID string) ([]ShareLink, error)
+	// LookupShareLink returns the unrevoked, unexpired link for a slug, or
+	// ErrShareLinkInvalid.
+	LookupShareLink(ctx context.Context, slug string) (*ShareLink, error)
+	RevokeShareLink(ctx context.Context, id string) error
+	// HasUsers reports whether any enabled user account exists.
+	HasUsers(ctx context.Context) (bool, error)
+	GetUsers(ctx context.Context) ([]User, error)
+	// DisableUser blocks a user's sign-in, ends their sessions and removes
+	// their site grants.
+	DisableUser(ctx context.Context, id string) error
+	// Login checks an email and password and starts a session, or returns
+	// ErrLoginFailed.
+	Login(ctx context.Context, email, password string) (*UserSession, error)
+	// LookupUserSession returns the unexpired session for a raw token with its
+	// user's grants, or ErrSessionInvalid.
+	LookupUserSession(ctx context.Context, token string) (*UserSession, error)
+	DeleteUserSession(ctx context.Context, token string) error
+	GetSiteMembers(ctx context.Context, siteID string) ([]SiteMember, error)
+	// SetSiteRole grants a role, or removes the grant when role is empty. It
+	// returns ErrLastOwner instead of leaving a site without owners.
+	SetSiteRole(ctx context.Context, siteID, userID, role string) error
+	CreateInvite(ctx context.Context, invite *Invite) error
+	GetInvites(ctx context.Context, siteID string) ([]Invite, error)
+	RevokeInvite(ctx context.Context, siteID, id string) error
+	// AcceptInvite consumes an invite, creating the user when the email is new
+	// or checking the existing user's password, and starts a session.
+	AcceptInvite(ctx context.Context, token, name, password string) (*UserSession, error)
+	// SignInExternal signs in a single sign-on user, creating or linking the
+	// account and replacing its provider-mapped grants. A SiteID of "*" grants
+	// the role on every site. It returns ErrNoSiteAccess, without creating the
+	// user, when the account would hold no grant at all.
+	SignInExternal(ctx context.Context, identity ExternalIdentity, grants []SiteGrant) (*UserSession, error)
+	// CreateAPIToken mints a token. When Replaces is set, the replaced token
+	// expires after a grace period instead of immediately.
+	CreateAPIToken(ctx context.Context, token *APIToken) error
+	// GetAPITokens lists unrevoked tokens restricted to siteID, or every
+	// unrevoked token when siteID is empty.
+	GetAPITokens(ctx context.Context, siteID string) ([]APIToken, error)
+	// LookupAPIToken returns the unrevoked, unexpired token for a raw value
+	// and records its use, or returns ErrAPITokenInvalid.
+	LookupAPIToken(ctx context.Context, rawToken string) (*APIToken, error)
+	RevokeAPIToken(ctx context.Context, id string) error
+	GetAuditLog(ctx context.Context, filter AuditFilter) (*AuditPage, error)
 	Insert(ctx context.Context, event *Event) error
 	InsertBatch(ctx context.Context, events []*Event) error
 	GetStats(ctx context.Context, siteKey, from, to string) (*StatsResult, error)
+	// GetSampling describes how the site's events in the window were
+	// sampled, or returns nil when every event was stored.
+	GetSampling(ctx context.Context, siteKey, from, to string) (*Sampling, error)
 	GetTopPages(ctx context.Context, siteKey, from, to string, limit int) ([]PageStat, error)
+	GetContentGroupPages(ctx context.Context, siteKey, group, from, to string, limit int) ([]PageStat, error)
+	GetContentGroups(ctx context.Context, siteKey, from, to string) ([]ContentGroupStat, error)
 	GetTopReferrers(ctx context.Context, siteKey, from, to string, limit int) ([]ReferrerStat, error)
+	GetOutboundLinks(ctx context.Context, siteKey, from, to string, limit int) ([]LinkStat, error)
+	GetDownloads(ctx context.Context, siteKey, from, to string, limit int) ([]LinkStat, error)
+	GetNotFoundPages(ctx context.Context, siteKey, from, to string, limit int) ([]NotFoundStat, error)
+	GetClicks(ctx context.Context, siteKey, pathname, from, to string, limit int) ([]ClickStat, error)
+	GetClickTimeSeries(ctx context.Context, siteKey, signature, pathname, from, to string) ([]ClickTimeSeriesBucket, error)
+	GetSiteSearch(ctx context.Context, siteKey, from, to string, limit int) (*SiteSearchResult, error)
 	GetVitals(ctx context.Context, siteKey, from, to string) ([]VitalStat, error)
 	GetVitalDistributions(ctx context.Context, siteKey, from, to string) ([]VitalDistribution, error)
 	GetPagePerformance(ctx context.Context, siteKey, from, to string, limit int) ([]PagePerformanceStat, error)
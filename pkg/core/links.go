@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// MaxURLLength is the longest page, referrer or link URL that is stored.
+const MaxURLLength = 2048
+
+// DefaultDownloadExtensions lists the file extensions whose links are
+// classified as downloads when no other list is configured.
+var DefaultDownloadExtensions = []string{
+	"7z", "apk", "csv", "dmg", "doc", "docx", "epub", "exe", "gz", "iso", "jar",
+	"json", "key", "mp3", "mp4", "msi", "pdf", "pkg", "ppt", "pptx", "rar", "rpm",
+	"tar", "tgz", "txt", "wav", "xls", "xlsx", "xml", "zip",
+}
+
+// DownloadExtensionSet returns extensions as a set for ClassifyLink. Leading
+// dots and letter case are ignored.
+func DownloadExtensionSet(extensions []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(extensions))
+	for _, extension := range extensions {
+		extension = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(extension)), ".")
+		if extension != "" {
+			set[extension] = struct{}{}
+		}
+	}
+	return set
+}
+
+// NormalizeTrackedURL parses an absolute http or https URL, lowercases its
+// scheme and host, and drops its query string and fragment.
+func NormalizeTrackedURL(raw string) (*url.URL, error) {
+	if len(raw) == 0 || len(raw) > MaxURLLength {
+		return nil, fmt.Errorf("url must contain between 1 and %d characters", MaxURLLength)
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" || parsed.User != nil {
+		return nil, fmt.Errorf("url must be an absolute http or https url")
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.RawQuery = ""
+	parsed.ForceQuery = false
+	parsed.Fragment = ""
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+	return parsed, nil
+}
+
+// ClassifyLink classifies a click on href, resolved against page. The link is
+// a "download" when its path has one of downloadExtensions, or "outbound" when
+// its host is not one of the site's domains; target is then the normalized
+// link URL. Other links, and hrefs that are not http or https URLs, return
+// empty strings.
+func ClassifyLink(site *Site, page *url.URL, href string, downloadExtensions map[string]struct{}) (kind, target string) {
+	href = strings.TrimSpace(href)
+	if href == "" || len(href) > MaxURLLength {
+		return "", ""
+	}
+	reference, err := url.Parse(href)
+	if err != nil {
+		return "", ""
+	}
+	link, err := NormalizeTrackedURL(page.ResolveReference(reference).String())
+	if err != nil {
+		return "", ""
+	}
+
+	extension := strings.TrimPrefix(strings.ToLower(path.Ext(link.Path)), ".")
+	if _, ok := downloadExtensions[extension]; ok && extension != "" {
+		return "download", link.String()
+	}
+	if !site.HasDomain(link.Hostname()) {
+		return "outbound", link.String()
+	}
+	return "", ""
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxPathRules       = 50
+	maxPathPatternSize = 512
+	maxGroupNameLength = 64
+)
+
+// PathRule rewrites matching pathnames before they are stored. A pattern that
+// begins with "^" is a regular expression and Replacement may reference its
+// capture groups. Any other pattern is a path such as "/users/:id/settings",
+// where ":name" and "*" match one segment and a trailing "**" matches the rest
+// of the path; an empty Replacement stores the pattern itself.
+type PathRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// ContentGroup names the pages whose rewritten pathname matches Pattern, which
+// uses the same syntax as PathRule.
+type ContentGroup struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+type compiledPathRule struct {
+	expression  *regexp.Regexp
+	replacement string
+}
+
+type compiledContentGroup struct {
+	expression *regexp.Regexp
+	name       string
+}
+
+// PathRuleSet is the compiled form of a site's rewrite rules and content
+// groups. The zero value leaves pathnames unchanged and ungrouped.
+type PathRuleSet struct {
+	rules  []compiledPathRule
+	groups []compiledContentGroup
+}
+
+func CompilePathRules(rules []PathRule, groups []ContentGroup) (*PathRuleSet, error) {
+	if len(rules) > maxPathRules || len(groups) > maxPathRules {
+		return nil, fmt.Errorf("at most %d path rules and %d content groups are allowed", maxPathRules, maxPathRules)
+	}
+	set := &PathRuleSet{}
+	for _, rule := range rules {
+		expression, err := compilePathPattern(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			if strings.HasPrefix(rule.Pattern, "^") {
+				return nil, fmt.Errorf("path rule %q requires a replacement", rule.Pattern)
+			}
+			replacement = rule.Pattern
+		}
+		if !strings.HasPrefix(replacement, "/") {
+			return nil, fmt.Errorf("path rule replacement %q must begin with /", replacement)
+		}
+		set.rules = append(set.rules, compiledPathRule{expression: expression, replacement: replacement})
+	}
+	seen := map[string]struct{}{}
+	for _, group := range groups {
+		name := strings.TrimSpace(group.Name)
+		if name == "" || len(name) > maxGroupNameLength {
+			return nil, fmt.Errorf("content group name must contain between 1 and %d characters", maxGroupNameLength)
+		}
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicate content group %q", name)
+		}
+		seen[name] = struct{}{}
+		expression, err := compilePathPattern(group.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		set.groups = append(set.groups, compiledContentGroup{expression: expression, name: name})
+	}
+	return set, nil
+}
+
+// Apply rewrites pathname with the first matching rule and returns it with the
+// first content group that matches the rewritten path.
+func (s *PathRuleSet) Apply(pathname string) (string, string) {
+	if s == nil {
+		return pathname, ""
+	}
+	for _, rule := range s.rules {
+		if rule.expression.MatchString(pathname) {
+			pathname = rule.expression.ReplaceAllString(pathname, rule.replacement)
+			break
+		}
+	}
+	for _, group := range s.groups {
+		if group.expression.MatchString(pathname) {
+			return pathname, group.name
+		}
+	}
+	return pathname, ""
+}
+
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" || len(pattern) > maxPathPatternSize {
+		return nil, fmt.Errorf("path pattern must contain between 1 and %d characters", maxPathPatternSize)
+	}
+	if strings.HasPrefix(pattern, "^") {
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+		}
+		return expression, nil
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("path pattern %q must begin with / or ^", pattern)
+	}
+
+	segments := strings.Split(pattern[1:], "/")
+	var builder strings.Builder
+	builder.WriteString("^")
+	for index, segment := range segments {
+		switch {
+		case segment == "**":
+			if index != len(segments)-1 {
+				return nil, fmt.Errorf("path pattern %q may only use ** as its last segment", pattern)
+			}
+			builder.WriteString("(?:/.*)?")
+		case segment == "*" || (strings.HasPrefix(segment, ":") && len(segment) > 1):
+			builder.WriteString("/[^/]+")
+		default:
+			builder.WriteString("/" + regexp.QuoteMeta(segment))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.MustCompile(builder.String()), nil
+}
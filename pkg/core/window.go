@@ -0,0 +1,36 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// IsDateOnly reports whether an analytics window bound is a YYYY-MM-DD date,
+// which is read in the site's timezone.
+func IsDateOnly(value string) bool {
+	return len(value) == len("2006-01-02")
+}
+
+// ParseAnalyticsTime parses a from or to bound of an analytics window. A date
+// is read in location and, with endOfDay, stands for its last microsecond;
+// other bounds are RFC 3339 or "2006-01-02 15:04:05" times in UTC.
+func ParseAnalyticsTime(value string, endOfDay bool, location *time.Location) (time.Time, error) {
+	if IsDateOnly(value) {
+		parsed, err := time.ParseInLocation("2006-01-02", value, location)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if endOfDay {
+			return parsed.AddDate(0, 0, 1).Add(-time.Microsecond), nil
+		}
+		return parsed, nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		parsed, err := time.ParseInLocation(layout, value, time.UTC)
+		if err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported time %q", value)
+}
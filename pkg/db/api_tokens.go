@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/google/uuid"
+)
+
+const (
+	// tokenRotationGrace is how long a replaced API token keeps working, so
+	// callers can switch to its successor without downtime.
+	tokenRotationGrace = time.Hour
+	// tokenUseInterval bounds how often a token's last-used time is written.
+	tokenUseInterval = time.Minute
+	// tokenUseTimeout bounds how long a lookup waits for the writer to
+	// record last use.
+	tokenUseTimeout = 250 * time.Millisecond
+)
+
+var apiTokenScopes = []string{core.ScopeSitesWrite, core.ScopeStatsRead, core.ScopeIngest, core.ScopeExport}
+
+func (r *SqliteRepository) CreateAPIToken(ctx context.Context, token *core.APIToken) error {
+	if token == nil {
+		return fmt.Errorf("API token is required")
+	}
+	token.Name = strings.TrimSpace(token.Name)
+	token.SiteID = strings.TrimSpace(token.SiteID)
+	if token.Name == "" || len(token.Name) > 200 {
+		return fmt.Errorf("API token name must contain between 1 and 200 characters")
+	}
+	var scopes []string
+	for _, scope := range token.Scopes {
+		scope = strings.TrimSpace(scope)
+		if !slices.Contains(apiTokenScopes, scope) {
+			return fmt.Errorf("unknown API token scope %q", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return fmt.Errorf("API token needs at least one scope")
+	}
+	slices.Sort(scopes)
+	token.Scopes = scopes
+	now := time.Now().UTC()
+	var expiresAt any
+	if token.ExpiresAt != nil {
+		expiry := token.ExpiresAt.UTC()
+		if !expiry.After(now) {
+			return fmt.Errorf("API token expiry must be in the future")
+		}
+		token.ExpiresAt = &expiry
+		expiresAt = expiry.UnixMicro()
+	}
+	if token.SiteID != "" {
+		var exists int
+		err := r.db.QueryRowContext(ctx, "SELECT 1 FROM sites WHERE id = ?", token.SiteID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %s", core.ErrSiteNotFound, token.SiteID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("generate API token: %w", err)
+	}
+	token.ID = uuid.NewString()
+	token.Token = core.APITokenPrefix + hex.EncodeToString(secret)
+	token.CreatedAt = now
+	token.LastUsedAt = nil
+
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if token.Replaces != "" {
+		graceEnd := now.Add(tokenRotationGrace).UnixMicro()
+		result, err := tx.ExecContext(ctx, `
+			UPDATE api_tokens
+			SET expires_at_us = MIN(COALESCE(expires_at_us, ?), ?)
+			WHERE id = ? AND revoked_at_us IS NULL
+		`, graceEnd, graceEnd, token.Replaces)
+		if err != nil {
+			return fmt.Errorf("expire replaced API token: %w", err)
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return core.ErrAPITokenInvalid
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO api_tokens (
+			id, token_hash, name, scopes, site_id, created_by, created_at_us, expires_at_us
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, token.ID, hashIngestKey(token.Token), token.Name, strings.Join(token.Scopes, " "),
+		token.SiteID, token.CreatedBy, now.UnixMicro(), expiresAt); err != nil {
+		return err
+	}
+	recorded := *token
+	recorded.Token = ""
+	if err := recordAudit(ctx, tx, token.ID, nil, recorded); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SqliteRepository) GetAPITokens(ctx context.Context, siteID string) ([]core.APIToken, error) {
+	query := `
+		SELECT id, name, scopes, site_id, created_by, created_at_us, expires_at_us, last_used_at_us
+		FROM api_tokens
+		WHERE revoked_at_us IS NULL`
+	var args []any
+	if siteID != "" {
+		query += " AND site_id = ?"
+		args = append(args, siteID)
+	}
+	rows, err := r.db.QueryContext(ctx, query+" ORDER BY created_at_us, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []core.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *SqliteRepository) LookupAPIToken(ctx context.Context, rawToken string) (*core.APIToken, error) {
+	now := time.Now().UTC()
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, name, scopes, site_id, created_by, created_at_us, expires_at_us, last_used_at_us
+		FROM api_tokens
+		WHERE token_hash = ? AND revoked_at_us IS NULL
+		  AND (expires_at_us IS NULL OR expires_at_us > ?)
+	`, hashIngestKey(strings.TrimSpace(rawToken)), now.UnixMicro())
+	token, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return nil, core.ErrAPITokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Writing on every request would contend with ingestion for the single
+	// writer, so last use is recorded at most once per interval. It is only
+	// bookkeeping: a busy or failing writer must not refuse a valid token.
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenUseInterval {
+		useCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenUseTimeout)
+		_, err := r.writer.ExecContext(useCtx, `
+			UPDATE api_tokens SET last_used_at_us = ? WHERE id = ?
+		`, now.UnixMicro(), token.ID)
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "could not record API token use", "component", "APITokens", "token", token.ID, "error", err)
+		} else {
+			token.LastUsedAt = &now
+		}
+	}
+	return token, nil
+}
+
+func (r *SqliteRepository) RevokeAPIToken(ctx context.Context, id string) error {
+	return r.revoke(ctx, id, core.ErrAPITokenInvalid, `
+		UPDATE api_tokens SET revoked_at_us = ? WHERE id = ? AND revoked_at_us IS NULL
+	`, time.Now().UTC().UnixMicro(), id)
+}
+
+func scanAPIToken(row interface{ Scan(...any) error }) (*core.APIToken, error) {
+	var token core.APIToken
+	var scopes string
+	var createdAt int64
+	var expiresAt, lastUsedAt sql.NullInt64
+	if err := row.Scan(
+		&token.ID, &token.Name, &scopes, &token.SiteID, &token.CreatedBy,
+		&createdAt, &expiresAt, &lastUsedAt,
+	); err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Fields(scopes)
+	token.CreatedAt = time.UnixMicro(createdAt).UTC()
+	if expiresAt.Valid {
+		expiry := time.UnixMicro(expiresAt.Int64).UTC()
+		token.ExpiresAt = &expiry
+	}
+	if lastUsedAt.Valid {
+		used := time.UnixMicro(lastUsedAt.Int64).UTC()
+		token.LastUsedAt = &used
+	}
+	return &token, nil
+}
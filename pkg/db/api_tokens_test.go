@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestAPITokens_HashScopeExpireAndRotate(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	for _, invalid := range []core.APIToken{
+		{Name: "", Scopes: []string{core.ScopeStatsRead}},
+		{Name: "no scopes"},
+		{Name: "bad scope", Scopes: []string{"root"}},
+		{Name: "missing site", Scopes: []string{core.ScopeStatsRead}, SiteID: "missing"},
+	} {
+		invalid := invalid
+		if err := repo.CreateAPIToken(ctx, &invalid); err == nil {
+			t.Errorf("CreateAPIToken accepted %+v", invalid)
+		}
+	}
+
+	token := core.APIToken{
+		Name: "ci", SiteID: "site-a", CreatedBy: "admin-token",
+		Scopes: []string{core.ScopeStatsRead, core.ScopeSitesWrite, core.ScopeStatsRead},
+	}
+	if err := repo.CreateAPIToken(ctx, &token); err != nil {
+		t.Fatalf("CreateAPIToken returned error: %v", err)
+	}
+	if !strings.HasPrefix(token.Token, core.APITokenPrefix) || len(token.Scopes) != 2 || token.Scopes[0] != core.ScopeSitesWrite {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+	var storedHash string
+	if err := repo.db.QueryRow("SELECT token_hash FROM api_tokens WHERE id = ?", token.ID).Scan(&storedHash); err != nil {
+		t.Fatalf("read token hash: %v", err)
+	}
+	if storedHash != hashIngestKey(token.Token) {
+		t.Fatalf("stored hash %q does not hash the token", storedHash)
+	}
+
+	found, err := repo.LookupAPIToken(ctx, token.Token)
+	if err != nil {
+		t.Fatalf("LookupAPIToken returned error: %v", err)
+	}
+	if found.ID != token.ID || found.LastUsedAt == nil || !found.Allows(core.ScopeStatsRead, "site-a") ||
+		found.Allows(core.ScopeStatsRead, "site-b") || found.Allows(core.ScopeExport, "site-a") {
+		t.Fatalf("looked-up token = %+v", found)
+	}
+	listed, err := repo.GetAPITokens(ctx, "site-a")
+	if err != nil || len(listed) != 1 || listed[0].Token != "" || listed[0].LastUsedAt == nil {
+		t.Fatalf("GetAPITokens = %+v, %v", listed, err)
+	}
+	if _, err := repo.LookupAPIToken(ctx, core.APITokenPrefix+"unknown"); !errors.Is(err, core.ErrAPITokenInvalid) {
+		t.Fatalf("unknown token error = %v, want ErrAPITokenInvalid", err)
+	}
+
+	successor := core.APIToken{Name: "ci", SiteID: "site-a", Scopes: []string{core.ScopeStatsRead}, Replaces: token.ID}
+	if err := repo.CreateAPIToken(ctx, &successor); err != nil {
+		t.Fatalf("rotate returned error: %v", err)
+	}
+	if _, err := repo.LookupAPIToken(ctx, token.Token); err != nil {
+		t.Fatalf("replaced token stopped working during the grace period: %v", err)
+	}
+	var expiresAt int64
+	if err := repo.db.QueryRow("SELECT expires_at_us FROM api_tokens WHERE id = ?", token.ID).Scan(&expiresAt); err != nil {
+		t.Fatalf("read replaced token expiry: %v", err)
+	}
+	if remaining := time.Until(time.UnixMicro(expiresAt)); remaining <= 0 || remaining > tokenRotationGrace {
+		t.Fatalf("replaced token expires in %s, want within the grace period", remaining)
+	}
+	if _, err := repo.writer.Exec("UPDATE api_tokens SET expires_at_us = ? WHERE id = ?",
+		time.Now().Add(-time.Second).UnixMicro(), token.ID); err != nil {
+		t.Fatalf("expire token: %v", err)
+	}
+	if _, err := repo.LookupAPIToken(ctx, token.Token); !errors.Is(err, core.ErrAPITokenInvalid) {
+		t.Fatalf("expired token error = %v, want ErrAPITokenInvalid", err)
+	}
+
+	if err := repo.RevokeAPIToken(ctx, successor.ID); err != nil {
+		t.Fatalf("RevokeAPIToken returned error: %v", err)
+	}
+	if _, err := repo.LookupAPIToken(ctx, successor.Token); !errors.Is(err, core.ErrAPITokenInvalid) {
+		t.Fatalf("revoked token error = %v, want ErrAPITokenInvalid", err)
+	}
+	if err := repo.RevokeAPIToken(ctx, successor.ID); !errors.Is(err, core.ErrAPITokenInvalid) {
+		t.Fatalf("second revoke error = %v, want ErrAPITokenInvalid", err)
+	}
+	rotateRevoked := core.APIToken{Name: "ci", Scopes: []string{core.ScopeStatsRead}, Replaces: successor.ID}
+	if err := repo.CreateAPIToken(ctx, &rotateRevoked); !errors.Is(err, core.ErrAPITokenInvalid) {
+		t.Fatalf("rotating a revoked token error = %v, want ErrAPITokenInvalid", err)
+	}
+}
+
+func TestLookupAPIToken_AuthenticatesWhileTheWriterIsBusy(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	token := core.APIToken{Name: "ci", SiteID: "site-a", Scopes: []string{core.ScopeStatsRead}}
+	if err := repo.CreateAPIToken(ctx, &token); err != nil {
+		t.Fatalf("CreateAPIToken returned error: %v", err)
+	}
+
+	held, err := repo.writer.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("hold writer: %v", err)
+	}
+	defer held.Rollback()
+	found, err := repo.LookupAPIToken(ctx, token.Token)
+	if err != nil {
+		t.Fatalf("LookupAPIToken with a busy writer returned error: %v", err)
+	}
+	if found.ID != token.ID || found.LastUsedAt != nil {
+		t.Fatalf("looked-up token = %+v, want it without a recorded use", found)
+	}
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// RecordAudit appends an entry to the audit log and sets its ID and time.
+func (r *SqliteRepository) RecordAudit(ctx context.Context, entry *core.AuditEntry) error {
+	return insertAudit(ctx, r.writer, entry)
+}
+
+// recordAudit writes the entry that core.WithAudit attached to ctx, if any,
+// in tx, the transaction of the change it describes. before and after are
+// the changed record without secrets, and either may be nil.
+func recordAudit(ctx context.Context, tx *sql.Tx, target string, before, after any) error {
+	entry := core.PendingAudit(ctx)
+	if entry == nil {
+		return nil
+	}
+	changes, err := core.AuditChanges(before, after)
+	if err != nil {
+		return fmt.Errorf("diff %s audit entry: %w", entry.Action, err)
+	}
+	entry.Target, entry.Changes = target, changes
+	return insertAudit(ctx, tx, entry)
+}
+
+// revoke runs an UPDATE that revokes the record id and records the audit
+// entry of ctx with it. It returns notFound when no row was revoked.
+func (r *SqliteRepository) revoke(ctx context.Context, id string, notFound error, query string, args ...any) error {
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return notFound
+	}
+	if err := recordAudit(ctx, tx, id, nil, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertAudit(
+	ctx context.Context,
+	execer interface {
+		ExecContext(context.Context, string, ...any) (sql.Result, error)
+	},
+	entry *core.AuditEntry,
+) error {
+	if entry.Actor == "" || entry.Action == "" {
+		return fmt.Errorf("audit entry needs an actor and an action")
+	}
+	changes := entry.Changes
+	if changes == nil {
+		changes = map[string]core.AuditChange{}
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("encode audit changes: %w", err)
+	}
+	entry.CreatedAt = time.Now().UTC()
+	result, err := execer.ExecContext(ctx, `
+		INSERT INTO audit_log(actor, action, site_id, target, changes, ip, created_at_us)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, entry.Actor, entry.Action, entry.SiteID, entry.Target, string(encoded), entry.IP,
+		entry.CreatedAt.UnixMicro())
+	if err != nil {
+		return fmt.Errorf("record %s audit entry: %w", entry.Action, err)
+	}
+	entry.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetAuditLog returns the newest audit entries that match the filter.
+func (r *SqliteRepository) GetAuditLog(ctx context.Context, filter core.AuditFilter) (*core.AuditPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditPageSize
+	}
+	if limit > maxAuditPageSize {
+		limit = maxAuditPageSize
+	}
+	var conditions []string
+	var args []any
+	for _, item := range []struct {
+		clause string
+		value  string
+	}{
+		{"site_id = ?", filter.SiteID},
+		{"actor = ?", filter.Actor},
+		{"action = ?", filter.Action},
+	} {
+		if item.value != "" {
+			conditions = append(conditions, item.clause)
+			args = append(args, item.value)
+		}
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_at_us >= ?")
+		args = append(args, filter.From.UnixMicro())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_at_us < ?")
+		args = append(args, filter.To.UnixMicro())
+	}
+	if filter.BeforeID > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	// One extra row tells whether another page follows.
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, actor, action, site_id, target, changes, ip, created_at_us
+		FROM audit_log
+		`+where+`
+		ORDER BY id DESC
+		LIMIT ?
+	`, append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &core.AuditPage{Entries: []core.AuditEntry{}}
+	for rows.Next() {
+		var entry core.AuditEntry
+		var changes string
+		var createdAt int64
+		if err := rows.Scan(
+			&entry.ID, &entry.Actor, &entry.Action, &entry.SiteID, &entry.Target,
+			&changes, &entry.IP, &createdAt,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			return nil, fmt.Errorf("decode changes of audit entry %d: %w", entry.ID, err)
+		}
+		entry.CreatedAt = time.UnixMicro(createdAt).UTC()
+		page.Entries = append(page.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		page.NextBeforeID = page.Entries[limit-1].ID
+	}
+	return page, nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestAuditLog_FiltersPagesAndRefusesEdits(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	changes, err := core.AuditChanges(
+		&core.Site{ID: "site-a", Name: "Site A", RetentionDays: 365, Domains: []string{"example.com"}},
+		&core.Site{ID: "site-a", Name: "Site A", RetentionDays: 30, Domains: []string{"example.com"}},
+	)
+	if err != nil {
+		t.Fatalf("AuditChanges returned error: %v", err)
+	}
+	if len(changes) != 1 || changes["retention_days"].Before != float64(365) || changes["retention_days"].After != float64(30) {
+		t.Fatalf("changes = %+v, want only retention_days", changes)
+	}
+	for _, entry := range []core.AuditEntry{
+		{Actor: "admin-token", Action: core.AuditSiteCreate, SiteID: "site-a", IP: "192.0.2.1"},
+		{Actor: "user:ada@example.com", Action: core.AuditSiteUpdate, SiteID: "site-a", Changes: changes, IP: "192.0.2.2"},
+		{Actor: "admin-token", Action: core.AuditSiteCreate, SiteID: "site-b"},
+		{Actor: "admin-token", Action: core.AuditUserDisable, Target: "user-1"},
+	} {
+		entry := entry
+		if err := repo.RecordAudit(ctx, &entry); err != nil {
+			t.Fatalf("RecordAudit returned error: %v", err)
+		}
+	}
+	if err := repo.RecordAudit(ctx, &core.AuditEntry{Action: core.AuditSiteCreate}); err == nil {
+		t.Fatal("RecordAudit accepted an entry without an actor")
+	}
+
+	updates, err := repo.GetAuditLog(ctx, core.AuditFilter{SiteID: "site-a", Action: core.AuditSiteUpdate})
+	if err != nil {
+		t.Fatalf("GetAuditLog returned error: %v", err)
+	}
+	if len(updates.Entries) != 1 || updates.Entries[0].Actor != "user:ada@example.com" ||
+		updates.Entries[0].Changes["retention_days"].After != float64(30) || updates.Entries[0].IP != "192.0.2.2" {
+		t.Fatalf("filtered entries = %+v", updates.Entries)
+	}
+
+	first, err := repo.GetAuditLog(ctx, core.AuditFilter{Actor: "admin-token", Limit: 2})
+	if err != nil {
+		t.Fatalf("GetAuditLog returned error: %v", err)
+	}
+	if len(first.Entries) != 2 || first.Entries[0].Action != core.AuditUserDisable || first.NextBeforeID == 0 {
+		t.Fatalf("first page = %+v", first)
+	}
+	second, err := repo.GetAuditLog(ctx, core.AuditFilter{Actor: "admin-token", Limit: 2, BeforeID: first.NextBeforeID})
+	if err != nil {
+		t.Fatalf("GetAuditLog returned error: %v", err)
+	}
+	if len(second.Entries) != 1 || second.Entries[0].SiteID != "site-a" || second.NextBeforeID != 0 {
+		t.Fatalf("second page = %+v", second)
+	}
+	future, err := repo.GetAuditLog(ctx, core.AuditFilter{From: time.Now().Add(time.Hour)})
+	if err != nil || len(future.Entries) != 0 {
+		t.Fatalf("entries after now = %+v, %v; want none", future, err)
+	}
+
+	if _, err := repo.writer.Exec("UPDATE audit_log SET actor = 'someone-else'"); err == nil {
+		t.Fatal("audit_log accepted an update")
+	}
+	if _, err := repo.writer.Exec("DELETE FROM audit_log"); err == nil {
+		t.Fatal("audit_log accepted a delete")
+	}
+}
+
+func TestWithAudit_RecordsTheChangeInItsTransaction(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	link := core.ShareLink{SiteID: "site-a", Name: "Board", Password: "hunter2"}
+	audited := core.WithAudit(ctx, &core.AuditEntry{Actor: "admin-token", Action: core.AuditShareLinkCreate, SiteID: "site-a"})
+	if err := repo.CreateShareLink(audited, &link); err != nil {
+		t.Fatalf("CreateShareLink returned error: %v", err)
+	}
+	entries, err := repo.GetAuditLog(ctx, core.AuditFilter{Action: core.AuditShareLinkCreate})
+	if err != nil {
+		t.Fatalf("GetAuditLog returned error: %v", err)
+	}
+	if len(entries.Entries) != 1 || entries.Entries[0].Target != link.ID ||
+		entries.Entries[0].Changes["name"].After != "Board" || entries.Entries[0].Changes["slug"].After != nil {
+		t.Fatalf("share link entries = %+v, want the link without its slug", entries.Entries)
+	}
+
+	refused := core.WithAudit(ctx, &core.AuditEntry{Action: core.AuditShareLinkRevoke, SiteID: "site-a"})
+	if err := repo.RevokeShareLink(refused, link.ID); err == nil {
+		t.Fatal("RevokeShareLink succeeded with an audit entry that cannot be recorded")
+	}
+	if _, err := repo.LookupShareLink(ctx, link.Slug); err != nil {
+		t.Fatalf("LookupShareLink after a failed audit returned %v, want the link still valid", err)
+	}
+}
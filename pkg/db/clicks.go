@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// GetClicks groups autocaptured clicks by page and element signature. An
+// empty pathname reports every page.
+func (r *SqliteRepository) GetClicks(
+	ctx context.Context,
+	siteKey, pathname, from, to string,
+	limit int,
+) ([]core.ClickStat, error) {
+	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	pathClause, pathArgs := clickPathClause(pathname)
+	query := `
+	SELECT
+		element_signature,
+		pathname,
+		COALESCE(MIN(json_extract(properties, '$.$tag')), '')  AS tag,
+		COALESCE(MIN(json_extract(properties, '$.$id')), '')   AS element_id,
+		COALESCE(MIN(json_extract(properties, '$.$text')), '') AS text,
+		COALESCE(MIN(json_extract(properties, '$.$href')), '') AS href,
+		` + weightedCount + ` AS clicks,
+		` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS visitors
+	FROM events
+	WHERE event_name = '$click'
+	  AND element_signature != ''
+	  AND site_id = ?` + pathClause + timeClause + `
+	GROUP BY pathname, element_signature
+	ORDER BY clicks DESC, pathname ASC, element_signature ASC
+	LIMIT ?
+	`
+	args := append([]any{siteKey}, pathArgs...)
+	args = append(args, timeArgs...)
+	rows, err := r.db.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []core.ClickStat{}
+	for rows.Next() {
+		var result core.ClickStat
+		if err := rows.Scan(
+			&result.Signature, &result.Pathname, &result.Tag, &result.ElementID,
+			&result.Text, &result.Href, &result.Clicks, &result.Visitors,
+		); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// GetClickTimeSeries returns daily clicks and visitors for one element
+// signature, optionally restricted to one page.
+func (r *SqliteRepository) GetClickTimeSeries(
+	ctx context.Context,
+	siteKey, signature, pathname, from, to string,
+) ([]core.ClickTimeSeriesBucket, error) {
+	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+	if err != nil {
+		return nil, err
+	}
+	pathClause, pathArgs := clickPathClause(pathname)
+	query := `
+	SELECT
+		local_day AS day,
+		` + weightedCount + ` AS clicks,
+		` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS visitors
+	FROM events
+	WHERE site_id = ?
+	  AND element_signature = ?
+	  AND event_name = '$click'` + pathClause + timeClause + `
+	GROUP BY day
+	ORDER BY day ASC
+	`
+	args := append([]any{siteKey, signature}, pathArgs...)
+	rows, err := r.db.QueryContext(ctx, query, append(args, timeArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []core.ClickTimeSeriesBucket{}
+	for rows.Next() {
+		var bucket core.ClickTimeSeriesBucket
+		if err := rows.Scan(&bucket.Date, &bucket.Clicks, &bucket.Visitors); err != nil {
+			return nil, err
+		}
+		results = append(results, bucket)
+	}
+	return results, rows.Err()
+}
+
+func clickPathClause(pathname string) (string, []any) {
+	if pathname == "" {
+		return "", nil
+	}
+	return " AND pathname = ?", []any{pathname}
+}
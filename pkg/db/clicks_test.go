@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestGetClicksGroupsBySignatureAndPage(t *testing.T) {
+	repo := newTestRepo(t)
+	signup := map[string]any{"$tag": "button", "$id": "signup", "$text": "Sign up"}
+	docs := map[string]any{"$tag": "a", "$text": "Read the  docs", "$href": "/docs"}
+	day := time.Date(2026, 8, 10, 9, 0, 0, 0, time.UTC)
+	for _, click := range []struct {
+		url        string
+		visitor    string
+		properties map[string]any
+		timestamp  time.Time
+	}{
+		{"https://example.com/pricing", "v1", signup, day},
+		{"https://example.com/pricing", "v1", signup, day.Add(time.Hour)},
+		{"https://example.com/pricing", "v2", signup, day.AddDate(0, 0, 1)},
+		{"https://example.com/", "v3", signup, day},
+		{"https://example.com/", "v3", docs, day},
+		{"https://example.com/", "v4", map[string]any{"$tag": "a", "$text": "Read the docs", "$href": "/docs"}, day},
+	} {
+		insertEvent(t, repo, core.Event{
+			EventName: "$click", Domain: "example.com", SiteID: "site-a", URL: click.url,
+			SessionID: click.visitor, VisitorID: click.visitor, Properties: click.properties,
+			Timestamp: click.timestamp, ElementSignature: core.ElementSignature(click.properties),
+		})
+	}
+
+	clicks, err := repo.GetClicks(context.Background(), "site-a", "", "", "", 10)
+	if err != nil {
+		t.Fatalf("GetClicks returned error: %v", err)
+	}
+	if len(clicks) != 3 {
+		t.Fatalf("click rows = %d, want 3: %+v", len(clicks), clicks)
+	}
+	top := clicks[0]
+	if top.Pathname != "/pricing" || top.Tag != "button" || top.ElementID != "signup" ||
+		top.Text != "Sign up" || top.Clicks != 3 || top.Visitors != 2 {
+		t.Fatalf("unexpected top click row: %+v", top)
+	}
+	if clicks[1].Pathname != "/" || clicks[1].Href != "/docs" || clicks[1].Clicks != 2 || clicks[1].Visitors != 2 {
+		t.Fatalf("whitespace variants were not grouped: %+v", clicks[1])
+	}
+
+	homeClicks, err := repo.GetClicks(context.Background(), "site-a", "/", "", "", 10)
+	if err != nil {
+		t.Fatalf("GetClicks with pathname returned error: %v", err)
+	}
+	if len(homeClicks) != 2 {
+		t.Fatalf("pathname filter returned %+v", homeClicks)
+	}
+
+	series, err := repo.GetClickTimeSeries(
+		context.Background(), "site-a", top.Signature, "/pricing", "2026-08-10", "2026-08-11",
+	)
+	if err != nil {
+		t.Fatalf("GetClickTimeSeries returned error: %v", err)
+	}
+	want := []core.ClickTimeSeriesBucket{
+		{Date: "2026-08-10", Clicks: 2, Visitors: 1},
+		{Date: "2026-08-11", Clicks: 1, Visitors: 1},
+	}
+	if len(series) != len(want) || series[0] != want[0] || series[1] != want[1] {
+		t.Fatalf("click time series = %+v, want %+v", series, want)
+	}
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/google/uuid"
+)
+
+// serverSessionTimeout matches the SDK's SESSION_INACTIVITY_MS.
+const serverSessionTimeout = 30 * time.Minute
+
+// identityCache holds each site's current salt and the recent sessions of
+// server-derived visitors.
+type identityCache struct {
+	mu        sync.Mutex
+	salts     map[string]identitySalt
+	sessions  map[string]serverSession
+	lastSweep time.Time
+}
+
+type identitySalt struct {
+	day  string
+	salt []byte
+}
+
+type serverSession struct {
+	id       string
+	lastSeen time.Time
+}
+
+func (r *SqliteRepository) ServerVisitorID(
+	ctx context.Context,
+	site *core.Site,
+	client string,
+	at time.Time,
+) (string, error) {
+	location, err := time.LoadLocation(site.Timezone)
+	if err != nil {
+		return "", fmt.Errorf("load site timezone %q: %w", site.Timezone, err)
+	}
+	salt, err := r.identitySalt(ctx, site.ID, at.In(location).Format("2006-01-02"))
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(site.ID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(client))
+	return hex.EncodeToString(mac.Sum(nil)[:16]), nil
+}
+
+// identitySalt returns the site's salt for a site-local day, creating it on
+// the first request of the day and deleting the salts of earlier days.
+func (r *SqliteRepository) identitySalt(ctx context.Context, siteID, day string) ([]byte, error) {
+	r.identity.mu.Lock()
+	cached, ok := r.identity.salts[siteID]
+	r.identity.mu.Unlock()
+	if ok && cached.day == day {
+		return cached.salt, nil
+	}
+
+	candidate := make([]byte, 32)
+	if _, err := rand.Read(candidate); err != nil {
+		return nil, fmt.Errorf("generate identity salt: %w", err)
+	}
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO identity_salts(site_id, local_day, salt) VALUES (?, ?, ?)
+		ON CONFLICT(site_id, local_day) DO NOTHING
+	`, siteID, day, candidate); err != nil {
+		return nil, fmt.Errorf("store identity salt: %w", err)
+	}
+	var salt []byte
+	if err := tx.QueryRowContext(ctx, `
+		SELECT salt FROM identity_salts WHERE site_id = ? AND local_day = ?
+	`, siteID, day).Scan(&salt); err != nil {
+		return nil, fmt.Errorf("read identity salt: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM identity_salts WHERE site_id = ? AND local_day < ?
+	`, siteID, day); err != nil {
+		return nil, fmt.Errorf("delete old identity salts: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	r.identity.mu.Lock()
+	if r.identity.salts == nil {
+		r.identity.salts = map[string]identitySalt{}
+	}
+	r.identity.salts[siteID] = identitySalt{day: day, salt: salt}
+	r.identity.mu.Unlock()
+	return salt, nil
+}
+
+func (r *SqliteRepository) ServerSessionID(
+	ctx context.Context,
+	siteID, visitorID string,
+	at time.Time,
+) (string, error) {
+	key := siteID + "\x00" + visitorID
+	r.identity.mu.Lock()
+	session, found := r.identity.sessions[key]
+	r.identity.mu.Unlock()
+	if !found {
+		// After a restart the visitor's latest stored event continues the
+		// session.
+		var lastSeen int64
+		err := r.db.QueryRowContext(ctx, `
+			SELECT session_id, occurred_at_us FROM events
+			WHERE site_id = ? AND visitor_id = ?
+			ORDER BY occurred_at_us DESC
+			LIMIT 1
+		`, siteID, visitorID).Scan(&session.id, &lastSeen)
+		if err != nil && err != sql.ErrNoRows {
+			return "", fmt.Errorf("read latest session: %w", err)
+		}
+		found = err == nil
+		session.lastSeen = time.UnixMicro(lastSeen).UTC()
+	}
+
+	r.identity.mu.Lock()
+	defer r.identity.mu.Unlock()
+	if current, ok := r.identity.sessions[key]; ok {
+		session, found = current, true
+	}
+	if !found || at.Sub(session.lastSeen) > serverSessionTimeout {
+		session = serverSession{id: uuid.NewString(), lastSeen: at}
+	} else if at.After(session.lastSeen) {
+		session.lastSeen = at
+	}
+	if r.identity.sessions == nil {
+		r.identity.sessions = map[string]serverSession{}
+	}
+	r.identity.sessions[key] = session
+	if at.Sub(r.identity.lastSweep) > time.Minute {
+		for sessionKey, candidate := range r.identity.sessions {
+			if at.Sub(candidate.lastSeen) > serverSessionTimeout {
+				delete(r.identity.sessions, sessionKey)
+			}
+		}
+		r.identity.lastSweep = at
+	}
+	return session.id, nil
+}
+
+// forget drops the cached sessions of an erased data subject, so that its
+// next event starts a new session instead of continuing an erased one.
+func (c *identityCache) forget(subject core.DataSubject) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, session := range c.sessions {
+		siteID, visitorID, _ := strings.Cut(key, "\x00")
+		if subject.SiteID != "" && siteID != subject.SiteID {
+			continue
+		}
+		if (subject.VisitorID != "" && visitorID == subject.VisitorID) ||
+			(subject.SessionID != "" && session.id == subject.SessionID) {
+			delete(c.sessions, key)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestServerVisitorID_RotatesSaltAtSiteLocalMidnight(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	site := &core.Site{ID: "site-a", Timezone: "Asia/Kolkata"}
+	// 18:00 UTC is 23:30 in Kolkata; 18:45 UTC is 00:15 the next local day.
+	beforeMidnight := time.Date(2026, 8, 1, 18, 0, 0, 0, time.UTC)
+	afterMidnight := time.Date(2026, 8, 1, 18, 45, 0, 0, time.UTC)
+
+	first, err := repo.ServerVisitorID(ctx, site, "203.0.113.7\x00Firefox", beforeMidnight)
+	if err != nil {
+		t.Fatalf("ServerVisitorID returned error: %v", err)
+	}
+	again, _ := repo.ServerVisitorID(ctx, site, "203.0.113.7\x00Firefox", beforeMidnight.Add(20*time.Minute))
+	other, _ := repo.ServerVisitorID(ctx, site, "203.0.113.8\x00Firefox", beforeMidnight)
+	if first != again || first == other {
+		t.Fatalf("visitor IDs = %q, %q, other client %q; want a stable per-client ID", first, again, other)
+	}
+
+	nextDay, err := repo.ServerVisitorID(ctx, site, "203.0.113.7\x00Firefox", afterMidnight)
+	if err != nil {
+		t.Fatalf("ServerVisitorID returned error: %v", err)
+	}
+	if nextDay == first {
+		t.Fatal("visitor ID did not rotate at site-local midnight")
+	}
+	var days []string
+	rows, err := repo.db.Query("SELECT local_day FROM identity_salts WHERE site_id = 'site-a'")
+	if err != nil {
+		t.Fatalf("query salts: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			t.Fatalf("scan salt: %v", err)
+		}
+		days = append(days, day)
+	}
+	if len(days) != 1 || days[0] != "2026-08-02" {
+		t.Fatalf("stored salt days = %v, want only 2026-08-02", days)
+	}
+}
+
+func TestServerSessionID_EndsAfterThirtyMinutesOfInactivity(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	start := time.Date(2026, 8, 1, 10, 0, 0, 0, time.UTC)
+
+	first, err := repo.ServerSessionID(ctx, "site-a", "visitor", start)
+	if err != nil {
+		t.Fatalf("ServerSessionID returned error: %v", err)
+	}
+	continued, _ := repo.ServerSessionID(ctx, "site-a", "visitor", start.Add(25*time.Minute))
+	stillContinued, _ := repo.ServerSessionID(ctx, "site-a", "visitor", start.Add(50*time.Minute))
+	expired, _ := repo.ServerSessionID(ctx, "site-a", "visitor", start.Add(81*time.Minute))
+	if first != continued || first != stillContinued || expired == first {
+		t.Fatalf("sessions = %q %q %q %q; want one session then a new one", first, continued, stillContinued, expired)
+	}
+
+	if err := repo.Insert(ctx, &core.Event{
+		ID: "stored", EventName: "$pageview", SiteID: "site-b", SessionID: "stored-session",
+		VisitorID: "returning", URL: "https://other.com/", Domain: "other.com", Pathname: "/",
+		Timestamp: start,
+	}); err != nil {
+		t.Fatalf("Insert returned error: %v", err)
+	}
+	resumed, err := repo.ServerSessionID(ctx, "site-b", "returning", start.Add(10*time.Minute))
+	if err != nil {
+		t.Fatalf("ServerSessionID returned error: %v", err)
+	}
+	if resumed != "stored-session" {
+		t.Fatalf("session after restart = %q, want the stored session", resumed)
+	}
+}
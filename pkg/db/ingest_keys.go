@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/google/uuid"
+)
+
+const (
+	ingestKeyPrefix = "iris_ik_"
+	// MaxIngestKeyBodyBytes and MaxIngestKeyBatchSize bound the limits an
+	// ingest key may raise a request to.
+	MaxIngestKeyBodyBytes = 64 << 20
+	MaxIngestKeyBatchSize = DefaultIngestQueueLimit
+)
+
+// hashIngestKey hashes a high-entropy credential for storage. Share link
+// slugs use it too.
+func hashIngestKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *SqliteRepository) CreateIngestKey(ctx context.Context, key *core.IngestKey) error {
+	if key == nil {
+		return fmt.Errorf("ingest key is required")
+	}
+	key.SiteID = strings.TrimSpace(key.SiteID)
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" || len(key.Name) > 200 {
+		return fmt.Errorf("ingest key name must contain between 1 and 200 characters")
+	}
+	if key.MaxBodyBytes < 0 || key.MaxBodyBytes > MaxIngestKeyBodyBytes {
+		return fmt.Errorf("max body bytes must be between 0 and %d", MaxIngestKeyBodyBytes)
+	}
+	if key.MaxBatchSize < 0 || key.MaxBatchSize > MaxIngestKeyBatchSize {
+		return fmt.Errorf("max batch size must be between 0 and %d", MaxIngestKeyBatchSize)
+	}
+	var exists int
+	err := r.db.QueryRowContext(ctx, "SELECT 1 FROM sites WHERE id = ?", key.SiteID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", core.ErrSiteNotFound, key.SiteID)
+	}
+	if err != nil {
+		return err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("generate ingest key: %w", err)
+	}
+	key.ID = uuid.NewString()
+	key.Key = ingestKeyPrefix + hex.EncodeToString(secret)
+	key.CreatedAt = time.Now().UTC()
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO ingest_keys (
+			id, site_id, key_hash, name, created_at_us, max_body_bytes, max_batch_size
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, key.ID, key.SiteID, hashIngestKey(key.Key), key.Name, key.CreatedAt.UnixMicro(),
+		key.MaxBodyBytes, key.MaxBatchSize); err != nil {
+		return err
+	}
+	recorded := *key
+	recorded.Key = ""
+	if err := recordAudit(ctx, tx, key.ID, nil, recorded); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetIngestKeys lists the unrevoked keys for a site without their secrets.
+func (r *SqliteRepository) GetIngestKeys(ctx context.Context, siteID string) ([]core.IngestKey, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, site_id, name, max_body_bytes, max_batch_size, created_at_us
+		FROM ingest_keys
+		WHERE site_id = ? AND revoked_at_us IS NULL
+		ORDER BY created_at_us, id
+	`, siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []core.IngestKey{}
+	for rows.Next() {
+		key, err := scanIngestKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// LookupIngestKey returns the unrevoked key matching rawKey, or
+// core.ErrIngestKeyInvalid.
+func (r *SqliteRepository) LookupIngestKey(ctx context.Context, rawKey string) (*core.IngestKey, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, site_id, name, max_body_bytes, max_batch_size, created_at_us
+		FROM ingest_keys
+		WHERE key_hash = ? AND revoked_at_us IS NULL
+	`, hashIngestKey(strings.TrimSpace(rawKey)))
+	key, err := scanIngestKey(row)
+	if err == sql.ErrNoRows {
+		return nil, core.ErrIngestKeyInvalid
+	}
+	return key, err
+}
+
+func (r *SqliteRepository) RevokeIngestKey(ctx context.Context, id string) error {
+	return r.revoke(ctx, id, core.ErrIngestKeyInvalid, `
+		UPDATE ingest_keys SET revoked_at_us = ? WHERE id = ? AND revoked_at_us IS NULL
+	`, time.Now().UTC().UnixMicro(), id)
+}
+
+func scanIngestKey(row interface{ Scan(...any) error }) (*core.IngestKey, error) {
+	var key core.IngestKey
+	var createdAt int64
+	if err := row.Scan(
+		&key.ID, &key.SiteID, &key.Name, &key.MaxBodyBytes, &key.MaxBatchSize, &createdAt,
+	); err != nil {
+		return nil, err
+	}
+	key.CreatedAt = time.UnixMicro(createdAt).UTC()
+	return &key, nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestIngestKeys_StoresHashAndRevokes(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	key := core.IngestKey{SiteID: "site-a", Name: "backend", MaxBodyBytes: 4 << 20, MaxBatchSize: 500}
+	if err := repo.CreateIngestKey(ctx, &key); err != nil {
+		t.Fatalf("CreateIngestKey returned error: %v", err)
+	}
+	if !strings.HasPrefix(key.Key, ingestKeyPrefix) || key.ID == "" {
+		t.Fatalf("unexpected created key: %+v", key)
+	}
+
+	var stored int
+	if err := repo.db.QueryRow(
+		"SELECT COUNT(*) FROM ingest_keys WHERE key_hash = ? AND key_hash != ?", hashIngestKey(key.Key), key.Key,
+	).Scan(&stored); err != nil {
+		t.Fatalf("count hashed keys: %v", err)
+	}
+	if stored != 1 {
+		t.Fatalf("stored %d hashed keys, want 1", stored)
+	}
+
+	found, err := repo.LookupIngestKey(ctx, key.Key)
+	if err != nil {
+		t.Fatalf("LookupIngestKey returned error: %v", err)
+	}
+	if found.ID != key.ID || found.MaxBodyBytes != 4<<20 || found.MaxBatchSize != 500 || found.Key != "" {
+		t.Fatalf("unexpected looked-up key: %+v", found)
+	}
+	keys, err := repo.GetIngestKeys(ctx, "site-a")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("GetIngestKeys = %+v, %v; want one key", keys, err)
+	}
+
+	if err := repo.RevokeIngestKey(ctx, key.ID); err != nil {
+		t.Fatalf("RevokeIngestKey returned error: %v", err)
+	}
+	if _, err := repo.LookupIngestKey(ctx, key.Key); !errors.Is(err, core.ErrIngestKeyInvalid) {
+		t.Fatalf("lookup after revoke error = %v, want ErrIngestKeyInvalid", err)
+	}
+	if err := repo.RevokeIngestKey(ctx, key.ID); !errors.Is(err, core.ErrIngestKeyInvalid) {
+		t.Fatalf("second revoke error = %v, want ErrIngestKeyInvalid", err)
+	}
+}
+
+func TestCreateIngestKey_ValidatesLimitsAndSite(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	for _, key := range []core.IngestKey{
+		{SiteID: "site-a", Name: ""},
+		{SiteID: "site-a", Name: "huge", MaxBodyBytes: MaxIngestKeyBodyBytes + 1},
+		{SiteID: "site-a", Name: "negative", MaxBatchSize: -1},
+	} {
+		if err := repo.CreateIngestKey(ctx, &key); err == nil {
+			t.Fatalf("CreateIngestKey(%+v) returned nil error", key)
+		}
+	}
+	if err := repo.CreateIngestKey(ctx, &core.IngestKey{SiteID: "missing", Name: "x"}); !errors.Is(err, core.ErrSiteNotFound) {
+		t.Fatalf("unknown site error = %v, want ErrSiteNotFound", err)
+	}
+}
@@ -0,0 +1,296 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+const (
+	// DefaultIngestQueueLimit bounds the events waiting for a group commit.
+	DefaultIngestQueueLimit = 10000
+	maxIngestGroupEvents    = 1000
+)
+
+type ingestRequest struct {
+	// ctx carries the caller's log values, such as its request ID. It does
+	// not bound the write, which outlives a cancelled caller.
+	ctx        context.Context
+	events     []*core.Event
+	properties [][]byte
+	done       chan error
+}
+
+// ingestQueue coalesces concurrent Insert and InsertBatch calls into group
+// commits on the single writer connection. Callers wait until their events
+// are committed, and new work is refused once limit events are waiting.
+type ingestQueue struct {
+	mu      sync.Mutex
+	pending []*ingestRequest
+	events  int
+	limit   int
+	closed  bool
+	wake    chan struct{}
+	stopped chan struct{}
+}
+
+func newIngestQueue(limit int) *ingestQueue {
+	return &ingestQueue{
+		limit:   limit,
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+}
+
+// SetIngestQueueLimit changes how many events may wait for a group commit
+// before Insert and InsertBatch return core.ErrIngestQueueFull.
+func (r *SqliteRepository) SetIngestQueueLimit(limit int) {
+	if limit <= 0 {
+		limit = DefaultIngestQueueLimit
+	}
+	r.ingest.mu.Lock()
+	r.ingest.limit = limit
+	r.ingest.mu.Unlock()
+}
+
+// IngestQueueDepth reports the number of events waiting for a group commit.
+func (r *SqliteRepository) IngestQueueDepth() int {
+	r.ingest.mu.Lock()
+	defer r.ingest.mu.Unlock()
+	return r.ingest.events
+}
+
+func (r *SqliteRepository) Insert(ctx context.Context, e *core.Event) error {
+	return r.enqueueEvents(ctx, []*core.Event{e})
+}
+
+func (r *SqliteRepository) InsertBatch(ctx context.Context, events []*core.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return r.enqueueEvents(ctx, events)
+}
+
+func (r *SqliteRepository) enqueueEvents(ctx context.Context, events []*core.Event) error {
+	for _, event := range events {
+		if event == nil {
+			return fmt.Errorf("event is required")
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	request := &ingestRequest{ctx: context.WithoutCancel(ctx), events: events, done: make(chan error, 1)}
+
+	queue := r.ingest
+	queue.mu.Lock()
+	if queue.closed {
+		queue.mu.Unlock()
+		return fmt.Errorf("repository is closed")
+	}
+	if queue.events > 0 && queue.events+len(events) > queue.limit {
+		queue.mu.Unlock()
+		return core.ErrIngestQueueFull
+	}
+	queue.pending = append(queue.pending, request)
+	queue.events += len(events)
+	queue.mu.Unlock()
+	queue.signal()
+
+	// Once queued, the events will be committed or spooled whatever happens
+	// to ctx, so the caller waits for that outcome rather than reporting a
+	// failure for events that are stored. Group writes are bounded by
+	// spoolWriteTimeout, so the wait is too.
+	return <-request.done
+}
+
+func (q *ingestQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next removes up to maxIngestGroupEvents of pending work. It returns false
+// once the queue is closed and drained.
+func (q *ingestQueue) next() ([]*ingestRequest, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			count, events := 0, 0
+			for count < len(q.pending) &&
+				(count == 0 || events+len(q.pending[count].events) <= maxIngestGroupEvents) {
+				events += len(q.pending[count].events)
+				count++
+			}
+			group := append([]*ingestRequest(nil), q.pending[:count]...)
+			q.pending = q.pending[count:]
+			q.events -= events
+			q.mu.Unlock()
+			return group, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-q.wake
+	}
+}
+
+func (q *ingestQueue) close() {
+	q.mu.Lock()
+	alreadyClosed := q.closed
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+	if !alreadyClosed {
+		<-q.stopped
+	}
+}
+
+func (r *SqliteRepository) runIngestQueue() {
+	defer close(r.ingest.stopped)
+	for {
+		group, ok := r.ingest.next()
+		if !ok {
+			return
+		}
+		r.commitGroup(group)
+	}
+}
+
+// commitGroup writes every valid request in one transaction. If that
+// transaction fails for a transient reason the group is spooled instead;
+// otherwise each request is retried alone so one bad event cannot fail
+// unrelated callers.
+func (r *SqliteRepository) commitGroup(group []*ingestRequest) {
+	ctx := context.Background()
+	locations := map[string]*time.Location{}
+	siteErrors := map[string]error{}
+	ready := make([]*ingestRequest, 0, len(group))
+	var unverified []*ingestRequest
+	var unverifiedErr error
+	for _, request := range group {
+		if err := r.prepareIngestRequest(ctx, request, locations, siteErrors); err != nil {
+			if isTransientWriteError(err) {
+				unverified, unverifiedErr = append(unverified, request), err
+				continue
+			}
+			request.done <- err
+			continue
+		}
+		ready = append(ready, request)
+	}
+	if len(unverified) > 0 {
+		r.spoolRequests(unverified, unverifiedErr)
+	}
+	if len(ready) == 0 {
+		return
+	}
+
+	err := r.writeWithTimeout(ready)
+	switch {
+	case err == nil:
+		for _, request := range ready {
+			request.done <- nil
+		}
+	case isTransientWriteError(err):
+		r.spoolRequests(ready, err)
+	case len(ready) == 1:
+		ready[0].done <- err
+	default:
+		for _, request := range ready {
+			if err := r.writeWithTimeout([]*ingestRequest{request}); isTransientWriteError(err) {
+				r.spoolRequests([]*ingestRequest{request}, err)
+			} else {
+				request.done <- err
+			}
+		}
+	}
+}
+
+func (r *SqliteRepository) writeWithTimeout(requests []*ingestRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), spoolWriteTimeout)
+	defer cancel()
+	return r.writeIngestRequests(ctx, requests)
+}
+
+func (r *SqliteRepository) prepareIngestRequest(
+	ctx context.Context,
+	request *ingestRequest,
+	locations map[string]*time.Location,
+	siteErrors map[string]error,
+) error {
+	request.properties = make([][]byte, len(request.events))
+	for index, event := range request.events {
+		propsJSON, err := json.Marshal(event.Properties)
+		if err != nil {
+			return fmt.Errorf("encode event properties: %w", err)
+		}
+		request.properties[index] = propsJSON
+		prepareEventTimes(event)
+
+		location, ok := locations[event.SiteID]
+		if !ok {
+			if err, failed := siteErrors[event.SiteID]; failed {
+				return err
+			}
+			location, err = r.ingestSiteLocation(ctx, event.SiteID)
+			if err != nil {
+				siteErrors[event.SiteID] = err
+				return err
+			}
+			locations[event.SiteID] = location
+		}
+		if event.LocalDay == "" {
+			event.LocalDay = event.Timestamp.In(location).Format(time.DateOnly)
+		}
+	}
+	return nil
+}
+
+func (r *SqliteRepository) ingestSiteLocation(ctx context.Context, siteID string) (*time.Location, error) {
+	var timezone string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT timezone FROM sites WHERE id = ? AND disabled_at_us IS NULL
+	`, siteID).Scan(&timezone)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", core.ErrSiteNotFound, siteID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read site timezone: %w", err)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("load site timezone %q: %w", timezone, err)
+	}
+	return location, nil
+}
+
+func (r *SqliteRepository) writeIngestRequests(ctx context.Context, requests []*ingestRequest) error {
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertEventSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, request := range requests {
+		for index, event := range request.events {
+			if _, err := stmt.ExecContext(ctx, eventInsertArgs(event, request.properties[index])...); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestInsertBatch_ConcurrentCallersShareGroupCommits(t *testing.T) {
+	repo := newTestRepo(t)
+
+	const callers, perCaller = 40, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, callers+1)
+	for caller := 0; caller < callers; caller++ {
+		wg.Add(1)
+		go func(caller int) {
+			defer wg.Done()
+			events := make([]*core.Event, perCaller)
+			for index := range events {
+				events[index] = &core.Event{
+					ID: fmt.Sprintf("caller-%d-%d", caller, index), EventName: "$pageview",
+					SiteID: "site-a", SessionID: "s", VisitorID: "v",
+					URL: "https://example.com/", Domain: "example.com", Pathname: "/",
+				}
+			}
+			errs <- repo.InsertBatch(context.Background(), events)
+		}(caller)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- repo.Insert(context.Background(), &core.Event{
+			ID: "unknown-site", EventName: "$pageview", SiteID: "missing",
+			SessionID: "s", VisitorID: "v", URL: "https://example.com/",
+		})
+	}()
+	wg.Wait()
+	close(errs)
+
+	siteErrors := 0
+	for err := range errs {
+		if errors.Is(err, core.ErrSiteNotFound) {
+			siteErrors++
+		} else if err != nil {
+			t.Fatalf("insert returned error: %v", err)
+		}
+	}
+	if siteErrors != 1 {
+		t.Fatalf("unknown site errors = %d, want 1", siteErrors)
+	}
+	var stored int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&stored); err != nil {
+		t.Fatalf("count events: %v", err)
+	}
+	if stored != callers*perCaller {
+		t.Fatalf("stored events = %d, want %d", stored, callers*perCaller)
+	}
+}
+
+func TestInsert_RejectsWorkWhenIngestQueueIsFull(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.SetIngestQueueLimit(2)
+	newEvent := func(id string) *core.Event {
+		return &core.Event{
+			ID: id, EventName: "$pageview", SiteID: "site-a", SessionID: "s", VisitorID: "v",
+			URL: "https://example.com/", Domain: "example.com", Pathname: "/",
+		}
+	}
+
+	// Holding the only writer connection stalls the committer on its next group.
+	held, err := repo.writer.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("hold writer: %v", err)
+	}
+	stalledContext, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stalled := make(chan error, 1)
+	go func() {
+		stalled <- repo.Insert(stalledContext, newEvent("stalled"))
+	}()
+	<-stalledContext.Done()
+	waitForIngestDepth(t, repo, 0)
+
+	queued := make(chan error, 1)
+	go func() {
+		queued <- repo.InsertBatch(context.Background(), []*core.Event{newEvent("queued-1"), newEvent("queued-2")})
+	}()
+	waitForIngestDepth(t, repo, 2)
+	if err := repo.Insert(context.Background(), newEvent("rejected")); !errors.Is(err, core.ErrIngestQueueFull) {
+		t.Fatalf("insert into full queue error = %v, want ErrIngestQueueFull", err)
+	}
+
+	if err := held.Rollback(); err != nil {
+		t.Fatalf("release writer: %v", err)
+	}
+	// The stalled insert outlived its context but was stored, so it must not
+	// report a failure that would make the client retry.
+	if err := <-stalled; err != nil {
+		t.Fatalf("stalled insert returned error: %v", err)
+	}
+	if err := <-queued; err != nil {
+		t.Fatalf("queued batch returned error: %v", err)
+	}
+	waitForSpoolDrain(t, repo)
+	var stored int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&stored); err != nil {
+		t.Fatalf("count events: %v", err)
+	}
+	if stored != 3 {
+		t.Fatalf("stored events = %d, want the stalled and queued events", stored)
+	}
+}
+
+func waitForIngestDepth(t *testing.T, repo *SqliteRepository, want int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for repo.IngestQueueDepth() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("ingest queue depth = %d, want %d", repo.IngestQueueDepth(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
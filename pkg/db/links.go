@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"sort"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func (r *SqliteRepository) GetOutboundLinks(ctx context.Context, siteKey, from, to string, limit int) ([]core.LinkStat, error) {
+	return r.topLinks(ctx, "outbound", siteKey, from, to, limit)
+}
+
+func (r *SqliteRepository) GetDownloads(ctx context.Context, siteKey, from, to string, limit int) ([]core.LinkStat, error) {
+	return r.topLinks(ctx, "download", siteKey, from, to, limit)
+}
+
+func (r *SqliteRepository) topLinks(
+	ctx context.Context,
+	kind, siteKey, from, to string,
+	limit int,
+) ([]core.LinkStat, error) {
+	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	query := `
+	SELECT link_url, ` + weightedCount + ` AS clicks, ` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS visitors
+	FROM events
+	WHERE event_name = '$click'
+	  AND link_kind = ?
+	  AND site_id = ?` + timeClause + `
+	GROUP BY link_url
+	ORDER BY clicks DESC, link_url ASC
+	LIMIT ?
+	`
+	args := append([]any{kind, siteKey}, timeArgs...)
+	args = append(args, limit)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []core.LinkStat{}
+	for rows.Next() {
+		var result core.LinkStat
+		if err := rows.Scan(&result.URL, &result.Clicks, &result.Visitors); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+const maxNotFoundReferrers = 5
+
+func (r *SqliteRepository) GetNotFoundPages(ctx context.Context, siteKey, from, to string, limit int) ([]core.NotFoundStat, error) {
+	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	query := `
+	SELECT pathname, ` + weightedCount + ` AS pageviews, ` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS visitors
+	FROM events
+	WHERE event_name = '$pageview'
+	  AND not_found = 1
+	  AND site_id = ?` + timeClause + `
+	GROUP BY pathname
+	ORDER BY pageviews DESC, pathname ASC
+	LIMIT ?
+	`
+	args := append([]any{siteKey}, timeArgs...)
+	rows, err := r.db.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	results := []core.NotFoundStat{}
+	index := map[string]int{}
+	for rows.Next() {
+		result := core.NotFoundStat{Referrers: []core.ReferrerStat{}}
+		if err := rows.Scan(&result.URL, &result.Pageviews, &result.Visitors); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[result.URL] = len(results)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	referrerQuery := `
+	SELECT pathname, referrer, ` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS visitors
+	FROM events
+	WHERE event_name = '$pageview'
+	  AND not_found = 1
+	  AND referrer != ''
+	  AND site_id = ?` + timeClause + `
+	GROUP BY pathname, referrer
+	`
+	referrerRows, err := r.db.QueryContext(ctx, referrerQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer referrerRows.Close()
+	for referrerRows.Next() {
+		var pathname string
+		var referrer core.ReferrerStat
+		if err := referrerRows.Scan(&pathname, &referrer.Referrer, &referrer.Visitors); err != nil {
+			return nil, err
+		}
+		if position, ok := index[pathname]; ok {
+			results[position].Referrers = append(results[position].Referrers, referrer)
+		}
+	}
+	if err := referrerRows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		referrers := results[i].Referrers
+		sort.Slice(referrers, func(a, b int) bool {
+			if referrers[a].Visitors != referrers[b].Visitors {
+				return referrers[a].Visitors > referrers[b].Visitors
+			}
+			return referrers[a].Referrer < referrers[b].Referrer
+		})
+		if len(referrers) > maxNotFoundReferrers {
+			results[i].Referrers = referrers[:maxNotFoundReferrers]
+		}
+	}
+	return results, nil
+}
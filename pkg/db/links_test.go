@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestGetOutboundLinksAndDownloadsGroupClassifiedClicks(t *testing.T) {
+	repo := newTestRepo(t)
+	base := time.Date(2026, 8, 10, 9, 0, 0, 0, time.UTC)
+	for _, event := range []core.Event{
+		{EventName: "$click", SessionID: "s1", VisitorID: "v1", LinkKind: "outbound", LinkURL: "https://github.com/iris"},
+		{EventName: "$click", SessionID: "s2", VisitorID: "v2", LinkKind: "outbound", LinkURL: "https://github.com/iris"},
+		{EventName: "$click", SessionID: "s2", VisitorID: "v2", LinkKind: "outbound", LinkURL: "https://docs.other.com/"},
+		{EventName: "$click", SessionID: "s3", VisitorID: "v3", LinkKind: "download", LinkURL: "https://example.com/report.pdf"},
+		{EventName: "$click", SessionID: "s3", VisitorID: "v3"},
+	} {
+		event.Domain = "example.com"
+		event.SiteID = "site-a"
+		event.Timestamp = base
+		insertEvent(t, repo, event)
+	}
+
+	outbound, err := repo.GetOutboundLinks(context.Background(), "site-a", "", "", 10)
+	if err != nil {
+		t.Fatalf("GetOutboundLinks returned error: %v", err)
+	}
+	if len(outbound) != 2 || outbound[0].URL != "https://github.com/iris" ||
+		outbound[0].Clicks != 2 || outbound[0].Visitors != 2 {
+		t.Fatalf("unexpected outbound links: %+v", outbound)
+	}
+	downloads, err := repo.GetDownloads(context.Background(), "site-a", "", "", 10)
+	if err != nil {
+		t.Fatalf("GetDownloads returned error: %v", err)
+	}
+	if len(downloads) != 1 || downloads[0].URL != "https://example.com/report.pdf" || downloads[0].Clicks != 1 {
+		t.Fatalf("unexpected downloads: %+v", downloads)
+	}
+}
+
+func TestGetNotFoundPagesIncludesReferrers(t *testing.T) {
+	repo := newTestRepo(t)
+	for _, event := range []core.Event{
+		{EventName: "$pageview", URL: "https://example.com/missing", SessionID: "s1", VisitorID: "v1", Referrer: "https://example.com/blog", NotFound: true},
+		{EventName: "$pageview", URL: "https://example.com/missing", SessionID: "s2", VisitorID: "v2", Referrer: "https://example.com/blog", NotFound: true},
+		{EventName: "$pageview", URL: "https://example.com/missing", SessionID: "s3", VisitorID: "v3", Referrer: "https://partner.example/links", NotFound: true},
+		{EventName: "$pageview", URL: "https://example.com/old", SessionID: "s4", VisitorID: "v4", NotFound: true},
+		{EventName: "$pageview", URL: "https://example.com/found", SessionID: "s5", VisitorID: "v5"},
+	} {
+		event.Domain = "example.com"
+		event.SiteID = "site-a"
+		insertEvent(t, repo, event)
+	}
+
+	pages, err := repo.GetNotFoundPages(context.Background(), "site-a", "", "", 10)
+	if err != nil {
+		t.Fatalf("GetNotFoundPages returned error: %v", err)
+	}
+	if len(pages) != 2 || pages[0].URL != "/missing" || pages[0].Pageviews != 3 || pages[0].Visitors != 3 {
+		t.Fatalf("unexpected broken pages: %+v", pages)
+	}
+	if len(pages[0].Referrers) != 2 || pages[0].Referrers[0].Referrer != "https://example.com/blog" ||
+		pages[0].Referrers[0].Visitors != 2 {
+		t.Fatalf("unexpected broken page referrers: %+v", pages[0].Referrers)
+	}
+	if pages[1].URL != "/old" || len(pages[1].Referrers) != 0 {
+		t.Fatalf("unexpected second broken page: %+v", pages[1])
+	}
+}
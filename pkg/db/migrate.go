@@ -4,11 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
 )
 
 //go:embed migrations/*.sql
@@ -18,11 +21,39 @@ type migration struct {
 	version int
 	name    string
 	file    string
+	// backfill derives column values that SQL alone cannot compute. It runs in
+	// the migration transaction after the schema change.
+	backfill func(context.Context, *sql.Tx) error
 }
 
 var migrations = []migration{
 	{version: 1, name: "v2_schema", file: "migrations/001_v2_schema.sql"},
 	{version: 2, name: "local_day_sets", file: "migrations/002_local_day_sets.sql"},
+	{version: 3, name: "engagement", file: "migrations/003_engagement.sql"},
+	{version: 4, name: "link_classification", file: "migrations/004_link_classification.sql"},
+	{
+		version: 5, name: "element_signature", file: "migrations/005_element_signature.sql",
+		backfill: backfillElementSignatures,
+	},
+	{version: 6, name: "site_search", file: "migrations/006_site_search.sql"},
+	{version: 7, name: "path_rules", file: "migrations/007_path_rules.sql"},
+	{version: 8, name: "ingest_key_limits", file: "migrations/008_ingest_key_limits.sql"},
+	{version: 9, name: "server_identity", file: "migrations/009_server_identity.sql"},
+	{version: 10, name: "privacy_policy", file: "migrations/010_privacy_policy.sql"},
+	{version: 11, name: "data_subject_requests", file: "migrations/011_data_subject_requests.sql"},
+	{version: 12, name: "breakdown_privacy", file: "migrations/012_breakdown_privacy.sql"},
+	{version: 13, name: "share_links", file: "migrations/013_share_links.sql"},
+	{version: 14, name: "users", file: "migrations/014_users.sql"},
+	{version: 15, name: "oidc_identities", file: "migrations/015_oidc_identities.sql"},
+	{version: 16, name: "audit_log", file: "migrations/016_audit_log.sql"},
+	{version: 17, name: "api_tokens", file: "migrations/017_api_tokens.sql"},
+	{version: 18, name: "usage_quotas", file: "migrations/018_usage_quotas.sql"},
+	{version: 19, name: "sampling", file: "migrations/019_sampling.sql"},
+	{version: 20, name: "site_reprojections", file: "migrations/020_site_reprojections.sql"},
+	{
+		version: 21, name: "link_classification_backfill", file: "migrations/021_link_classification_backfill.sql",
+		backfill: backfillLinkClassification,
+	},
 }
 
 func migrate(ctx context.Context, database *sql.DB) error {
@@ -89,6 +120,12 @@ func applyMigration(ctx context.Context, database *sql.DB, item migration) error
 		return fmt.Errorf("apply migration %d: %w", item.version, err)
 	}
 
+	if item.backfill != nil {
+		if err := item.backfill(ctx, tx); err != nil {
+			return fmt.Errorf("backfill migration %d: %w", item.version, err)
+		}
+	}
+
 	if legacy {
 		if err := migrateLegacyEvents(ctx, tx); err != nil {
 			return fmt.Errorf("migrate legacy events: %w", err)
@@ -113,6 +150,105 @@ func applyMigration(ctx context.Context, database *sql.DB, item migration) error
 	return nil
 }
 
+func backfillElementSignatures(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT seq, properties FROM events WHERE event_name = '$click'
+	`)
+	if err != nil {
+		return err
+	}
+	signatures := map[int64]string{}
+	for rows.Next() {
+		var seq int64
+		var rawProperties string
+		if err := rows.Scan(&seq, &rawProperties); err != nil {
+			rows.Close()
+			return err
+		}
+		var properties map[string]any
+		if json.Unmarshal([]byte(rawProperties), &properties) != nil {
+			continue
+		}
+		signatures[seq] = core.ElementSignature(properties)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for seq, signature := range signatures {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE events SET element_signature = ? WHERE seq = ?
+		`, signature, seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillLinkClassification classifies the links of clicks stored before
+// ingestion did, with the default download extensions since the configured
+// ones are not known to the database.
+func backfillLinkClassification(ctx context.Context, tx *sql.Tx) error {
+	sites := map[string]*core.Site{}
+	rows, err := tx.QueryContext(ctx, "SELECT site_id, hostname FROM site_domains")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var siteID, hostname string
+		if err := rows.Scan(&siteID, &hostname); err != nil {
+			rows.Close()
+			return err
+		}
+		if sites[siteID] == nil {
+			sites[siteID] = &core.Site{ID: siteID}
+		}
+		sites[siteID].Domains = append(sites[siteID].Domains, hostname)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT seq, site_id, url, properties FROM events
+		WHERE event_name = '$click' AND link_kind = ''
+	`)
+	if err != nil {
+		return err
+	}
+	type link struct{ kind, url string }
+	links := map[int64]link{}
+	extensions := core.DownloadExtensionSet(core.DefaultDownloadExtensions)
+	for rows.Next() {
+		var seq int64
+		var siteID, rawURL, rawProperties string
+		if err := rows.Scan(&seq, &siteID, &rawURL, &rawProperties); err != nil {
+			rows.Close()
+			return err
+		}
+		site := sites[siteID]
+		page, err := url.Parse(rawURL)
+		var properties map[string]any
+		if site == nil || err != nil || json.Unmarshal([]byte(rawProperties), &properties) != nil {
+			continue
+		}
+		href, _ := properties["$href"].(string)
+		if kind, target := core.ClassifyLink(site, page, href, extensions); kind != "" {
+			links[seq] = link{kind: kind, url: target}
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for seq, item := range links {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE events SET link_kind = ?, link_url = ? WHERE seq = ?
+		`, item.kind, item.url, seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func isLegacyEventsTable(ctx context.Context, tx *sql.Tx) (bool, error) {
 	var exists int
 	if err := tx.QueryRowContext(ctx, `
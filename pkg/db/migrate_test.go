@@ -3,10 +3,13 @@ package db
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"maps"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/VatsalP117/iris/pkg/core"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -25,6 +28,7 @@ func TestNewSqliteDB_CreatesVersionedV2Schema(t *testing.T) {
 		"daily_referrer_visitors",
 		"daily_visitors",
 		"daily_sessions",
+		"daily_page_engagement",
 		"projection_checkpoints",
 	} {
 		var found string
@@ -39,8 +43,8 @@ func TestNewSqliteDB_CreatesVersionedV2Schema(t *testing.T) {
 	if err := repo.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
 		t.Fatalf("read schema version: %v", err)
 	}
-	if version != 2 {
-		t.Fatalf("schema version = %d, want 2", version)
+	if version != 21 {
+		t.Fatalf("schema version = %d, want 21", version)
 	}
 }
 
@@ -65,6 +69,11 @@ func TestNewSqliteDB_MigratesLegacyEvents(t *testing.T) {
 			'legacy-null-time', '$pageview', 'https://example.com/null?secret=1',
 			'example.com', '', 1440, 'site-a', 'session-b', 'visitor-b', '{}', NULL
 		);
+		INSERT INTO events VALUES (
+			'legacy-click', '$click', 'https://example.com/pricing', 'example.com', '',
+			1440, 'site-a', 'session-a', 'visitor-a',
+			'{"$tag":"button","$id":"buy","$text":"Buy now"}', '2026-08-01 12:01:00'
+		);
 	`)
 	if err != nil {
 		t.Fatalf("create legacy schema: %v", err)
@@ -111,4 +120,73 @@ func TestNewSqliteDB_MigratesLegacyEvents(t *testing.T) {
 	if occurredAt <= 0 {
 		t.Fatalf("null legacy timestamp did not receive fallback: %d", occurredAt)
 	}
+
+	var signature string
+	if err := repo.db.QueryRow(`
+		SELECT element_signature FROM events WHERE id = 'legacy-click'
+	`).Scan(&signature); err != nil {
+		t.Fatalf("read migrated click: %v", err)
+	}
+	want := core.ElementSignature(map[string]any{"$tag": "button", "$id": "buy", "$text": "Buy now"})
+	if signature != want {
+		t.Fatalf("backfilled element signature = %q, want %q", signature, want)
+	}
+}
+
+func TestMigration_BackfillsLinkClassification(t *testing.T) {
+	databasePath := filepath.Join(t.TempDir(), "iris.db")
+	repo, err := NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com", "docs.example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	for index, href := range []string{"/files/report.PDF", "https://other.com/x?ref=1", "https://docs.example.com/", "mailto:a@b.c"} {
+		insertProjectionEvent(t, repo, fmt.Sprint("click-", index), core.Event{
+			EventName: "$click", SiteID: "site-a", SessionID: "s", VisitorID: "v", Pathname: "/pricing",
+			Properties: map[string]any{"$href": href},
+			Timestamp:  time.Date(2026, 8, 1, 12, 0, 0, 0, time.UTC),
+		})
+	}
+	// Stand in for clicks stored before ingestion classified links.
+	if _, err := repo.writer.Exec(`
+		UPDATE events SET link_kind = '', link_url = '';
+		DELETE FROM schema_migrations WHERE version = 21;
+	`); err != nil {
+		t.Fatalf("reset link classification: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	repo, err = NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	rows, err := repo.db.Query("SELECT id, link_kind, link_url FROM events ORDER BY id")
+	if err != nil {
+		t.Fatalf("read clicks: %v", err)
+	}
+	defer rows.Close()
+	got := map[string]string{}
+	for rows.Next() {
+		var id, kind, linkURL string
+		if err := rows.Scan(&id, &kind, &linkURL); err != nil {
+			t.Fatalf("scan click: %v", err)
+		}
+		got[id] = kind + " " + linkURL
+	}
+	want := map[string]string{
+		"click-0": "download https://example.com/files/report.PDF",
+		"click-1": "outbound https://other.com/x",
+		"click-2": " ",
+		"click-3": " ",
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("backfilled links = %q, want %q", got, want)
+	}
 }
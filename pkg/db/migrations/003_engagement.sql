@@ -0,0 +1,14 @@
+ALTER TABLE sessions ADD COLUMN engaged_ms INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE daily_page_engagement (
+    site_id           TEXT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    day               TEXT NOT NULL,
+    pathname          TEXT NOT NULL,
+    engagements       INTEGER NOT NULL DEFAULT 0,
+    engaged_ms        INTEGER NOT NULL DEFAULT 0,
+    scroll_25         INTEGER NOT NULL DEFAULT 0,
+    scroll_50         INTEGER NOT NULL DEFAULT 0,
+    scroll_75         INTEGER NOT NULL DEFAULT 0,
+    scroll_100        INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (site_id, day, pathname)
+);
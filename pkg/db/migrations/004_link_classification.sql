@@ -0,0 +1,9 @@
+ALTER TABLE events ADD COLUMN link_kind TEXT NOT NULL DEFAULT ''
+    CHECK (link_kind IN ('', 'outbound', 'download'));
+ALTER TABLE events ADD COLUMN link_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN not_found INTEGER NOT NULL DEFAULT 0
+    CHECK (not_found IN (0, 1));
+
+UPDATE events
+SET not_found = 1
+WHERE event_name = '$pageview' AND json_extract(properties, '$.$404') IN (1, 'true');
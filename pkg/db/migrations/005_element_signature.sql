@@ -0,0 +1,5 @@
+ALTER TABLE events ADD COLUMN element_signature TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_events_site_element_time
+    ON events(site_id, element_signature, occurred_at_us)
+    WHERE element_signature != '';
@@ -0,0 +1,7 @@
+ALTER TABLE sites ADD COLUMN search_param TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE events ADD COLUMN search_term TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_events_site_search_time
+    ON events(site_id, occurred_at_us)
+    WHERE search_term != '';
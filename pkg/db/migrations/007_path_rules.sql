@@ -0,0 +1,8 @@
+ALTER TABLE sites ADD COLUMN path_rules TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE sites ADD COLUMN content_groups TEXT NOT NULL DEFAULT '[]';
+
+ALTER TABLE events ADD COLUMN content_group TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_events_site_group_time
+    ON events(site_id, content_group, occurred_at_us)
+    WHERE content_group != '';
@@ -0,0 +1,4 @@
+ALTER TABLE ingest_keys ADD COLUMN max_body_bytes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE ingest_keys ADD COLUMN max_batch_size INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_ingest_keys_site ON ingest_keys(site_id);
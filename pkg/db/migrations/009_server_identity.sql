@@ -0,0 +1,10 @@
+ALTER TABLE sites ADD COLUMN server_identity INTEGER NOT NULL DEFAULT 0;
+
+-- One random salt per site and site-local day. Older salts are deleted when a
+-- new day's salt is created so past visitor hashes cannot be recomputed.
+CREATE TABLE identity_salts (
+    site_id TEXT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    local_day TEXT NOT NULL,
+    salt BLOB NOT NULL,
+    PRIMARY KEY (site_id, local_day)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE sites ADD COLUMN privacy_signals TEXT NOT NULL DEFAULT 'ignore';
+ALTER TABLE sites ADD COLUMN default_consent TEXT NOT NULL DEFAULT 'full';
@@ -0,0 +1,15 @@
+-- Audit trail of data subject exports and erasures. The subject identifier is
+-- stored as a SHA-256 hash so the trail does not retain erased identifiers;
+-- an operator can still confirm a request by hashing the identifier again.
+CREATE TABLE data_subject_requests (
+    id INTEGER PRIMARY KEY,
+    action TEXT NOT NULL CHECK (action IN ('export', 'erase')),
+    site_id TEXT NOT NULL DEFAULT '',
+    id_kind TEXT NOT NULL CHECK (id_kind IN ('visitor_id', 'session_id')),
+    id_hash TEXT NOT NULL,
+    event_count INTEGER NOT NULL,
+    actor TEXT NOT NULL,
+    requested_at_us INTEGER NOT NULL
+);
+
+CREATE INDEX idx_data_subject_requests_time ON data_subject_requests(requested_at_us);
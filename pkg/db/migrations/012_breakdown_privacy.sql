@@ -0,0 +1,2 @@
+ALTER TABLE sites ADD COLUMN min_breakdown_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sites ADD COLUMN privacy_epsilon REAL NOT NULL DEFAULT 0;
@@ -0,0 +1,14 @@
+CREATE TABLE share_links (
+    id              TEXT PRIMARY KEY,
+    site_id         TEXT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    slug_hash       TEXT NOT NULL UNIQUE,
+    name            TEXT NOT NULL,
+    password_hash   TEXT NOT NULL DEFAULT '',
+    locked_from     TEXT NOT NULL DEFAULT '',
+    locked_to       TEXT NOT NULL DEFAULT '',
+    expires_at_us   INTEGER,
+    created_at_us   INTEGER NOT NULL,
+    revoked_at_us   INTEGER
+);
+
+CREATE INDEX idx_share_links_site ON share_links(site_id);
@@ -0,0 +1,43 @@
+CREATE TABLE users (
+    id              TEXT PRIMARY KEY,
+    email           TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    name            TEXT NOT NULL DEFAULT '',
+    password_hash   TEXT NOT NULL,
+    created_at_us   INTEGER NOT NULL,
+    disabled_at_us  INTEGER
+);
+
+CREATE TABLE user_sessions (
+    token_hash      TEXT PRIMARY KEY,
+    user_id         TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    csrf_token      TEXT NOT NULL,
+    created_at_us   INTEGER NOT NULL,
+    expires_at_us   INTEGER NOT NULL
+);
+
+CREATE INDEX idx_user_sessions_user ON user_sessions(user_id);
+
+CREATE TABLE site_grants (
+    site_id         TEXT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    user_id         TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role            TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'viewer')),
+    created_at_us   INTEGER NOT NULL,
+    PRIMARY KEY (site_id, user_id)
+);
+
+CREATE INDEX idx_site_grants_user ON site_grants(user_id);
+
+CREATE TABLE invites (
+    id              TEXT PRIMARY KEY,
+    token_hash      TEXT NOT NULL UNIQUE,
+    site_id         TEXT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+    email           TEXT NOT NULL,
+    role            TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'viewer')),
+    invited_by      TEXT NOT NULL,
+    created_at_us   INTEGER NOT NULL,
+    expires_at_us   INTEGER NOT NULL,
+    accepted_at_us  INTEGER,
+    revoked_at_us   INTEGER
+);
+
+CREATE INDEX idx_invites_site ON invites(site_id);
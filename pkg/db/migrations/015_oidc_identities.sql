@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN oidc_issuer TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN oidc_subject TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX idx_users_oidc ON users(oidc_issuer, oidc_subject)
+    WHERE oidc_subject != '';
+
+-- Grants mapped from identity provider claims are replaced on every single
+-- sign-on; invited and manually granted roles are 'local'.
+ALTER TABLE site_grants ADD COLUMN source TEXT NOT NULL DEFAULT 'local';
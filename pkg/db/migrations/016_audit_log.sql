@@ -0,0 +1,26 @@
+-- Append-only record of administrative changes. changes is a JSON object of
+-- the fields that differ, each with its before and after value. The triggers
+-- refuse edits so the log can answer who changed what, and when.
+CREATE TABLE audit_log (
+    id              INTEGER PRIMARY KEY,
+    actor           TEXT NOT NULL,
+    action          TEXT NOT NULL,
+    site_id         TEXT NOT NULL DEFAULT '',
+    target          TEXT NOT NULL DEFAULT '',
+    changes         TEXT NOT NULL DEFAULT '{}',
+    ip              TEXT NOT NULL DEFAULT '',
+    created_at_us   INTEGER NOT NULL
+);
+
+CREATE INDEX idx_audit_log_site ON audit_log(site_id, id);
+CREATE INDEX idx_audit_log_time ON audit_log(created_at_us);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,17 @@
+-- Named API tokens for scripts and integrations. Only a SHA-256 hash of each
+-- token is stored. scopes is a space-separated list; an empty site_id lets the
+-- token act on every site.
+CREATE TABLE api_tokens (
+    id               TEXT PRIMARY KEY,
+    token_hash       TEXT NOT NULL UNIQUE,
+    name             TEXT NOT NULL,
+    scopes           TEXT NOT NULL,
+    site_id          TEXT NOT NULL DEFAULT '',
+    created_by       TEXT NOT NULL,
+    created_at_us    INTEGER NOT NULL,
+    expires_at_us    INTEGER,
+    last_used_at_us  INTEGER,
+    revoked_at_us    INTEGER
+);
+
+CREATE INDEX idx_api_tokens_site ON api_tokens(site_id);
@@ -0,0 +1,21 @@
+ALTER TABLE sites ADD COLUMN monthly_event_quota INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sites ADD COLUMN quota_mode TEXT NOT NULL DEFAULT 'soft';
+ALTER TABLE sites ADD COLUMN quota_sample_rate REAL NOT NULL DEFAULT 0.1;
+
+-- Usage is billing data with its own projection checkpoint. Retention,
+-- erasure and projection rebuilds leave it alone.
+CREATE TABLE daily_usage (
+    site_id     TEXT NOT NULL,
+    day         TEXT NOT NULL,
+    event_name  TEXT NOT NULL,
+    events      INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (site_id, day, event_name)
+);
+
+INSERT INTO daily_usage(site_id, day, event_name, events)
+SELECT site_id, local_day, event_name, COUNT(*)
+FROM events
+GROUP BY site_id, local_day, event_name;
+
+INSERT INTO projection_checkpoints(name, last_seq, version, updated_at_us)
+VALUES ('usage', (SELECT COALESCE(MAX(seq), 0) FROM events), 1, CAST(strftime('%s', 'now') AS INTEGER) * 1000000);
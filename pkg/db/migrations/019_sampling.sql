@@ -0,0 +1,15 @@
+-- Ingestion sampling. Each event records the share of its kind that was kept,
+-- and reports weight it by 1 / sample_rate. Daily distinct sets keep the
+-- weight of the event that added the row.
+ALTER TABLE sites ADD COLUMN sample_rate REAL NOT NULL DEFAULT 1;
+ALTER TABLE sites ADD COLUMN event_sample_rates TEXT NOT NULL DEFAULT '{}';
+
+ALTER TABLE events ADD COLUMN sample_rate REAL NOT NULL DEFAULT 1;
+
+CREATE INDEX idx_events_site_sampled_time
+    ON events(site_id, occurred_at_us)
+    WHERE sample_rate < 1;
+
+ALTER TABLE daily_referrer_visitors ADD COLUMN weight REAL NOT NULL DEFAULT 1;
+ALTER TABLE daily_visitors ADD COLUMN weight REAL NOT NULL DEFAULT 1;
+ALTER TABLE daily_sessions ADD COLUMN weight REAL NOT NULL DEFAULT 1;
@@ -0,0 +1,8 @@
+-- Sites whose path rules changed after events were stored. The projector
+-- rewrites their events in batches after after_seq and then reprojects the
+-- site, so saving the settings never waits for the rewrite.
+CREATE TABLE site_reprojections (
+    site_id         TEXT PRIMARY KEY REFERENCES sites(id) ON DELETE CASCADE,
+    after_seq       INTEGER NOT NULL DEFAULT 0,
+    requested_at_us INTEGER NOT NULL
+);
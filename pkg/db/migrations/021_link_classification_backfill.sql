@@ -0,0 +1,3 @@
+-- Clicks stored before links were classified get link_kind and link_url from
+-- backfillLinkClassification, using the default download extensions and the
+-- site's domains at migration time.
@@ -11,12 +11,24 @@ import (
 
 const (
 	analyticsProjectionName    = "analytics"
-	analyticsProjectionVersion = 1
+	analyticsProjectionVersion = 2
 	defaultProjectionBatchSize = 1000
 )
 
 var ErrProjectionVersionMismatch = errors.New("projection version mismatch")
 
+// projectionTables lists the rebuildable analytics tables. Each is keyed by
+// site_id so a single site can be reprojected.
+var projectionTables = []string{
+	"sessions",
+	"daily_site_metrics",
+	"daily_page_metrics",
+	"daily_referrer_visitors",
+	"daily_visitors",
+	"daily_sessions",
+	"daily_page_engagement",
+}
+
 type projectionEvent struct {
 	seq          int64
 	siteID       string
@@ -27,6 +39,10 @@ type projectionEvent struct {
 	sessionID    string
 	visitorID    string
 	localDay     string
+	engagedMS    int64
+	scrollPct    int
+	// weight is how many events this one stands for: 1 / sample_rate.
+	weight float64
 }
 
 type projectionSessionKey struct {
@@ -36,7 +52,8 @@ type projectionSessionKey struct {
 
 // ProjectPending applies at most batchSize raw events to the rebuildable
 // analytics tables. The derived writes and checkpoint advance are committed
-// together, so retrying after an error cannot count an event twice.
+// together, so retrying after an error cannot count an event twice. Each call
+// also rewrites one batch of events for a site whose path rules changed.
 func (r *SqliteRepository) ProjectPending(ctx context.Context, batchSize int) (int, error) {
 	if batchSize <= 0 {
 		return 0, fmt.Errorf("projection batch size must be positive")
@@ -48,6 +65,12 @@ func (r *SqliteRepository) ProjectPending(ctx context.Context, batchSize int) (i
 	}
 	defer tx.Rollback()
 
+	if _, err := projectUsage(ctx, tx, batchSize); err != nil {
+		return 0, err
+	}
+	if _, err := reapplyPathRules(ctx, tx, batchSize); err != nil {
+		return 0, err
+	}
 	checkpoint, err := projectionCheckpoint(ctx, tx)
 	if err != nil {
 		return 0, err
@@ -104,14 +127,7 @@ func (r *SqliteRepository) RebuildProjections(ctx context.Context) error {
 	}
 	defer tx.Rollback()
 
-	for _, table := range []string{
-		"sessions",
-		"daily_site_metrics",
-		"daily_page_metrics",
-		"daily_referrer_visitors",
-		"daily_visitors",
-		"daily_sessions",
-	} {
+	for _, table := range projectionTables {
 		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
 			return fmt.Errorf("clear %s: %w", table, err)
 		}
@@ -141,6 +157,83 @@ func (r *SqliteRepository) RebuildProjections(ctx context.Context) error {
 	}
 }
 
+// reprojectSite discards one site's derived analytics and replays its events
+// up to the current checkpoint inside tx. Events after the checkpoint are left
+// for ProjectPending, so nothing is counted twice.
+func reprojectSite(ctx context.Context, tx *sql.Tx, siteID string) error {
+	checkpoint, err := projectionCheckpoint(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range projectionTables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE site_id = ?", siteID); err != nil {
+			return fmt.Errorf("clear %s for site %q: %w", table, siteID, err)
+		}
+	}
+
+	sessions := map[string]struct{}{}
+	var afterSeq int64
+	for {
+		events, err := readProjectionEvents(
+			ctx, tx, "e.site_id = ? AND e.seq > ? AND e.seq <= ?",
+			[]any{siteID, afterSeq, checkpoint}, defaultProjectionBatchSize,
+		)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := projectDailyEvent(ctx, tx, event, event.localDay); err != nil {
+				return fmt.Errorf("reproject event %d: %w", event.seq, err)
+			}
+			if event.sessionID != "" {
+				sessions[event.sessionID] = struct{}{}
+			}
+			afterSeq = event.seq
+		}
+		if len(events) < defaultProjectionBatchSize {
+			break
+		}
+	}
+	for sessionID := range sessions {
+		if err := rebuildSession(ctx, tx, siteID, sessionID, checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reprojectSiteDay rebuilds one site-local day of a site's daily projections
+// from events up to checkpoint inside tx. Sessions are left to rebuildSession.
+func reprojectSiteDay(ctx context.Context, tx *sql.Tx, siteID, day string, checkpoint int64) error {
+	for _, table := range projectionTables {
+		if table == "sessions" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE site_id = ? AND day = ?", siteID, day); err != nil {
+			return fmt.Errorf("clear %s for site %q on %s: %w", table, siteID, day, err)
+		}
+	}
+	var afterSeq int64
+	for {
+		events, err := readProjectionEvents(
+			ctx, tx, "e.site_id = ? AND e.local_day = ? AND e.seq > ? AND e.seq <= ?",
+			[]any{siteID, day, afterSeq, checkpoint}, defaultProjectionBatchSize,
+		)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := projectDailyEvent(ctx, tx, event, event.localDay); err != nil {
+				return fmt.Errorf("reproject event %d: %w", event.seq, err)
+			}
+			afterSeq = event.seq
+		}
+		if len(events) < defaultProjectionBatchSize {
+			return nil
+		}
+	}
+}
+
 func projectionCheckpoint(ctx context.Context, tx *sql.Tx) (int64, error) {
 	now := time.Now().UTC().UnixMicro()
 	if _, err := tx.ExecContext(ctx, `
@@ -170,15 +263,32 @@ func pendingProjectionEvents(
 	tx *sql.Tx,
 	checkpoint int64,
 	batchSize int,
+) ([]projectionEvent, error) {
+	return readProjectionEvents(ctx, tx, "e.seq > ?", []any{checkpoint}, batchSize)
+}
+
+func readProjectionEvents(
+	ctx context.Context,
+	tx *sql.Tx,
+	where string,
+	args []any,
+	batchSize int,
 ) ([]projectionEvent, error) {
 	rows, err := tx.QueryContext(ctx, `
 		SELECT e.seq, e.site_id, e.event_name, e.occurred_at_us, e.pathname,
-		       e.referrer_host, e.session_id, e.visitor_id, e.local_day
+		       e.referrer_host, e.session_id, e.visitor_id, e.local_day,
+		       CASE WHEN e.event_name = '$engagement'
+		            THEN COALESCE(CAST(json_extract(e.properties, '$.$engaged_ms') AS INTEGER), 0)
+		            ELSE 0 END,
+		       CASE WHEN e.event_name = '$engagement'
+		            THEN COALESCE(CAST(json_extract(e.properties, '$.$scroll_pct') AS INTEGER), 0)
+		            ELSE 0 END,
+		       1.0 / e.sample_rate
 		FROM events e
-		WHERE e.seq > ?
+		WHERE `+where+`
 		ORDER BY e.seq
 		LIMIT ?
-	`, checkpoint, batchSize)
+	`, append(args, batchSize)...)
 	if err != nil {
 		return nil, fmt.Errorf("read pending projection events: %w", err)
 	}
@@ -197,6 +307,9 @@ func pendingProjectionEvents(
 			&event.sessionID,
 			&event.visitorID,
 			&event.localDay,
+			&event.engagedMS,
+			&event.scrollPct,
+			&event.weight,
 		); err != nil {
 			return nil, fmt.Errorf("scan pending projection event: %w", err)
 		}
@@ -208,9 +321,18 @@ func pendingProjectionEvents(
 	return events, nil
 }
 
+// projectDailyEvent adds one event to the daily tables. Counts grow by the
+// event's weight, so sampled events are scaled up as they are projected, and
+// daily sets keep the weight of the event that added each member.
 func projectDailyEvent(ctx context.Context, tx *sql.Tx, event projectionEvent, day string) error {
 	pageview := event.eventName == "$pageview"
 	customEvent := event.eventName != "" && !strings.HasPrefix(event.eventName, "$")
+	weigh := func(counted bool) float64 {
+		if counted {
+			return event.weight
+		}
+		return 0
+	}
 	if pageview || customEvent {
 		if _, err := tx.ExecContext(ctx, `
 			INSERT INTO daily_site_metrics(site_id, day, pageviews, custom_events)
@@ -218,45 +340,66 @@ func projectDailyEvent(ctx context.Context, tx *sql.Tx, event projectionEvent, d
 			ON CONFLICT(site_id, day) DO UPDATE SET
 				pageviews = pageviews + excluded.pageviews,
 				custom_events = custom_events + excluded.custom_events
-		`, event.siteID, day, boolToInt(pageview), boolToInt(customEvent)); err != nil {
+		`, event.siteID, day, weigh(pageview), weigh(customEvent)); err != nil {
 			return fmt.Errorf("update daily site metrics: %w", err)
 		}
 	}
+	if event.eventName == "$engagement" {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO daily_page_engagement(
+				site_id, day, pathname, engagements, engaged_ms,
+				scroll_25, scroll_50, scroll_75, scroll_100
+			)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(site_id, day, pathname) DO UPDATE SET
+				engagements = engagements + excluded.engagements,
+				engaged_ms = engaged_ms + excluded.engaged_ms,
+				scroll_25 = scroll_25 + excluded.scroll_25,
+				scroll_50 = scroll_50 + excluded.scroll_50,
+				scroll_75 = scroll_75 + excluded.scroll_75,
+				scroll_100 = scroll_100 + excluded.scroll_100
+		`, event.siteID, day, event.pathname, event.weight, float64(event.engagedMS)*event.weight,
+			weigh(event.scrollPct >= 25), weigh(event.scrollPct >= 50),
+			weigh(event.scrollPct >= 75), weigh(event.scrollPct >= 100),
+		); err != nil {
+			return fmt.Errorf("update daily page engagement: %w", err)
+		}
+	}
 	if !pageview {
 		return nil
 	}
 	if _, err := tx.ExecContext(ctx, `
 		INSERT INTO daily_page_metrics(site_id, day, pathname, pageviews)
-		VALUES (?, ?, ?, 1)
+		VALUES (?, ?, ?, ?)
 		ON CONFLICT(site_id, day, pathname) DO UPDATE SET
-			pageviews = pageviews + 1
-	`, event.siteID, day, event.pathname); err != nil {
+			pageviews = pageviews + excluded.pageviews
+	`, event.siteID, day, event.pathname, event.weight); err != nil {
 		return fmt.Errorf("update daily page metrics: %w", err)
 	}
 	if event.referrerHost != "" && event.visitorID != "" {
 		if _, err := tx.ExecContext(ctx, `
-			INSERT INTO daily_referrer_visitors(site_id, day, referrer_host, visitor_id)
-			VALUES (?, ?, ?, ?)
+			INSERT INTO daily_referrer_visitors(site_id, day, referrer_host, visitor_id, weight)
+			VALUES (?, ?, ?, ?, ?)
 			ON CONFLICT(site_id, day, referrer_host, visitor_id) DO NOTHING
-		`, event.siteID, day, event.referrerHost, event.visitorID); err != nil {
+		`, event.siteID, day, event.referrerHost, event.visitorID, event.weight); err != nil {
 			return fmt.Errorf("update daily referrer visitors: %w", err)
 		}
 	}
 	if event.visitorID != "" {
 		if _, err := tx.ExecContext(ctx, `
-			INSERT INTO daily_visitors(site_id, day, visitor_id)
-			VALUES (?, ?, ?)
+			INSERT INTO daily_visitors(site_id, day, visitor_id, weight)
+			VALUES (?, ?, ?, ?)
 			ON CONFLICT(site_id, day, visitor_id) DO NOTHING
-		`, event.siteID, day, event.visitorID); err != nil {
+		`, event.siteID, day, event.visitorID, event.weight); err != nil {
 			return fmt.Errorf("update daily visitors: %w", err)
 		}
 	}
 	if event.sessionID != "" {
 		if _, err := tx.ExecContext(ctx, `
-			INSERT INTO daily_sessions(site_id, day, session_id)
-			VALUES (?, ?, ?)
+			INSERT INTO daily_sessions(site_id, day, session_id, weight)
+			VALUES (?, ?, ?, ?)
 			ON CONFLICT(site_id, day, session_id) DO NOTHING
-		`, event.siteID, day, event.sessionID); err != nil {
+		`, event.siteID, day, event.sessionID, event.weight); err != nil {
 			return fmt.Errorf("update daily sessions: %w", err)
 		}
 	}
@@ -268,7 +411,7 @@ func rebuildSession(ctx context.Context, tx *sql.Tx, siteID, sessionID string, t
 		INSERT INTO sessions(
 			site_id, session_id, visitor_id, started_at_us, ended_at_us,
 			entry_pathname, exit_pathname, referrer_host, pageviews,
-			event_count, is_bounce, projection_version
+			event_count, is_bounce, engaged_ms, projection_version
 		)
 		SELECT
 			e.site_id,
@@ -303,6 +446,9 @@ func rebuildSession(ctx context.Context, tx *sql.Tx, siteID, sessionID string, t
 			COUNT(*),
 			CASE WHEN SUM(CASE WHEN e.event_name = '$pageview' THEN 1 ELSE 0 END) <= 1
 				THEN 1 ELSE 0 END,
+			SUM(CASE WHEN e.event_name = '$engagement'
+				THEN COALESCE(CAST(json_extract(e.properties, '$.$engaged_ms') AS INTEGER), 0)
+				ELSE 0 END),
 			?
 		FROM events e
 		WHERE e.site_id = ? AND e.session_id = ? AND e.seq <= ?
@@ -317,6 +463,7 @@ func rebuildSession(ctx context.Context, tx *sql.Tx, siteID, sessionID string, t
 			pageviews = excluded.pageviews,
 			event_count = excluded.event_count,
 			is_bounce = excluded.is_bounce,
+			engaged_ms = excluded.engaged_ms,
 			projection_version = excluded.projection_version
 	`, throughSeq, throughSeq, throughSeq, throughSeq, analyticsProjectionVersion,
 		siteID, sessionID, throughSeq)
@@ -155,6 +155,34 @@ func TestProjectPending_UsesSiteTimezoneForDailyMetrics(t *testing.T) {
 	}
 }
 
+func TestProjectPending_CountsAnonymousEventsOnlyInTotals(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	occurredAt := time.Date(2026, 8, 5, 12, 0, 0, 0, time.UTC)
+	insertProjectionEvent(t, repo, "anonymous-1", core.Event{
+		EventName: "$pageview", SiteID: "site-a", Pathname: "/", Timestamp: occurredAt,
+	})
+	insertProjectionEvent(t, repo, "identified-1", core.Event{
+		EventName: "$pageview", SiteID: "site-a", SessionID: "session-a",
+		VisitorID: "visitor-a", Pathname: "/", Timestamp: occurredAt,
+	})
+
+	if count, err := repo.ProjectPending(ctx, 10); err != nil || count != 2 {
+		t.Fatalf("ProjectPending = (%d, %v), want (2, nil)", count, err)
+	}
+	assertDailySiteMetrics(t, repo, "site-a", "2026-08-05", 2, 0)
+	var sessions, visitors int
+	if err := repo.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM sessions WHERE site_id = 'site-a'),
+		       (SELECT COUNT(*) FROM daily_visitors WHERE site_id = 'site-a')
+	`).Scan(&sessions, &visitors); err != nil {
+		t.Fatalf("count projected identities: %v", err)
+	}
+	if sessions != 1 || visitors != 1 {
+		t.Fatalf("projected %d sessions and %d visitors, want only the identified one", sessions, visitors)
+	}
+}
+
 func TestGetSystemStatus_ReportsProjectionLag(t *testing.T) {
 	repo := newTestRepo(t)
 	ctx := context.Background()
@@ -179,6 +207,9 @@ func TestGetSystemStatus_ReportsProjectionLag(t *testing.T) {
 	if status.ProjectionLag != 0 || status.EventLastSeq != status.ProjectionLastSeq {
 		t.Fatalf("unexpected status after projection: %+v", status)
 	}
+	if status.PageCount <= 0 || status.DatabaseBytes <= 0 || status.WALBytes <= 0 {
+		t.Fatalf("unexpected storage sizes: %+v", status)
+	}
 }
 
 func TestProjectPending_RollsBackBatchOnFailure(t *testing.T) {
@@ -319,3 +350,117 @@ func TestProjectPending_ValidatesBatchSize(t *testing.T) {
 		})
 	}
 }
+
+func TestProjectPending_ProjectsEngagementPerPageAndSession(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	base := time.Date(2026, 8, 7, 9, 0, 0, 0, time.UTC)
+	for _, event := range []struct {
+		id     string
+		name   string
+		path   string
+		engage float64
+		scroll float64
+	}{
+		{id: "engage-view-1", name: "$pageview", path: "/guide"},
+		{id: "engage-1", name: "$engagement", path: "/guide", engage: 40000, scroll: 80},
+		{id: "engage-view-2", name: "$pageview", path: "/guide"},
+		{id: "engage-2", name: "$engagement", path: "/guide", engage: 20000, scroll: 30},
+	} {
+		var properties map[string]any
+		if event.name == "$engagement" {
+			properties = map[string]any{"$engaged_ms": event.engage, "$scroll_pct": event.scroll}
+		}
+		insertProjectionEvent(t, repo, event.id, core.Event{
+			EventName: event.name, SiteID: "site-a", SessionID: "session-engaged",
+			VisitorID: "visitor-engaged", Pathname: event.path, Properties: properties,
+			Timestamp: base,
+		})
+		base = base.Add(time.Minute)
+	}
+	if _, err := repo.ProjectPending(ctx, 10); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+
+	var engagements, engagedMS, reached50, reached75 int
+	if err := repo.db.QueryRowContext(ctx, `
+		SELECT engagements, engaged_ms, scroll_50, scroll_75 FROM daily_page_engagement
+		WHERE site_id = 'site-a' AND day = '2026-08-07' AND pathname = '/guide'
+	`).Scan(&engagements, &engagedMS, &reached50, &reached75); err != nil {
+		t.Fatalf("read daily page engagement: %v", err)
+	}
+	if engagements != 2 || engagedMS != 60000 || reached50 != 1 || reached75 != 1 {
+		t.Fatalf("page engagement = (%d, %d, %d, %d), want (2, 60000, 1, 1)",
+			engagements, engagedMS, reached50, reached75)
+	}
+	var sessionEngagedMS, pageviews int
+	if err := repo.db.QueryRowContext(ctx, `
+		SELECT engaged_ms, pageviews FROM sessions
+		WHERE site_id = 'site-a' AND session_id = 'session-engaged'
+	`).Scan(&sessionEngagedMS, &pageviews); err != nil {
+		t.Fatalf("read engaged session: %v", err)
+	}
+	if sessionEngagedMS != 60000 || pageviews != 2 {
+		t.Fatalf("session engagement = %d ms over %d pageviews, want 60000 over 2", sessionEngagedMS, pageviews)
+	}
+
+	for _, window := range [][2]string{{"2026-08-07", "2026-08-07"}, {"", ""}} {
+		pages, err := repo.GetTopPages(ctx, "site-a", window[0], window[1], 10)
+		if err != nil {
+			t.Fatalf("GetTopPages(%v) returned error: %v", window, err)
+		}
+		if len(pages) != 1 || pages[0].Visitors != 1 || pages[0].AvgEngagedMS != 30000 || pages[0].ScrollDepth.Samples != 2 ||
+			pages[0].ScrollDepth.Reached25 != 2 || pages[0].ScrollDepth.Reached100 != 0 {
+			t.Fatalf("GetTopPages(%v) = %+v", window, pages)
+		}
+	}
+	stats, err := repo.GetStats(ctx, "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Pageviews != 2 || stats.Sessions != 1 || stats.AvgEngagedMS != 60000 {
+		t.Fatalf("unexpected engaged stats: %+v", stats)
+	}
+}
+
+func TestGetSessionsTimeSeries_ReportsEngagedTimePerSession(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	base := time.Date(2026, 8, 7, 10, 0, 0, 0, time.UTC)
+	for index, event := range []struct {
+		name, session string
+		engage        float64
+	}{
+		{name: "$pageview", session: "engaged"},
+		{name: "$engagement", session: "engaged", engage: 40000},
+		{name: "$engagement", session: "engaged", engage: 20000},
+		{name: "$pageview", session: "idle"},
+	} {
+		var properties map[string]any
+		if event.name == "$engagement" {
+			properties = map[string]any{"$engaged_ms": event.engage}
+		}
+		insertProjectionEvent(t, repo, fmt.Sprint("session-engagement-", index), core.Event{
+			EventName: event.name, SiteID: "site-a", SessionID: event.session, VisitorID: "v",
+			Pathname: "/", Properties: properties, Timestamp: base.Add(time.Duration(index) * time.Minute),
+		})
+	}
+
+	check := func(from, to string) {
+		t.Helper()
+		series, err := repo.GetSessionsTimeSeries(ctx, "site-a", from, to)
+		if err != nil {
+			t.Fatalf("GetSessionsTimeSeries(%s, %s) returned error: %v", from, to, err)
+		}
+		if len(series) != 1 || series[0].Sessions != 2 || series[0].AvgEngagedMS != 30000 {
+			t.Fatalf("GetSessionsTimeSeries(%s, %s) = %+v, want 2 sessions averaging 30000 ms", from, to, series)
+		}
+	}
+	// Unprojected events and timed windows are read from raw events.
+	check("2026-08-07", "2026-08-07")
+	if _, err := repo.ProjectPending(ctx, 10); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+	check("2026-08-07", "2026-08-07")
+	check("2026-08-07T00:00:00Z", "2026-08-07T23:59:59Z")
+}
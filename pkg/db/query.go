@@ -12,12 +12,34 @@ import (
 	"github.com/VatsalP117/iris/pkg/core"
 )
 
+// A sampled event stands for 1 / sample_rate events. weightedCount sums that
+// weight over the rows of a group, and weightedIf over the rows matching
+// cond. scaledDistinct estimates how many distinct values expr had before
+// sampling: the sampled distinct count times the mean weight of the rows with
+// a value, which is exact while the rate is constant.
+const weightedCount = "CAST(ROUND(SUM(1.0 / sample_rate)) AS INTEGER)"
+
+func weightedIf(cond string) string {
+	return "CAST(ROUND(COALESCE(SUM(CASE WHEN " + cond + " THEN 1.0 / sample_rate END), 0)) AS INTEGER)"
+}
+
+func scaledDistinct(expr string) string {
+	return "CAST(ROUND(COUNT(DISTINCT " + expr + ") * COALESCE(AVG(CASE WHEN " + expr +
+		" IS NOT NULL THEN 1.0 / sample_rate END), 1)) AS INTEGER)"
+}
+
+// roundedSum sums a projected column, whose sampled rows hold fractional
+// weights.
+func roundedSum(column string) string {
+	return "CAST(ROUND(SUM(" + column + ")) AS INTEGER)"
+}
+
 func (r *SqliteRepository) analyticsWindow(
 	ctx context.Context,
 	siteID, from, to string,
 ) (string, []any, error) {
 	location := time.UTC
-	if isDateOnly(from) || isDateOnly(to) {
+	if core.IsDateOnly(from) || core.IsDateOnly(to) {
 		var timezone string
 		if err := r.db.QueryRowContext(ctx, `
 			SELECT COALESCE((SELECT NULLIF(timezone, '') FROM sites WHERE id = ?), 'UTC')
@@ -34,7 +56,7 @@ func (r *SqliteRepository) analyticsWindow(
 	clause := ""
 	args := []any{}
 	if from != "" {
-		value, err := parseAnalyticsTime(from, false, location)
+		value, err := core.ParseAnalyticsTime(from, false, location)
 		if err != nil {
 			return "", nil, fmt.Errorf("parse from time: %w", err)
 		}
@@ -42,7 +64,7 @@ func (r *SqliteRepository) analyticsWindow(
 		args = append(args, value.UnixMicro())
 	}
 	if to != "" {
-		value, err := parseAnalyticsTime(to, true, location)
+		value, err := core.ParseAnalyticsTime(to, true, location)
 		if err != nil {
 			return "", nil, fmt.Errorf("parse to time: %w", err)
 		}
@@ -52,36 +74,11 @@ func (r *SqliteRepository) analyticsWindow(
 	return clause, args, nil
 }
 
-func isDateOnly(value string) bool {
-	return len(value) == len("2006-01-02")
-}
-
-func parseAnalyticsTime(value string, endOfDay bool, location *time.Location) (time.Time, error) {
-	if isDateOnly(value) {
-		parsed, err := time.ParseInLocation("2006-01-02", value, location)
-		if err != nil {
-			return time.Time{}, err
-		}
-		if endOfDay {
-			return parsed.AddDate(0, 0, 1).Add(-time.Microsecond), nil
-		}
-		return parsed, nil
-	}
-
-	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
-		parsed, err := time.ParseInLocation(layout, value, time.UTC)
-		if err == nil {
-			return parsed.UTC(), nil
-		}
-	}
-	return time.Time{}, fmt.Errorf("unsupported time %q", value)
-}
-
 func (r *SqliteRepository) projectionDayWindow(
 	ctx context.Context,
 	from, to string,
 ) (string, []any, bool, error) {
-	if (from != "" && !isDateOnly(from)) || (to != "" && !isDateOnly(to)) {
+	if (from != "" && !core.IsDateOnly(from)) || (to != "" && !core.IsDateOnly(to)) {
 		return "", nil, false, nil
 	}
 	status, err := r.GetSystemStatus(ctx)
@@ -110,82 +107,345 @@ func (r *SqliteRepository) GetStats(ctx context.Context, siteKey, from, to strin
 	if err != nil {
 		return nil, err
 	}
+	// Engaged time counts only toward the sessions it is averaged over: those
+	// with a pageview in the window.
 	query := `
+	WITH windowed AS (
+		SELECT event_name, visitor_id, session_id, properties, sample_rate
+		FROM events
+		WHERE event_name IN ('$pageview', '$engagement')
+		  AND site_id = ?` + timeClause + `
+	),
+	pageview_sessions AS (
+		SELECT DISTINCT session_id FROM windowed
+		WHERE event_name = '$pageview' AND session_id <> ''
+	)
 	SELECT
-		COUNT(*)                                          AS pageviews,
-		COUNT(DISTINCT visitor_id)                        AS unique_visitors,
-		COUNT(DISTINCT session_id)                        AS sessions
-	FROM events
-	WHERE event_name = '$pageview'
-	  AND site_id = ?` + timeClause + `
+		` + weightedIf("event_name = '$pageview'") + ` AS pageviews,
+		` + scaledDistinct("CASE WHEN event_name = '$pageview' THEN NULLIF(visitor_id, '') END") + ` AS unique_visitors,
+		` + scaledDistinct("CASE WHEN event_name = '$pageview' THEN NULLIF(session_id, '') END") + ` AS sessions,
+		CAST(ROUND(SUM(CASE WHEN event_name = '$engagement'
+			AND session_id IN (SELECT session_id FROM pageview_sessions)
+			THEN COALESCE(CAST(json_extract(properties, '$.$engaged_ms') AS INTEGER), 0) / sample_rate
+			ELSE 0 END)) AS INTEGER)                                       AS engaged_ms
+	FROM windowed
 	`
 	args := append([]any{siteKey}, timeArgs...)
 	row := r.db.QueryRowContext(ctx, query, args...)
 
 	var res core.StatsResult
-	if err := row.Scan(&res.Pageviews, &res.UniqueVisitors, &res.Sessions); err != nil {
+	var pageviews, engagedMS sql.NullInt64
+	if err := row.Scan(&pageviews, &res.UniqueVisitors, &res.Sessions, &engagedMS); err != nil {
 		return nil, err
 	}
+	res.Pageviews = int(pageviews.Int64)
+	if res.Sessions > 0 {
+		res.AvgEngagedMS = math.Round(float64(engagedMS.Int64) / float64(res.Sessions))
+	}
 	return &res, nil
 }
 
+// GetSampling reports the share of the window's events that were stored and
+// the 95% margin of error of counts scaled up from them. Each sampled event
+// is treated as kept independently with probability sample_rate, so the
+// variance of a scaled count is the sum of (1 - p) / p² over kept events.
+func (r *SqliteRepository) GetSampling(ctx context.Context, siteKey, from, to string) (*core.Sampling, error) {
+	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+	if err != nil {
+		return nil, err
+	}
+	args := append([]any{siteKey}, timeArgs...)
+	var sampled int64
+	var sampledWeight, variance sql.NullFloat64
+	if err := r.db.QueryRowContext(ctx, `
+	SELECT COUNT(*), SUM(1.0 / sample_rate), SUM((1.0 - sample_rate) / (sample_rate * sample_rate))
+	FROM events
+	WHERE sample_rate < 1
+	  AND site_id = ?`+timeClause+`
+	`, args...).Scan(&sampled, &sampledWeight, &variance); err != nil {
+		return nil, err
+	}
+	if sampled == 0 {
+		return nil, nil
+	}
+	var stored int64
+	if err := r.db.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM events
+	WHERE site_id = ?`+timeClause+`
+	`, args...).Scan(&stored); err != nil {
+		return nil, err
+	}
+	estimated := float64(stored-sampled) + sampledWeight.Float64
+	return &core.Sampling{
+		Rate:        math.Round(float64(stored)/estimated*10000) / 10000,
+		ErrorMargin: math.Round(1.96*math.Sqrt(variance.Float64)/estimated*10000) / 10000,
+	}, nil
+}
+
 func (r *SqliteRepository) GetTopPages(ctx context.Context, siteKey, from, to string, limit int) ([]core.PageStat, error) {
+	return r.topPages(ctx, siteKey, "", from, to, limit)
+}
+
+// GetContentGroupPages returns the top pages within one content group. Daily
+// projections are not grouped, so these reads always use raw events.
+func (r *SqliteRepository) GetContentGroupPages(
+	ctx context.Context,
+	siteKey, group, from, to string,
+	limit int,
+) ([]core.PageStat, error) {
+	return r.topPages(ctx, siteKey, group, from, to, limit)
+}
+
+func (r *SqliteRepository) topPages(
+	ctx context.Context,
+	siteKey, group, from, to string,
+	limit int,
+) ([]core.PageStat, error) {
+	var results []core.PageStat
 	if dayClause, dayArgs, ok, err := r.projectionDayWindow(ctx, from, to); err != nil {
 		return nil, err
-	} else if ok {
+	} else if ok && group == "" {
 		query := `
-			SELECT pathname, SUM(pageviews)
+			SELECT pathname, ` + roundedSum("pageviews") + ` AS pageviews, 0 AS visitors
 			FROM daily_page_metrics
 			WHERE site_id = ?` + dayClause + `
 			GROUP BY pathname
-			ORDER BY SUM(pageviews) DESC, pathname ASC
+			ORDER BY pageviews DESC, pathname ASC
 			LIMIT ?
 		`
 		args := append([]any{siteKey}, dayArgs...)
 		args = append(args, limit)
-		rows, queryErr := r.db.QueryContext(ctx, query, args...)
-		if queryErr != nil {
-			return nil, queryErr
-		}
-		defer rows.Close()
-		var results []core.PageStat
-		for rows.Next() {
-			var result core.PageStat
-			if err := rows.Scan(&result.URL, &result.Pageviews); err != nil {
-				return nil, err
-			}
-			results = append(results, result)
+		results, err = r.scanPageStats(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.addPageVisitors(ctx, siteKey, from, to, results); err != nil {
+			return nil, err
+		}
+	} else {
+		timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+		if err != nil {
+			return nil, err
+		}
+		groupClause, groupArgs := contentGroupClause(group)
+		query := `
+		SELECT pathname, ` + weightedCount + ` AS pageviews,
+		       ` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS visitors
+		FROM events
+		WHERE event_name = '$pageview'
+		  AND site_id = ?` + groupClause + timeClause + `
+		GROUP BY pathname
+		ORDER BY pageviews DESC
+		LIMIT ?
+		`
+		args := append([]any{siteKey}, groupArgs...)
+		args = append(args, timeArgs...)
+		args = append(args, limit)
+		results, err = r.scanPageStats(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	engagement, err := r.pageEngagement(ctx, siteKey, group, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		totals, ok := engagement[results[i].URL]
+		if !ok {
+			continue
+		}
+		results[i].ScrollDepth = totals.depth
+		if totals.depth.Samples > 0 {
+			results[i].AvgEngagedMS = math.Round(float64(totals.engagedMS) / float64(totals.depth.Samples))
+		}
+	}
+	return results, nil
+}
+
+func (r *SqliteRepository) scanPageStats(ctx context.Context, query string, args []any) ([]core.PageStat, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []core.PageStat
+	for rows.Next() {
+		var s core.PageStat
+		if err := rows.Scan(&s.URL, &s.Pageviews, &s.Visitors); err != nil {
+			return nil, err
+		}
+		results = append(results, s)
+	}
+	return results, rows.Err()
+}
+
+// addPageVisitors fills in the distinct visitors of pages read from the daily
+// projection, which keeps no visitor sets per page, from the raw pageviews of
+// just those pages.
+func (r *SqliteRepository) addPageVisitors(ctx context.Context, siteKey, from, to string, pages []core.PageStat) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+	if err != nil {
+		return err
+	}
+	args := []any{siteKey}
+	for _, page := range pages {
+		args = append(args, page.URL)
+	}
+	args = append(args, timeArgs...)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT pathname, `+scaledDistinct("NULLIF(visitor_id, '')")+`
+		FROM events
+		WHERE event_name = '$pageview'
+		  AND site_id = ?
+		  AND pathname IN (?`+strings.Repeat(", ?", len(pages)-1)+`)`+timeClause+`
+		GROUP BY pathname
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	visitors := map[string]int{}
+	for rows.Next() {
+		var pathname string
+		var count int
+		if err := rows.Scan(&pathname, &count); err != nil {
+			return err
+		}
+		visitors[pathname] = count
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range pages {
+		pages[i].Visitors = visitors[pages[i].URL]
+	}
+	return nil
+}
+
+type pageEngagementTotals struct {
+	engagedMS int64
+	depth     core.ScrollDepth
+}
+
+// pageEngagement sums $engagement reports per pathname, preferring the daily
+// projection when the window is whole days and the projector has caught up.
+func (r *SqliteRepository) pageEngagement(
+	ctx context.Context,
+	siteKey, group, from, to string,
+) (map[string]pageEngagementTotals, error) {
+	var query string
+	var args []any
+	if dayClause, dayArgs, ok, err := r.projectionDayWindow(ctx, from, to); err != nil {
+		return nil, err
+	} else if ok && group == "" {
+		query = `
+			SELECT pathname, ` + roundedSum("engagements") + `, ` + roundedSum("engaged_ms") + `,
+			       ` + roundedSum("scroll_25") + `, ` + roundedSum("scroll_50") + `,
+			       ` + roundedSum("scroll_75") + `, ` + roundedSum("scroll_100") + `
+			FROM daily_page_engagement
+			WHERE site_id = ?` + dayClause + `
+			GROUP BY pathname
+		`
+		args = append([]any{siteKey}, dayArgs...)
+	} else {
+		timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+		if err != nil {
+			return nil, err
 		}
-		return results, rows.Err()
+		groupClause, groupArgs := contentGroupClause(group)
+		query = `
+		SELECT
+			pathname,
+			` + weightedCount + `,
+			CAST(ROUND(SUM(engaged_ms / sample_rate)) AS INTEGER),
+			` + weightedIf("scroll_pct >= 25") + `,
+			` + weightedIf("scroll_pct >= 50") + `,
+			` + weightedIf("scroll_pct >= 75") + `,
+			` + weightedIf("scroll_pct >= 100") + `
+		FROM (
+			SELECT
+				pathname,
+				sample_rate,
+				COALESCE(CAST(json_extract(properties, '$.$engaged_ms') AS INTEGER), 0) AS engaged_ms,
+				COALESCE(CAST(json_extract(properties, '$.$scroll_pct') AS INTEGER), 0) AS scroll_pct
+			FROM events
+			WHERE event_name = '$engagement'
+			  AND site_id = ?` + groupClause + timeClause + `
+		)
+		GROUP BY pathname
+		`
+		args = append([]any{siteKey}, groupArgs...)
+		args = append(args, timeArgs...)
 	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := map[string]pageEngagementTotals{}
+	for rows.Next() {
+		var pathname string
+		var totals pageEngagementTotals
+		if err := rows.Scan(
+			&pathname,
+			&totals.depth.Samples,
+			&totals.engagedMS,
+			&totals.depth.Reached25,
+			&totals.depth.Reached50,
+			&totals.depth.Reached75,
+			&totals.depth.Reached100,
+		); err != nil {
+			return nil, err
+		}
+		results[pathname] = totals
+	}
+	return results, rows.Err()
+}
+
+func contentGroupClause(group string) (string, []any) {
+	if group == "" {
+		return "", nil
+	}
+	return "\n\t  AND content_group = ?", []any{group}
+}
+
+// GetContentGroups reports pageviews and visitors per named content group.
+func (r *SqliteRepository) GetContentGroups(ctx context.Context, siteKey, from, to string) ([]core.ContentGroupStat, error) {
 	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
 	if err != nil {
 		return nil, err
 	}
 	query := `
-	SELECT pathname, COUNT(*) AS pageviews
+	SELECT content_group, ` + weightedCount + ` AS pageviews, ` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS visitors
 	FROM events
 	WHERE event_name = '$pageview'
+	  AND content_group != ''
 	  AND site_id = ?` + timeClause + `
-	GROUP BY pathname
-	ORDER BY pageviews DESC
-	LIMIT ?
+	GROUP BY content_group
+	ORDER BY pageviews DESC, content_group ASC
 	`
 	args := append([]any{siteKey}, timeArgs...)
-	args = append(args, limit)
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var results []core.PageStat
+	results := []core.ContentGroupStat{}
 	for rows.Next() {
-		var s core.PageStat
-		if err := rows.Scan(&s.URL, &s.Pageviews); err != nil {
+		var result core.ContentGroupStat
+		if err := rows.Scan(&result.Group, &result.Pageviews, &result.Visitors); err != nil {
 			return nil, err
 		}
-		results = append(results, s)
+		results = append(results, result)
 	}
 	return results, rows.Err()
 }
@@ -199,7 +459,7 @@ func (r *SqliteRepository) GetTopReferrers(ctx context.Context, siteKey, from, t
 		limit = -1
 	}
 	query := `
-	SELECT referrer_host, COUNT(DISTINCT NULLIF(visitor_id, '')) AS visitors
+	SELECT referrer_host, ` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS visitors
 	FROM events
 	WHERE event_name = '$pageview'
 	  AND site_id = ?
@@ -293,7 +553,8 @@ func (r *SqliteRepository) GetVitalDistributions(ctx context.Context, siteKey, f
 	query := `
 	SELECT
 		json_extract(properties, '$.$name') AS name,
-		CAST(json_extract(properties, '$.$val') AS REAL) AS value
+		CAST(json_extract(properties, '$.$val') AS REAL) AS value,
+		1.0 / sample_rate
 	FROM events
 	WHERE event_name = '$web_vital'
 	  AND site_id = ?` + timeClause + `
@@ -305,30 +566,32 @@ func (r *SqliteRepository) GetVitalDistributions(ctx context.Context, siteKey, f
 	}
 	defer rows.Close()
 
-	byName := map[string]*core.VitalDistribution{}
+	// Weighted totals per metric: total, good, needs improvement, poor.
+	byName := map[string]*[4]float64{}
 	for rows.Next() {
 		var name sql.NullString
 		var value sql.NullFloat64
-		if err := rows.Scan(&name, &value); err != nil {
+		var weight float64
+		if err := rows.Scan(&name, &value, &weight); err != nil {
 			return nil, err
 		}
 		if !name.Valid || !value.Valid || vitalThresholds[name.String] == [2]float64{} {
 			continue
 		}
 
-		distribution := byName[name.String]
-		if distribution == nil {
-			distribution = &core.VitalDistribution{Name: name.String}
-			byName[name.String] = distribution
+		totals := byName[name.String]
+		if totals == nil {
+			totals = &[4]float64{}
+			byName[name.String] = totals
 		}
-		distribution.Total++
+		totals[0] += weight
 		switch classifyVital(name.String, value.Float64) {
 		case "good":
-			distribution.Good++
+			totals[1] += weight
 		case "needs-improvement":
-			distribution.NeedsImprovement++
+			totals[2] += weight
 		case "poor":
-			distribution.Poor++
+			totals[3] += weight
 		}
 	}
 	if err := rows.Err(); err != nil {
@@ -337,8 +600,14 @@ func (r *SqliteRepository) GetVitalDistributions(ctx context.Context, siteKey, f
 
 	results := make([]core.VitalDistribution, 0, len(byName))
 	for _, name := range []string{"LCP", "INP", "CLS"} {
-		if distribution := byName[name]; distribution != nil {
-			results = append(results, *distribution)
+		if totals := byName[name]; totals != nil {
+			results = append(results, core.VitalDistribution{
+				Name:             name,
+				Total:            int(math.Round(totals[0])),
+				Good:             int(math.Round(totals[1])),
+				NeedsImprovement: int(math.Round(totals[2])),
+				Poor:             int(math.Round(totals[3])),
+			})
 		}
 	}
 	return results, nil
@@ -389,7 +658,7 @@ func (r *SqliteRepository) GetPagePerformance(ctx context.Context, siteKey, from
 	rows.Close()
 
 	trafficQuery := `
-	SELECT pathname, COUNT(*) AS pageviews
+	SELECT pathname, ` + weightedCount + ` AS pageviews
 	FROM events
 	WHERE event_name = '$pageview'
 	  AND site_id = ?` + timeClause + `
@@ -498,9 +767,9 @@ func (r *SqliteRepository) GetCustomEvents(ctx context.Context, siteKey, from, t
 	}
 	summaryQuery := `
 	SELECT
-		COUNT(*) AS total_events,
-		COUNT(DISTINCT NULLIF(visitor_id, '')) AS unique_users,
-		COUNT(DISTINCT NULLIF(session_id, '')) AS event_sessions
+		COALESCE(` + weightedCount + `, 0) AS total_events,
+		` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS unique_users,
+		` + scaledDistinct("NULLIF(session_id, '')") + ` AS event_sessions
 	FROM events
 	WHERE event_name != ''
 	  AND event_name NOT LIKE '$%'
@@ -526,8 +795,8 @@ func (r *SqliteRepository) GetCustomEvents(ctx context.Context, siteKey, from, t
 	eventsQuery := `
 	SELECT
 		event_name,
-		COUNT(*) AS total_count,
-		COUNT(DISTINCT NULLIF(visitor_id, '')) AS unique_users
+		` + weightedCount + ` AS total_count,
+		` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS unique_users
 	FROM events
 	WHERE event_name != ''
 	  AND event_name NOT LIKE '$%'
@@ -567,7 +836,7 @@ func (r *SqliteRepository) GetCustomEventTimeSeries(
 	query := `
 	SELECT
 		local_day AS day,
-		COUNT(*) AS count
+		` + weightedCount + ` AS count
 	FROM events
 	WHERE event_name = ?
 	  AND event_name NOT LIKE '$%'
@@ -597,7 +866,7 @@ func (r *SqliteRepository) GetPageviewsTimeSeries(ctx context.Context, siteKey,
 	if dayClause, dayArgs, ok, err := r.projectionDayWindow(ctx, from, to); err != nil {
 		return nil, err
 	} else if ok {
-		return r.projectedTimeSeries(ctx, "daily_site_metrics", "SUM(pageviews)", siteKey, dayClause, dayArgs)
+		return r.projectedTimeSeries(ctx, "daily_site_metrics", roundedSum("pageviews"), siteKey, dayClause, dayArgs)
 	}
 	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
 	if err != nil {
@@ -606,7 +875,7 @@ func (r *SqliteRepository) GetPageviewsTimeSeries(ctx context.Context, siteKey,
 	query := `
 	SELECT
 		local_day AS day,
-		COUNT(*)                        AS pageviews
+		` + weightedCount + ` AS pageviews
 	FROM events
 	WHERE event_name = '$pageview'
 	  AND site_id = ?` + timeClause + `
@@ -635,7 +904,7 @@ func (r *SqliteRepository) GetUniqueVisitorsTimeSeries(ctx context.Context, site
 	if dayClause, dayArgs, ok, err := r.projectionDayWindow(ctx, from, to); err != nil {
 		return nil, err
 	} else if ok {
-		return r.projectedTimeSeries(ctx, "daily_visitors", "COUNT(*)", siteKey, dayClause, dayArgs)
+		return r.projectedTimeSeries(ctx, "daily_visitors", roundedSum("weight"), siteKey, dayClause, dayArgs)
 	}
 	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
 	if err != nil {
@@ -644,7 +913,7 @@ func (r *SqliteRepository) GetUniqueVisitorsTimeSeries(ctx context.Context, site
 	query := `
 	SELECT
 		local_day AS day,
-		COUNT(DISTINCT visitor_id)      AS unique_visitors
+		` + scaledDistinct("NULLIF(visitor_id, '')") + ` AS unique_visitors
 	FROM events
 	WHERE event_name = '$pageview'
 	  AND site_id = ?` + timeClause + `
@@ -673,7 +942,18 @@ func (r *SqliteRepository) GetSessionsTimeSeries(ctx context.Context, siteKey, f
 	if dayClause, dayArgs, ok, err := r.projectionDayWindow(ctx, from, to); err != nil {
 		return nil, err
 	} else if ok {
-		return r.projectedTimeSeries(ctx, "daily_sessions", "COUNT(*)", siteKey, dayClause, dayArgs)
+		results, err := r.projectedTimeSeries(ctx, "daily_sessions", roundedSum("weight"), siteKey, dayClause, dayArgs)
+		if err != nil {
+			return nil, err
+		}
+		engaged, err := r.dailySessionEngagement(ctx, `
+		SELECT d.day, SUM(s.engaged_ms * d.weight) / SUM(d.weight)
+		FROM daily_sessions d
+		JOIN sessions s ON s.site_id = d.site_id AND s.session_id = d.session_id
+		WHERE d.site_id = ?`+dayClause+`
+		GROUP BY d.day
+		`, append([]any{siteKey}, dayArgs...))
+		return withSessionEngagement(results, engaged), err
 	}
 	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
 	if err != nil {
@@ -682,7 +962,7 @@ func (r *SqliteRepository) GetSessionsTimeSeries(ctx context.Context, siteKey, f
 	query := `
 	SELECT
 		local_day AS day,
-		COUNT(DISTINCT session_id)       AS sessions
+		` + scaledDistinct("NULLIF(session_id, '')") + ` AS sessions
 	FROM events
 	WHERE event_name = '$pageview'
 	  AND site_id = ?` + timeClause + `
@@ -704,7 +984,59 @@ func (r *SqliteRepository) GetSessionsTimeSeries(ctx context.Context, siteKey, f
 		}
 		results = append(results, b)
 	}
-	return results, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Like the sessions projection, a session's engaged time includes
+	// engagement outside the window.
+	engaged, err := r.dailySessionEngagement(ctx, `
+	WITH day_sessions AS (
+		SELECT local_day AS day, session_id, MIN(sample_rate) AS sample_rate
+		FROM events
+		WHERE event_name = '$pageview' AND session_id != ''
+		  AND site_id = ?`+timeClause+`
+		GROUP BY day, session_id
+	), engaged AS (
+		SELECT session_id,
+		       SUM(COALESCE(CAST(json_extract(properties, '$.$engaged_ms') AS INTEGER), 0)) AS engaged_ms
+		FROM events
+		WHERE event_name = '$engagement' AND site_id = ?
+		  AND session_id IN (SELECT session_id FROM day_sessions)
+		GROUP BY session_id
+	)
+	SELECT d.day, SUM(COALESCE(g.engaged_ms, 0) / d.sample_rate) / SUM(1.0 / d.sample_rate)
+	FROM day_sessions d
+	LEFT JOIN engaged g ON g.session_id = d.session_id
+	GROUP BY d.day
+	`, append(args, siteKey))
+	return withSessionEngagement(results, engaged), err
+}
+
+// dailySessionEngagement runs query, which returns a day and the mean engaged
+// milliseconds of its sessions, and maps each day to the rounded mean.
+func (r *SqliteRepository) dailySessionEngagement(ctx context.Context, query string, args []any) (map[string]float64, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	engaged := map[string]float64{}
+	for rows.Next() {
+		var day string
+		var mean sql.NullFloat64
+		if err := rows.Scan(&day, &mean); err != nil {
+			return nil, err
+		}
+		engaged[day] = math.Round(mean.Float64)
+	}
+	return engaged, rows.Err()
+}
+
+func withSessionEngagement(buckets []core.TimeSeriesBucket, engaged map[string]float64) []core.TimeSeriesBucket {
+	for i := range buckets {
+		buckets[i].AvgEngagedMS = engaged[buckets[i].Date]
+	}
+	return buckets
 }
 
 func (r *SqliteRepository) projectedTimeSeries(
@@ -752,7 +1084,7 @@ func (r *SqliteRepository) GetDevices(ctx context.Context, siteKey, from, to str
 			WHEN screen_width < 1024 THEN 'Tablet'
 			ELSE 'Desktop'
 		END AS device,
-		COUNT(*) AS count
+		` + weightedCount + ` AS count
 	FROM events
 	WHERE event_name = '$pageview'
 	  AND site_id = ?` + timeClause + `
@@ -785,11 +1117,24 @@ func (r *SqliteRepository) GetSites(ctx context.Context) ([]core.SiteStat, error
 		COALESCE(MAX(CASE WHEN d.is_primary = 1 THEN d.hostname END), MIN(d.hostname), ''),
 		COALESCE(GROUP_CONCAT(d.hostname), ''),
 		s.timezone,
-		s.retention_days
+		s.retention_days,
+		s.search_param,
+		s.path_rules,
+		s.content_groups,
+		s.server_identity,
+		s.privacy_signals,
+		s.default_consent,
+		s.min_breakdown_count,
+		s.privacy_epsilon,
+		s.monthly_event_quota,
+		s.quota_mode,
+		s.quota_sample_rate,
+		s.sample_rate,
+		s.event_sample_rates
 	FROM sites s
 	LEFT JOIN site_domains d ON d.site_id = s.id
 	WHERE s.disabled_at_us IS NULL
-	GROUP BY s.id, s.name, s.timezone, s.retention_days
+	GROUP BY s.id
 	ORDER BY s.id ASC
 	`
 	rows, err := r.db.QueryContext(ctx, query)
@@ -801,12 +1146,22 @@ func (r *SqliteRepository) GetSites(ctx context.Context) ([]core.SiteStat, error
 	results := []core.SiteStat{}
 	for rows.Next() {
 		var s core.SiteStat
-		var domainsCSV string
+		var domainsCSV, pathRules, contentGroups, eventSampleRates string
 		if err := rows.Scan(
-			&s.SiteID, &s.Name, &s.Domain, &domainsCSV, &s.Timezone, &s.RetentionDays,
+			&s.SiteID, &s.Name, &s.Domain, &domainsCSV, &s.Timezone, &s.RetentionDays, &s.SearchParam,
+			&pathRules, &contentGroups, &s.ServerIdentity, &s.PrivacySignals, &s.DefaultConsent,
+			&s.MinBreakdownCount, &s.PrivacyEpsilon,
+			&s.MonthlyEventQuota, &s.QuotaMode, &s.QuotaSampleRate,
+			&s.SampleRate, &eventSampleRates,
 		); err != nil {
 			return nil, err
 		}
+		if err := decodeSiteSettings(pathRules, contentGroups, &s.PathRules, &s.ContentGroups); err != nil {
+			return nil, err
+		}
+		if err := decodeSampleRates(eventSampleRates, &s.SampleRate, &s.EventSampleRates); err != nil {
+			return nil, err
+		}
 		s.Domains = splitDomains(domainsCSV)
 		results = append(results, s)
 	}
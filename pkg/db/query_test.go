@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/url"
 	"path/filepath"
 	"reflect"
@@ -315,6 +316,31 @@ func TestCustomEventsAndPerformanceAnalytics(t *testing.T) {
 	}
 }
 
+func TestGetStatsAveragesEngagementOverCountedSessionsOnly(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	at := time.Date(2026, 8, 7, 10, 0, 0, 0, time.UTC)
+	for _, event := range []core.Event{
+		{EventName: "$pageview", SiteID: "site-a", SessionID: "s1", VisitorID: "v1", Timestamp: at},
+		{EventName: "$engagement", SiteID: "site-a", SessionID: "s1", VisitorID: "v1", Timestamp: at,
+			Properties: map[string]any{"$engaged_ms": 20000}},
+		{EventName: "$engagement", SiteID: "site-a", VisitorID: "v2", Timestamp: at,
+			Properties: map[string]any{"$engaged_ms": 90000}},
+		{EventName: "$engagement", SiteID: "site-a", SessionID: "s-no-pageview", VisitorID: "v3", Timestamp: at,
+			Properties: map[string]any{"$engaged_ms": 90000}},
+	} {
+		insertEvent(t, repo, event)
+	}
+
+	stats, err := repo.GetStats(ctx, "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	if stats.Sessions != 1 || stats.AvgEngagedMS != 20000 {
+		t.Fatalf("sessions = %d, average engaged = %v ms; want 1 and 20000", stats.Sessions, stats.AvgEngagedMS)
+	}
+}
+
 func TestGetStatsSupportsDateTimeAndDateWindows(t *testing.T) {
 	repo := newTestRepo(t)
 
@@ -419,6 +445,79 @@ func TestGetStatsUsesSiteTimezoneForDateOnlyWindow(t *testing.T) {
 	}
 }
 
+func TestQueriesScaleSampledEvents(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	day := time.Date(2026, 8, 6, 10, 0, 0, 0, time.UTC)
+	// Two sessions kept at a quarter stand for eight; one unsampled session
+	// stands for itself.
+	for index, rate := range []float64{0.25, 0.25, 1} {
+		for page := 0; page < 2; page++ {
+			insertEvent(t, repo, core.Event{
+				EventName:  "$pageview",
+				URL:        fmt.Sprintf("https://example.com/p%d", page),
+				SiteID:     "site-a",
+				SessionID:  fmt.Sprintf("s%d", index),
+				VisitorID:  fmt.Sprintf("v%d", index),
+				Timestamp:  day.Add(time.Duration(index*2+page) * time.Minute),
+				SampleRate: rate,
+			})
+		}
+	}
+	insertEvent(t, repo, core.Event{
+		EventName: "signup", SiteID: "site-a", SessionID: "s0", VisitorID: "v0",
+		Timestamp: day.Add(10 * time.Minute), SampleRate: 0.25,
+	})
+
+	stats, err := repo.GetStats(ctx, "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetStats returned error: %v", err)
+	}
+	// Four sampled pageviews weigh 16; visitors and sessions scale by the
+	// mean pageview weight of 3.
+	if stats.Pageviews != 18 || stats.UniqueVisitors != 9 || stats.Sessions != 9 {
+		t.Fatalf("stats = %+v, want 18 pageviews and 9 visitors and sessions", stats)
+	}
+	custom, err := repo.GetCustomEvents(ctx, "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetCustomEvents returned error: %v", err)
+	}
+	if custom.Summary.TotalEvents != 4 || custom.Events[0].TotalCount != 4 {
+		t.Fatalf("custom events = %+v", custom)
+	}
+
+	if _, err := repo.ProjectPending(ctx, 100); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+	pages, err := repo.GetTopPages(ctx, "site-a", "2026-08-06", "2026-08-06", 10)
+	if err != nil {
+		t.Fatalf("GetTopPages returned error: %v", err)
+	}
+	if len(pages) != 2 || pages[0].Pageviews != 9 || pages[1].Pageviews != 9 {
+		t.Fatalf("projected pages = %+v, want 9 pageviews each", pages)
+	}
+	visitors, err := repo.GetUniqueVisitorsTimeSeries(ctx, "site-a", "2026-08-06", "2026-08-06")
+	if err != nil {
+		t.Fatalf("GetUniqueVisitorsTimeSeries returned error: %v", err)
+	}
+	if len(visitors) != 1 || visitors[0].UniqueVisitors != 9 {
+		t.Fatalf("projected visitors = %+v, want 9", visitors)
+	}
+
+	sampling, err := repo.GetSampling(ctx, "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetSampling returned error: %v", err)
+	}
+	// 7 stored events stand for 22; each sampled one adds 12 to the variance.
+	wantMargin := math.Round(1.96*math.Sqrt(5*12)/22*10000) / 10000
+	if sampling == nil || sampling.Rate != math.Round(7.0/22*10000)/10000 || sampling.ErrorMargin != wantMargin {
+		t.Fatalf("sampling = %+v, want rate 7/22 and margin %v", sampling, wantMargin)
+	}
+	if sampling, err := repo.GetSampling(ctx, "site-b", "", ""); err != nil || sampling != nil {
+		t.Fatalf("unsampled site sampling = %+v, %v; want nil", sampling, err)
+	}
+}
+
 func newTestRepo(t *testing.T) *SqliteRepository {
 	t.Helper()
 
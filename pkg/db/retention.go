@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
 )
 
 // ApplyRetention removes raw and projected data outside each site's configured
-// retention window. It is safe to run repeatedly.
+// retention window. It is safe to run repeatedly. Each site that loses events
+// gets an audit entry in the same transaction. Pending events are counted
+// into usage first, so deleted events stay billed.
 func (r *SqliteRepository) ApplyRetention(ctx context.Context, now time.Time) (int64, error) {
 	now = now.UTC()
 	rows, err := r.db.QueryContext(ctx, `
@@ -18,6 +22,7 @@ func (r *SqliteRepository) ApplyRetention(ctx context.Context, now time.Time) (i
 	}
 	type policy struct {
 		siteID string
+		days   int
 		cutoff time.Time
 	}
 	var policies []policy
@@ -28,7 +33,7 @@ func (r *SqliteRepository) ApplyRetention(ctx context.Context, now time.Time) (i
 			rows.Close()
 			return 0, err
 		}
-		policies = append(policies, policy{siteID: siteID, cutoff: now.AddDate(0, 0, -days)})
+		policies = append(policies, policy{siteID: siteID, days: days, cutoff: now.AddDate(0, 0, -days)})
 	}
 	if err := rows.Close(); err != nil {
 		return 0, err
@@ -39,6 +44,9 @@ func (r *SqliteRepository) ApplyRetention(ctx context.Context, now time.Time) (i
 		return 0, err
 	}
 	defer tx.Rollback()
+	if err := catchUpUsage(ctx, tx); err != nil {
+		return 0, err
+	}
 
 	var deletedEvents int64
 	for _, item := range policies {
@@ -73,6 +81,20 @@ func (r *SqliteRepository) ApplyRetention(ctx context.Context, now time.Time) (i
 			return 0, err
 		}
 		deletedEvents += count
+		if count > 0 {
+			if err := insertAudit(ctx, tx, &core.AuditEntry{
+				Actor:  core.AuditActorRetention,
+				Action: core.AuditRetentionApply,
+				SiteID: item.siteID,
+				Changes: map[string]core.AuditChange{
+					"retention_days": {After: item.days},
+					"cutoff":         {After: item.cutoff.Format(time.RFC3339)},
+					"deleted_events": {After: count},
+				},
+			}); err != nil {
+				return 0, err
+			}
+		}
 
 		cutoffDay := item.cutoff.Format("2006-01-02")
 		deletions := []struct {
@@ -85,6 +107,7 @@ func (r *SqliteRepository) ApplyRetention(ctx context.Context, now time.Time) (i
 			{"DELETE FROM daily_referrer_visitors WHERE site_id = ? AND day < ?", cutoffDay},
 			{"DELETE FROM daily_visitors WHERE site_id = ? AND day < ?", cutoffDay},
 			{"DELETE FROM daily_sessions WHERE site_id = ? AND day < ?", cutoffDay},
+			{"DELETE FROM daily_page_engagement WHERE site_id = ? AND day < ?", cutoffDay},
 		}
 		for _, deletion := range deletions {
 			if _, err := tx.ExecContext(ctx, deletion.statement, item.siteID, deletion.cutoff); err != nil {
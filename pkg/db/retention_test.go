@@ -37,6 +37,15 @@ func TestApplyRetention_RemovesOnlyExpiredSiteData(t *testing.T) {
 	if deleted != 1 {
 		t.Fatalf("deleted events = %d, want 1", deleted)
 	}
+	audit, err := repo.GetAuditLog(ctx, core.AuditFilter{Action: core.AuditRetentionApply})
+	if err != nil {
+		t.Fatalf("GetAuditLog returned error: %v", err)
+	}
+	if len(audit.Entries) != 1 || audit.Entries[0].SiteID != "short-retention" ||
+		audit.Entries[0].Actor != core.AuditActorRetention ||
+		audit.Entries[0].Changes["deleted_events"].After != float64(1) {
+		t.Fatalf("retention audit entries = %+v, want one for the expired event", audit.Entries)
+	}
 	var remaining string
 	if err := repo.db.QueryRow(`SELECT id FROM events WHERE site_id = 'short-retention'`).Scan(&remaining); err != nil {
 		t.Fatalf("read retained event: %v", err)
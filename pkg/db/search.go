@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// GetSiteSearch reports on-site search terms. Each search pageview is compared
+// with the next pageview in its session, which may fall after the window, to
+// count exits and refinements.
+func (r *SqliteRepository) GetSiteSearch(
+	ctx context.Context,
+	siteKey, from, to string,
+	limit int,
+) (*core.SiteSearchResult, error) {
+	timeClause, timeArgs, err := r.analyticsWindow(ctx, siteKey, from, to)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	WITH ordered AS (
+		SELECT
+			search_term,
+			visitor_id,
+			occurred_at_us,
+			sample_rate,
+			LEAD(seq)         OVER session_order AS next_seq,
+			LEAD(search_term) OVER session_order AS next_term
+		FROM events
+		WHERE event_name = '$pageview'
+		  AND site_id = ?
+		  AND session_id IN (
+			SELECT session_id FROM events
+			WHERE event_name = '$pageview'
+			  AND search_term != ''
+			  AND session_id != ''
+			  AND site_id = ?` + timeClause + `
+		  )
+		WINDOW session_order AS (PARTITION BY session_id ORDER BY occurred_at_us, seq)
+	)
+	SELECT
+		search_term,
+		` + weightedCount + `,
+		` + scaledDistinct("NULLIF(visitor_id, '')") + `,
+		` + weightedIf("next_seq IS NULL") + `,
+		` + weightedIf("next_term != '' AND next_term != search_term") + `
+	FROM ordered
+	WHERE search_term != ''` + timeClause + `
+	GROUP BY search_term
+	`
+	args := append([]any{siteKey, siteKey}, timeArgs...)
+	rows, err := r.db.QueryContext(ctx, query, append(args, timeArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &core.SiteSearchResult{Terms: []core.SearchTermStat{}, ExitTerms: []core.SearchTermStat{}}
+	refinements := 0
+	for rows.Next() {
+		var term core.SearchTermStat
+		if err := rows.Scan(&term.Term, &term.Searches, &term.Visitors, &term.Exits, &term.Refinements); err != nil {
+			return nil, err
+		}
+		result.Searches += term.Searches
+		result.Exits += term.Exits
+		refinements += term.Refinements
+		result.Terms = append(result.Terms, term)
+		if term.Exits > 0 {
+			result.ExitTerms = append(result.ExitTerms, term)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if result.Searches > 0 {
+		result.ExitRate = math.Round((float64(result.Exits)/float64(result.Searches))*1000) / 10
+		result.RefinementRate = math.Round((float64(refinements)/float64(result.Searches))*1000) / 10
+	}
+
+	sort.Slice(result.Terms, func(i, j int) bool {
+		if result.Terms[i].Searches != result.Terms[j].Searches {
+			return result.Terms[i].Searches > result.Terms[j].Searches
+		}
+		return result.Terms[i].Term < result.Terms[j].Term
+	})
+	sort.Slice(result.ExitTerms, func(i, j int) bool {
+		if result.ExitTerms[i].Exits != result.ExitTerms[j].Exits {
+			return result.ExitTerms[i].Exits > result.ExitTerms[j].Exits
+		}
+		return result.ExitTerms[i].Term < result.ExitTerms[j].Term
+	})
+	if limit > 0 && len(result.Terms) > limit {
+		result.Terms = result.Terms[:limit]
+	}
+	if limit > 0 && len(result.ExitTerms) > limit {
+		result.ExitTerms = result.ExitTerms[:limit]
+	}
+	return result, nil
+}
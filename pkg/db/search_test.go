@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestGetSiteSearchReportsTermsExitsAndRefinements(t *testing.T) {
+	repo := newTestRepo(t)
+	start := time.Date(2026, 8, 10, 9, 0, 0, 0, time.UTC)
+	for index, pageview := range []struct {
+		session, term, path string
+	}{
+		// Session 1 refines "api" to "api keys" and then opens a result.
+		{"s1", "api", "/search"},
+		{"s1", "api keys", "/search"},
+		{"s1", "", "/docs/keys"},
+		// Session 2 searches once and leaves.
+		{"s2", "api", "/search"},
+		// Session 3 reloads the same search before leaving.
+		{"s3", "pricing", "/search"},
+		{"s3", "pricing", "/search"},
+	} {
+		insertEvent(t, repo, core.Event{
+			EventName: "$pageview", Domain: "example.com", SiteID: "site-a",
+			URL: "https://example.com" + pageview.path, SearchTerm: pageview.term,
+			SessionID: pageview.session, VisitorID: "v-" + pageview.session,
+			Timestamp: start.Add(time.Duration(index) * time.Minute),
+		})
+	}
+
+	result, err := repo.GetSiteSearch(context.Background(), "site-a", "", "", 10)
+	if err != nil {
+		t.Fatalf("GetSiteSearch returned error: %v", err)
+	}
+	if result.Searches != 5 || result.Exits != 2 || result.ExitRate != 40 || result.RefinementRate != 20 {
+		t.Fatalf("unexpected search summary: %+v", result)
+	}
+	wantTerms := []core.SearchTermStat{
+		{Term: "api", Searches: 2, Visitors: 2, Exits: 1, Refinements: 1},
+		{Term: "pricing", Searches: 2, Visitors: 1, Exits: 1},
+		{Term: "api keys", Searches: 1, Visitors: 1},
+	}
+	if len(result.Terms) != len(wantTerms) {
+		t.Fatalf("terms = %+v, want %+v", result.Terms, wantTerms)
+	}
+	for i := range wantTerms {
+		if result.Terms[i] != wantTerms[i] {
+			t.Fatalf("terms = %+v, want %+v", result.Terms, wantTerms)
+		}
+	}
+	if len(result.ExitTerms) != 2 || result.ExitTerms[0].Term != "api" || result.ExitTerms[1].Term != "pricing" {
+		t.Fatalf("unexpected exit terms: %+v", result.ExitTerms)
+	}
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (r *SqliteRepository) CreateShareLink(ctx context.Context, link *core.ShareLink) error {
+	if link == nil {
+		return fmt.Errorf("share link is required")
+	}
+	link.SiteID = strings.TrimSpace(link.SiteID)
+	link.Name = strings.TrimSpace(link.Name)
+	link.From = strings.TrimSpace(link.From)
+	link.To = strings.TrimSpace(link.To)
+	if link.Name == "" || len(link.Name) > 200 {
+		return fmt.Errorf("share link name must contain between 1 and 200 characters")
+	}
+	if len(link.Password) > 72 {
+		return fmt.Errorf("share link password must be at most 72 bytes")
+	}
+	for _, bound := range []struct {
+		name, value string
+		endOfDay    bool
+	}{{"from", link.From, false}, {"to", link.To, true}} {
+		if bound.value == "" {
+			continue
+		}
+		if _, err := core.ParseAnalyticsTime(bound.value, bound.endOfDay, time.UTC); err != nil {
+			return fmt.Errorf("invalid locked %s time %q", bound.name, bound.value)
+		}
+	}
+	now := time.Now().UTC()
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(now) {
+		return fmt.Errorf("share link expiry must be in the future")
+	}
+	var exists int
+	err := r.db.QueryRowContext(ctx, "SELECT 1 FROM sites WHERE id = ?", link.SiteID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", core.ErrSiteNotFound, link.SiteID)
+	}
+	if err != nil {
+		return err
+	}
+
+	link.PasswordHash = ""
+	if link.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(link.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("hash share link password: %w", err)
+		}
+		link.PasswordHash = string(hash)
+	}
+	link.Password = ""
+	link.PasswordRequired = link.PasswordHash != ""
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("generate share link: %w", err)
+	}
+	link.ID = uuid.NewString()
+	link.Slug = core.ShareSlugPrefix + hex.EncodeToString(secret)
+	link.CreatedAt = now
+	var expiresAt any
+	if link.ExpiresAt != nil {
+		expiry := link.ExpiresAt.UTC()
+		link.ExpiresAt = &expiry
+		expiresAt = expiry.UnixMicro()
+	}
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO share_links (
+			id, site_id, slug_hash, name, password_hash, locked_from, locked_to,
+			expires_at_us, created_at_us
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, link.ID, link.SiteID, hashIngestKey(link.Slug), link.Name, link.PasswordHash,
+		link.From, link.To, expiresAt, link.CreatedAt.UnixMicro()); err != nil {
+		return err
+	}
+	recorded := *link
+	recorded.Slug = ""
+	if err := recordAudit(ctx, tx, link.ID, nil, recorded); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetShareLinks lists the unrevoked links for a site without their slugs,
+// including expired ones so they can be revoked.
+func (r *SqliteRepository) GetShareLinks(ctx context.Context, siteID string) ([]core.ShareLink, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, site_id, name, password_hash, locked_from, locked_to,
+		       expires_at_us, created_at_us
+		FROM share_links
+		WHERE site_id = ? AND revoked_at_us IS NULL
+		ORDER BY created_at_us, id
+	`, siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []core.ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		link.PasswordHash = ""
+		links = append(links, *link)
+	}
+	return links, rows.Err()
+}
+
+func (r *SqliteRepository) LookupShareLink(ctx context.Context, slug string) (*core.ShareLink, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, site_id, name, password_hash, locked_from, locked_to,
+		       expires_at_us, created_at_us
+		FROM share_links
+		WHERE slug_hash = ? AND revoked_at_us IS NULL
+		  AND (expires_at_us IS NULL OR expires_at_us > ?)
+	`, hashIngestKey(strings.TrimSpace(slug)), time.Now().UTC().UnixMicro())
+	link, err := scanShareLink(row)
+	if err == sql.ErrNoRows {
+		return nil, core.ErrShareLinkInvalid
+	}
+	return link, err
+}
+
+func (r *SqliteRepository) RevokeShareLink(ctx context.Context, id string) error {
+	return r.revoke(ctx, id, core.ErrShareLinkInvalid, `
+		UPDATE share_links SET revoked_at_us = ? WHERE id = ? AND revoked_at_us IS NULL
+	`, time.Now().UTC().UnixMicro(), id)
+}
+
+func scanShareLink(row interface{ Scan(...any) error }) (*core.ShareLink, error) {
+	var link core.ShareLink
+	var expiresAt sql.NullInt64
+	var createdAt int64
+	if err := row.Scan(
+		&link.ID, &link.SiteID, &link.Name, &link.PasswordHash, &link.From, &link.To,
+		&expiresAt, &createdAt,
+	); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		expiry := time.UnixMicro(expiresAt.Int64).UTC()
+		link.ExpiresAt = &expiry
+	}
+	link.PasswordRequired = link.PasswordHash != ""
+	link.CreatedAt = time.UnixMicro(createdAt).UTC()
+	return &link, nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestShareLinks_HashesSecretsAndExpires(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	link := core.ShareLink{
+		SiteID: "site-a", Name: "client", Password: "open sesame", From: "2026-07-01", To: "2026-07-31",
+	}
+	if err := repo.CreateShareLink(ctx, &link); err != nil {
+		t.Fatalf("CreateShareLink returned error: %v", err)
+	}
+	if !strings.HasPrefix(link.Slug, core.ShareSlugPrefix) || link.Password != "" || !link.PasswordRequired {
+		t.Fatalf("unexpected created link: %+v", link)
+	}
+	var storedHash, storedPassword string
+	if err := repo.db.QueryRow(
+		"SELECT slug_hash, password_hash FROM share_links WHERE id = ?", link.ID,
+	).Scan(&storedHash, &storedPassword); err != nil {
+		t.Fatalf("read stored link: %v", err)
+	}
+	if storedHash != hashIngestKey(link.Slug) || strings.Contains(storedPassword, "open sesame") {
+		t.Fatalf("stored slug hash %q and password hash %q", storedHash, storedPassword)
+	}
+
+	found, err := repo.LookupShareLink(ctx, link.Slug)
+	if err != nil {
+		t.Fatalf("LookupShareLink returned error: %v", err)
+	}
+	if found.SiteID != "site-a" || found.From != "2026-07-01" || found.PasswordHash == "" || found.Slug != "" {
+		t.Fatalf("unexpected looked-up link: %+v", found)
+	}
+	links, err := repo.GetShareLinks(ctx, "site-a")
+	if err != nil || len(links) != 1 || links[0].PasswordHash != "" {
+		t.Fatalf("GetShareLinks = %+v, %v; want one link without its hash", links, err)
+	}
+
+	if _, err := repo.writer.Exec(
+		"UPDATE share_links SET expires_at_us = ? WHERE id = ?", time.Now().Add(-time.Minute).UnixMicro(), link.ID,
+	); err != nil {
+		t.Fatalf("expire link: %v", err)
+	}
+	if _, err := repo.LookupShareLink(ctx, link.Slug); !errors.Is(err, core.ErrShareLinkInvalid) {
+		t.Fatalf("lookup after expiry error = %v, want ErrShareLinkInvalid", err)
+	}
+	if err := repo.RevokeShareLink(ctx, link.ID); err != nil {
+		t.Fatalf("RevokeShareLink returned error: %v", err)
+	}
+	if err := repo.RevokeShareLink(ctx, link.ID); !errors.Is(err, core.ErrShareLinkInvalid) {
+		t.Fatalf("second revoke error = %v, want ErrShareLinkInvalid", err)
+	}
+}
+
+func TestCreateShareLink_ValidatesRangeExpiryAndSite(t *testing.T) {
+	repo := newTestRepo(t)
+	past := time.Now().Add(-time.Hour)
+	for _, link := range []core.ShareLink{
+		{SiteID: "site-a", Name: ""},
+		{SiteID: "site-a", Name: "bad range", From: "last week"},
+		{SiteID: "site-a", Name: "expired", ExpiresAt: &past},
+		{SiteID: "missing", Name: "no site"},
+	} {
+		if err := repo.CreateShareLink(context.Background(), &link); err == nil {
+			t.Fatalf("CreateShareLink(%+v) returned nil error", link)
+		}
+	}
+}
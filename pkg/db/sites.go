@@ -3,14 +3,37 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/VatsalP117/iris/pkg/core"
 )
 
+// maxBreakdownThreshold bounds a site's minimum breakdown count.
+const maxBreakdownThreshold = 1000
+
+// defaultQuotaSampleRate is the share of sessions kept by a site over a
+// sampling quota when it sets no rate.
+const defaultQuotaSampleRate = 0.1
+
+// CreateSite registers site, or replaces the settings of the site with its ID.
 func (r *SqliteRepository) CreateSite(ctx context.Context, site *core.Site) error {
+	return r.saveSite(ctx, site, true)
+}
+
+// InsertSite registers site and fails with core.ErrSiteExists when a site,
+// enabled or not, already has its ID. The check and insert share one
+// transaction, so two callers cannot both create the same site.
+func (r *SqliteRepository) InsertSite(ctx context.Context, site *core.Site) error {
+	return r.saveSite(ctx, site, false)
+}
+
+func (r *SqliteRepository) saveSite(ctx context.Context, site *core.Site, replace bool) error {
 	if site == nil {
 		return fmt.Errorf("site is required")
 	}
@@ -34,6 +57,86 @@ func (r *SqliteRepository) CreateSite(ctx context.Context, site *core.Site) erro
 		retentionDays = 365
 	}
 
+	searchParam := strings.TrimSpace(site.SearchParam)
+	if len(searchParam) > 64 || strings.ContainsAny(searchParam, "&=#? \t\r\n") {
+		return fmt.Errorf("invalid search parameter %q", searchParam)
+	}
+
+	privacySignals := strings.TrimSpace(site.PrivacySignals)
+	switch privacySignals {
+	case "":
+		privacySignals = core.PrivacySignalsIgnore
+	case core.PrivacySignalsIgnore, core.PrivacySignalsDrop, core.PrivacySignalsAnonymize:
+	default:
+		return fmt.Errorf("invalid privacy signal policy %q", privacySignals)
+	}
+	defaultConsent := strings.TrimSpace(site.DefaultConsent)
+	switch defaultConsent {
+	case "":
+		defaultConsent = core.ConsentFull
+	case core.ConsentFull, core.ConsentAnonymous:
+	default:
+		return fmt.Errorf("invalid default consent %q", defaultConsent)
+	}
+
+	if site.MinBreakdownCount < 0 || site.MinBreakdownCount > maxBreakdownThreshold {
+		return fmt.Errorf("invalid minimum breakdown count %d", site.MinBreakdownCount)
+	}
+	if site.PrivacyEpsilon < 0 || math.IsNaN(site.PrivacyEpsilon) || math.IsInf(site.PrivacyEpsilon, 0) {
+		return fmt.Errorf("invalid privacy epsilon %v", site.PrivacyEpsilon)
+	}
+
+	if site.MonthlyEventQuota < 0 {
+		return fmt.Errorf("invalid monthly event quota %d", site.MonthlyEventQuota)
+	}
+	quotaMode := strings.TrimSpace(site.QuotaMode)
+	switch quotaMode {
+	case "":
+		quotaMode = core.QuotaSoft
+	case core.QuotaSoft, core.QuotaHard, core.QuotaSample:
+	default:
+		return fmt.Errorf("invalid quota mode %q", quotaMode)
+	}
+	quotaSampleRate := site.QuotaSampleRate
+	if quotaSampleRate == 0 {
+		quotaSampleRate = defaultQuotaSampleRate
+	}
+	if !(quotaSampleRate > 0 && quotaSampleRate <= 1) {
+		return fmt.Errorf("invalid quota sample rate %v", site.QuotaSampleRate)
+	}
+
+	sampleRate := site.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+	if !(sampleRate > 0 && sampleRate <= 1) {
+		return fmt.Errorf("invalid sample rate %v", site.SampleRate)
+	}
+	for eventName, rate := range site.EventSampleRates {
+		if strings.TrimSpace(eventName) == "" || !(rate > 0 && rate <= 1) {
+			return fmt.Errorf("invalid sample rate %v for event %q", rate, eventName)
+		}
+	}
+	eventSampleRates, err := json.Marshal(site.EventSampleRates)
+	if err != nil {
+		return fmt.Errorf("encode event sample rates: %w", err)
+	}
+	if site.EventSampleRates == nil {
+		eventSampleRates = []byte("{}")
+	}
+
+	if _, err := core.CompilePathRules(site.PathRules, site.ContentGroups); err != nil {
+		return err
+	}
+	pathRules, err := encodeSiteSetting(site.PathRules)
+	if err != nil {
+		return err
+	}
+	contentGroups, err := encodeSiteSetting(site.ContentGroups)
+	if err != nil {
+		return err
+	}
+
 	domains, err := normalizedDomains(site.Domains)
 	if err != nil {
 		return err
@@ -47,27 +150,58 @@ func (r *SqliteRepository) CreateSite(ctx context.Context, site *core.Site) erro
 		return err
 	}
 	defer tx.Rollback()
-	var existingTimezone string
+	var existingTimezone, existingPathRules, existingContentGroups string
 	var hasEvents int
 	err = tx.QueryRowContext(ctx, `
-		SELECT timezone, EXISTS(SELECT 1 FROM events WHERE site_id = sites.id LIMIT 1)
+		SELECT timezone, path_rules, content_groups,
+		       EXISTS(SELECT 1 FROM events WHERE site_id = sites.id LIMIT 1)
 		FROM sites WHERE id = ?
-	`, siteID).Scan(&existingTimezone, &hasEvents)
+	`, siteID).Scan(&existingTimezone, &existingPathRules, &existingContentGroups, &hasEvents)
 	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
+	if err == nil && !replace {
+		return fmt.Errorf("%w: %s", core.ErrSiteExists, siteID)
+	}
 	if err == nil && hasEvents == 1 && existingTimezone != timezone {
 		return fmt.Errorf("%w: %s uses %s", core.ErrTimezoneImmutable, siteID, existingTimezone)
 	}
+	before, err := auditedSite(ctx, tx, siteID)
+	if err != nil {
+		return err
+	}
 
 	if _, err := tx.ExecContext(ctx, `
-		INSERT INTO sites(id, name, timezone, retention_days, created_at_us)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO sites(
+			id, name, timezone, retention_days, search_param,
+			path_rules, content_groups, server_identity, privacy_signals,
+			default_consent, min_breakdown_count, privacy_epsilon,
+			monthly_event_quota, quota_mode, quota_sample_rate,
+			sample_rate, event_sample_rates, created_at_us
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			name = excluded.name,
 			timezone = excluded.timezone,
-			retention_days = excluded.retention_days
-	`, siteID, name, timezone, retentionDays, now); err != nil {
+			retention_days = excluded.retention_days,
+			search_param = excluded.search_param,
+			path_rules = excluded.path_rules,
+			content_groups = excluded.content_groups,
+			server_identity = excluded.server_identity,
+			privacy_signals = excluded.privacy_signals,
+			default_consent = excluded.default_consent,
+			min_breakdown_count = excluded.min_breakdown_count,
+			privacy_epsilon = excluded.privacy_epsilon,
+			monthly_event_quota = excluded.monthly_event_quota,
+			quota_mode = excluded.quota_mode,
+			quota_sample_rate = excluded.quota_sample_rate,
+			sample_rate = excluded.sample_rate,
+			event_sample_rates = excluded.event_sample_rates
+	`, siteID, name, timezone, retentionDays, searchParam, pathRules, contentGroups,
+		boolToInt(site.ServerIdentity), privacySignals, defaultConsent,
+		site.MinBreakdownCount, site.PrivacyEpsilon,
+		site.MonthlyEventQuota, quotaMode, quotaSampleRate,
+		sampleRate, string(eventSampleRates), now); err != nil {
 		return err
 	}
 	if _, err := tx.ExecContext(ctx, "DELETE FROM site_domains WHERE site_id = ?", siteID); err != nil {
@@ -82,9 +216,143 @@ func (r *SqliteRepository) CreateSite(ctx context.Context, site *core.Site) erro
 			return err
 		}
 	}
+	if hasEvents == 1 && (existingPathRules != pathRules || existingContentGroups != contentGroups) {
+		// The projector rewrites the stored events and reprojects the site;
+		// a further change before it finishes starts the rewrite over.
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO site_reprojections(site_id, after_seq, requested_at_us)
+			VALUES (?, 0, ?)
+			ON CONFLICT(site_id) DO UPDATE SET after_seq = 0, requested_at_us = excluded.requested_at_us
+		`, siteID, now); err != nil {
+			return fmt.Errorf("queue path rule reprojection: %w", err)
+		}
+	}
+	after, err := auditedSite(ctx, tx, siteID)
+	if err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, "", before, after); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
+// auditedSite returns the enabled site as tx sees it, or nil when there is
+// none, for the audit entry of a change to it.
+func auditedSite(ctx context.Context, tx *sql.Tx, siteID string) (*core.Site, error) {
+	site, err := getSite(ctx, tx, siteID)
+	if errors.Is(err, core.ErrSiteNotFound) {
+		return nil, nil
+	}
+	return site, err
+}
+
+// reapplyPathRules rewrites up to batchSize stored pathnames and content
+// groups for the oldest site queued by CreateSite. Once the site's last batch
+// is rewritten it reprojects the site and leaves the queue. It returns the
+// number of events it read.
+func reapplyPathRules(ctx context.Context, tx *sql.Tx, batchSize int) (int, error) {
+	var siteID, pathRules, contentGroups string
+	var afterSeq int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT q.site_id, q.after_seq, s.path_rules, s.content_groups
+		FROM site_reprojections q
+		JOIN sites s ON s.id = q.site_id
+		ORDER BY q.requested_at_us, q.site_id
+		LIMIT 1
+	`).Scan(&siteID, &afterSeq, &pathRules, &contentGroups)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read queued reprojection: %w", err)
+	}
+	var rules []core.PathRule
+	var groups []core.ContentGroup
+	if err := decodeSiteSettings(pathRules, contentGroups, &rules, &groups); err != nil {
+		return 0, err
+	}
+	ruleSet, err := core.CompilePathRules(rules, groups)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT seq, url, pathname, content_group FROM events
+		WHERE site_id = ? AND seq > ?
+		ORDER BY seq
+		LIMIT ?
+	`, siteID, afterSeq, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("read events for path rules: %w", err)
+	}
+	type change struct {
+		seq             int64
+		pathname, group string
+	}
+	var changes []change
+	count := 0
+	for rows.Next() {
+		var seq int64
+		var rawURL, pathname, group string
+		if err := rows.Scan(&seq, &rawURL, &pathname, &group); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		count++
+		afterSeq = seq
+		rewritten, rewrittenGroup := ruleSet.Apply(rawPathname(rawURL))
+		if rewritten != pathname || rewrittenGroup != group {
+			changes = append(changes, change{seq: seq, pathname: rewritten, group: rewrittenGroup})
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	for _, item := range changes {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE events SET pathname = ?, content_group = ? WHERE seq = ?
+		`, item.pathname, item.group, item.seq); err != nil {
+			return 0, fmt.Errorf("rewrite event %d: %w", item.seq, err)
+		}
+	}
+
+	if count == batchSize {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE site_reprojections SET after_seq = ? WHERE site_id = ?
+		`, afterSeq, siteID); err != nil {
+			return 0, fmt.Errorf("advance reprojection of site %q: %w", siteID, err)
+		}
+		return count, nil
+	}
+	if err := reprojectSite(ctx, tx, siteID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM site_reprojections WHERE site_id = ?", siteID); err != nil {
+		return 0, fmt.Errorf("finish reprojection of site %q: %w", siteID, err)
+	}
+	return count, nil
+}
+
+func rawPathname(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.EscapedPath() == "" {
+		return "/"
+	}
+	return parsed.EscapedPath()
+}
+
+func encodeSiteSetting[T any](values []T) (string, error) {
+	if values == nil {
+		values = []T{}
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("encode site setting: %w", err)
+	}
+	return string(encoded), nil
+}
+
 func (r *SqliteRepository) ValidateSite(ctx context.Context, siteID, domain string) error {
 	siteID = strings.TrimSpace(siteID)
 	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
@@ -107,6 +375,104 @@ func (r *SqliteRepository) ValidateSite(ctx context.Context, siteID, domain stri
 	return err
 }
 
+func (r *SqliteRepository) HasSiteDomain(ctx context.Context, domain string) (bool, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	var exists int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT 1 FROM site_domains d
+		JOIN sites s ON s.id = d.site_id
+		WHERE d.hostname = ? AND s.disabled_at_us IS NULL
+		LIMIT 1
+	`, domain).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetSite returns an enabled site with its ingestion settings.
+func (r *SqliteRepository) GetSite(ctx context.Context, siteID string) (*core.Site, error) {
+	return getSite(ctx, r.db, siteID)
+}
+
+func getSite(
+	ctx context.Context,
+	querier interface {
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	},
+	siteID string,
+) (*core.Site, error) {
+	site := core.Site{ID: strings.TrimSpace(siteID)}
+	var pathRules, contentGroups, eventSampleRates, domainsCSV string
+	err := querier.QueryRowContext(ctx, `
+		SELECT s.name, s.timezone, s.retention_days, s.search_param,
+		       s.path_rules, s.content_groups, s.server_identity,
+		       s.privacy_signals, s.default_consent,
+		       s.min_breakdown_count, s.privacy_epsilon,
+		       s.monthly_event_quota, s.quota_mode, s.quota_sample_rate,
+		       s.sample_rate, s.event_sample_rates,
+		       COALESCE((
+		           SELECT GROUP_CONCAT(hostname) FROM (
+		               SELECT hostname FROM site_domains
+		               WHERE site_id = s.id
+		               ORDER BY is_primary DESC, hostname ASC
+		           )
+		       ), '')
+		FROM sites s
+		WHERE s.id = ? AND s.disabled_at_us IS NULL
+	`, site.ID).Scan(
+		&site.Name, &site.Timezone, &site.RetentionDays, &site.SearchParam,
+		&pathRules, &contentGroups, &site.ServerIdentity,
+		&site.PrivacySignals, &site.DefaultConsent,
+		&site.MinBreakdownCount, &site.PrivacyEpsilon,
+		&site.MonthlyEventQuota, &site.QuotaMode, &site.QuotaSampleRate,
+		&site.SampleRate, &eventSampleRates, &domainsCSV,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", core.ErrSiteNotFound, site.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	site.Domains = splitDomains(domainsCSV)
+	if err := decodeSiteSettings(pathRules, contentGroups, &site.PathRules, &site.ContentGroups); err != nil {
+		return nil, err
+	}
+	if err := decodeSampleRates(eventSampleRates, &site.SampleRate, &site.EventSampleRates); err != nil {
+		return nil, err
+	}
+	return &site, nil
+}
+
+// decodeSampleRates reads a site's sampling settings. A stored rate of 1 is
+// reported as zero, meaning every event is stored.
+func decodeSampleRates(eventSampleRates string, rate *float64, rates *map[string]float64) error {
+	if *rate >= 1 {
+		*rate = 0
+	}
+	if err := json.Unmarshal([]byte(eventSampleRates), rates); err != nil {
+		return fmt.Errorf("decode event sample rates: %w", err)
+	}
+	if len(*rates) == 0 {
+		*rates = nil
+	}
+	return nil
+}
+
+func decodeSiteSettings(
+	pathRules, contentGroups string,
+	rules *[]core.PathRule,
+	groups *[]core.ContentGroup,
+) error {
+	if err := json.Unmarshal([]byte(pathRules), rules); err != nil {
+		return fmt.Errorf("decode path rules: %w", err)
+	}
+	if err := json.Unmarshal([]byte(contentGroups), groups); err != nil {
+		return fmt.Errorf("decode content groups: %w", err)
+	}
+	return nil
+}
+
 func normalizedDomains(domains []string) ([]string, error) {
 	seen := map[string]struct{}{}
 	result := make([]string, 0, len(domains))
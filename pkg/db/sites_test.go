@@ -25,6 +25,11 @@ func TestCreateSite_ReplacesDomainAllowlist(t *testing.T) {
 	if err := repo.ValidateSite(ctx, "site-a", "example.com"); !errors.Is(err, core.ErrDomainNotAllowed) {
 		t.Fatalf("ValidateSite(old domain) error = %v, want ErrDomainNotAllowed", err)
 	}
+	for domain, want := range map[string]bool{"new.example.com": true, "example.com": false, "other.com": true} {
+		if got, err := repo.HasSiteDomain(ctx, domain); err != nil || got != want {
+			t.Fatalf("HasSiteDomain(%q) = %v, %v; want %v", domain, got, err, want)
+		}
+	}
 	sites, err := repo.GetSites(ctx)
 	if err != nil {
 		t.Fatalf("GetSites returned error: %v", err)
@@ -69,3 +74,208 @@ func TestCreateSite_RejectsMalformedDomains(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateSite_StoresSearchParameter(t *testing.T) {
+	repo := newTestRepo(t)
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "docs", Domains: []string{"docs.example.com"}, SearchParam: " q ",
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	site, err := repo.GetSite(context.Background(), "docs")
+	if err != nil {
+		t.Fatalf("GetSite returned error: %v", err)
+	}
+	if site.SearchParam != "q" || len(site.Domains) != 1 || site.Domains[0] != "docs.example.com" {
+		t.Fatalf("unexpected site: %+v", site)
+	}
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "docs", Domains: []string{"docs.example.com"}, SearchParam: "q&x",
+	}); err == nil {
+		t.Fatal("expected malformed search parameter to be rejected")
+	}
+}
+
+func TestCreateSite_StoresBreakdownPrivacySettings(t *testing.T) {
+	repo := newTestRepo(t)
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "shared", Domains: []string{"shared.example.com"}, MinBreakdownCount: 5, PrivacyEpsilon: 0.5,
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	site, err := repo.GetSite(context.Background(), "shared")
+	if err != nil {
+		t.Fatalf("GetSite returned error: %v", err)
+	}
+	if site.MinBreakdownCount != 5 || site.PrivacyEpsilon != 0.5 {
+		t.Fatalf("unexpected site: %+v", site)
+	}
+	for _, invalid := range []core.Site{
+		{ID: "shared", Domains: []string{"shared.example.com"}, MinBreakdownCount: -1},
+		{ID: "shared", Domains: []string{"shared.example.com"}, PrivacyEpsilon: -0.1},
+	} {
+		if err := repo.CreateSite(context.Background(), &invalid); err == nil {
+			t.Fatalf("expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestCreateSite_PathRuleChangeReprocessesEventsAndProjections(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	for index, rawURL := range []string{
+		"https://example.com/users/123/settings",
+		"https://example.com/users/456/settings",
+		"https://example.com/blog/launch",
+		"https://example.com/blog",
+	} {
+		insertEvent(t, repo, core.Event{
+			EventName: "$pageview", Domain: "example.com", SiteID: "site-a", URL: rawURL,
+			SessionID: "s", VisitorID: "v",
+			Timestamp: time.Date(2026, 8, 10, 9, index, 0, 0, time.UTC),
+		})
+	}
+	if _, err := repo.ProjectPending(ctx, 100); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+
+	if err := repo.CreateSite(ctx, &core.Site{
+		ID: "site-a", Domains: []string{"example.com", "www.example.com"},
+		PathRules:     []core.PathRule{{Pattern: "/users/:id/settings"}},
+		ContentGroups: []core.ContentGroup{{Name: "Blog", Pattern: "/blog/**"}},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	pages, err := repo.GetTopPages(ctx, "site-a", "2026-08-10", "2026-08-10", 10)
+	if err != nil {
+		t.Fatalf("GetTopPages returned error: %v", err)
+	}
+	if len(pages) != 4 {
+		t.Fatalf("saving the site rewrote pages before the projector ran: %+v", pages)
+	}
+	// Batches of three take two projector runs to rewrite the four events.
+	for range 2 {
+		if _, err := repo.ProjectPending(ctx, 3); err != nil {
+			t.Fatalf("ProjectPending returned error: %v", err)
+		}
+	}
+
+	pages, err = repo.GetTopPages(ctx, "site-a", "2026-08-10", "2026-08-10", 10)
+	if err != nil {
+		t.Fatalf("GetTopPages returned error: %v", err)
+	}
+	if len(pages) != 3 || pages[0].URL != "/users/:id/settings" || pages[0].Pageviews != 2 {
+		t.Fatalf("projected pages were not rewritten: %+v", pages)
+	}
+	var exitPathname string
+	if err := repo.db.QueryRow(`
+		SELECT exit_pathname FROM sessions WHERE site_id = 'site-a' AND session_id = 's'
+	`).Scan(&exitPathname); err != nil {
+		t.Fatalf("read session: %v", err)
+	}
+	if exitPathname != "/blog" {
+		t.Fatalf("session exit pathname = %q, want /blog", exitPathname)
+	}
+
+	groups, err := repo.GetContentGroups(ctx, "site-a", "", "")
+	if err != nil {
+		t.Fatalf("GetContentGroups returned error: %v", err)
+	}
+	if len(groups) != 1 || groups[0] != (core.ContentGroupStat{Group: "Blog", Pageviews: 2, Visitors: 1}) {
+		t.Fatalf("unexpected content groups: %+v", groups)
+	}
+	blogPages, err := repo.GetContentGroupPages(ctx, "site-a", "Blog", "", "", 10)
+	if err != nil {
+		t.Fatalf("GetContentGroupPages returned error: %v", err)
+	}
+	if len(blogPages) != 2 || blogPages[0].Visitors != 1 || blogPages[1].Visitors != 1 {
+		t.Fatalf("unexpected Blog pages: %+v", blogPages)
+	}
+
+	if err := repo.CreateSite(ctx, &core.Site{
+		ID: "site-a", Domains: []string{"example.com", "www.example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	if _, err := repo.ProjectPending(ctx, 100); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+	pages, err = repo.GetTopPages(ctx, "site-a", "2026-08-10", "2026-08-10", 10)
+	if err != nil {
+		t.Fatalf("GetTopPages returned error: %v", err)
+	}
+	if len(pages) != 4 {
+		t.Fatalf("removing rules did not restore raw pathnames: %+v", pages)
+	}
+}
+
+func TestCreateSite_RejectsInvalidPathRules(t *testing.T) {
+	repo := newTestRepo(t)
+	for _, site := range []core.Site{
+		{ID: "bad", Domains: []string{"bad.example"}, PathRules: []core.PathRule{{Pattern: "users/:id"}}},
+		{ID: "bad", Domains: []string{"bad.example"}, PathRules: []core.PathRule{{Pattern: "^/users/(\\d+"}}},
+		{ID: "bad", Domains: []string{"bad.example"}, PathRules: []core.PathRule{{Pattern: "^/users/\\d+$"}}},
+		{ID: "bad", Domains: []string{"bad.example"}, ContentGroups: []core.ContentGroup{{Name: "Docs", Pattern: "/**/docs"}}},
+	} {
+		if err := repo.CreateSite(context.Background(), &site); err == nil {
+			t.Fatalf("expected invalid rules to be rejected: %+v", site)
+		}
+	}
+}
+
+func TestCreateSite_StoresSampleRates(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	for _, site := range []core.Site{
+		{SampleRate: 1.5},
+		{SampleRate: -0.1},
+		{EventSampleRates: map[string]float64{"signup": 0}},
+		{EventSampleRates: map[string]float64{" ": 0.5}},
+	} {
+		site.ID, site.Domains = "sampled", []string{"sampled.example"}
+		if err := repo.CreateSite(ctx, &site); err == nil {
+			t.Fatalf("CreateSite(%+v) returned nil error", site)
+		}
+	}
+
+	if err := repo.CreateSite(ctx, &core.Site{
+		ID: "sampled", Domains: []string{"sampled.example"},
+		SampleRate: 0.1, EventSampleRates: map[string]float64{"signup": 1},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	site, err := repo.GetSite(ctx, "sampled")
+	if err != nil {
+		t.Fatalf("GetSite returned error: %v", err)
+	}
+	if site.SampleRate != 0.1 || site.EventSampleRate("signup") != 1 || site.EventSampleRate("$pageview") != 0.1 {
+		t.Fatalf("sample rates = %v %v", site.SampleRate, site.EventSampleRates)
+	}
+	unsampled, err := repo.GetSite(ctx, "site-a")
+	if err != nil {
+		t.Fatalf("GetSite returned error: %v", err)
+	}
+	if unsampled.SampleRate != 0 || unsampled.EventSampleRates != nil {
+		t.Fatalf("unsampled site rates = %v %v, want zero values", unsampled.SampleRate, unsampled.EventSampleRates)
+	}
+}
+
+func TestInsertSite_RefusesATakenID(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	err := repo.InsertSite(ctx, &core.Site{ID: "site-a", Domains: []string{"taken.example"}})
+	if !errors.Is(err, core.ErrSiteExists) {
+		t.Fatalf("InsertSite over an existing site returned %v, want ErrSiteExists", err)
+	}
+	site, err := repo.GetSite(ctx, "site-a")
+	if err != nil {
+		t.Fatalf("GetSite returned error: %v", err)
+	}
+	if !site.HasDomain("example.com") || site.HasDomain("taken.example") {
+		t.Fatalf("refused insert changed the site's domains: %v", site.Domains)
+	}
+
+	if err := repo.InsertSite(ctx, &core.Site{ID: "site-c", Domains: []string{"new.example"}}); err != nil {
+		t.Fatalf("InsertSite returned error: %v", err)
+	}
+}
@@ -0,0 +1,510 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	spoolHeaderSize    = 8
+	maxSpoolRecordSize = 64 << 20
+	spoolWriteTimeout  = 2 * time.Second
+	spoolDrainInterval = time.Second
+)
+
+var spoolChecksums = crc32.MakeTable(crc32.Castagnoli)
+
+// eventSpool is an append-only file of events that could not be committed to
+// SQLite. Each record is a little-endian payload length and CRC-32C checksum
+// followed by a JSON array of events, and is fsynced before the append
+// returns, so a spooled event is at least as durable as a committed one.
+type eventSpool struct {
+	// replay serializes drainSpool with erase, which moves unreplayed records.
+	replay  sync.Mutex
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	drained int64
+	depth   int64
+}
+
+// spooledEvent carries the derived columns that core.Event omits from JSON.
+type spooledEvent struct {
+	core.Event
+	ReceivedAt       time.Time `json:"received_at"`
+	Pathname         string    `json:"pathname"`
+	ReferrerHost     string    `json:"referrer_host,omitempty"`
+	LocalDay         string    `json:"local_day,omitempty"`
+	LinkKind         string    `json:"link_kind,omitempty"`
+	LinkURL          string    `json:"link_url,omitempty"`
+	NotFound         bool      `json:"not_found,omitempty"`
+	ElementSignature string    `json:"element_signature,omitempty"`
+	SearchTerm       string    `json:"search_term,omitempty"`
+	ContentGroup     string    `json:"content_group,omitempty"`
+	SampleRate       float64   `json:"sample_rate,omitempty"`
+}
+
+// spoolPath returns the spool file beside a database path, or "" for an
+// in-memory database.
+func spoolPath(databasePath string) string {
+	databasePath = strings.TrimPrefix(databasePath, "file:")
+	if index := strings.IndexByte(databasePath, '?'); index >= 0 {
+		databasePath = databasePath[:index]
+	}
+	if databasePath == "" || databasePath == ":memory:" {
+		return ""
+	}
+	return databasePath + "-spool"
+}
+
+// openEventSpool opens or creates the spool and counts the events it holds. A
+// torn final record was never acknowledged, so it is truncated away.
+func openEventSpool(path string) (*eventSpool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open event spool: %w", err)
+	}
+	spool := &eventSpool{path: path, file: file}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("stat event spool: %w", err)
+	}
+	for spool.size < info.Size() {
+		events, next, err := spool.readRecord(spool.size)
+		if err != nil {
+			slog.Warn("discarding unreadable spool tail", "component", "Spool", "bytes", info.Size()-spool.size, "error", err)
+			if err := file.Truncate(spool.size); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("truncate event spool: %w", err)
+			}
+			if err := file.Sync(); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("sync event spool: %w", err)
+			}
+			break
+		}
+		spool.depth += int64(len(events))
+		spool.size = next
+	}
+	if err := syncDirectory(filepath.Dir(path)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return spool, nil
+}
+
+func syncDirectory(path string) error {
+	directory, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open spool directory: %w", err)
+	}
+	defer directory.Close()
+	if err := directory.Sync(); err != nil {
+		return fmt.Errorf("sync spool directory: %w", err)
+	}
+	return nil
+}
+
+func (s *eventSpool) readRecord(offset int64) ([]spooledEvent, int64, error) {
+	return readSpoolRecord(s.file, offset)
+}
+
+func readSpoolRecord(file *os.File, offset int64) ([]spooledEvent, int64, error) {
+	header := make([]byte, spoolHeaderSize)
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return nil, 0, fmt.Errorf("read record header: %w", err)
+	}
+	length := binary.LittleEndian.Uint32(header[:4])
+	if length == 0 || length > maxSpoolRecordSize {
+		return nil, 0, fmt.Errorf("invalid record length %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := file.ReadAt(payload, offset+spoolHeaderSize); err != nil {
+		return nil, 0, fmt.Errorf("read record payload: %w", err)
+	}
+	if crc32.Checksum(payload, spoolChecksums) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+	var events []spooledEvent
+	if err := json.Unmarshal(payload, &events); err != nil {
+		return nil, 0, fmt.Errorf("decode record: %w", err)
+	}
+	return events, offset + spoolHeaderSize + int64(length), nil
+}
+
+func (s *eventSpool) append(events []*core.Event) error {
+	records := make([]spooledEvent, len(events))
+	for index, event := range events {
+		records[index] = spooledEvent{
+			Event:            *event,
+			ReceivedAt:       event.ReceivedAt,
+			Pathname:         event.Pathname,
+			ReferrerHost:     event.ReferrerHost,
+			LocalDay:         event.LocalDay,
+			LinkKind:         event.LinkKind,
+			LinkURL:          event.LinkURL,
+			NotFound:         event.NotFound,
+			ElementSignature: event.ElementSignature,
+			SearchTerm:       event.SearchTerm,
+			ContentGroup:     event.ContentGroup,
+			SampleRate:       event.SampleRate,
+		}
+	}
+	record, err := encodeSpoolRecord(records)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.WriteAt(record, s.size); err != nil {
+		_ = s.file.Truncate(s.size)
+		return fmt.Errorf("append spool record: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		_ = s.file.Truncate(s.size)
+		return fmt.Errorf("sync spool record: %w", err)
+	}
+	s.size += int64(len(record))
+	s.depth += int64(len(events))
+	return nil
+}
+
+func encodeSpoolRecord(records []spooledEvent) ([]byte, error) {
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return nil, fmt.Errorf("encode spool record: %w", err)
+	}
+	if len(payload) > maxSpoolRecordSize {
+		return nil, fmt.Errorf("spool record exceeds %d bytes", maxSpoolRecordSize)
+	}
+	record := make([]byte, spoolHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, spoolChecksums))
+	copy(record[spoolHeaderSize:], payload)
+	return record, nil
+}
+
+// next returns the oldest record that has not been replayed, or nil once the
+// spool is drained.
+func (s *eventSpool) next() ([]spooledEvent, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.drained >= s.size {
+		return nil, 0, nil
+	}
+	return s.readRecord(s.drained)
+}
+
+// advance marks a record as replayed and truncates the file once every record
+// has been. A crash before truncation replays records again, which the event
+// ID conflict clause makes harmless.
+func (s *eventSpool) advance(next int64, events int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drained = next
+	s.depth -= int64(events)
+	if s.drained < s.size {
+		return nil
+	}
+	if err := s.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate event spool: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("sync event spool: %w", err)
+	}
+	s.size, s.drained, s.depth = 0, 0, 0
+	return nil
+}
+
+// quarantinePath is the file that keeps records the database rejected. It
+// uses the spool's record format, so that an operator can inspect it and,
+// once the cause is fixed, move it in place of an empty spool to replay it.
+func (s *eventSpool) quarantinePath() string {
+	return s.path + "-quarantine"
+}
+
+// quarantine copies the oldest unreplayed record, which ends at next, to the
+// quarantine file and fsyncs it, so that it can be advanced past without
+// losing acknowledged events.
+func (s *eventSpool) quarantine(next int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := make([]byte, next-s.drained)
+	if _, err := s.file.ReadAt(record, s.drained); err != nil {
+		return fmt.Errorf("read spool record: %w", err)
+	}
+	file, err := os.OpenFile(s.quarantinePath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open spool quarantine: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(record); err != nil {
+		return fmt.Errorf("append spool quarantine: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync spool quarantine: %w", err)
+	}
+	return syncDirectory(filepath.Dir(s.path))
+}
+
+// erase removes the events that match selects from the unreplayed records and
+// from the quarantine file, and returns how many it removed. Each file is
+// rewritten beside itself and renamed into place, so a crash leaves either
+// the old or the new records. The caller holds replay.
+func (s *eventSpool) erase(match func(*core.Event) bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, removed, size, err := rewriteSpoolFile(s.file, s.drained, s.size, s.path, match)
+	if file != nil {
+		s.file.Close()
+		s.file = file
+		s.size, s.drained = size, 0
+		s.depth -= removed
+	}
+	if err != nil {
+		return removed, err
+	}
+
+	quarantine, err := os.Open(s.quarantinePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return removed, nil
+	}
+	if err != nil {
+		return removed, fmt.Errorf("open spool quarantine: %w", err)
+	}
+	defer quarantine.Close()
+	info, err := quarantine.Stat()
+	if err != nil {
+		return removed, fmt.Errorf("stat spool quarantine: %w", err)
+	}
+	file, quarantined, _, err := rewriteSpoolFile(quarantine, 0, info.Size(), s.quarantinePath(), match)
+	if file != nil {
+		file.Close()
+	}
+	return removed + quarantined, err
+}
+
+// rewriteSpoolFile copies the records of source between from and to, without
+// the events that match selects, over path. It returns the new file, open for
+// reading and writing, with the number of events removed and its size, or a
+// nil file when path was left untouched.
+func rewriteSpoolFile(
+	source *os.File,
+	from, to int64,
+	path string,
+	match func(*core.Event) bool,
+) (*os.File, int64, int64, error) {
+	rewrite, err := os.OpenFile(path+"-rewrite", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("create spool rewrite: %w", err)
+	}
+	discard := func(err error) (*os.File, int64, int64, error) {
+		rewrite.Close()
+		os.Remove(rewrite.Name())
+		return nil, 0, 0, err
+	}
+
+	var removed, size int64
+	for offset := from; offset < to; {
+		records, next, err := readSpoolRecord(source, offset)
+		if err != nil {
+			return discard(fmt.Errorf("read %s: %w", filepath.Base(path), err))
+		}
+		offset = next
+		kept := records[:0]
+		for index := range records {
+			if match(&records[index].Event) {
+				removed++
+			} else {
+				kept = append(kept, records[index])
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		record, err := encodeSpoolRecord(kept)
+		if err != nil {
+			return discard(err)
+		}
+		if _, err := rewrite.Write(record); err != nil {
+			return discard(fmt.Errorf("write spool rewrite: %w", err))
+		}
+		size += int64(len(record))
+	}
+	if removed == 0 {
+		return discard(nil)
+	}
+	if err := rewrite.Sync(); err != nil {
+		return discard(fmt.Errorf("sync spool rewrite: %w", err))
+	}
+	if err := os.Rename(rewrite.Name(), path); err != nil {
+		return discard(fmt.Errorf("replace %s: %w", filepath.Base(path), err))
+	}
+	// The rename has happened, so the caller must switch to the new file even
+	// if making it durable failed.
+	return rewrite, removed, size, syncDirectory(filepath.Dir(path))
+}
+
+func (s *eventSpool) pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth
+}
+
+// SpoolDepth reports the number of accepted events waiting in the spool.
+func (r *SqliteRepository) SpoolDepth() int64 {
+	if r.spool == nil {
+		return 0
+	}
+	return r.spool.pending()
+}
+
+// isTransientWriteError reports whether a failed write is likely to succeed
+// later, such as when another process holds the database lock or the disk is
+// full, rather than being rejected by the data itself.
+func isTransientWriteError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrFull, sqlite3.ErrIoErr,
+		sqlite3.ErrCantOpen, sqlite3.ErrReadonly, sqlite3.ErrInterrupt:
+		return true
+	}
+	return false
+}
+
+// spoolRequests makes requests durable in the spool after writeErr prevented
+// them from being committed, and reports the outcome to each caller.
+func (r *SqliteRepository) spoolRequests(requests []*ingestRequest, writeErr error) {
+	if r.spool == nil {
+		for _, request := range requests {
+			request.done <- writeErr
+		}
+		return
+	}
+	var events []*core.Event
+	for _, request := range requests {
+		events = append(events, request.events...)
+	}
+	err := r.spool.append(events)
+	if err != nil {
+		err = errors.Join(writeErr, err)
+	} else {
+		for _, request := range requests {
+			slog.WarnContext(request.ctx, "spooled events after write error", "component", "Spool",
+				"events", len(request.events), "error", writeErr)
+		}
+		r.signalSpoolDrain()
+	}
+	for _, request := range requests {
+		request.done <- err
+	}
+}
+
+func (r *SqliteRepository) signalSpoolDrain() {
+	select {
+	case r.spoolWake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *SqliteRepository) runSpoolDrainer(stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(spoolDrainInterval)
+	defer ticker.Stop()
+	for {
+		if r.spool.pending() > 0 {
+			if err := r.drainSpool(); err != nil {
+				slog.Warn("spool replay paused", "component", "Spool", "error", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-r.spoolWake:
+			// Give the database a moment to recover before retrying.
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// drainSpool replays spooled records into events in order. It stops at the
+// first transient failure and moves records the database rejects outright to
+// the quarantine file.
+func (r *SqliteRepository) drainSpool() error {
+	r.spool.replay.Lock()
+	defer r.spool.replay.Unlock()
+	for {
+		records, next, err := r.spool.next()
+		if err != nil || records == nil {
+			return err
+		}
+		events := make([]*core.Event, len(records))
+		for index, record := range records {
+			event := record.Event
+			event.ReceivedAt = record.ReceivedAt
+			event.Pathname = record.Pathname
+			event.ReferrerHost = record.ReferrerHost
+			event.LocalDay = record.LocalDay
+			event.LinkKind = record.LinkKind
+			event.LinkURL = record.LinkURL
+			event.NotFound = record.NotFound
+			event.ElementSignature = record.ElementSignature
+			event.SearchTerm = record.SearchTerm
+			event.ContentGroup = record.ContentGroup
+			event.SampleRate = record.SampleRate
+			events[index] = &event
+		}
+
+		request := &ingestRequest{ctx: context.Background(), events: events}
+		err = r.prepareIngestRequest(context.Background(), request, map[string]*time.Location{}, map[string]error{})
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), spoolWriteTimeout)
+			err = r.writeIngestRequests(ctx, []*ingestRequest{request})
+			cancel()
+		}
+		if err != nil && isTransientWriteError(err) {
+			return err
+		}
+		if err != nil {
+			if quarantineErr := r.spool.quarantine(next); quarantineErr != nil {
+				return errors.Join(err, quarantineErr)
+			}
+			slog.Error("quarantined spooled events", "component", "Spool", "events", len(events),
+				"file", r.spool.quarantinePath(), "error", err)
+		}
+		if err := r.spool.advance(next, len(events)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *eventSpool) close() error {
+	return s.file.Close()
+}
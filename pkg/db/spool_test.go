@@ -0,0 +1,262 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestInsert_SpoolsEventsWhileDatabaseIsFullAndReplaysThem(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	if _, err := repo.ConstrainGrowthPages(ctx, 0); err != nil {
+		t.Fatalf("ConstrainGrowthPages returned error: %v", err)
+	}
+
+	padding := strings.Repeat("x", 2000)
+	for index := 0; index < 50; index++ {
+		event := &core.Event{
+			ID: fmt.Sprintf("full-%d", index), EventName: "$pageview", SiteID: "site-a",
+			SessionID: "s", VisitorID: "v", URL: "https://example.com/", Domain: "example.com",
+			Pathname: "/", SearchTerm: "spooled", Properties: map[string]any{"padding": padding},
+		}
+		if err := repo.Insert(ctx, event); err != nil {
+			t.Fatalf("Insert %d returned error: %v", index, err)
+		}
+	}
+	status, err := repo.GetSystemStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetSystemStatus returned error: %v", err)
+	}
+	if status.SpoolDepth == 0 {
+		t.Fatal("full database did not spool any events")
+	}
+
+	if err := repo.ResetGrowthPageLimit(ctx); err != nil {
+		t.Fatalf("ResetGrowthPageLimit returned error: %v", err)
+	}
+	waitForSpoolDrain(t, repo)
+
+	var stored, searches int
+	if err := repo.db.QueryRow(
+		"SELECT COUNT(*), COUNT(*) FILTER (WHERE search_term = 'spooled') FROM events",
+	).Scan(&stored, &searches); err != nil {
+		t.Fatalf("count events: %v", err)
+	}
+	if stored != 50 || searches != 50 {
+		t.Fatalf("stored %d events with %d derived search terms, want 50 of each", stored, searches)
+	}
+}
+
+func TestNewSqliteDB_ReplaysSpoolAndDiscardsTornRecord(t *testing.T) {
+	databasePath := filepath.Join(t.TempDir(), "iris.db")
+	repo, err := NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	spool, err := openEventSpool(databasePath + "-spool")
+	if err != nil {
+		t.Fatalf("openEventSpool returned error: %v", err)
+	}
+	for _, id := range []string{"spooled-1", "spooled-2"} {
+		if err := spool.append([]*core.Event{{
+			ID: id, EventName: "$pageview", SiteID: "site-a", SessionID: "s", VisitorID: "v",
+			URL: "https://example.com/docs", Domain: "example.com", Pathname: "/docs",
+			Timestamp: time.Date(2026, 8, 1, 12, 0, 0, 0, time.UTC),
+		}}); err != nil {
+			t.Fatalf("append spool record: %v", err)
+		}
+	}
+	if err := spool.close(); err != nil {
+		t.Fatalf("close spool: %v", err)
+	}
+	file, err := os.OpenFile(databasePath+"-spool", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	if _, err := file.Write([]byte{42, 0, 0, 0, 1, 2}); err != nil {
+		t.Fatalf("write torn record: %v", err)
+	}
+	file.Close()
+
+	repo, err = NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("reopen with spool returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	waitForSpoolDrain(t, repo)
+
+	var stored int
+	var localDay string
+	if err := repo.db.QueryRow(
+		"SELECT COUNT(*), MAX(local_day) FROM events WHERE pathname = '/docs'",
+	).Scan(&stored, &localDay); err != nil {
+		t.Fatalf("count replayed events: %v", err)
+	}
+	if stored != 2 || localDay != "2026-08-01" {
+		t.Fatalf("replayed %d events with local day %q, want 2 on 2026-08-01", stored, localDay)
+	}
+	if info, err := os.Stat(databasePath + "-spool"); err != nil || info.Size() != 0 {
+		t.Fatalf("drained spool was not truncated: %v %v", info, err)
+	}
+}
+
+func TestDrainSpool_QuarantinesRejectedRecords(t *testing.T) {
+	databasePath := filepath.Join(t.TempDir(), "iris.db")
+	repo, err := NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("NewSqliteDB returned error: %v", err)
+	}
+	if err := repo.CreateSite(context.Background(), &core.Site{
+		ID: "site-a", Domains: []string{"example.com"},
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	spool, err := openEventSpool(databasePath + "-spool")
+	if err != nil {
+		t.Fatalf("openEventSpool returned error: %v", err)
+	}
+	for _, siteID := range []string{"site-deleted", "site-a"} {
+		if err := spool.append([]*core.Event{{
+			ID: "spooled-" + siteID, EventName: "$pageview", SiteID: siteID, SessionID: "s", VisitorID: "v",
+			URL: "https://example.com/", Domain: "example.com", Pathname: "/",
+		}}); err != nil {
+			t.Fatalf("append spool record: %v", err)
+		}
+	}
+	if err := spool.close(); err != nil {
+		t.Fatalf("close spool: %v", err)
+	}
+
+	repo, err = NewSqliteDB(databasePath)
+	if err != nil {
+		t.Fatalf("reopen with spool returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	waitForSpoolDrain(t, repo)
+
+	var stored int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM events WHERE id = 'spooled-site-a'").Scan(&stored); err != nil {
+		t.Fatalf("count replayed events: %v", err)
+	}
+	if stored != 1 {
+		t.Fatalf("replayed %d events after the rejected record, want 1", stored)
+	}
+	quarantine, err := openEventSpool(databasePath + "-spool-quarantine")
+	if err != nil {
+		t.Fatalf("open quarantine: %v", err)
+	}
+	defer quarantine.close()
+	kept, _, err := quarantine.readRecord(0)
+	if err != nil || len(kept) != 1 || kept[0].ID != "spooled-site-deleted" || quarantine.pending() != 1 {
+		t.Fatalf("quarantined records = %+v, %v; want the rejected event", kept, err)
+	}
+}
+
+func TestEventSpool_EraseRemovesSubjectEventsFromSpoolAndQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iris.db-spool")
+	spool, err := openEventSpool(path)
+	if err != nil {
+		t.Fatalf("openEventSpool returned error: %v", err)
+	}
+	t.Cleanup(func() { _ = spool.close() })
+	event := func(id, visitorID string) *core.Event {
+		return &core.Event{
+			ID: id, EventName: "$pageview", SiteID: "site-a", SessionID: "s-" + visitorID, VisitorID: visitorID,
+			URL: "https://example.com/", Domain: "example.com", Pathname: "/",
+		}
+	}
+	for _, events := range [][]*core.Event{
+		{event("quarantined-erase", "erase-me"), event("quarantined-keep", "keep-me")},
+		{event("replayed", "erase-me")},
+		{event("spooled-erase", "erase-me")},
+		{event("spooled-keep", "keep-me"), event("spooled-erase-2", "erase-me")},
+	} {
+		if err := spool.append(events); err != nil {
+			t.Fatalf("append spool record: %v", err)
+		}
+	}
+	records, next, _ := spool.next()
+	if err := spool.quarantine(next); err != nil {
+		t.Fatalf("quarantine returned error: %v", err)
+	}
+	if err := spool.advance(next, len(records)); err != nil {
+		t.Fatalf("advance returned error: %v", err)
+	}
+	_, next, _ = spool.next()
+	if err := spool.advance(next, 1); err != nil {
+		t.Fatalf("advance returned error: %v", err)
+	}
+
+	removed, err := spool.erase(func(event *core.Event) bool {
+		return subjectMatches(core.DataSubject{VisitorID: "erase-me"}, event)
+	})
+	if err != nil {
+		t.Fatalf("erase returned error: %v", err)
+	}
+	if removed != 3 || spool.pending() != 1 {
+		t.Fatalf("erase removed %d events leaving %d pending, want 3 and 1", removed, spool.pending())
+	}
+	if err := spool.append([]*core.Event{event("appended", "keep-me")}); err != nil {
+		t.Fatalf("append after erase: %v", err)
+	}
+
+	var remaining []string
+	for {
+		records, next, err := spool.next()
+		if err != nil {
+			t.Fatalf("next returned error: %v", err)
+		}
+		if records == nil {
+			break
+		}
+		for _, record := range records {
+			remaining = append(remaining, record.ID)
+		}
+		if err := spool.advance(next, len(records)); err != nil {
+			t.Fatalf("advance returned error: %v", err)
+		}
+	}
+	if strings.Join(remaining, ",") != "spooled-keep,appended" {
+		t.Fatalf("spool holds %v after erase, want spooled-keep and appended", remaining)
+	}
+	quarantine, err := openEventSpool(spool.quarantinePath())
+	if err != nil {
+		t.Fatalf("open quarantine: %v", err)
+	}
+	defer quarantine.close()
+	kept, _, err := quarantine.readRecord(0)
+	if err != nil || len(kept) != 1 || kept[0].ID != "quarantined-keep" || quarantine.pending() != 1 {
+		t.Fatalf("quarantined records = %+v, %v; want only the other visitor's event", kept, err)
+	}
+}
+
+func waitForSpoolDrain(t *testing.T, repo *SqliteRepository) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for repo.SpoolDepth() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("spool depth = %d after waiting for replay", repo.SpoolDepth())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
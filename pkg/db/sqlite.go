@@ -3,7 +3,7 @@ package db
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -15,6 +15,14 @@ import (
 type SqliteRepository struct {
 	db     *sql.DB
 	writer *sql.DB
+	ingest *ingestQueue
+	// identity caches server-derived visitor salts and sessions.
+	identity identityCache
+
+	spool        *eventSpool
+	spoolWake    chan struct{}
+	spoolStop    chan struct{}
+	spoolStopped chan struct{}
 }
 
 // ConstrainGrowthPages limits this database to its current size plus extraPages.
@@ -81,33 +89,39 @@ func NewSqliteDB(filepath string) (*SqliteRepository, error) {
 		return nil, err
 	}
 
-	return &SqliteRepository{db: reader, writer: writer}, nil
+	repo := &SqliteRepository{db: reader, writer: writer, ingest: newIngestQueue(DefaultIngestQueueLimit)}
+	if path := spoolPath(filepath); path != "" {
+		spool, err := openEventSpool(path)
+		if err != nil {
+			reader.Close()
+			writer.Close()
+			return nil, err
+		}
+		repo.spool = spool
+		repo.spoolWake = make(chan struct{}, 1)
+		repo.spoolStop = make(chan struct{})
+		repo.spoolStopped = make(chan struct{})
+		go repo.runSpoolDrainer(repo.spoolStop, repo.spoolStopped)
+	}
+	go repo.runIngestQueue()
+	return repo, nil
 }
 
-func (r *SqliteRepository) Insert(ctx context.Context, e *core.Event) error {
-	if err := r.requireSites(ctx, []*core.Event{e}); err != nil {
-		return err
-	}
-	propsJSON, err := json.Marshal(e.Properties)
-	if err != nil {
-		return fmt.Errorf("encode event properties: %w", err)
-	}
-	prepareEventTimes(e)
-	if err := r.prepareEventLocalDay(ctx, e); err != nil {
-		return err
-	}
-
-	query := `
+const insertEventSQL = `
 	INSERT INTO events (
 		id, event_name, site_id, occurred_at_us, received_at_us, timestamp,
 		url, domain, pathname, referrer, referrer_host, screen_width,
-		session_id, visitor_id, properties, schema_version, sdk_version, local_day
+		session_id, visitor_id, properties, schema_version, sdk_version, local_day,
+		link_kind, link_url, not_found, element_signature, search_term,
+		content_group, sample_rate
 	)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(id) DO NOTHING
 	`
 
-	_, err = r.writer.ExecContext(ctx, query,
+// eventInsertArgs returns the insertEventSQL arguments for one prepared event.
+func eventInsertArgs(e *core.Event, propsJSON []byte) []any {
+	return []any{
 		e.ID,
 		e.EventName,
 		e.SiteID,
@@ -126,116 +140,36 @@ func (r *SqliteRepository) Insert(ctx context.Context, e *core.Event) error {
 		e.SchemaVersion,
 		e.SDKVersion,
 		e.LocalDay,
-	)
-
-	return err
-}
-
-func (r *SqliteRepository) Close() error {
-	readerErr := r.db.Close()
-	writerErr := r.writer.Close()
-	if readerErr != nil {
-		return readerErr
+		e.LinkKind,
+		e.LinkURL,
+		boolToInt(e.NotFound),
+		e.ElementSignature,
+		e.SearchTerm,
+		e.ContentGroup,
+		storedSampleRate(e),
 	}
-	return writerErr
 }
 
-func (r *SqliteRepository) InsertBatch(ctx context.Context, events []*core.Event) error {
-	if err := r.requireSites(ctx, events); err != nil {
-		return err
-	}
-	properties := make([][]byte, len(events))
-	locations := map[string]*time.Location{}
-	for index, event := range events {
-		propsJSON, err := json.Marshal(event.Properties)
-		if err != nil {
-			return fmt.Errorf("encode event properties: %w", err)
-		}
-		prepareEventTimes(event)
-		if event.LocalDay == "" {
-			location := locations[event.SiteID]
-			if location == nil {
-				location, err = r.siteLocation(ctx, event.SiteID)
-				if err != nil {
-					return err
-				}
-				locations[event.SiteID] = location
-			}
-			event.LocalDay = event.Timestamp.In(location).Format(time.DateOnly)
-		}
-		properties[index] = propsJSON
-	}
-	tx, err := r.writer.BeginTx(ctx, nil)
-	if err != nil {
-		return err
+// storedSampleRate is the sample_rate recorded for e. Events that were not
+// sampled store 1.
+func storedSampleRate(e *core.Event) float64 {
+	if e.SampleRate <= 0 || e.SampleRate > 1 {
+		return 1
 	}
-	defer tx.Rollback()
-
-	stmt, err := tx.PrepareContext(ctx, `
-	INSERT INTO events (
-		id, event_name, site_id, occurred_at_us, received_at_us, timestamp,
-		url, domain, pathname, referrer, referrer_host, screen_width,
-		session_id, visitor_id, properties, schema_version, sdk_version, local_day
-	)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	ON CONFLICT(id) DO NOTHING
-	`)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
-	for index, e := range events {
-		_, err = stmt.ExecContext(ctx,
-			e.ID,
-			e.EventName,
-			e.SiteID,
-			e.Timestamp.UnixMicro(),
-			e.ReceivedAt.UnixMicro(),
-			e.Timestamp,
-			e.URL,
-			e.Domain,
-			e.Pathname,
-			e.Referrer,
-			e.ReferrerHost,
-			e.ScreenWidth,
-			e.SessionID,
-			e.VisitorID,
-			string(properties[index]),
-			e.SchemaVersion,
-			e.SDKVersion,
-			e.LocalDay,
-		)
-		if err != nil {
-			return err
-		}
-	}
-
-	return tx.Commit()
+	return e.SampleRate
 }
 
-func (r *SqliteRepository) requireSites(ctx context.Context, events []*core.Event) error {
-	checked := map[string]struct{}{}
-	for _, event := range events {
-		if event == nil {
-			return fmt.Errorf("event is required")
-		}
-		if _, ok := checked[event.SiteID]; ok {
-			continue
-		}
-		var exists int
-		err := r.db.QueryRowContext(ctx, `
-			SELECT 1 FROM sites WHERE id = ? AND disabled_at_us IS NULL
-		`, event.SiteID).Scan(&exists)
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("%w: %s", core.ErrSiteNotFound, event.SiteID)
-		}
-		if err != nil {
-			return err
-		}
-		checked[event.SiteID] = struct{}{}
+func (r *SqliteRepository) Close() error {
+	r.ingest.close()
+	var spoolErr error
+	if r.spool != nil {
+		close(r.spoolStop)
+		<-r.spoolStopped
+		spoolErr = r.spool.close()
 	}
-	return nil
+	readerErr := r.db.Close()
+	writerErr := r.writer.Close()
+	return errors.Join(spoolErr, readerErr, writerErr)
 }
 
 func prepareEventTimes(event *core.Event) {
@@ -254,27 +188,3 @@ func prepareEventTimes(event *core.Event) {
 		event.SchemaVersion = 1
 	}
 }
-
-func (r *SqliteRepository) prepareEventLocalDay(ctx context.Context, event *core.Event) error {
-	if event.LocalDay != "" {
-		return nil
-	}
-	location, err := r.siteLocation(ctx, event.SiteID)
-	if err != nil {
-		return err
-	}
-	event.LocalDay = event.Timestamp.In(location).Format(time.DateOnly)
-	return nil
-}
-
-func (r *SqliteRepository) siteLocation(ctx context.Context, siteID string) (*time.Location, error) {
-	var timezone string
-	if err := r.db.QueryRowContext(ctx, "SELECT timezone FROM sites WHERE id = ?", siteID).Scan(&timezone); err != nil {
-		return nil, fmt.Errorf("read site timezone: %w", err)
-	}
-	location, err := time.LoadLocation(timezone)
-	if err != nil {
-		return nil, fmt.Errorf("load site timezone %q: %w", timezone, err)
-	}
-	return location, nil
-}
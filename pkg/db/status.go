@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"os"
 
 	"github.com/VatsalP117/iris/pkg/core"
 )
@@ -25,5 +26,24 @@ func (r *SqliteRepository) GetSystemStatus(ctx context.Context) (*core.SystemSta
 	if status.ProjectionLag < 0 {
 		status.ProjectionLag = 0
 	}
+	status.SpoolDepth = r.SpoolDepth()
+
+	var pageSize int64
+	var file string
+	if err := r.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT page_count FROM pragma_page_count()),
+			(SELECT page_size FROM pragma_page_size()),
+			(SELECT freelist_count FROM pragma_freelist_count()),
+			COALESCE((SELECT file FROM pragma_database_list() WHERE name = 'main'), '')
+	`).Scan(&status.PageCount, &pageSize, &status.FreelistPages, &file); err != nil {
+		return nil, err
+	}
+	status.DatabaseBytes = status.PageCount * pageSize
+	if file != "" {
+		if info, err := os.Stat(file + "-wal"); err == nil {
+			status.WALBytes = info.Size()
+		}
+	}
 	return &status, nil
 }
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// subjectFilter returns the events WHERE clause for a data subject and the
+// identifier kind recorded in the audit table.
+func subjectFilter(subject *core.DataSubject) (string, []any, string, string, error) {
+	subject.SiteID = strings.TrimSpace(subject.SiteID)
+	subject.VisitorID = strings.TrimSpace(subject.VisitorID)
+	subject.SessionID = strings.TrimSpace(subject.SessionID)
+	var clause, kind, value string
+	switch {
+	case subject.VisitorID != "" && subject.SessionID == "":
+		clause, kind, value = "visitor_id = ?", "visitor_id", subject.VisitorID
+	case subject.SessionID != "" && subject.VisitorID == "":
+		clause, kind, value = "session_id = ?", "session_id", subject.SessionID
+	default:
+		return "", nil, "", "", fmt.Errorf("exactly one of visitor_id or session_id is required")
+	}
+	args := []any{value}
+	if subject.SiteID != "" {
+		clause += " AND site_id = ?"
+		args = append(args, subject.SiteID)
+	}
+	return clause, args, kind, value, nil
+}
+
+// subjectMatches reports whether event belongs to a subject that
+// subjectFilter has validated.
+func subjectMatches(subject core.DataSubject, event *core.Event) bool {
+	if subject.SiteID != "" && event.SiteID != subject.SiteID {
+		return false
+	}
+	if subject.VisitorID != "" {
+		return event.VisitorID == subject.VisitorID
+	}
+	return event.SessionID == subject.SessionID
+}
+
+func recordSubjectRequest(
+	ctx context.Context,
+	execer interface {
+		ExecContext(context.Context, string, ...any) (sql.Result, error)
+	},
+	action string,
+	subject core.DataSubject,
+	kind, value string,
+	events int64,
+	actor string,
+) error {
+	sum := sha256.Sum256([]byte(value))
+	if _, err := execer.ExecContext(ctx, `
+		INSERT INTO data_subject_requests(
+			action, site_id, id_kind, id_hash, event_count, actor, requested_at_us
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, action, subject.SiteID, kind, hex.EncodeToString(sum[:]), events, actor,
+		time.Now().UTC().UnixMicro()); err != nil {
+		return fmt.Errorf("record %s request: %w", action, err)
+	}
+	return nil
+}
+
+// ExportDataSubject returns every stored event for a visitor or session.
+func (r *SqliteRepository) ExportDataSubject(
+	ctx context.Context,
+	subject core.DataSubject,
+	actor string,
+) (*core.DataSubjectExport, error) {
+	clause, args, kind, value, err := subjectFilter(&subject)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, site_id, event_name, url, pathname, referrer, search_term,
+		       screen_width, session_id, visitor_id, properties, occurred_at_us,
+		       received_at_us, schema_version, sdk_version
+		FROM events
+		WHERE `+clause+`
+		ORDER BY occurred_at_us, seq
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	export := &core.DataSubjectExport{
+		Subject: subject, ExportedAt: time.Now().UTC(), Events: []core.SubjectEvent{},
+	}
+	for rows.Next() {
+		var event core.SubjectEvent
+		var properties string
+		var occurredAt, receivedAt int64
+		if err := rows.Scan(
+			&event.ID, &event.SiteID, &event.EventName, &event.URL, &event.Pathname,
+			&event.Referrer, &event.SearchTerm, &event.ScreenWidth, &event.SessionID,
+			&event.VisitorID, &properties, &occurredAt, &receivedAt,
+			&event.SchemaVersion, &event.SDKVersion,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(properties), &event.Properties); err != nil {
+			return nil, fmt.Errorf("decode properties of event %s: %w", event.ID, err)
+		}
+		event.OccurredAt = time.UnixMicro(occurredAt).UTC()
+		event.ReceivedAt = time.UnixMicro(receivedAt).UTC()
+		export.Events = append(export.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := recordSubjectRequest(
+		ctx, r.writer, "export", subject, kind, value, int64(len(export.Events)), actor,
+	); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// EraseDataSubject deletes every stored event for a visitor or session and
+// recomputes the affected sessions and site-local days in the same transaction.
+// The subject's events are first removed from the spool and its quarantine,
+// and spool replay is held off until the transaction ends, so that no spooled
+// event is written back after the erase.
+func (r *SqliteRepository) EraseDataSubject(
+	ctx context.Context,
+	subject core.DataSubject,
+	actor string,
+) (int64, error) {
+	clause, args, kind, value, err := subjectFilter(&subject)
+	if err != nil {
+		return 0, err
+	}
+	var spooled int64
+	if r.spool != nil {
+		r.spool.replay.Lock()
+		defer r.spool.replay.Unlock()
+		spooled, err = r.spool.erase(func(event *core.Event) bool {
+			return subjectMatches(subject, event)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("erase spooled subject events: %w", err)
+		}
+	}
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if err := catchUpUsage(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	type siteDay struct{ siteID, day string }
+	days := map[siteDay]struct{}{}
+	sessions := map[projectionSessionKey]struct{}{}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT site_id, local_day, session_id FROM events WHERE `+clause,
+		args...)
+	if err != nil {
+		return 0, fmt.Errorf("read subject events: %w", err)
+	}
+	for rows.Next() {
+		var siteID, day, sessionID string
+		if err := rows.Scan(&siteID, &day, &sessionID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		days[siteDay{siteID, day}] = struct{}{}
+		if sessionID != "" {
+			sessions[projectionSessionKey{siteID: siteID, sessionID: sessionID}] = struct{}{}
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM events WHERE "+clause, args...)
+	if err != nil {
+		return 0, fmt.Errorf("delete subject events: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	deleted += spooled
+
+	checkpoint, err := projectionCheckpoint(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	for key := range sessions {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM sessions WHERE site_id = ? AND session_id = ?
+		`, key.siteID, key.sessionID); err != nil {
+			return 0, fmt.Errorf("clear session %q: %w", key.sessionID, err)
+		}
+		if err := rebuildSession(ctx, tx, key.siteID, key.sessionID, checkpoint); err != nil {
+			return 0, err
+		}
+	}
+	for key := range days {
+		if err := reprojectSiteDay(ctx, tx, key.siteID, key.day, checkpoint); err != nil {
+			return 0, err
+		}
+	}
+	if err := recordSubjectRequest(ctx, tx, "erase", subject, kind, value, deleted, actor); err != nil {
+		return 0, err
+	}
+	if err := recordAudit(ctx, tx, kind, nil, map[string]int64{"deleted_events": deleted}); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	r.identity.forget(subject)
+	return deleted, nil
+}
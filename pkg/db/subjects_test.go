@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestEraseDataSubject_DeletesEventsAndRecomputesProjections(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	dayOne := time.Date(2026, 8, 1, 10, 0, 0, 0, time.UTC)
+	dayTwo := dayOne.AddDate(0, 0, 1)
+	for id, event := range map[string]core.Event{
+		"erase-1": {EventName: "$pageview", SiteID: "site-a", SessionID: "erase-s1", VisitorID: "erase-me",
+			Pathname: "/", Referrer: "https://search.example/", ReferrerHost: "search.example", Timestamp: dayOne},
+		"erase-2": {EventName: "signup", SiteID: "site-a", SessionID: "erase-s1", VisitorID: "erase-me",
+			Pathname: "/", Timestamp: dayOne.Add(time.Minute)},
+		"erase-3": {EventName: "$pageview", SiteID: "site-a", SessionID: "erase-s2", VisitorID: "erase-me",
+			Pathname: "/docs", Timestamp: dayTwo},
+		"keep-1": {EventName: "$pageview", SiteID: "site-a", SessionID: "keep-s", VisitorID: "keep-me",
+			Pathname: "/", Timestamp: dayOne},
+	} {
+		insertProjectionEvent(t, repo, id, event)
+	}
+	if _, err := repo.ProjectPending(ctx, 100); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+
+	export, err := repo.ExportDataSubject(ctx, core.DataSubject{VisitorID: "erase-me"}, "test")
+	if err != nil {
+		t.Fatalf("ExportDataSubject returned error: %v", err)
+	}
+	if len(export.Events) != 3 || export.Events[0].ID != "erase-1" || export.Events[2].Pathname != "/docs" {
+		t.Fatalf("unexpected export: %+v", export.Events)
+	}
+
+	deleted, err := repo.EraseDataSubject(ctx, core.DataSubject{SiteID: "site-a", VisitorID: "erase-me"}, "test")
+	if err != nil {
+		t.Fatalf("EraseDataSubject returned error: %v", err)
+	}
+	if deleted != 3 {
+		t.Fatalf("deleted %d events, want 3", deleted)
+	}
+	assertDailySiteMetrics(t, repo, "site-a", "2026-08-01", 1, 0)
+
+	var remaining, sessions, visitors, referrers, dayTwoRows int
+	if err := repo.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM events WHERE visitor_id = 'erase-me'),
+		       (SELECT COUNT(*) FROM sessions WHERE visitor_id = 'erase-me'),
+		       (SELECT COUNT(*) FROM daily_visitors WHERE visitor_id = 'erase-me'),
+		       (SELECT COUNT(*) FROM daily_referrer_visitors WHERE visitor_id = 'erase-me'),
+		       (SELECT COUNT(*) FROM daily_page_metrics WHERE day = '2026-08-02')
+	`).Scan(&remaining, &sessions, &visitors, &referrers, &dayTwoRows); err != nil {
+		t.Fatalf("count erased data: %v", err)
+	}
+	if remaining+sessions+visitors+referrers+dayTwoRows != 0 {
+		t.Fatalf("erased visitor left events=%d sessions=%d visitors=%d referrers=%d day-two pages=%d",
+			remaining, sessions, visitors, referrers, dayTwoRows)
+	}
+	var kept int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE session_id = 'keep-s'").Scan(&kept); err != nil || kept != 1 {
+		t.Fatalf("other visitor's session count = %d, %v; want 1", kept, err)
+	}
+
+	sum := sha256.Sum256([]byte("erase-me"))
+	rows, err := repo.db.Query("SELECT action, id_hash, event_count FROM data_subject_requests ORDER BY id")
+	if err != nil {
+		t.Fatalf("query audit: %v", err)
+	}
+	defer rows.Close()
+	var actions []string
+	for rows.Next() {
+		var action, hash string
+		var count int
+		if err := rows.Scan(&action, &hash, &count); err != nil {
+			t.Fatalf("scan audit: %v", err)
+		}
+		if hash != hex.EncodeToString(sum[:]) || count != 3 {
+			t.Fatalf("audit row %s has hash %s and count %d", action, hash, count)
+		}
+		actions = append(actions, action)
+	}
+	if len(actions) != 2 || actions[0] != "export" || actions[1] != "erase" {
+		t.Fatalf("audit actions = %v, want export then erase", actions)
+	}
+}
+
+func TestEraseDataSubject_RequiresExactlyOneIdentifier(t *testing.T) {
+	repo := newTestRepo(t)
+	for _, subject := range []core.DataSubject{
+		{},
+		{VisitorID: "v", SessionID: "s"},
+	} {
+		if _, err := repo.EraseDataSubject(context.Background(), subject, "test"); err == nil {
+			t.Fatalf("EraseDataSubject(%+v) returned nil error", subject)
+		}
+	}
+}
+
+func TestEraseDataSubject_StartsANewServerSession(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	at := time.Date(2026, 8, 1, 10, 0, 0, 0, time.UTC)
+	before, err := repo.ServerSessionID(ctx, "site-a", "erase-me", at)
+	if err != nil {
+		t.Fatalf("ServerSessionID returned error: %v", err)
+	}
+	kept, _ := repo.ServerSessionID(ctx, "site-a", "keep-me", at)
+
+	if _, err := repo.EraseDataSubject(ctx, core.DataSubject{VisitorID: "erase-me"}, "test"); err != nil {
+		t.Fatalf("EraseDataSubject returned error: %v", err)
+	}
+	after, _ := repo.ServerSessionID(ctx, "site-a", "erase-me", at.Add(time.Minute))
+	stillKept, _ := repo.ServerSessionID(ctx, "site-a", "keep-me", at.Add(time.Minute))
+	if after == before || stillKept != kept {
+		t.Fatalf("sessions after erase = %q (was %q), other visitor %q (was %q); want only the erased one renewed",
+			after, before, stillKept, kept)
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+const (
+	usageProjectionName    = "usage"
+	usageProjectionVersion = 1
+)
+
+// projectUsage counts at most batchSize events past the usage checkpoint
+// into daily_usage and advances the checkpoint in tx. It reports how many
+// events it counted. Usage has its own checkpoint so rebuilding the
+// analytics projections never counts an event twice.
+func projectUsage(ctx context.Context, tx *sql.Tx, batchSize int) (int, error) {
+	now := time.Now().UTC().UnixMicro()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO projection_checkpoints(name, last_seq, version, updated_at_us)
+		VALUES (?, 0, ?, ?)
+		ON CONFLICT(name) DO NOTHING
+	`, usageProjectionName, usageProjectionVersion, now); err != nil {
+		return 0, fmt.Errorf("initialize usage checkpoint: %w", err)
+	}
+	var checkpoint int64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT last_seq FROM projection_checkpoints WHERE name = ?
+	`, usageProjectionName).Scan(&checkpoint); err != nil {
+		return 0, fmt.Errorf("read usage checkpoint: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT seq, site_id, local_day, event_name FROM events
+		WHERE seq > ? ORDER BY seq LIMIT ?
+	`, checkpoint, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("read usage events: %w", err)
+	}
+	type usageKey struct{ siteID, day, eventName string }
+	counts := map[usageKey]int64{}
+	lastSeq, projected := checkpoint, 0
+	for rows.Next() {
+		var key usageKey
+		if err := rows.Scan(&lastSeq, &key.siteID, &key.day, &key.eventName); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		counts[key]++
+		projected++
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if projected == 0 {
+		return 0, nil
+	}
+
+	for key, count := range counts {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO daily_usage(site_id, day, event_name, events)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(site_id, day, event_name) DO UPDATE SET
+				events = events + excluded.events
+		`, key.siteID, key.day, key.eventName, count); err != nil {
+			return 0, fmt.Errorf("update usage for site %s: %w", key.siteID, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE projection_checkpoints
+		SET last_seq = ?, updated_at_us = ?
+		WHERE name = ?
+	`, lastSeq, now, usageProjectionName); err != nil {
+		return 0, fmt.Errorf("advance usage checkpoint: %w", err)
+	}
+	return projected, nil
+}
+
+// catchUpUsage counts every event not yet in daily_usage. Callers that delete
+// events run it first in the same transaction, so deleted events stay billed.
+func catchUpUsage(ctx context.Context, tx *sql.Tx) error {
+	for {
+		projected, err := projectUsage(ctx, tx, defaultProjectionBatchSize)
+		if err != nil {
+			return err
+		}
+		if projected < defaultProjectionBatchSize {
+			return nil
+		}
+	}
+}
+
+// GetUsage returns events per site per month between the from and to months
+// (YYYY-MM, inclusive), broken down by event name. An empty siteID covers
+// every site. Months are the sites' local calendar months. Events that have
+// not been projected yet are not included.
+func (r *SqliteRepository) GetUsage(ctx context.Context, siteID, from, to string) ([]core.SiteUsage, error) {
+	for _, month := range []string{from, to} {
+		if _, err := time.Parse("2006-01", month); err != nil {
+			return nil, fmt.Errorf("invalid month %q", month)
+		}
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT u.site_id, substr(u.day, 1, 7) AS month, u.event_name, SUM(u.events),
+		       COALESCE(s.monthly_event_quota, 0), COALESCE(s.quota_mode, '')
+		FROM daily_usage u
+		LEFT JOIN sites s ON s.id = u.site_id
+		WHERE (? = '' OR u.site_id = ?)
+		  AND u.day >= ? AND u.day < ?
+		GROUP BY u.site_id, month, u.event_name
+		ORDER BY u.site_id, month, u.event_name
+	`, siteID, siteID, from+"-01", to+"-32")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []core.SiteUsage{}
+	for rows.Next() {
+		var site, month, eventName, mode string
+		var events, quota int64
+		if err := rows.Scan(&site, &month, &eventName, &events, &quota, &mode); err != nil {
+			return nil, err
+		}
+		last := len(usage) - 1
+		if last < 0 || usage[last].SiteID != site || usage[last].Month != month {
+			usage = append(usage, core.SiteUsage{
+				SiteID: site, Month: month, ByEvent: map[string]int64{},
+				Quota: quota, QuotaMode: mode,
+			})
+			last++
+		}
+		usage[last].Events += events
+		usage[last].ByEvent[eventName] = events
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range usage {
+		usage[i].OverQuota = usage[i].Quota > 0 && usage[i].Events >= usage[i].Quota
+	}
+	return usage, nil
+}
+
+// CountMonthEvents returns the site's events in its local month (YYYY-MM):
+// those already in daily_usage plus those past the usage checkpoint. Both are
+// read in one statement, so no event is counted twice or missed.
+func (r *SqliteRepository) CountMonthEvents(ctx context.Context, siteID, month string) (int64, error) {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return 0, fmt.Errorf("invalid month %q", month)
+	}
+	// Unprojected events are the newest, so they are found by seq; the
+	// unary + keeps SQLite from scanning the site's whole history instead.
+	var events int64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COALESCE(SUM(events), 0) FROM daily_usage
+			 WHERE site_id = ? AND day >= ? AND day < ?)
+			+
+			(SELECT COUNT(*) FROM events
+			 WHERE seq > COALESCE((SELECT last_seq FROM projection_checkpoints WHERE name = ?), 0)
+			   AND +site_id = ? AND local_day >= ? AND local_day < ?)
+	`, siteID, month+"-01", month+"-32",
+		usageProjectionName, siteID, month+"-01", month+"-32").Scan(&events)
+	return events, err
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestGetUsage_SurvivesRetentionAndRebuild(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	if err := repo.CreateSite(ctx, &core.Site{
+		ID: "site-a", Name: "Site A", RetentionDays: 7, Domains: []string{"example.com"},
+		MonthlyEventQuota: 3, QuotaMode: core.QuotaHard,
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	now := time.Date(2026, 8, 9, 12, 0, 0, 0, time.UTC)
+	for i, event := range []core.Event{
+		{EventName: "$pageview", Timestamp: time.Date(2026, 7, 20, 12, 0, 0, 0, time.UTC)},
+		{EventName: "$pageview", Timestamp: now.AddDate(0, 0, -8)},
+		{EventName: "signup", Timestamp: now.AddDate(0, 0, -8)},
+		{EventName: "$pageview", Timestamp: now},
+	} {
+		event.SiteID, event.SessionID, event.VisitorID = "site-a", "session", "visitor"
+		event.Pathname = "/"
+		insertProjectionEvent(t, repo, fmt.Sprintf("usage-%d", i), event)
+	}
+
+	// Retention catches usage up before it deletes the events, without a
+	// projector run in between.
+	if deleted, err := repo.ApplyRetention(ctx, now); err != nil || deleted != 3 {
+		t.Fatalf("ApplyRetention = %d, %v; want 3 deleted", deleted, err)
+	}
+	if err := repo.RebuildProjections(ctx); err != nil {
+		t.Fatalf("RebuildProjections returned error: %v", err)
+	}
+	if _, err := repo.ProjectPending(ctx, 10); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+
+	usage, err := repo.GetUsage(ctx, "site-a", "2026-07", "2026-08")
+	if err != nil {
+		t.Fatalf("GetUsage returned error: %v", err)
+	}
+	if len(usage) != 2 {
+		t.Fatalf("usage = %+v, want July and August", usage)
+	}
+	july, august := usage[0], usage[1]
+	if july.Month != "2026-07" || july.Events != 1 || july.OverQuota {
+		t.Fatalf("july = %+v", july)
+	}
+	if august.Month != "2026-08" || august.Events != 3 ||
+		august.ByEvent["$pageview"] != 2 || august.ByEvent["signup"] != 1 ||
+		august.Quota != 3 || august.QuotaMode != core.QuotaHard || !august.OverQuota {
+		t.Fatalf("august = %+v", august)
+	}
+
+	other, err := repo.GetUsage(ctx, "", "2026-08", "2026-08")
+	if err != nil {
+		t.Fatalf("GetUsage returned error: %v", err)
+	}
+	if len(other) != 1 || other[0].SiteID != "site-a" {
+		t.Fatalf("all-site usage = %+v", other)
+	}
+	if _, err := repo.GetUsage(ctx, "", "2026-8", "2026-08"); err == nil {
+		t.Fatalf("GetUsage accepted an invalid month")
+	}
+}
+
+func TestCreateSite_ValidatesQuota(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	for _, site := range []core.Site{
+		{MonthlyEventQuota: -1},
+		{MonthlyEventQuota: 10, QuotaMode: "block"},
+		{MonthlyEventQuota: 10, QuotaMode: core.QuotaSample, QuotaSampleRate: 1.5},
+	} {
+		site.ID, site.Name, site.Domains = "site-q", "Quota", []string{"quota.example"}
+		if err := repo.CreateSite(ctx, &site); err == nil {
+			t.Fatalf("CreateSite(%+v) returned nil error", site)
+		}
+	}
+
+	if err := repo.CreateSite(ctx, &core.Site{
+		ID: "site-q", Name: "Quota", Domains: []string{"quota.example"}, MonthlyEventQuota: 10,
+	}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	site, err := repo.GetSite(ctx, "site-q")
+	if err != nil {
+		t.Fatalf("GetSite returned error: %v", err)
+	}
+	if site.MonthlyEventQuota != 10 || site.QuotaMode != core.QuotaSoft || site.QuotaSampleRate != 0.1 {
+		t.Fatalf("quota settings = %d %q %v", site.MonthlyEventQuota, site.QuotaMode, site.QuotaSampleRate)
+	}
+}
+
+func TestCountMonthEvents_IncludesUnprojectedEvents(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	for i, day := range []int{1, 2, 3} {
+		insertProjectionEvent(t, repo, fmt.Sprintf("count-%d", i), core.Event{
+			EventName: "$pageview", SiteID: "site-a", SessionID: "s", VisitorID: "v", Pathname: "/",
+			Timestamp: time.Date(2026, 8, day, 12, 0, 0, 0, time.UTC),
+		})
+	}
+	if _, err := repo.ProjectPending(ctx, 2); err != nil {
+		t.Fatalf("ProjectPending returned error: %v", err)
+	}
+	insertProjectionEvent(t, repo, "count-july", core.Event{
+		EventName: "$pageview", SiteID: "site-a", SessionID: "s", VisitorID: "v", Pathname: "/",
+		Timestamp: time.Date(2026, 7, 31, 12, 0, 0, 0, time.UTC),
+	})
+
+	for month, want := range map[string]int64{"2026-08": 3, "2026-07": 1, "2026-09": 0} {
+		events, err := repo.CountMonthEvents(ctx, "site-a", month)
+		if err != nil {
+			t.Fatalf("CountMonthEvents(%s) returned error: %v", month, err)
+		}
+		if events != want {
+			t.Fatalf("CountMonthEvents(%s) = %d, want %d", month, events, want)
+		}
+	}
+}
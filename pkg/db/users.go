@@ -0,0 +1,659 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// UserSessionTTL is how long a dashboard sign-in lasts.
+	UserSessionTTL = 14 * 24 * time.Hour
+	// DefaultInviteTTL and MaxInviteTTL bound how long an invite link works.
+	DefaultInviteTTL = 7 * 24 * time.Hour
+	MaxInviteTTL     = 30 * 24 * time.Hour
+	invitePrefix     = "iris_inv_"
+	minPasswordBytes = 10
+	maxPasswordBytes = 72
+)
+
+// dummyPasswordHash is compared against when a login names an unknown email,
+// so unknown and known accounts take the same time to reject.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("iris-dummy-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func randomToken(prefix string, size int) (string, error) {
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(secret), nil
+}
+
+func normalizedEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 || len(email) > 254 || strings.ContainsAny(email, " \t\r\n") {
+		return "", fmt.Errorf("invalid email %q", email)
+	}
+	return email, nil
+}
+
+func validRole(role string) bool {
+	return role == core.RoleOwner || role == core.RoleAdmin || role == core.RoleViewer
+}
+
+func (r *SqliteRepository) HasUsers(ctx context.Context) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM users WHERE disabled_at_us IS NULL)
+	`).Scan(&exists)
+	return exists, err
+}
+
+// GetUsers lists every user, including disabled ones, with their grants.
+func (r *SqliteRepository) GetUsers(ctx context.Context) ([]core.User, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, email, name, created_at_us, disabled_at_us IS NOT NULL
+		FROM users
+		ORDER BY email
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []core.User{}
+	for rows.Next() {
+		var user core.User
+		var createdAt int64
+		if err := rows.Scan(&user.ID, &user.Email, &user.Name, &createdAt, &user.Disabled); err != nil {
+			return nil, err
+		}
+		user.CreatedAt = time.UnixMicro(createdAt).UTC()
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for index := range users {
+		if users[index].Grants, err = r.userGrants(ctx, users[index].ID); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+func (r *SqliteRepository) userGrants(ctx context.Context, userID string) ([]core.SiteGrant, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT site_id, role FROM site_grants WHERE user_id = ? ORDER BY site_id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	grants := []core.SiteGrant{}
+	for rows.Next() {
+		var grant core.SiteGrant
+		if err := rows.Scan(&grant.SiteID, &grant.Role); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+func (r *SqliteRepository) DisableUser(ctx context.Context, id string) error {
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET disabled_at_us = ? WHERE id = ? AND disabled_at_us IS NULL
+	`, time.Now().UTC().UnixMicro(), id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return core.ErrUserNotFound
+	}
+	for _, statement := range []string{
+		"DELETE FROM user_sessions WHERE user_id = ?",
+		"DELETE FROM site_grants WHERE user_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, statement, id); err != nil {
+			return fmt.Errorf("disable user: %w", err)
+		}
+	}
+	if err := recordAudit(ctx, tx, id, nil, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SqliteRepository) Login(ctx context.Context, email, password string) (*core.UserSession, error) {
+	var userID, hash string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, password_hash FROM users
+		WHERE email = ? AND disabled_at_us IS NULL
+	`, strings.ToLower(strings.TrimSpace(email))).Scan(&userID, &hash)
+	if err == sql.ErrNoRows {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, core.ErrLoginFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, core.ErrLoginFailed
+	}
+	return r.createUserSession(ctx, userID)
+}
+
+func (r *SqliteRepository) createUserSession(ctx context.Context, userID string) (*core.UserSession, error) {
+	token, err := randomToken("", 32)
+	if err != nil {
+		return nil, fmt.Errorf("generate session: %w", err)
+	}
+	csrf, err := randomToken("", 32)
+	if err != nil {
+		return nil, fmt.Errorf("generate session: %w", err)
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(UserSessionTTL)
+	if _, err := r.writer.ExecContext(ctx,
+		"DELETE FROM user_sessions WHERE expires_at_us <= ?", now.UnixMicro(),
+	); err != nil {
+		return nil, fmt.Errorf("sweep sessions: %w", err)
+	}
+	if _, err := r.writer.ExecContext(ctx, `
+		INSERT INTO user_sessions(token_hash, user_id, csrf_token, created_at_us, expires_at_us)
+		VALUES (?, ?, ?, ?, ?)
+	`, hashIngestKey(token), userID, csrf, now.UnixMicro(), expiresAt.UnixMicro()); err != nil {
+		return nil, err
+	}
+	session, err := r.LookupUserSession(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	session.Token = token
+	return session, nil
+}
+
+func (r *SqliteRepository) LookupUserSession(ctx context.Context, token string) (*core.UserSession, error) {
+	var session core.UserSession
+	var createdAt, expiresAt int64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT s.csrf_token, s.expires_at_us, u.id, u.email, u.name, u.created_at_us
+		FROM user_sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at_us > ? AND u.disabled_at_us IS NULL
+	`, hashIngestKey(token), time.Now().UTC().UnixMicro()).Scan(
+		&session.CSRFToken, &expiresAt, &session.User.ID, &session.User.Email,
+		&session.User.Name, &createdAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, core.ErrSessionInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = time.UnixMicro(expiresAt).UTC()
+	session.User.CreatedAt = time.UnixMicro(createdAt).UTC()
+	if session.User.Grants, err = r.userGrants(ctx, session.User.ID); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SqliteRepository) DeleteUserSession(ctx context.Context, token string) error {
+	_, err := r.writer.ExecContext(ctx, "DELETE FROM user_sessions WHERE token_hash = ?", hashIngestKey(token))
+	return err
+}
+
+func (r *SqliteRepository) GetSiteMembers(ctx context.Context, siteID string) ([]core.SiteMember, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT u.id, u.email, u.name, g.role
+		FROM site_grants g
+		JOIN users u ON u.id = g.user_id
+		WHERE g.site_id = ?
+		ORDER BY CASE g.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, u.email
+	`, siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []core.SiteMember{}
+	for rows.Next() {
+		var member core.SiteMember
+		if err := rows.Scan(&member.UserID, &member.Email, &member.Name, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (r *SqliteRepository) SetSiteRole(ctx context.Context, siteID, userID, role string) error {
+	if role != "" && !validRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	current, err := setSiteRole(ctx, tx, siteID, userID, role)
+	if err != nil {
+		return err
+	}
+	var before, after any
+	if current != "" {
+		before = map[string]string{"role": current}
+	}
+	if role != "" {
+		after = map[string]string{"role": role}
+	}
+	if err := recordAudit(ctx, tx, userID, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setSiteRole grants role, or removes the grant when role is empty, and
+// returns the role the user held before.
+func setSiteRole(ctx context.Context, tx *sql.Tx, siteID, userID, role string) (string, error) {
+	var current string
+	err := tx.QueryRowContext(ctx, `
+		SELECT role FROM site_grants WHERE site_id = ? AND user_id = ?
+	`, siteID, userID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	if current == core.RoleOwner && role != core.RoleOwner {
+		var owners int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM site_grants WHERE site_id = ? AND role = 'owner'
+		`, siteID).Scan(&owners); err != nil {
+			return "", err
+		}
+		if owners <= 1 {
+			return "", core.ErrLastOwner
+		}
+	}
+	if role == "" {
+		if current == "" {
+			return "", core.ErrUserNotFound
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM site_grants WHERE site_id = ? AND user_id = ?", siteID, userID)
+		return current, err
+	}
+	var enabled int
+	err = tx.QueryRowContext(ctx, `
+		SELECT 1 FROM users WHERE id = ? AND disabled_at_us IS NULL
+	`, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return "", core.ErrUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO site_grants(site_id, user_id, role, created_at_us)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(site_id, user_id) DO UPDATE SET role = excluded.role
+	`, siteID, userID, role, time.Now().UTC().UnixMicro())
+	return current, err
+}
+
+func (r *SqliteRepository) CreateInvite(ctx context.Context, invite *core.Invite) error {
+	if invite == nil {
+		return fmt.Errorf("invite is required")
+	}
+	email, err := normalizedEmail(invite.Email)
+	if err != nil {
+		return err
+	}
+	if !validRole(invite.Role) {
+		return fmt.Errorf("invalid role %q", invite.Role)
+	}
+	now := time.Now().UTC()
+	if invite.ExpiresAt.IsZero() {
+		invite.ExpiresAt = now.Add(DefaultInviteTTL)
+	}
+	if !invite.ExpiresAt.After(now) || invite.ExpiresAt.After(now.Add(MaxInviteTTL)) {
+		return fmt.Errorf("invite expiry must be within %s", MaxInviteTTL)
+	}
+	var exists int
+	err = r.db.QueryRowContext(ctx, "SELECT 1 FROM sites WHERE id = ?", invite.SiteID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", core.ErrSiteNotFound, invite.SiteID)
+	}
+	if err != nil {
+		return err
+	}
+	token, err := randomToken(invitePrefix, 32)
+	if err != nil {
+		return fmt.Errorf("generate invite: %w", err)
+	}
+	invite.ID = uuid.NewString()
+	invite.Email = email
+	invite.Token = token
+	invite.CreatedAt = now
+	invite.ExpiresAt = invite.ExpiresAt.UTC()
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO invites(
+			id, token_hash, site_id, email, role, invited_by, created_at_us, expires_at_us
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, invite.ID, hashIngestKey(token), invite.SiteID, email, invite.Role, invite.InvitedBy,
+		now.UnixMicro(), invite.ExpiresAt.UnixMicro()); err != nil {
+		return err
+	}
+	recorded := *invite
+	recorded.Token = ""
+	if err := recordAudit(ctx, tx, invite.ID, nil, recorded); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetInvites lists a site's pending invites without their tokens.
+func (r *SqliteRepository) GetInvites(ctx context.Context, siteID string) ([]core.Invite, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, site_id, email, role, invited_by, expires_at_us, created_at_us
+		FROM invites
+		WHERE site_id = ? AND accepted_at_us IS NULL AND revoked_at_us IS NULL
+		  AND expires_at_us > ?
+		ORDER BY created_at_us, id
+	`, siteID, time.Now().UTC().UnixMicro())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invites := []core.Invite{}
+	for rows.Next() {
+		var invite core.Invite
+		var expiresAt, createdAt int64
+		if err := rows.Scan(
+			&invite.ID, &invite.SiteID, &invite.Email, &invite.Role, &invite.InvitedBy,
+			&expiresAt, &createdAt,
+		); err != nil {
+			return nil, err
+		}
+		invite.ExpiresAt = time.UnixMicro(expiresAt).UTC()
+		invite.CreatedAt = time.UnixMicro(createdAt).UTC()
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (r *SqliteRepository) RevokeInvite(ctx context.Context, siteID, id string) error {
+	return r.revoke(ctx, id, core.ErrInviteInvalid, `
+		UPDATE invites SET revoked_at_us = ?
+		WHERE id = ? AND site_id = ? AND accepted_at_us IS NULL AND revoked_at_us IS NULL
+	`, time.Now().UTC().UnixMicro(), id, siteID)
+}
+
+func (r *SqliteRepository) AcceptInvite(
+	ctx context.Context,
+	token, name, password string,
+) (*core.UserSession, error) {
+	tokenHash := hashIngestKey(strings.TrimSpace(token))
+	var inviteID, siteID, email, role string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, site_id, email, role FROM invites
+		WHERE token_hash = ? AND accepted_at_us IS NULL AND revoked_at_us IS NULL
+		  AND expires_at_us > ?
+	`, tokenHash, time.Now().UTC().UnixMicro()).Scan(&inviteID, &siteID, &email, &role)
+	if err == sql.ErrNoRows {
+		return nil, core.ErrInviteInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Password hashing is slow, so it happens before the write transaction.
+	var userID, existingHash string
+	var disabled bool
+	err = r.db.QueryRowContext(ctx, `
+		SELECT id, password_hash, disabled_at_us IS NOT NULL FROM users WHERE email = ?
+	`, email).Scan(&userID, &existingHash, &disabled)
+	newUser := err == sql.ErrNoRows
+	if err != nil && !newUser {
+		return nil, err
+	}
+	var passwordHash []byte
+	switch {
+	case newUser:
+		if len(password) < minPasswordBytes || len(password) > maxPasswordBytes {
+			return nil, fmt.Errorf("password must contain between %d and %d bytes", minPasswordBytes, maxPasswordBytes)
+		}
+		if passwordHash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost); err != nil {
+			return nil, fmt.Errorf("hash password: %w", err)
+		}
+		userID = uuid.NewString()
+	case disabled:
+		return nil, core.ErrInviteInvalid
+	case bcrypt.CompareHashAndPassword([]byte(existingHash), []byte(password)) != nil:
+		return nil, core.ErrLoginFailed
+	}
+
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC().UnixMicro()
+	result, err := tx.ExecContext(ctx, `
+		UPDATE invites SET accepted_at_us = ?
+		WHERE id = ? AND accepted_at_us IS NULL AND revoked_at_us IS NULL
+	`, now, inviteID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, core.ErrInviteInvalid
+	}
+	if newUser {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO users(id, email, name, password_hash, created_at_us)
+			VALUES (?, ?, ?, ?, ?)
+		`, userID, email, strings.TrimSpace(name), string(passwordHash), now); err != nil {
+			return nil, fmt.Errorf("create user: %w", err)
+		}
+	}
+	var current string
+	err = tx.QueryRowContext(ctx, `
+		SELECT role FROM site_grants WHERE site_id = ? AND user_id = ?
+	`, siteID, userID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	// An invite never lowers a role the user already holds.
+	if !core.RoleAtLeast(current, role) {
+		if _, err := setSiteRole(ctx, tx, siteID, userID, role); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.createUserSession(ctx, userID)
+}
+
+func (r *SqliteRepository) SignInExternal(
+	ctx context.Context,
+	identity core.ExternalIdentity,
+	grants []core.SiteGrant,
+) (*core.UserSession, error) {
+	if identity.Issuer == "" || identity.Subject == "" {
+		return nil, fmt.Errorf("external identity needs an issuer and subject")
+	}
+	email, err := normalizedEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	for _, grant := range grants {
+		if !validRole(grant.Role) {
+			return nil, fmt.Errorf("invalid role %q", grant.Role)
+		}
+	}
+
+	tx, err := r.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC().UnixMicro()
+	var userID string
+	var disabled, created bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, disabled_at_us IS NOT NULL FROM users
+		WHERE oidc_issuer = ? AND oidc_subject = ?
+	`, identity.Issuer, identity.Subject).Scan(&userID, &disabled)
+	if err == sql.ErrNoRows {
+		var linkedSubject string
+		err = tx.QueryRowContext(ctx, `
+			SELECT id, disabled_at_us IS NOT NULL, oidc_subject FROM users WHERE email = ?
+		`, email).Scan(&userID, &disabled, &linkedSubject)
+		switch {
+		case err == sql.ErrNoRows:
+			userID, created = uuid.NewString(), true
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO users(id, email, name, password_hash, created_at_us, oidc_issuer, oidc_subject)
+				VALUES (?, ?, ?, '', ?, ?, ?)
+			`, userID, email, strings.TrimSpace(identity.Name), now, identity.Issuer, identity.Subject)
+		case err != nil:
+		case linkedSubject != "" || !identity.EmailVerified:
+			// The address belongs to another account that the provider has
+			// not proven this person controls.
+			return nil, core.ErrLoginFailed
+		default:
+			_, err = tx.ExecContext(ctx, `
+				UPDATE users SET oidc_issuer = ?, oidc_subject = ? WHERE id = ?
+			`, identity.Issuer, identity.Subject, userID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		return nil, core.ErrLoginFailed
+	}
+	if name := strings.TrimSpace(identity.Name); name != "" && !created {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET name = ? WHERE id = ?", name, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := syncExternalGrants(ctx, tx, userID, grants, now); err != nil {
+		return nil, err
+	}
+	var granted int
+	if err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM site_grants WHERE user_id = ?", userID,
+	).Scan(&granted); err != nil {
+		return nil, err
+	}
+	if granted == 0 {
+		// Keep revoked grants revoked for an existing user, but do not create
+		// an account that cannot see anything.
+		if !created {
+			if err := tx.Commit(); err != nil {
+				return nil, err
+			}
+		}
+		return nil, core.ErrNoSiteAccess
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.createUserSession(ctx, userID)
+}
+
+// syncExternalGrants replaces a user's provider-mapped grants. A local grant
+// with an equal or higher role is left in place; a lower one is replaced.
+func syncExternalGrants(ctx context.Context, tx *sql.Tx, userID string, grants []core.SiteGrant, now int64) error {
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM site_grants WHERE user_id = ? AND source = 'oidc'", userID,
+	); err != nil {
+		return err
+	}
+	roles := map[string]string{}
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM sites WHERE disabled_at_us IS NULL")
+	if err != nil {
+		return err
+	}
+	var siteIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		siteIDs = append(siteIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		for _, siteID := range siteIDs {
+			if (grant.SiteID == "*" || grant.SiteID == siteID) && !core.RoleAtLeast(roles[siteID], grant.Role) {
+				roles[siteID] = grant.Role
+			}
+		}
+	}
+
+	local := map[string]string{}
+	rows, err = tx.QueryContext(ctx, "SELECT site_id, role FROM site_grants WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var siteID, role string
+		if err := rows.Scan(&siteID, &role); err != nil {
+			rows.Close()
+			return err
+		}
+		local[siteID] = role
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for siteID, role := range roles {
+		if core.RoleAtLeast(local[siteID], role) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO site_grants(site_id, user_id, role, created_at_us, source)
+			VALUES (?, ?, ?, ?, 'oidc')
+			ON CONFLICT(site_id, user_id) DO UPDATE SET role = excluded.role, source = 'oidc'
+		`, siteID, userID, role, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+func TestInvites_CreateUsersWithRolesAndSessions(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	if hasUsers, err := repo.HasUsers(ctx); err != nil || hasUsers {
+		t.Fatalf("HasUsers = %v, %v; want false", hasUsers, err)
+	}
+	invite := core.Invite{SiteID: "site-a", Email: " Owner@Example.com ", Role: core.RoleOwner, InvitedBy: "admin-token"}
+	if err := repo.CreateInvite(ctx, &invite); err != nil {
+		t.Fatalf("CreateInvite returned error: %v", err)
+	}
+	if invite.Email != "owner@example.com" || !strings.HasPrefix(invite.Token, "iris_inv_") {
+		t.Fatalf("unexpected invite: %+v", invite)
+	}
+	if _, err := repo.AcceptInvite(ctx, invite.Token, "Owner", "short"); err == nil {
+		t.Fatal("AcceptInvite accepted a short password")
+	}
+	session, err := repo.AcceptInvite(ctx, invite.Token, "Owner", "correct horse battery")
+	if err != nil {
+		t.Fatalf("AcceptInvite returned error: %v", err)
+	}
+	if session.Token == "" || session.CSRFToken == "" || session.User.Email != "owner@example.com" {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+	if _, err := repo.AcceptInvite(ctx, invite.Token, "Owner", "correct horse battery"); !errors.Is(err, core.ErrInviteInvalid) {
+		t.Fatalf("second accept error = %v, want ErrInviteInvalid", err)
+	}
+
+	var storedHash, storedPassword string
+	if err := repo.db.QueryRow(
+		"SELECT s.token_hash, u.password_hash FROM user_sessions s JOIN users u ON u.id = s.user_id",
+	).Scan(&storedHash, &storedPassword); err != nil {
+		t.Fatalf("read stored session: %v", err)
+	}
+	if storedHash != hashIngestKey(session.Token) || strings.Contains(storedPassword, "battery") {
+		t.Fatalf("stored session hash %q and password hash %q", storedHash, storedPassword)
+	}
+
+	if _, err := repo.Login(ctx, "owner@example.com", "wrong password"); !errors.Is(err, core.ErrLoginFailed) {
+		t.Fatalf("wrong password error = %v, want ErrLoginFailed", err)
+	}
+	if _, err := repo.Login(ctx, "nobody@example.com", "correct horse battery"); !errors.Is(err, core.ErrLoginFailed) {
+		t.Fatalf("unknown email error = %v, want ErrLoginFailed", err)
+	}
+	login, err := repo.Login(ctx, "OWNER@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatalf("Login returned error: %v", err)
+	}
+	found, err := repo.LookupUserSession(ctx, login.Token)
+	if err != nil {
+		t.Fatalf("LookupUserSession returned error: %v", err)
+	}
+	if len(found.User.Grants) != 1 || found.User.Grants[0] != (core.SiteGrant{SiteID: "site-a", Role: core.RoleOwner}) {
+		t.Fatalf("unexpected grants: %+v", found.User.Grants)
+	}
+	if err := repo.DeleteUserSession(ctx, login.Token); err != nil {
+		t.Fatalf("DeleteUserSession returned error: %v", err)
+	}
+	if _, err := repo.LookupUserSession(ctx, login.Token); !errors.Is(err, core.ErrSessionInvalid) {
+		t.Fatalf("lookup after logout error = %v, want ErrSessionInvalid", err)
+	}
+}
+
+func TestSiteRoles_KeepAnOwnerAndOffboardUsers(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	accept := func(email, role string) *core.UserSession {
+		t.Helper()
+		invite := core.Invite{SiteID: "site-a", Email: email, Role: role}
+		if err := repo.CreateInvite(ctx, &invite); err != nil {
+			t.Fatalf("CreateInvite returned error: %v", err)
+		}
+		session, err := repo.AcceptInvite(ctx, invite.Token, "", "correct horse battery")
+		if err != nil {
+			t.Fatalf("AcceptInvite returned error: %v", err)
+		}
+		return session
+	}
+	owner := accept("owner@example.com", core.RoleOwner)
+	viewer := accept("viewer@example.com", core.RoleViewer)
+
+	if err := repo.SetSiteRole(ctx, "site-a", owner.User.ID, core.RoleViewer); !errors.Is(err, core.ErrLastOwner) {
+		t.Fatalf("demote last owner error = %v, want ErrLastOwner", err)
+	}
+	if err := repo.SetSiteRole(ctx, "site-a", viewer.User.ID, "superuser"); err == nil {
+		t.Fatal("SetSiteRole accepted an unknown role")
+	}
+	if err := repo.SetSiteRole(ctx, "site-a", viewer.User.ID, core.RoleAdmin); err != nil {
+		t.Fatalf("SetSiteRole returned error: %v", err)
+	}
+	members, err := repo.GetSiteMembers(ctx, "site-a")
+	if err != nil || len(members) != 2 {
+		t.Fatalf("GetSiteMembers = %+v, %v; want two members", members, err)
+	}
+
+	if err := repo.DisableUser(ctx, viewer.User.ID); err != nil {
+		t.Fatalf("DisableUser returned error: %v", err)
+	}
+	if _, err := repo.LookupUserSession(ctx, viewer.Token); !errors.Is(err, core.ErrSessionInvalid) {
+		t.Fatalf("disabled user's session error = %v, want ErrSessionInvalid", err)
+	}
+	if _, err := repo.Login(ctx, "viewer@example.com", "correct horse battery"); !errors.Is(err, core.ErrLoginFailed) {
+		t.Fatalf("disabled user's login error = %v, want ErrLoginFailed", err)
+	}
+	if members, err := repo.GetSiteMembers(ctx, "site-a"); err != nil || len(members) != 1 {
+		t.Fatalf("members after off-boarding = %+v, %v; want the owner only", members, err)
+	}
+	if err := repo.DisableUser(ctx, viewer.User.ID); !errors.Is(err, core.ErrUserNotFound) {
+		t.Fatalf("second disable error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestSignInExternal_SyncsMappedGrantsAndLinksVerifiedEmail(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	if err := repo.CreateSite(ctx, &core.Site{ID: "site-b", Domains: []string{"other.example.com"}}); err != nil {
+		t.Fatalf("CreateSite returned error: %v", err)
+	}
+	identity := core.ExternalIdentity{
+		Issuer: "https://idp.example.com", Subject: "user-1", Email: "ada@example.com", Name: "Ada",
+	}
+	if _, err := repo.SignInExternal(ctx, identity, nil); !errors.Is(err, core.ErrNoSiteAccess) {
+		t.Fatalf("unmapped sign-in error = %v, want ErrNoSiteAccess", err)
+	}
+	if hasUsers, err := repo.HasUsers(ctx); err != nil || hasUsers {
+		t.Fatalf("HasUsers after refused sign-in = %v, %v; want false", hasUsers, err)
+	}
+
+	session, err := repo.SignInExternal(ctx, identity, []core.SiteGrant{
+		{SiteID: "*", Role: core.RoleViewer}, {SiteID: "site-b", Role: core.RoleAdmin}, {SiteID: "missing", Role: core.RoleOwner},
+	})
+	if err != nil {
+		t.Fatalf("SignInExternal returned error: %v", err)
+	}
+	want := []core.SiteGrant{{SiteID: "site-a", Role: core.RoleViewer}, {SiteID: "site-b", Role: core.RoleAdmin}}
+	if len(session.User.Grants) != 2 || session.User.Grants[0] != want[0] || session.User.Grants[1] != want[1] {
+		t.Fatalf("grants = %+v, want %+v", session.User.Grants, want)
+	}
+	if _, err := repo.Login(ctx, "ada@example.com", ""); !errors.Is(err, core.ErrLoginFailed) {
+		t.Fatalf("password login for a single sign-on user error = %v, want ErrLoginFailed", err)
+	}
+
+	// Leaving a group removes the mapped grant; an owner grant from an invite
+	// stays because it outranks the mapped role.
+	if err := repo.SetSiteRole(ctx, "site-a", session.User.ID, core.RoleOwner); err != nil {
+		t.Fatalf("SetSiteRole returned error: %v", err)
+	}
+	if _, err := repo.writer.Exec("UPDATE site_grants SET source = 'local' WHERE site_id = 'site-a'"); err != nil {
+		t.Fatalf("mark local grant: %v", err)
+	}
+	session, err = repo.SignInExternal(ctx, identity, []core.SiteGrant{{SiteID: "*", Role: core.RoleViewer}})
+	if err != nil {
+		t.Fatalf("second SignInExternal returned error: %v", err)
+	}
+	want = []core.SiteGrant{{SiteID: "site-a", Role: core.RoleOwner}, {SiteID: "site-b", Role: core.RoleViewer}}
+	if len(session.User.Grants) != 2 || session.User.Grants[0] != want[0] || session.User.Grants[1] != want[1] {
+		t.Fatalf("grants after resync = %+v, want %+v", session.User.Grants, want)
+	}
+
+	invite := core.Invite{SiteID: "site-a", Email: "grace@example.com", Role: core.RoleViewer}
+	if err := repo.CreateInvite(ctx, &invite); err != nil {
+		t.Fatalf("CreateInvite returned error: %v", err)
+	}
+	local, err := repo.AcceptInvite(ctx, invite.Token, "Grace", "correct horse battery")
+	if err != nil {
+		t.Fatalf("AcceptInvite returned error: %v", err)
+	}
+	grace := core.ExternalIdentity{Issuer: "https://idp.example.com", Subject: "user-2", Email: "grace@example.com"}
+	if _, err := repo.SignInExternal(ctx, grace, nil); !errors.Is(err, core.ErrLoginFailed) {
+		t.Fatalf("unverified email link error = %v, want ErrLoginFailed", err)
+	}
+	grace.EmailVerified = true
+	linked, err := repo.SignInExternal(ctx, grace, nil)
+	if err != nil {
+		t.Fatalf("verified email link returned error: %v", err)
+	}
+	if linked.User.ID != local.User.ID || len(linked.User.Grants) != 1 {
+		t.Fatalf("linked session = %+v, want the invited account with its grant", linked.User)
+	}
+}
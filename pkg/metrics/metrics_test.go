@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WritesTextExposition(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("test_requests_total", "Requests.", "path", "code")
+	latency := registry.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "path")
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc("/a", `quoted "\`)
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(5, "/a")
+
+	var out strings.Builder
+	registry.Write(&out)
+	WriteGauge(&out, "test_up", "Up.", 1)
+
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{path="/a",code="200"} 2
+test_requests_total{path="/a",code="quoted \"\\"} 1
+test_requests_total{path="/b",code="200"} 1
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{path="/a",le="0.1"} 1
+test_latency_seconds_bucket{path="/a",le="1"} 2
+test_latency_seconds_bucket{path="/a",le="+Inf"} 3
+test_latency_seconds_sum{path="/a"} 5.55
+test_latency_seconds_count{path="/a"} 3
+# HELP test_up Up.
+# TYPE test_up gauge
+test_up 1
+`
+	if out.String() != want {
+		t.Fatalf("exposition =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestCounter_PanicsOnLabelMismatch(t *testing.T) {
+	counter := NewRegistry().Counter("test_total", "Test.", "site")
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Inc with missing label values did not panic")
+		}
+	}()
+	counter.Inc()
+}
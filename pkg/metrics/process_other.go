@@ -0,0 +1,7 @@
+//go:build !unix
+
+package metrics
+
+func readProcessStats() processStats {
+	return processStats{cpuSeconds: -1, residentBytes: -1, readBytes: -1, writeBytes: -1}
+}
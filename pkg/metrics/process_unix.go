@@ -0,0 +1,48 @@
+//go:build unix
+
+package metrics
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readProcessStats reads CPU time from getrusage, and resident memory and
+// I/O from /proc/self where it exists.
+func readProcessStats() processStats {
+	stats := processStats{cpuSeconds: -1, residentBytes: -1, readBytes: -1, writeBytes: -1}
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err == nil {
+		stats.cpuSeconds = timevalSeconds(usage.Utime) + timevalSeconds(usage.Stime)
+	}
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) >= 2 {
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				stats.residentBytes = pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	if data, err := os.ReadFile("/proc/self/io"); err == nil {
+		stats.readBytes, stats.writeBytes = 0, 0
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseInt(fields[1], 10, 64)
+			switch fields[0] {
+			case "read_bytes:":
+				stats.readBytes = value
+			case "write_bytes:":
+				stats.writeBytes = value
+			}
+		}
+	}
+	return stats
+}
+
+func timevalSeconds(tv syscall.Timeval) float64 {
+	return float64(tv.Sec) + float64(tv.Usec)/1e6
+}
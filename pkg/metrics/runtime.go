@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"io"
+	"runtime"
+)
+
+// WriteRuntime writes Go runtime and process statistics. Process CPU time,
+// resident memory and I/O are written only where the platform reports them.
+func WriteRuntime(w io.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	WriteGauge(w, "go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	WriteGauge(w, "go_memstats_alloc_bytes", "Bytes of allocated heap objects.", float64(stats.Alloc))
+	WriteGauge(w, "go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", float64(stats.HeapInuse))
+	WriteGauge(w, "go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", float64(stats.Sys))
+	WriteCounter(w, "go_gc_cycles_total", "Completed garbage collection cycles.", float64(stats.NumGC))
+	WriteCounter(w, "go_gc_pause_seconds_total", "Total stop-the-world pause time for garbage collection.",
+		float64(stats.PauseTotalNs)/1e9)
+
+	process := readProcessStats()
+	if process.cpuSeconds >= 0 {
+		WriteCounter(w, "process_cpu_seconds_total", "Total user and system CPU time spent in seconds.", process.cpuSeconds)
+	}
+	if process.residentBytes >= 0 {
+		WriteGauge(w, "process_resident_memory_bytes", "Resident memory size in bytes.", float64(process.residentBytes))
+	}
+	if process.readBytes >= 0 {
+		WriteCounter(w, "process_io_read_bytes_total", "Bytes the process has read from storage.", float64(process.readBytes))
+		WriteCounter(w, "process_io_write_bytes_total", "Bytes the process has written to storage.", float64(process.writeBytes))
+	}
+}
+
+// processStats holds values the platform may not report; those are -1.
+type processStats struct {
+	cpuSeconds    float64
+	residentBytes int64
+	readBytes     int64
+	writeBytes    int64
+}
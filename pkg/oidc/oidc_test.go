@@ -0,0 +1,123 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VatsalP117/iris/pkg/core"
+	"github.com/VatsalP117/iris/pkg/oidc"
+	"github.com/VatsalP117/iris/pkg/oidc/oidctest"
+)
+
+func TestProvider_CodeFlowWithPKCEVerifiesIDToken(t *testing.T) {
+	idp := oidctest.NewServer(t, "iris", "client-secret")
+	idp.SetClaims(map[string]any{
+		"sub": "user-1", "email": "ada@example.com", "email_verified": true,
+		"name": "Ada", "groups": []string{"analysts", "iris-admins"},
+	})
+	provider, err := oidc.NewProvider(oidc.Config{
+		Issuer: idp.URL, ClientID: "iris", ClientSecret: "client-secret",
+		RedirectURL: "https://iris.example.com/api/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider returned error: %v", err)
+	}
+	ctx := context.Background()
+	authorizeURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned error: %v", err)
+	}
+	query, _ := url.ParseQuery(authorizeURL[strings.Index(authorizeURL, "?")+1:])
+	if query.Get("code_challenge") != oidc.PKCEChallenge("verifier-1") || query.Get("scope") != "openid email profile" {
+		t.Fatalf("unexpected authorize URL: %s", authorizeURL)
+	}
+	callback := oidctest.FollowLogin(t, authorizeURL)
+	if callback.Query().Get("state") != "state-1" {
+		t.Fatalf("callback state = %q", callback.Query().Get("state"))
+	}
+	if _, err := provider.Exchange(ctx, callback.Query().Get("code"), "wrong-verifier"); err == nil {
+		t.Fatal("Exchange accepted a wrong PKCE verifier")
+	}
+
+	callback = oidctest.FollowLogin(t, authorizeURL)
+	rawToken, err := provider.Exchange(ctx, callback.Query().Get("code"), "verifier-1")
+	if err != nil {
+		t.Fatalf("Exchange returned error: %v", err)
+	}
+	if _, err := provider.Verify(ctx, rawToken, "other-nonce"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Fatalf("wrong nonce error = %v, want ErrInvalidToken", err)
+	}
+	claims, err := provider.Verify(ctx, rawToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("Verify returned error: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "ada@example.com" || !claims.EmailVerified || claims.Name != "Ada" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	rules, err := oidc.ParseRoleRules("groups=iris-admins:*:owner, groups=analysts:blog:viewer, email=bob@example.com:docs:admin")
+	if err != nil {
+		t.Fatalf("ParseRoleRules returned error: %v", err)
+	}
+	grants := claims.Grants(rules)
+	want := []core.SiteGrant{{SiteID: "*", Role: core.RoleOwner}, {SiteID: "blog", Role: core.RoleViewer}}
+	if len(grants) != len(want) || grants[0] != want[0] || grants[1] != want[1] {
+		t.Fatalf("grants = %+v, want %+v", grants, want)
+	}
+}
+
+func TestProvider_RejectsForgedOrStaleIDTokens(t *testing.T) {
+	idp := oidctest.NewServer(t, "iris", "")
+	provider, err := oidc.NewProvider(oidc.Config{
+		Issuer: idp.URL, ClientID: "iris", RedirectURL: "https://iris.example.com/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider returned error: %v", err)
+	}
+	valid := map[string]any{"sub": "user-1", "nonce": "n"}
+	with := func(name string, value any) map[string]any {
+		claims := map[string]any{}
+		for key, existing := range valid {
+			claims[key] = existing
+		}
+		claims[name] = value
+		return claims
+	}
+	if _, err := provider.Verify(context.Background(), idp.SignToken(valid), "n"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	good := idp.SignToken(valid)
+	parts := strings.Split(good, ".")
+	for name, token := range map[string]string{
+		"wrong audience":   idp.SignToken(with("aud", "someone-else")),
+		"multi audience":   idp.SignToken(with("aud", []string{"iris", "other"})),
+		"wrong issuer":     idp.SignToken(with("iss", "https://evil.example")),
+		"expired":          idp.SignToken(with("exp", time.Now().Add(-time.Hour).Unix())),
+		"future issue":     idp.SignToken(with("iat", time.Now().Add(time.Hour).Unix())),
+		"missing subject":  idp.SignToken(with("sub", "")),
+		"tampered payload": parts[0] + "." + strings.Split(idp.SignToken(with("sub", "admin")), ".")[1] + "." + parts[2],
+		"alg none":         "eyJhbGciOiJub25lIiwia2lkIjoidGVzdC1rZXkifQ." + parts[1] + ".",
+		"unknown key":      "eyJhbGciOiJSUzI1NiIsImtpZCI6Im90aGVyIn0." + parts[1] + "." + parts[2],
+		"malformed":        "not-a-jwt",
+	} {
+		if _, err := provider.Verify(context.Background(), token, "n"); !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("%s: error = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestParseRoleRules_RejectsMalformedRules(t *testing.T) {
+	rules, err := oidc.ParseRoleRules("groups=team:ops:blog:viewer")
+	if err != nil || len(rules) != 1 || rules[0].Value != "team:ops" || rules[0].SiteID != "blog" {
+		t.Fatalf("rules = %+v, %v", rules, err)
+	}
+	for _, raw := range []string{"groups", "groups=a:b", "groups=a:blog:root", "=a:blog:viewer"} {
+		if _, err := oidc.ParseRoleRules(raw); err == nil {
+			t.Errorf("ParseRoleRules(%q) returned no error", raw)
+		}
+	}
+}
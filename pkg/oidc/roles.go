@@ -0,0 +1,66 @@
+package oidc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/VatsalP117/iris/pkg/core"
+)
+
+// RoleRule grants Role on SiteID, or on every site when SiteID is "*", to
+// users whose Claim holds Value. Array claims such as groups match when any
+// element equals Value.
+type RoleRule struct {
+	Claim  string
+	Value  string
+	SiteID string
+	Role   string
+}
+
+// ParseRoleRules parses comma-separated rules of the form
+// "claim=value:site:role", for example
+// "groups=iris-admins:*:owner,groups=marketing:blog:viewer". The value may
+// itself contain colons; the last two fields are always site and role.
+func ParseRoleRules(raw string) ([]RoleRule, error) {
+	var rules []RoleRule
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		claim, rest, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("role rule %q: want claim=value:site:role", item)
+		}
+		fields := strings.Split(rest, ":")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("role rule %q: want claim=value:site:role", item)
+		}
+		rule := RoleRule{
+			Claim:  strings.TrimSpace(claim),
+			Value:  strings.Join(fields[:len(fields)-2], ":"),
+			SiteID: strings.TrimSpace(fields[len(fields)-2]),
+			Role:   strings.TrimSpace(fields[len(fields)-1]),
+		}
+		if rule.Claim == "" || rule.Value == "" || rule.SiteID == "" {
+			return nil, fmt.Errorf("role rule %q: claim, value and site are required", item)
+		}
+		if rule.Role != core.RoleOwner && rule.Role != core.RoleAdmin && rule.Role != core.RoleViewer {
+			return nil, fmt.Errorf("role rule %q: unknown role %q", item, rule.Role)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Grants returns the site grants the rules give these claims.
+func (c *Claims) Grants(rules []RoleRule) []core.SiteGrant {
+	var grants []core.SiteGrant
+	for _, rule := range rules {
+		if slices.Contains(stringValues(c.Raw[rule.Claim]), rule.Value) {
+			grants = append(grants, core.SiteGrant{SiteID: rule.SiteID, Role: rule.Role})
+		}
+	}
+	return grants
+}
@@ -9,6 +9,7 @@ export const API_REQUEST_CONSTANTS = {
     },
     CONTENT_TYPES: {
         JSON: "application/json",
+        TEXT: "text/plain",
     },
     METHODS: {
         POST: "POST",
@@ -12,6 +12,12 @@ const RETRY_DELAY_MS = 100;
 const STALLED_REQUEST_MS = 450;
 const RETRYABLE_STATUS_CODES = new Set([408, 429, 502, 503, 504]);
 
+interface BatchResult {
+  accepted: number;
+  rejected: number;
+  results: { index: number; status: number; error?: string }[];
+}
+
 export class Transport {
   private queue: EventPayload[] = [];
   private timer: ReturnType<typeof setInterval> | null = null;
@@ -47,13 +53,15 @@ export class Transport {
     if (this.queue.length === 0) return;
 
     const events = this.queue.splice(0);
-    const url = `${this.config.host}/${API_ENDPOINTS.BATCH_EVENTS}`;
+    // Partial mode stores the valid events even if another one is rejected.
+    const url = `${this.config.host}/${API_ENDPOINTS.BATCH_EVENTS}?partial=1`;
     const body = JSON.stringify(events);
 
     if (this.config.debug) console.log(`Iris: Flushing ${events.length} events`);
 
     if (useBeacon && navigator.sendBeacon) {
-      const blob = new Blob([body], { type: API_REQUEST_CONSTANTS.CONTENT_TYPES.JSON });
+      // text/plain is CORS-safelisted, so the beacon needs no preflight.
+      const blob = new Blob([body], { type: API_REQUEST_CONSTANTS.CONTENT_TYPES.TEXT });
       const queued = navigator.sendBeacon(url, blob);
       if (!queued) {
         void this.sendWithFetch(url, body, "Iris: Batch flush failed", true);
@@ -107,6 +115,9 @@ export class Transport {
           !RETRYABLE_STATUS_CODES.has(response.status) ||
           attempt === MAX_DELIVERY_ATTEMPTS
         ) {
+          if (this.config.debug && response.status === 200) {
+            await this.logRejectedEvents(response);
+          }
           return;
         }
       } catch (err) {
@@ -124,6 +135,19 @@ export class Transport {
     }
   }
 
+  private async logRejectedEvents(response: Response) {
+    try {
+      const result: BatchResult = await response.json();
+      for (const event of result.results) {
+        if (event.status !== 202) {
+          console.warn(`Iris: Event ${event.index} rejected (${event.status})`, event.error);
+        }
+      }
+    } catch {
+      // The response body is only diagnostic.
+    }
+  }
+
   private startTimer() {
     if (!this.batchConfig) return;
     this.timer = setInterval(() => this.flush(), this.batchConfig.flushInterval);